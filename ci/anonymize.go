@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// exportRecord is one line of an XTDB export: a row and the table it belongs to
+type exportRecord struct {
+	Table string         `json:"table"`
+	Row   map[string]any `json:"row"`
+}
+
+// anonymizationRule masks one field; Table may be "*" to match every table
+type anonymizationRule struct {
+	Table    string `json:"table"`
+	Field    string `json:"field"`
+	Strategy string `json:"strategy"`
+}
+
+var fakeFirstNames = []string{"Avery", "Blake", "Casey", "Devon", "Emery", "Finley", "Harper", "Jordan", "Morgan", "Quinn", "Riley", "Sage"}
+var fakeLastNames = []string{"Adams", "Baker", "Carter", "Ellis", "Foster", "Hayes", "Lane", "Parker", "Reed", "Shaw", "Turner", "Wells"}
+
+// anonymizer replaces values deterministically, so the same input always maps
+// to the same fake and references between rows survive
+type anonymizer struct {
+	salt []byte
+}
+
+func (a anonymizer) digest(value string) []byte {
+	mac := hmac.New(sha256.New, a.salt)
+	mac.Write([]byte(value))
+	return mac.Sum(nil)
+}
+
+// apply returns the masked value for a strategy
+func (a anonymizer) apply(strategy string, value any) (any, error) {
+	if value == nil {
+		return nil, nil
+	}
+	raw := fmt.Sprint(value)
+	sum := a.digest(raw)
+	switch strategy {
+	case "email":
+		// 12 bytes keep distinct addresses from colliding in datasets of any size
+		return fmt.Sprintf("user-%s@example.com", hex.EncodeToString(sum[:12])), nil
+	case "name":
+		return fmt.Sprintf("%s %s", fakeFirstNames[int(sum[0])%len(fakeFirstNames)], fakeLastNames[int(sum[1])%len(fakeLastNames)]), nil
+	case "token":
+		token := hex.EncodeToString(sum)
+		for len(token) < len(raw) {
+			token += token
+		}
+		return token[:len(raw)], nil
+	case "hash":
+		return hex.EncodeToString(sum), nil
+	case "redact":
+		return "[REDACTED]", nil
+	case "null":
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown anonymization strategy %q (use email, name, token, hash, redact or null)", strategy)
+}
+
+// ExportXTDB exports the current rows of every table as JSON lines of {"table": ..., "row": ...}
+func (m *CljXtdbDevops) ExportXTDB(
+	ctx context.Context,
+	// XTDB service to export, e.g. tcp://localhost:3000
+	xtdb *dagger.Service,
+) (*dagger.File, error) {
+	client, err := newXtdbClient(ctx, xtdb)
+	if err != nil {
+		return nil, err
+	}
+	tables, err := client.userTables(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var out strings.Builder
+	for _, table := range tables {
+		rows, err := client.query(ctx, fmt.Sprintf("SELECT * FROM %s", table))
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("📤 Exporting %d rows from %s\n", len(rows), table)
+		for _, row := range rows {
+			line, err := json.Marshal(exportRecord{Table: table, Row: row})
+			if err != nil {
+				return nil, err
+			}
+			out.Write(line)
+			out.WriteString("\n")
+		}
+	}
+	return dag.Directory().WithNewFile("export.jsonl", out.String()).File("export.jsonl"), nil
+}
+
+// AnonymizeDataset applies field-level masking rules to an XTDB export before it is shared with developer environments
+func (m *CljXtdbDevops) AnonymizeDataset(
+	ctx context.Context,
+	// Export produced by ExportXTDB
+	export *dagger.File,
+	// JSON array of {"table": ..., "field": ..., "strategy": ...} rules
+	rules *dagger.File,
+	// Salt for the deterministic fakes. It is required and must stay private:
+	// with a known salt, emails and names can be reversed by brute force.
+	salt *dagger.Secret,
+) (*dagger.File, error) {
+	var ruleSet []anonymizationRule
+	if err := readJSONFile(ctx, rules, &ruleSet); err != nil {
+		return nil, fmt.Errorf("failed to read rules: %w", err)
+	}
+
+	plain, err := salt.Plaintext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if plain == "" {
+		return nil, fmt.Errorf("the anonymization salt is empty")
+	}
+	a := anonymizer{salt: []byte(plain)}
+
+	contents, err := export.Contents(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read export: %w", err)
+	}
+
+	var out strings.Builder
+	masked := 0
+	scanner := bufio.NewScanner(strings.NewReader(contents))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var record exportRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		for _, rule := range ruleSet {
+			if rule.Table != "*" && rule.Table != record.Table {
+				continue
+			}
+			value, ok := record.Row[rule.Field]
+			if !ok {
+				continue
+			}
+			replacement, err := a.apply(rule.Strategy, xtdbValue(value))
+			if err != nil {
+				return nil, err
+			}
+			record.Row[rule.Field] = replacement
+			masked++
+		}
+		line, err := json.Marshal(record)
+		if err != nil {
+			return nil, err
+		}
+		out.Write(line)
+		out.WriteString("\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("🕶️  Masked %d field values\n", masked)
+	return dag.Directory().WithNewFile("anonymized.jsonl", out.String()).File("anonymized.jsonl"), nil
+}
+
+// importBatchSize is how many rows ImportDataset puts per transaction
+const importBatchSize = 500
+
+// ImportDataset loads an export, usually the output of AnonymizeDataset, into
+// a development XTDB, table by table
+func (m *CljXtdbDevops) ImportDataset(
+	ctx context.Context,
+	// Export produced by ExportXTDB or AnonymizeDataset
+	dataset *dagger.File,
+	// Development XTDB service to load, e.g. tcp://localhost:3000
+	xtdb *dagger.Service,
+) (string, error) {
+	contents, err := dataset.Contents(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to read dataset: %w", err)
+	}
+
+	var tables []string
+	rows := map[string][]map[string]any{}
+	scanner := bufio.NewScanner(strings.NewReader(contents))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var record exportRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return "", fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		if _, ok := rows[record.Table]; !ok {
+			tables = append(tables, record.Table)
+		}
+		rows[record.Table] = append(rows[record.Table], record.Row)
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	client, err := newXtdbClient(ctx, xtdb)
+	if err != nil {
+		return "", err
+	}
+	if err := client.waitReady(ctx, 30); err != nil {
+		return "", err
+	}
+
+	var summary strings.Builder
+	for _, table := range tables {
+		docs := rows[table]
+		fmt.Printf("📥 Importing %d rows into %s\n", len(docs), table)
+		for start := 0; start < len(docs); start += importBatchSize {
+			end := min(start+importBatchSize, len(docs))
+			if err := client.putDocs(ctx, table, docs[start:end]); err != nil {
+				return "", fmt.Errorf("failed to import %s: %w", table, err)
+			}
+		}
+		fmt.Fprintf(&summary, "%s: %d rows\n", table, len(docs))
+	}
+	return summary.String(), nil
+}
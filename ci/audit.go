@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// auditEvent is one entry of the audit log: who ran which function against
+// which environment, with what parameters and digests, and how it ended
+type auditEvent struct {
+	Time     string            `json:"time"`
+	Actor    string            `json:"actor"`
+	Function string            `json:"function"`
+	Env      string            `json:"env"`
+	Params   map[string]string `json:"params"`
+	Outcome  string            `json:"outcome"`
+	Error    string            `json:"error,omitempty"`
+	Digests  map[string]string `json:"digests,omitempty"`
+}
+
+func (e auditEvent) String() string {
+	var params []string
+	for _, k := range slices.Sorted(maps.Keys(e.Params)) {
+		params = append(params, k+"="+e.Params[k])
+	}
+	line := fmt.Sprintf("%s %s %s %s by %s (%s)", e.Time, e.Outcome, e.Function, e.Env, e.Actor, strings.Join(params, " "))
+	if e.Error != "" {
+		line += ": " + e.Error
+	}
+	for _, service := range slices.Sorted(maps.Keys(e.Digests)) {
+		line += "\n  " + service + " " + e.Digests[service]
+	}
+	return line
+}
+
+// audit appends the outcome of a function call to the audit log, in one
+// stream per environment and function; calls outside an environment, e.g.
+// publishes, go to pipeline/<function>. The actor is the caller's AWS
+// identity, e.g. the deploy role session or an SSO user.
+func audit(ctx context.Context, cli *dagger.Container, logGroup string, event auditEvent, err error) error {
+	actor, idErr := awsOutput(ctx, cli, "sts", "get-caller-identity", "--query", "Arn", "--output", "text")
+	if idErr != nil {
+		return idErr
+	}
+	event.Actor = actor
+	event.Time = time.Now().UTC().Format(time.RFC3339)
+	switch {
+	case err == nil:
+		event.Outcome = "succeeded"
+	case errors.Is(err, errPolicyRejected):
+		event.Outcome, event.Error = "rejected", err.Error()
+	default:
+		event.Outcome, event.Error = "failed", err.Error()
+	}
+	message, jsonErr := json.Marshal(event)
+	if jsonErr != nil {
+		return jsonErr
+	}
+	events, jsonErr := json.Marshal([]map[string]any{{"timestamp": time.Now().UnixMilli(), "message": string(message)}})
+	if jsonErr != nil {
+		return jsonErr
+	}
+
+	stream := event.Env + "/" + event.Function
+	if event.Env == "" {
+		stream = "pipeline/" + event.Function
+	}
+	if _, err := awsOutput(ctx, cli, "logs", "create-log-stream", "--log-group-name", logGroup, "--log-stream-name", stream); err != nil &&
+		!strings.Contains(err.Error(), "ResourceAlreadyExistsException") {
+		return err
+	}
+	_, err = awsOutput(ctx, cli.WithNewFile("/audit/events.json", string(events)),
+		"logs", "put-log-events", "--log-group-name", logGroup, "--log-stream-name", stream,
+		"--log-events", "file:///audit/events.json")
+	return err
+}
+
+// WithAuditLog records the publishes chained after it in the audit log, as
+// the deployments, approvals and reconciles always are. Without it a publish
+// is not audited.
+func (m *CljXtdbDevops) WithAuditLog(
+	// Shared AWS credentials file
+	// +optional
+	awsCreds *dagger.Secret,
+	// Role to assume with webIdentityToken instead of using awsCreds, e.g. the deploy role
+	// +optional
+	roleArn string,
+	// OIDC token for roleArn, e.g. a GitHub Actions ID token
+	// +optional
+	webIdentityToken *dagger.Secret,
+	// +optional
+	// +default="clj-xtdb-devops-audit"
+	logGroup string,
+	// Region of the audit log, the state region
+	// +optional
+	// +default="us-east-1"
+	stateRegion string,
+) (*CljXtdbDevops, error) {
+	if awsCreds == nil && (roleArn == "" || webIdentityToken == nil) {
+		return nil, fmt.Errorf("pass either aws-creds or role-arn with web-identity-token")
+	}
+	m.AuditCreds = awsCreds
+	m.AuditRoleArn = roleArn
+	m.AuditWebIdentityToken = webIdentityToken
+	m.AuditLogGroup = logGroup
+	m.AuditRegion = stateRegion
+	return m, nil
+}
+
+// auditPublish records a publish of tag in the audit log set by
+// with-audit-log, if any, and returns err joined with a failure to record it
+func (m *CljXtdbDevops) auditPublish(ctx context.Context, function string, tag string, ref string, err error) error {
+	if m.AuditLogGroup == "" {
+		return err
+	}
+	cli := withAwsAuth(dag.Container().From(awsCliImage), m.AuditCreds, m.AuditRoleArn, m.AuditWebIdentityToken).
+		WithEnvVariable("AWS_REGION", m.AuditRegion).
+		WithEnvVariable("AWS_PAGER", "").
+		WithEnvVariable("CACHE_BUSTER", time.Now().String())
+	event := auditEvent{Function: function, Params: map[string]string{"tag": tag}}
+	if ref != "" {
+		event.Digests = map[string]string{"image": ref}
+	}
+	if auditErr := audit(ctx, cli, m.AuditLogGroup, event, err); auditErr != nil {
+		return errors.Join(err, fmt.Errorf("failed to write the audit log: %w", auditErr))
+	}
+	return err
+}
+
+// RecordInfraDeploy records the outcome of an infra deploy run outside this
+// module, e.g. by `xtdbops infra deploy` after AuthorizeDeploy allowed it
+func (m *CljXtdbDevops) RecordInfraDeploy(
+	ctx context.Context,
+	// Environment name, e.g. prod
+	env string,
+	// Stacks deployed, e.g. infra-prod-*
+	stacks string,
+	// What was deployed, e.g. the image tag the stacks pin
+	imageRef string,
+	// Why the deploy failed, if it did
+	// +optional
+	failure string,
+	// Shared AWS credentials file
+	awsCreds *dagger.Secret,
+	// +optional
+	// +default="clj-xtdb-devops-audit"
+	logGroup string,
+	// Region of the audit log, the state region
+	// +optional
+	// +default="us-east-1"
+	stateRegion string,
+	// +optional
+	profile string,
+) error {
+	var deployErr error
+	if failure != "" {
+		deployErr = errors.New(failure)
+	}
+	cli := awsCli(awsCreds, stateRegion, profile).WithEnvVariable("CACHE_BUSTER", time.Now().String())
+	event := auditEvent{Function: "InfraDeploy", Env: env, Params: map[string]string{"stacks": stacks, "image": imageRef}}
+	return audit(ctx, cli, logGroup, event, deployErr)
+}
+
+// AuditLog lists the publishes, deployments, approvals, reconciles and infra
+// deploys recorded by this module since a duration ago or a time, oldest
+// first, for SOC 2 evidence
+func (m *CljXtdbDevops) AuditLog(
+	ctx context.Context,
+	// How far back to look, e.g. 168h, or an RFC 3339 time
+	since string,
+	// Shared AWS credentials file of the auditor
+	awsCreds *dagger.Secret,
+	// Only this environment
+	// +optional
+	env string,
+	// Only this function, e.g. DeployToECS or InfraDeploy
+	// +optional
+	function string,
+	// +optional
+	// +default="clj-xtdb-devops-audit"
+	logGroup string,
+	// Region of the audit log, the state region
+	// +optional
+	// +default="us-east-1"
+	stateRegion string,
+	// +optional
+	profile string,
+) (string, error) {
+	start, err := time.Parse(time.RFC3339, since)
+	if err != nil {
+		ago, durErr := time.ParseDuration(since)
+		if durErr != nil {
+			return "", fmt.Errorf("since %q is neither a duration nor an RFC 3339 time", since)
+		}
+		start = time.Now().Add(-ago)
+	}
+	args := []string{"logs", "filter-log-events", "--log-group-name", logGroup,
+		"--start-time", fmt.Sprint(start.UnixMilli()), "--query", "events[].message"}
+	var filters []string
+	if env != "" {
+		filters = append(filters, fmt.Sprintf("$.env = %q", env))
+	}
+	if function != "" {
+		filters = append(filters, fmt.Sprintf("$.function = %q", function))
+	}
+	if len(filters) > 0 {
+		args = append(args, "--filter-pattern", "{ "+strings.Join(filters, " && ")+" }")
+	}
+
+	var messages []string
+	cli := awsCli(awsCreds, stateRegion, profile).WithEnvVariable("CACHE_BUSTER", time.Now().String())
+	if err := awsJSON(ctx, cli, &messages, args...); err != nil {
+		return "", err
+	}
+	events := make([]auditEvent, 0, len(messages))
+	for _, message := range messages {
+		var event auditEvent
+		if err := json.Unmarshal([]byte(message), &event); err != nil {
+			return "", fmt.Errorf("invalid audit event %s: %w", message, err)
+		}
+		events = append(events, event)
+	}
+	slices.SortStableFunc(events, func(a, b auditEvent) int { return strings.Compare(a.Time, b.Time) })
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d audited calls since %s\n", len(events), start.UTC().Format(time.RFC3339))
+	for _, event := range events {
+		b.WriteString(event.String())
+		b.WriteString("\n")
+	}
+	return b.String(), nil
+}
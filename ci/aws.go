@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// awsCliImage is the AWS CLI release used by the operational functions, the
+// one images.lock pins for the scheduled backups
+var awsCliImage = lockedImage("public.ecr.aws/aws-cli/aws-cli")
+
+// resourcePrefix prefixes the names of everything the infra stacks create
+const resourcePrefix = "clj-xtdb-devops"
+
+// envPrefix is the name prefix of an environment's resources, e.g. clj-xtdb-devops-staging
+func envPrefix(env string) string {
+	return resourcePrefix + "-" + env
+}
+
+// awsCli returns an AWS CLI container authenticated with a shared credentials
+// file, e.g. --aws-creds file:$HOME/.aws/credentials
+func awsCli(awsCreds *dagger.Secret, region string, profile string) *dagger.Container {
+	ctr := withAwsAuth(dag.Container().From(awsCliImage), awsCreds, "", nil).
+		WithEnvVariable("AWS_REGION", region).
+		WithEnvVariable("AWS_PAGER", "")
+	if profile != "" {
+		ctr = ctr.WithEnvVariable("AWS_PROFILE", profile)
+	}
+	return ctr
+}
+
+// withAwsAuth authenticates AWS clients in ctr either with a shared
+// credentials file or, in CI, by assuming roleArn with an OIDC token, e.g.
+// the deploy role output by the infra stack and a GitHub Actions ID token.
+// The CLI, SDKs and Terraform all read the web identity variables, and back
+// off and retry throttled and transient API errors up to awsMaxAttempts.
+func withAwsAuth(ctr *dagger.Container, awsCreds *dagger.Secret, roleArn string, webIdentityToken *dagger.Secret) *dagger.Container {
+	ctr = ctr.
+		WithEnvVariable("AWS_RETRY_MODE", "adaptive").
+		WithEnvVariable("AWS_MAX_ATTEMPTS", fmt.Sprint(awsMaxAttempts))
+	if roleArn != "" && webIdentityToken != nil {
+		return ctr.
+			WithMountedSecret("/run/secrets/aws-web-identity-token", webIdentityToken).
+			WithEnvVariable("AWS_ROLE_ARN", roleArn).
+			WithEnvVariable("AWS_WEB_IDENTITY_TOKEN_FILE", "/run/secrets/aws-web-identity-token").
+			WithEnvVariable("AWS_ROLE_SESSION_NAME", resourcePrefix+"-ci")
+	}
+	return ctr.WithMountedSecret("/root/.aws/credentials", awsCreds)
+}
+
+// awsOutput runs an AWS CLI command and returns its trimmed text output
+func awsOutput(ctx context.Context, cli *dagger.Container, args ...string) (string, error) {
+	out, err := cli.WithExec(append([]string{"aws"}, args...)).Stdout(ctx)
+	if err != nil {
+		return "", fmt.Errorf("aws %s failed: %w", strings.Join(args, " "), err)
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// awsJSON runs an AWS CLI command with JSON output and decodes it into v
+func awsJSON(ctx context.Context, cli *dagger.Container, v any, args ...string) error {
+	out, err := awsOutput(ctx, cli, append(args, "--output", "json")...)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal([]byte(out), v); err != nil {
+		return fmt.Errorf("failed to decode aws %s output: %w", strings.Join(args, " "), err)
+	}
+	return nil
+}
+
+// sessionManagerPluginInstall installs the session manager plugin built for
+// the container's architecture, since the engine may run on arm64, e.g. on
+// Apple silicon
+const sessionManagerPluginInstall = `case "$(uname -m)" in
+  aarch64|arm64) arch=linux_arm64 ;;
+  *) arch=linux_64bit ;;
+esac
+yum install -y -q "https://s3.amazonaws.com/session-manager-downloads/plugin/latest/$arch/session-manager-plugin.rpm"`
+
+// EcsExec opens an interactive shell in a running Fargate task of a service through ECS Exec
+func (m *CljXtdbDevops) EcsExec(
+	ctx context.Context,
+	cluster string,
+	service string,
+	// Shared AWS credentials file
+	awsCreds *dagger.Secret,
+	// Container in the task to attach to (required when the task has several)
+	// +optional
+	container string,
+	// Command to run in the task
+	// +optional
+	// +default="/bin/sh"
+	command string,
+	// +optional
+	// +default="us-east-1"
+	region string,
+	// +optional
+	profile string,
+) (*dagger.Container, error) {
+	cli := awsCli(awsCreds, region, profile)
+
+	fmt.Printf("🔎 Looking for a running task of %s/%s...\n", cluster, service)
+	task, err := awsOutput(ctx, cli, "ecs", "list-tasks",
+		"--cluster", cluster,
+		"--service-name", service,
+		"--desired-status", "RUNNING",
+		"--query", "taskArns[0]",
+		"--output", "text")
+	if err != nil {
+		return nil, err
+	}
+	if task == "" || task == "None" {
+		return nil, fmt.Errorf("no running tasks found for service %s in cluster %s", service, cluster)
+	}
+	fmt.Printf("🐚 Opening %s in %s\n", command, task)
+
+	args := []string{"aws", "ecs", "execute-command",
+		"--cluster", cluster,
+		"--task", task,
+		"--interactive",
+		"--command", command}
+	if container != "" {
+		args = append(args, "--container", container)
+	}
+
+	// execute-command hands the session to the SSM session manager plugin
+	return cli.
+		WithExec([]string{"sh", "-c", sessionManagerPluginInstall}).
+		Terminal(dagger.ContainerTerminalOpts{Cmd: args}), nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// Prefixes of the cache volumes backing the scheduled local backups, one
+// pair per backup environment. They live in the Dagger engine, so data and
+// snapshots survive the environment being torn down.
+const (
+	localDataVolume   = "clj-xtdb-devops-xtdb-data"
+	localBackupVolume = "clj-xtdb-devops-xtdb-backups"
+)
+
+// localBackupEnvsVolume holds an empty file per backup environment the
+// sidecar ever ran in. The engine can't list its cache volumes, so this is
+// how the cache functions find every environment's pair.
+const localBackupEnvsVolume = "clj-xtdb-devops-xtdb-backup-envs"
+
+// defaultBackupEnv is the backup environment used unless another is named
+const defaultBackupEnv = "default"
+
+// backupVolume returns the volume of a backup environment
+func backupVolume(prefix, env string) string {
+	return prefix + "-" + env
+}
+
+// backupLoop snapshots the data directory as a tar.gz every interval and
+// prunes everything but the newest snapshots. XTDB keeps writing while the
+// tar runs, so a snapshot is only kept if no file changed during it; one
+// taken mid-write is retried a few times, then skipped until the next
+// interval.
+const backupLoop = `touch "/envs/$BACKUP_ENV"
+listing() { find /var/lib/xtdb -type f -exec stat -c '%n %s %Y' {} + | sort; }
+while true; do
+  sleep "$BACKUP_INTERVAL_SECONDS"
+  snapshot="/backups/xtdb-$(date -u +%Y%m%dT%H%M%SZ).tar.gz"
+  for attempt in 1 2 3 4 5; do
+    before="$(listing)"
+    if ! tar -czf "$snapshot.tmp" -C /var/lib/xtdb .; then
+      echo "❌ snapshot failed"
+      break
+    fi
+    if [ "$before" = "$(listing)" ]; then
+      mv "$snapshot.tmp" "$snapshot"
+      echo "💾 wrote $snapshot"
+      break
+    fi
+    echo "⏳ XTDB wrote during the snapshot, retrying ($attempt of 5)"
+    sleep 5
+  done
+  rm -f "$snapshot.tmp"
+  ls -1t /backups/xtdb-*.tar.gz | tail -n +$((BACKUP_RETENTION + 1)) | xargs -r rm -f
+done`
+
+// withLocalBackups persists the XTDB data directory in the backup
+// environment's cache volume and binds a sidecar that snapshots it
+// periodically. The sidecar is bound to the XTDB container so it starts and
+// stops with it.
+func withLocalBackups(xtdb *dagger.Container, env string, interval time.Duration, retention int) *dagger.Container {
+	data := dag.CacheVolume(backupVolume(localDataVolume, env))
+	backups := dag.CacheVolume(backupVolume(localBackupVolume, env))
+
+	sidecar := dag.Container().From("alpine:3.21").
+		WithMountedCache(xtdbDataDir, data, dagger.ContainerWithMountedCacheOpts{Sharing: dagger.CacheSharingModeShared}).
+		WithMountedCache("/backups", backups).
+		WithMountedCache("/envs", dag.CacheVolume(localBackupEnvsVolume)).
+		WithEnvVariable("BACKUP_ENV", env).
+		WithEnvVariable("BACKUP_INTERVAL_SECONDS", fmt.Sprint(int(interval.Seconds()))).
+		WithEnvVariable("BACKUP_RETENTION", fmt.Sprint(retention)).
+		AsService(dagger.ContainerAsServiceOpts{Args: []string{"sh", "-c", backupLoop}})
+
+	return xtdb.
+		WithMountedCache(xtdbDataDir, data, dagger.ContainerWithMountedCacheOpts{Sharing: dagger.CacheSharingModeShared}).
+		WithServiceBinding("backup", sidecar)
+}
+
+// backupEnvs lists the backup environments the sidecar has run in, always
+// including the default one
+func backupEnvs(ctx context.Context) ([]string, error) {
+	out, err := dag.Container().From("alpine:3.21").
+		WithMountedCache("/envs", dag.CacheVolume(localBackupEnvsVolume)).
+		WithEnvVariable("CACHE_BUSTER", time.Now().String()).
+		WithExec([]string{"ls", "-1", "/envs"}).
+		Stdout(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the backup environments: %w", err)
+	}
+	envs := []string{defaultBackupEnv}
+	for _, env := range strings.Fields(out) {
+		if !slices.Contains(envs, env) {
+			envs = append(envs, env)
+		}
+	}
+	return envs, nil
+}
+
+// LocalBackups returns the XTDB snapshots taken by RunLocalDevelopment; export them with `export --path ./backups`
+func (m *CljXtdbDevops) LocalBackups(
+	// Backup environment the snapshots were taken in
+	// +optional
+	// +default="default"
+	backupEnv string,
+) *dagger.Directory {
+	return dag.Container().From("alpine:3.21").
+		WithMountedCache("/backups", dag.CacheVolume(backupVolume(localBackupVolume, backupEnv))).
+		// Cache volumes change outside the DAG, so never reuse an old copy
+		WithEnvVariable("CACHE_BUSTER", time.Now().String()).
+		WithExec([]string{"sh", "-c", "mkdir -p /export && cp -a /backups/. /export/"}).
+		Directory("/export")
+}
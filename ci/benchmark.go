@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// namedQuery is one entry of a JSON queries file
+type namedQuery struct {
+	Name string `json:"name"`
+	SQL  string `json:"sql"`
+}
+
+// queryLatency holds latency percentiles in milliseconds
+type queryLatency struct {
+	P50 float64 `json:"p50Ms"`
+	P95 float64 `json:"p95Ms"`
+	P99 float64 `json:"p99Ms"`
+}
+
+// benchmarkReport is both the output of a run and the baseline format
+type benchmarkReport struct {
+	Iterations int                     `json:"iterations"`
+	Queries    map[string]queryLatency `json:"queries"`
+}
+
+// percentile returns the nearest-rank percentile of sorted samples
+func percentile(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return float64(sorted[rank].Microseconds()) / 1000
+}
+
+// readJSONFile decodes a JSON file argument
+func readJSONFile(ctx context.Context, file *dagger.File, v any) error {
+	contents, err := file.Contents(ctx)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(contents), v)
+}
+
+// BenchmarkQueries measures query latency percentiles against a seeded XTDB and fails on regressions against a baseline
+func (m *CljXtdbDevops) BenchmarkQueries(
+	ctx context.Context,
+	// JSON array of {"name": ..., "sql": ...} queries
+	queries *dagger.File,
+	// Previous benchmark output to compare against
+	// +optional
+	baseline *dagger.File,
+	// SQL script loaded into a fresh XTDB before benchmarking
+	// +optional
+	seed *dagger.File,
+	// Benchmark an existing XTDB service instead of a fresh one
+	// +optional
+	xtdb *dagger.Service,
+	// Number of timed runs per query
+	// +optional
+	// +default=50
+	iterations int,
+	// Maximum allowed slowdown per percentile, in percent
+	// +optional
+	// +default=20
+	maxRegression int,
+) (string, error) {
+	var suite []namedQuery
+	if err := readJSONFile(ctx, queries, &suite); err != nil {
+		return "", fmt.Errorf("failed to read queries: %w", err)
+	}
+
+	client, err := m.seededXtdb(ctx, xtdb, seed)
+	if err != nil {
+		return "", err
+	}
+
+	report := benchmarkReport{Iterations: iterations, Queries: map[string]queryLatency{}}
+	for _, q := range suite {
+		fmt.Printf("⏱️  Benchmarking %s (%d runs)...\n", q.Name, iterations)
+		// Warm-up run so the first sample doesn't include cold caches
+		if _, err := client.query(ctx, q.SQL); err != nil {
+			return "", fmt.Errorf("%s: %w", q.Name, err)
+		}
+		samples := make([]time.Duration, 0, iterations)
+		for i := 0; i < iterations; i++ {
+			start := time.Now()
+			if _, err := client.query(ctx, q.SQL); err != nil {
+				return "", fmt.Errorf("%s: %w", q.Name, err)
+			}
+			samples = append(samples, time.Since(start))
+		}
+		sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+		report.Queries[q.Name] = queryLatency{
+			P50: percentile(samples, 50),
+			P95: percentile(samples, 95),
+			P99: percentile(samples, 99),
+		}
+	}
+
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if baseline == nil {
+		return string(out), nil
+	}
+
+	var previous benchmarkReport
+	if err := readJSONFile(ctx, baseline, &previous); err != nil {
+		return "", fmt.Errorf("failed to read baseline: %w", err)
+	}
+	var regressions []string
+	for _, q := range suite {
+		before, ok := previous.Queries[q.Name]
+		if !ok {
+			fmt.Printf("🆕 %s has no baseline yet\n", q.Name)
+			continue
+		}
+		after := report.Queries[q.Name]
+		for _, p := range []struct {
+			name          string
+			before, after float64
+		}{{"p50", before.P50, after.P50}, {"p95", before.P95, after.P95}, {"p99", before.P99, after.P99}} {
+			change := 0.0
+			if p.before > 0 {
+				change = (p.after - p.before) / p.before * 100
+			}
+			fmt.Printf("  %s %s: %.2fms → %.2fms (%+.1f%%)\n", q.Name, p.name, p.before, p.after, change)
+			if change > float64(maxRegression) {
+				regressions = append(regressions, fmt.Sprintf("%s %s regressed %.1f%% (%.2fms → %.2fms)", q.Name, p.name, change, p.before, p.after))
+			}
+		}
+	}
+	if len(regressions) > 0 {
+		return string(out), fmt.Errorf("query latency regressed more than %d%%:\n  - %s", maxRegression, strings.Join(regressions, "\n  - "))
+	}
+	fmt.Println("✅ No latency regressions against the baseline")
+	return string(out), nil
+}
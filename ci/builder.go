@@ -0,0 +1,24 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// builderDockerfile pins the Clojure CLI, clj-kondo and cljfmt of every
+// Clojure stage
+//
+//go:embed builder/Dockerfile
+var builderDockerfile string
+
+// BuilderImage returns the toolchain image every Clojure stage builds, tests
+// and lints in, built from builder/Dockerfile. The engine caches it until the
+// Dockerfile changes, so all stages run the identical toolchain.
+func (m *CljXtdbDevops) BuilderImage() *dagger.Container {
+	fmt.Println("🧰 Preparing builder image...")
+	return dag.Directory().
+		WithNewFile("Dockerfile", builderDockerfile).
+		DockerBuild()
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// Dependency caches shared by the build functions
+const (
+	m2CacheVolume      = "clj-xtdb-devops-m2"
+	goModCacheVolume   = "clj-xtdb-devops-go-mod"
+	goBuildCacheVolume = "clj-xtdb-devops-go-build"
+)
+
+// moduleCache is a named cache volume created by this module's functions.
+// Only prunable ones are safe to trim: the rest hold local data.
+type moduleCache struct {
+	Name        string
+	Description string
+	Prunable    bool
+}
+
+// moduleCaches lists the cache volumes, with the data and snapshot volumes
+// of every backup environment
+func moduleCaches(ctx context.Context) ([]moduleCache, error) {
+	caches := []moduleCache{
+		{m2CacheVolume, "Maven dependencies", true},
+		{goModCacheVolume, "Go modules", true},
+		{goBuildCacheVolume, "Go build cache", true},
+	}
+	envs, err := backupEnvs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, env := range envs {
+		caches = append(caches,
+			moduleCache{backupVolume(localDataVolume, env), "local XTDB data of the " + env + " backup environment", false},
+			moduleCache{backupVolume(localBackupVolume, env), "local XTDB snapshots of the " + env + " backup environment, pruned by retention", false})
+	}
+	return append(caches,
+		moduleCache{localLogsVolume, "logs kept by keep-logs", true},
+		moduleCache{trivyCacheVolume, "Trivy vulnerability database", true}), nil
+}
+
+// cacheUsage returns the size of a cache volume and of its files not
+// modified for olderThan, in KiB
+func cacheUsage(ctx context.Context, name string, olderThan time.Duration) (total, stale int, err error) {
+	out, err := dag.Container().From("alpine:3.21").
+		// BusyBox du can't read file names from stdin, and xargs would split a
+		// long list into batches with a total each
+		WithExec([]string{"apk", "add", "--no-cache", "coreutils"}).
+		WithMountedCache("/cache", dag.CacheVolume(name)).
+		// Cache volumes change outside the DAG, so never reuse an old result
+		WithEnvVariable("CACHE_BUSTER", time.Now().String()).
+		WithExec([]string{"sh", "-c", fmt.Sprintf(
+			"du -sk /cache | cut -f1; find /cache -type f -mmin +%d -print0 | du -cks --files0-from=- | tail -n1 | cut -f1",
+			int(olderThan.Minutes()))}).
+		Stdout(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to measure %s: %w", name, err)
+	}
+	lines := strings.Fields(out)
+	if len(lines) > 0 {
+		total, _ = strconv.Atoi(lines[0])
+	}
+	if len(lines) > 1 {
+		stale, _ = strconv.Atoi(lines[1])
+	}
+	return total, stale, nil
+}
+
+// emptyCache deletes the contents of a cache volume, e.g. the per-run
+// volumes of rehearsals, which are never reused
+func emptyCache(ctx context.Context, volume *dagger.CacheVolume) error {
+	_, err := dag.Container().From("alpine:3.21").
+		WithMountedCache("/cache", volume).
+		WithEnvVariable("CACHE_BUSTER", time.Now().String()).
+		WithExec([]string{"find", "/cache", "-mindepth", "1", "-delete"}).
+		Sync(ctx)
+	return err
+}
+
+// formatKiB renders a size in KiB for humans
+func formatKiB(kib int) string {
+	switch {
+	case kib >= 1<<20:
+		return fmt.Sprintf("%.1f GiB", float64(kib)/(1<<20))
+	case kib >= 1<<10:
+		return fmt.Sprintf("%.1f MiB", float64(kib)/(1<<10))
+	}
+	return fmt.Sprintf("%d KiB", kib)
+}
+
+// CacheStats reports the size of the cache volumes this module creates,
+// how much of them has not been touched for olderThan, and the Dagger
+// engine's total cache usage
+func (m *CljXtdbDevops) CacheStats(
+	ctx context.Context,
+	// Age after which files count as stale, e.g. 720h
+	// +optional
+	// +default="720h"
+	olderThan string,
+) (string, error) {
+	age, err := time.ParseDuration(olderThan)
+	if err != nil {
+		return "", fmt.Errorf("invalid age %q: %w", olderThan, err)
+	}
+	caches, err := moduleCaches(ctx)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Cache volumes (stale: untouched for %s):\n", age)
+	for _, cache := range caches {
+		total, stale, err := cacheUsage(ctx, cache.Name, age)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "  %-26s %10s, %10s stale  %s\n", cache.Name, formatKiB(total), formatKiB(stale), cache.Description)
+	}
+
+	entries := dag.Engine().LocalCache().EntrySet()
+	bytes, err := entries.DiskSpaceBytes(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the engine cache: %w", err)
+	}
+	count, err := entries.EntryCount(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the engine cache: %w", err)
+	}
+	fmt.Fprintf(&b, "Engine cache: %s in %d entries, including images and layers\n", formatKiB(bytes/1024), count)
+	return b.String(), nil
+}
+
+// CachePrune deletes dependency cache files untouched for olderThan, which
+// the next build downloads again, and optionally prunes the engine's unused
+// cache entries, e.g. old image layers. Local XTDB data and snapshots are
+// never pruned.
+func (m *CljXtdbDevops) CachePrune(
+	ctx context.Context,
+	// Age after which files are pruned, e.g. 720h
+	// +optional
+	// +default="720h"
+	olderThan string,
+	// Also prune every cache entry the engine is not using
+	// +optional
+	engine bool,
+) (string, error) {
+	age, err := time.ParseDuration(olderThan)
+	if err != nil {
+		return "", fmt.Errorf("invalid age %q: %w", olderThan, err)
+	}
+	caches, err := moduleCaches(ctx)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	freed := 0
+	for _, cache := range caches {
+		if !cache.Prunable {
+			continue
+		}
+		_, stale, err := cacheUsage(ctx, cache.Name, age)
+		if err != nil {
+			return "", err
+		}
+		fmt.Printf("🧹 Pruning %s of %s...\n", formatKiB(stale), cache.Name)
+		_, err = dag.Container().From("alpine:3.21").
+			WithMountedCache("/cache", dag.CacheVolume(cache.Name)).
+			WithEnvVariable("CACHE_BUSTER", time.Now().String()).
+			WithExec([]string{"sh", "-c", fmt.Sprintf(
+				"find /cache -type f -mmin +%d -delete && find /cache -mindepth 1 -type d -empty -delete",
+				int(age.Minutes()))}).
+			Sync(ctx)
+		if err != nil {
+			return "", fmt.Errorf("failed to prune %s: %w", cache.Name, err)
+		}
+		freed += stale
+		fmt.Fprintf(&b, "  %s: freed %s\n", cache.Name, formatKiB(stale))
+	}
+	fmt.Fprintf(&b, "Freed %s of dependency caches\n", formatKiB(freed))
+
+	if engine {
+		before, err := dag.Engine().LocalCache().EntrySet().DiskSpaceBytes(ctx)
+		if err != nil {
+			return "", err
+		}
+		fmt.Println("🧹 Pruning unused engine cache entries...")
+		if err := dag.Engine().LocalCache().Prune(ctx); err != nil {
+			return "", fmt.Errorf("failed to prune the engine cache: %w", err)
+		}
+		after, err := dag.Engine().LocalCache().EntrySet().DiskSpaceBytes(ctx)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "Freed %s of engine cache\n", formatKiB((before-after)/1024))
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// environmentTag is the cost-allocation tag that identifies an environment's resources
+const environmentTag = "Environment"
+
+// costAndUsage is the subset of `aws ce get-cost-and-usage` we report on
+type costAndUsage struct {
+	ResultsByTime []struct {
+		TimePeriod struct {
+			Start string `json:"Start"`
+		} `json:"TimePeriod"`
+		Groups []struct {
+			Keys    []string `json:"Keys"`
+			Metrics map[string]struct {
+				Amount string `json:"Amount"`
+				Unit   string `json:"Unit"`
+			} `json:"Metrics"`
+		} `json:"Groups"`
+	} `json:"ResultsByTime"`
+}
+
+// CostReport prints a per-service monthly cost breakdown for an environment from Cost Explorer
+func (m *CljXtdbDevops) CostReport(
+	ctx context.Context,
+	// Environment name, matched against the Environment cost-allocation tag
+	env string,
+	// Shared AWS credentials file
+	awsCreds *dagger.Secret,
+	// Number of months to report, including the current month to date
+	// +optional
+	// +default=3
+	months int,
+	// +optional
+	profile string,
+) (string, error) {
+	now := time.Now().UTC()
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -(months - 1), 0)
+	end := now.AddDate(0, 0, 1)
+
+	filter, err := json.Marshal(map[string]any{
+		"Tags": map[string]any{"Key": environmentTag, "Values": []string{env}, "MatchOptions": []string{"EQUALS"}},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	fmt.Printf("💰 Fetching costs for %s since %s...\n", env, start.Format("2006-01-02"))
+	// Cost Explorer is only served from us-east-1
+	var usage costAndUsage
+	if err := awsJSON(ctx, awsCli(awsCreds, "us-east-1", profile), &usage, "ce", "get-cost-and-usage",
+		"--time-period", fmt.Sprintf("Start=%s,End=%s", start.Format("2006-01-02"), end.Format("2006-01-02")),
+		"--granularity", "MONTHLY",
+		"--metrics", "UnblendedCost",
+		"--group-by", "Type=DIMENSION,Key=SERVICE",
+		"--filter", string(filter)); err != nil {
+		return "", err
+	}
+
+	var report strings.Builder
+	fmt.Fprintf(&report, "Cost report for %s (%s=%s)\n", env, environmentTag, env)
+	for _, period := range usage.ResultsByTime {
+		type line struct {
+			service string
+			amount  float64
+		}
+		var lines []line
+		total, unit := 0.0, "USD"
+		for _, group := range period.Groups {
+			cost := group.Metrics["UnblendedCost"]
+			amount, err := strconv.ParseFloat(cost.Amount, 64)
+			if err != nil || amount < 0.005 {
+				continue
+			}
+			lines = append(lines, line{service: strings.Join(group.Keys, " "), amount: amount})
+			total += amount
+			unit = cost.Unit
+		}
+		sort.Slice(lines, func(i, j int) bool { return lines[i].amount > lines[j].amount })
+
+		fmt.Fprintf(&report, "\n%s  total %.2f %s\n", period.TimePeriod.Start[:7], total, unit)
+		for _, l := range lines {
+			fmt.Fprintf(&report, "  %-50s %10.2f\n", l.service, l.amount)
+		}
+	}
+	return report.String(), nil
+}
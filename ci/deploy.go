@@ -0,0 +1,522 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// serviceContainers is the container running each ECS service's image, as
+// named by the infra stacks
+var serviceContainers = map[string]string{"app": "AppContainer", "xtdb": "XTDBContainer"}
+
+// taskDefinitionReadOnly are the fields describe-task-definition returns
+// that register-task-definition rejects
+var taskDefinitionReadOnly = []string{
+	"taskDefinitionArn", "revision", "status", "requiresAttributes",
+	"compatibilities", "registeredAt", "registeredBy", "deregisteredAt",
+}
+
+// deployRecord is what the deploy table keeps per environment: the image
+// digests its tasks run, the commit deployed, the Terraform state serial of
+// each stack, when the deployment finished and what allowed it, plus the
+// deployments the deploy policy rejected
+type deployRecord struct {
+	Environment string
+	GitSha      string
+	DeployedAt  string
+	Approval    string
+	Images      map[string]string
+	Stacks      map[string]int
+	Rejected    []rejectedDeploy
+}
+
+// rejectedDeploy is a deployment the deploy policy rejected
+type rejectedDeploy struct {
+	At, Image, GitSha, Reason string
+}
+
+// dynamoValue is a DynamoDB attribute value in the CLI's JSON
+type dynamoValue struct {
+	S string                 `json:"S,omitempty"`
+	N string                 `json:"N,omitempty"`
+	M map[string]dynamoValue `json:"M,omitempty"`
+	L []dynamoValue          `json:"L,omitempty"`
+}
+
+func (r deployRecord) item() map[string]dynamoValue {
+	images := map[string]dynamoValue{}
+	for service, image := range r.Images {
+		images[service] = dynamoValue{S: image}
+	}
+	stacks := map[string]dynamoValue{}
+	for stack, serial := range r.Stacks {
+		stacks[stack] = dynamoValue{N: strconv.Itoa(serial)}
+	}
+	item := map[string]dynamoValue{
+		"Environment": {S: r.Environment},
+		"GitSha":      {S: r.GitSha},
+		"DeployedAt":  {S: r.DeployedAt},
+		"Approval":    {S: r.Approval},
+	}
+	// The CLI's JSON has no empty map, so leave out what there is none of
+	if len(images) > 0 {
+		item["Images"] = dynamoValue{M: images}
+	}
+	if len(stacks) > 0 {
+		item["Stacks"] = dynamoValue{M: stacks}
+	}
+	return item
+}
+
+func deployRecordFromItem(item map[string]dynamoValue) deployRecord {
+	r := deployRecord{
+		Environment: item["Environment"].S,
+		GitSha:      item["GitSha"].S,
+		DeployedAt:  item["DeployedAt"].S,
+		Approval:    item["Approval"].S,
+		Images:      map[string]string{},
+		Stacks:      map[string]int{},
+	}
+	for service, image := range item["Images"].M {
+		r.Images[service] = image.S
+	}
+	for stack, serial := range item["Stacks"].M {
+		r.Stacks[stack], _ = strconv.Atoi(serial.N)
+	}
+	for _, attempt := range item["Rejected"].L {
+		r.Rejected = append(r.Rejected, rejectedDeploy{
+			At:     attempt.M["At"].S,
+			Image:  attempt.M["Image"].S,
+			GitSha: attempt.M["GitSha"].S,
+			Reason: attempt.M["Reason"].S,
+		})
+	}
+	return r
+}
+
+func (r deployRecord) String() string {
+	var b strings.Builder
+	if r.DeployedAt != "" {
+		fmt.Fprintf(&b, "%s: %s deployed at %s (%s)\n", r.Environment, r.GitSha, r.DeployedAt, r.Approval)
+	} else {
+		fmt.Fprintf(&b, "%s: nothing deployed\n", r.Environment)
+	}
+	for _, service := range slices.Sorted(maps.Keys(r.Images)) {
+		fmt.Fprintf(&b, "  %-5s %s\n", service, r.Images[service])
+	}
+	for _, stack := range slices.Sorted(maps.Keys(r.Stacks)) {
+		fmt.Fprintf(&b, "  %s at state serial %d\n", stack, r.Stacks[stack])
+	}
+	if n := len(r.Rejected); n > 0 {
+		last := r.Rejected[n-1]
+		fmt.Fprintf(&b, "  %d rejected deployments, last %s of %s at %s: %s\n", n, last.GitSha, last.Image, last.At, last.Reason)
+	}
+	return b.String()
+}
+
+// DeployToECS rolls an ECS service of an environment onto a published image
+// by registering a new revision of its task definition, waits for the
+// service to settle, and records the environment in the deploy table. The
+// next infra deploy puts back the image the stacks pin.
+//
+// Gated environments, e.g. prod, deploy inside their deploy window, with an
+// approval token from ApproveDeploy or with a change ticket. Deployments the
+// policy rejects fail and are recorded in the deploy table. Every call is
+// recorded in the audit log.
+func (m *CljXtdbDevops) DeployToECS(
+	ctx context.Context,
+	// Environment name, e.g. staging
+	env string,
+	// Published image to roll out, e.g. from PublishCljWebApp
+	imageRef string,
+	// Commit the image was built from
+	gitSha string,
+	// Service to roll, app or xtdb
+	// +optional
+	// +default="app"
+	service string,
+	// Token from ApproveDeploy, for gated environments outside their deploy window
+	// +optional
+	approvalToken *dagger.Secret,
+	// Change ticket authorizing the deployment, e.g. CHG-1234
+	// +optional
+	changeTicket string,
+	// Shared AWS credentials file
+	// +optional
+	awsCreds *dagger.Secret,
+	// Role to assume with webIdentityToken instead of using awsCreds, e.g. the deploy role
+	// +optional
+	roleArn string,
+	// OIDC token for roleArn, e.g. a GitHub Actions ID token
+	// +optional
+	webIdentityToken *dagger.Secret,
+	// +optional
+	// +default="us-east-1"
+	region string,
+	// Bucket holding the stacks' Terraform state
+	// +optional
+	// +default="clj-xtdb-devops-tfstate"
+	stateBucket string,
+	// Table recording what is deployed to each environment
+	// +optional
+	// +default="clj-xtdb-devops-deploys"
+	deployTable string,
+	// Log group of the audit log
+	// +optional
+	// +default="clj-xtdb-devops-audit"
+	auditLogGroup string,
+	// Region of the state bucket, deploy table and audit log
+	// +optional
+	// +default="us-east-1"
+	stateRegion string,
+	// Environments whose deployments need approval outside the deploy window
+	// +optional
+	// +default=["prod"]
+	gated []string,
+	// When gated environments deploy without approval: days and UTC hours, e.g. Mon-Thu 14-20
+	// +optional
+	// +default="Mon-Thu 14-20"
+	deployWindow string,
+) (report string, err error) {
+	if awsCreds == nil && (roleArn == "" || webIdentityToken == nil) {
+		return "", fmt.Errorf("pass either aws-creds or role-arn with web-identity-token")
+	}
+	container, ok := serviceContainers[service]
+	if !ok {
+		return "", fmt.Errorf("unknown service %q (use app or xtdb)", service)
+	}
+	policy, err := newDeployPolicy(gated, deployWindow)
+	if err != nil {
+		return "", err
+	}
+	// Every call must see the live services, never a cached result
+	cli := withAwsAuth(dag.Container().From(awsCliImage), awsCreds, roleArn, webIdentityToken).
+		WithEnvVariable("AWS_PAGER", "").
+		WithEnvVariable("CACHE_BUSTER", time.Now().String())
+	ecs := cli.WithEnvVariable("AWS_REGION", region)
+	state := cli.WithEnvVariable("AWS_REGION", stateRegion)
+
+	params := map[string]string{"service": service, "image": imageRef, "gitSha": gitSha}
+	var digests map[string]string
+	defer func() {
+		if auditErr := audit(ctx, state, auditLogGroup, auditEvent{Function: "DeployToECS", Env: env, Params: params, Digests: digests}, err); auditErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to write the audit log: %w", auditErr))
+		}
+	}()
+
+	approval, rejected, err := checkDeployPolicy(ctx, ecs, policy, env, imageRef, approvalToken, changeTicket)
+	if err != nil {
+		return "", err
+	}
+	if rejected != "" {
+		if err := recordRejectedDeploy(ctx, state, deployTable, env, imageRef, gitSha, rejected); err != nil {
+			return "", err
+		}
+		return "", fmt.Errorf("deploy of %s to %s %w: %s", imageRef, env, errPolicyRejected, rejected)
+	}
+	params["approval"] = approval
+
+	target, err := appStackTarget(ctx, state, stateBucket, env)
+	if err != nil {
+		return "", err
+	}
+	cluster, name := target.Cluster, target.Services[service]
+	if name == "" {
+		return "", fmt.Errorf("the app stack of %s has no %s service", env, service)
+	}
+	current, err := describeService(ctx, ecs, cluster, name)
+	if err != nil {
+		return "", err
+	}
+	taskDef, err := describeTaskDefinition(ctx, ecs, current.TaskDefinition)
+	if err != nil {
+		return "", err
+	}
+	for _, field := range taskDefinitionReadOnly {
+		delete(taskDef, field)
+	}
+	def := containerDefinition(taskDef, container)
+	if def == nil {
+		return "", fmt.Errorf("task definition %s has no %s container", current.TaskDefinition, container)
+	}
+	def["image"] = imageRef
+	input, err := json.Marshal(taskDef)
+	if err != nil {
+		return "", err
+	}
+
+	fmt.Printf("🚢 Rolling %s onto %s...\n", name, imageRef)
+	registered, err := awsOutput(ctx, ecs.WithNewFile("/deploy/task-definition.json", string(input)),
+		"ecs", "register-task-definition", "--cli-input-json", "file:///deploy/task-definition.json",
+		"--query", "taskDefinition.taskDefinitionArn", "--output", "text")
+	if err != nil {
+		return "", err
+	}
+	if _, err := awsOutput(ctx, ecs, "ecs", "update-service", "--cluster", cluster, "--service", name,
+		"--task-definition", registered, "--query", "service.serviceName", "--output", "text"); err != nil {
+		return "", err
+	}
+	fmt.Println("⏳ Waiting for the service to become stable...")
+	if _, err := awsOutput(ctx, ecs, "ecs", "wait", "services-stable", "--cluster", cluster, "--services", name); err != nil {
+		return "", fmt.Errorf("%s did not become stable on %s: %w", name, registered, err)
+	}
+
+	record := deployRecord{
+		Environment: env,
+		GitSha:      gitSha,
+		DeployedAt:  time.Now().UTC().Format(time.RFC3339),
+		Approval:    approval,
+	}
+	if record.Images, err = runningImages(ctx, ecs, target); err != nil {
+		return "", err
+	}
+	digests = record.Images
+	if record.Stacks, err = stackSerials(ctx, state, stateBucket, env); err != nil {
+		return "", err
+	}
+	if err := saveDeployRecord(ctx, state, deployTable, record); err != nil {
+		return "", err
+	}
+	fmt.Printf("✅ Deployed %s to %s\n", gitSha, env)
+	return record.String(), nil
+}
+
+// saveDeployRecord sets the environment's item in the deploy table to the
+// record, keeping its rejected deployments
+func saveDeployRecord(ctx context.Context, cli *dagger.Container, table string, record deployRecord) error {
+	item := record.item()
+	key := map[string]dynamoValue{"Environment": item["Environment"]}
+	delete(item, "Environment")
+	var set []string
+	names, values := map[string]string{}, map[string]dynamoValue{}
+	for _, attr := range slices.Sorted(maps.Keys(item)) {
+		set = append(set, "#"+attr+" = :"+attr)
+		names["#"+attr] = attr
+		values[":"+attr] = item[attr]
+	}
+	update, err := json.Marshal(map[string]any{
+		"TableName":                 table,
+		"Key":                       key,
+		"UpdateExpression":          "SET " + strings.Join(set, ", "),
+		"ExpressionAttributeNames":  names,
+		"ExpressionAttributeValues": values,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = awsOutput(ctx, cli.WithNewFile("/deploy/update.json", string(update)),
+		"dynamodb", "update-item", "--cli-input-json", "file:///deploy/update.json")
+	return err
+}
+
+// ecsService is what `aws ecs describe-services` says a service is set to run
+type ecsService struct {
+	TaskDefinition string `json:"taskDefinition"`
+	DesiredCount   int    `json:"desiredCount"`
+}
+
+func describeService(ctx context.Context, cli *dagger.Container, cluster string, name string) (ecsService, error) {
+	var services []ecsService
+	if err := awsJSON(ctx, cli, &services, "ecs", "describe-services", "--cluster", cluster, "--services", name,
+		"--query", "services[?status=='ACTIVE']"); err != nil {
+		return ecsService{}, err
+	}
+	if len(services) == 0 {
+		return ecsService{}, fmt.Errorf("no service %s in cluster %s", name, cluster)
+	}
+	return services[0], nil
+}
+
+func describeTaskDefinition(ctx context.Context, cli *dagger.Container, arn string) (map[string]any, error) {
+	var described struct {
+		TaskDefinition map[string]any `json:"taskDefinition"`
+	}
+	if err := awsJSON(ctx, cli, &described, "ecs", "describe-task-definition", "--task-definition", arn); err != nil {
+		return nil, err
+	}
+	return described.TaskDefinition, nil
+}
+
+// containerDefinition returns the named container of a task definition, or nil
+func containerDefinition(taskDef map[string]any, name string) map[string]any {
+	containers, _ := taskDef["containerDefinitions"].([]any)
+	for _, c := range containers {
+		if def, ok := c.(map[string]any); ok && def["name"] == name {
+			return def
+		}
+	}
+	return nil
+}
+
+// ecsTarget is where an environment's app stack runs its services
+type ecsTarget struct {
+	Cluster string
+	// Services maps each service, e.g. app, to its ECS service name
+	Services map[string]string
+}
+
+// appStackTarget reads the cluster and service names from the outputs of an
+// environment's app stack, so deploys follow a configured name prefix or an
+// existing cluster instead of assuming the default names
+func appStackTarget(ctx context.Context, cli *dagger.Container, bucket string, env string) (ecsTarget, error) {
+	stack := "infra-" + env + "-app"
+	var state struct {
+		Outputs map[string]struct {
+			Value any `json:"value"`
+		} `json:"outputs"`
+	}
+	if err := readState(ctx, cli, bucket, stack, &state); err != nil {
+		return ecsTarget{}, err
+	}
+	cluster, _ := state.Outputs["cluster_name"].Value.(string)
+	if cluster == "" {
+		return ecsTarget{}, fmt.Errorf("the state of %s has no cluster_name output; is the app stack deployed?", stack)
+	}
+	target := ecsTarget{Cluster: cluster, Services: map[string]string{}}
+	for service := range serviceContainers {
+		if name, _ := state.Outputs[service+"_service_name"].Value.(string); name != "" {
+			target.Services[service] = name
+		}
+	}
+	return target, nil
+}
+
+// runningImages returns the image and digest each ECS service of an
+// environment runs, keyed by service
+func runningImages(ctx context.Context, cli *dagger.Container, target ecsTarget) (map[string]string, error) {
+	arns, err := awsOutput(ctx, cli, "ecs", "list-tasks", "--cluster", target.Cluster, "--desired-status", "RUNNING",
+		"--query", "taskArns", "--output", "text")
+	if err != nil {
+		return nil, err
+	}
+	services := map[string]string{}
+	for service, name := range target.Services {
+		services["service:"+name] = service
+	}
+	images := map[string]string{}
+	if tasks := strings.Fields(arns); len(tasks) > 0 && arns != "None" {
+		var described struct {
+			Tasks []struct {
+				Group      string `json:"group"`
+				Containers []struct {
+					Name        string `json:"name"`
+					Image       string `json:"image"`
+					ImageDigest string `json:"imageDigest"`
+				} `json:"containers"`
+			} `json:"tasks"`
+		}
+		if err := awsJSON(ctx, cli, &described, append([]string{"ecs", "describe-tasks", "--cluster", target.Cluster, "--tasks"}, tasks...)...); err != nil {
+			return nil, err
+		}
+		for _, task := range described.Tasks {
+			service := services[task.Group]
+			for _, c := range task.Containers {
+				if c.Name == serviceContainers[service] {
+					images[service] = c.Image + "@" + c.ImageDigest
+				}
+			}
+		}
+	}
+	return images, nil
+}
+
+// readState decodes the Terraform state of a stack
+func readState(ctx context.Context, cli *dagger.Container, bucket string, stack string, state any) error {
+	out, err := awsOutput(ctx, cli, "s3", "cp", "s3://"+bucket+"/"+stack+"/terraform.tfstate", "-")
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal([]byte(out), state); err != nil {
+		return fmt.Errorf("failed to decode the state of %s: %w", stack, err)
+	}
+	return nil
+}
+
+// stackSerials returns the Terraform state serial of each stack of an
+// environment, which grows with every apply that changes the stack. The
+// standby's stacks (infra-<env>-dr-<layer>) and those of environments whose
+// names start with env share the prefix, so only infra-<env>-<layer> counts.
+func stackSerials(ctx context.Context, cli *dagger.Container, bucket string, env string) (map[string]int, error) {
+	prefix := "infra-" + env + "-"
+	keys, err := awsOutput(ctx, cli, "s3api", "list-objects-v2", "--bucket", bucket, "--prefix", prefix,
+		"--query", "Contents[].Key", "--output", "text")
+	if err != nil {
+		return nil, err
+	}
+	serials := map[string]int{}
+	for _, key := range strings.Fields(keys) {
+		stack, ok := strings.CutSuffix(key, "/terraform.tfstate")
+		if !ok || strings.Contains(strings.TrimPrefix(stack, prefix), "-") {
+			continue
+		}
+		var state struct {
+			Serial int `json:"serial"`
+		}
+		if err := readState(ctx, cli, bucket, stack, &state); err != nil {
+			return nil, err
+		}
+		serials[stack] = state.Serial
+	}
+	return serials, nil
+}
+
+// EnvList reports what DeployToECS last deployed to each environment: image
+// digests, commit, stack versions and when
+func (m *CljXtdbDevops) EnvList(
+	ctx context.Context,
+	// Shared AWS credentials file
+	// +optional
+	awsCreds *dagger.Secret,
+	// Role to assume with webIdentityToken instead of using awsCreds, e.g. the deploy role
+	// +optional
+	roleArn string,
+	// OIDC token for roleArn, e.g. a GitHub Actions ID token
+	// +optional
+	webIdentityToken *dagger.Secret,
+	// Table recording what is deployed to each environment
+	// +optional
+	// +default="clj-xtdb-devops-deploys"
+	deployTable string,
+	// Region of the deploy table
+	// +optional
+	// +default="us-east-1"
+	stateRegion string,
+) (string, error) {
+	if awsCreds == nil && (roleArn == "" || webIdentityToken == nil) {
+		return "", fmt.Errorf("pass either aws-creds or role-arn with web-identity-token")
+	}
+	cli := withAwsAuth(dag.Container().From(awsCliImage), awsCreds, roleArn, webIdentityToken).
+		WithEnvVariable("AWS_REGION", stateRegion).
+		WithEnvVariable("AWS_PAGER", "").
+		WithEnvVariable("CACHE_BUSTER", time.Now().String())
+
+	var scanned struct {
+		Items []map[string]dynamoValue `json:"Items"`
+	}
+	if err := awsJSON(ctx, cli, &scanned, "dynamodb", "scan", "--table-name", deployTable); err != nil {
+		return "", err
+	}
+	if len(scanned.Items) == 0 {
+		return "No deployments recorded in " + deployTable + "\n", nil
+	}
+	records := []deployRecord{}
+	for _, item := range scanned.Items {
+		records = append(records, deployRecordFromItem(item))
+	}
+	slices.SortFunc(records, func(a, b deployRecord) int { return strings.Compare(a.Environment, b.Environment) })
+
+	var b strings.Builder
+	for _, r := range records {
+		b.WriteString(r.String())
+	}
+	return b.String(), nil
+}
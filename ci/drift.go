@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// infraLayers are the CDKTF stacks of an environment, infra-<env>-<layer>.
+// An environment has one of the app, eks and apprunner layers, or only the
+// gcp or azure stack.
+var infraLayers = []string{"network", "data", "app", "eks", "apprunner", "gcp", "azure"}
+
+// InfraDrift plans every stack of an environment against its remote state
+// with -detailed-exitcode and reports the stacks whose deployed resources no
+// longer match the CDKTF definition. Drift is published to the
+// environment's alerts topic, which reaches the alert emails and Slack, and
+// fails the call so a scheduled run shows up red.
+func (m *CljXtdbDevops) InfraDrift(
+	ctx context.Context,
+	infraDir *dagger.Directory,
+	// Environment name, e.g. staging
+	env string,
+	// Shared AWS credentials file
+	// +optional
+	awsCreds *dagger.Secret,
+	// Role to assume with webIdentityToken instead of using awsCreds, e.g. the deploy role
+	// +optional
+	roleArn string,
+	// OIDC token for roleArn, e.g. a GitHub Actions ID token
+	// +optional
+	webIdentityToken *dagger.Secret,
+	// +optional
+	// +default="us-east-1"
+	region string,
+	// Only print the report, without publishing to the alerts topic
+	// +optional
+	noNotify bool,
+) (string, error) {
+	if awsCreds == nil && (roleArn == "" || webIdentityToken == nil) {
+		return "", fmt.Errorf("pass either aws-creds or role-arn with web-identity-token")
+	}
+	fmt.Printf("🧭 Checking %s for drift...\n", env)
+	synth := cdktfContainer(infraDir)
+
+	entries, err := synth.Directory("/infra/cdktf.out/stacks").Entries(ctx)
+	if err != nil {
+		return "", err
+	}
+	synthesized := map[string]bool{}
+	for _, entry := range entries {
+		synthesized[strings.TrimSuffix(entry, "/")] = true
+	}
+
+	var report strings.Builder
+	var drifted []string
+	for _, layer := range infraLayers {
+		stack := fmt.Sprintf("infra-%s-%s", env, layer)
+		if !synthesized[stack] {
+			continue
+		}
+		plan := withAwsAuth(synth, awsCreds, roleArn, webIdentityToken).
+			WithWorkdir("/infra/cdktf.out/stacks/"+stack).
+			WithExec([]string{"terraform", "init", "-input=false"}).
+			WithExec([]string{"terraform", "plan", "-input=false", "-lock=false", "-no-color", "-detailed-exitcode"},
+				dagger.ContainerWithExecOpts{Expect: dagger.ReturnTypeAny})
+		code, err := plan.ExitCode(ctx)
+		if err != nil {
+			return "", err
+		}
+		switch code {
+		case 0:
+			fmt.Fprintf(&report, "✅ %s matches its definition\n", stack)
+		case 2:
+			out, err := plan.Stdout(ctx)
+			if err != nil {
+				return "", err
+			}
+			drifted = append(drifted, stack)
+			fmt.Fprintf(&report, "❌ %s has drifted:\n%s\n", stack, planChanges(out))
+		default:
+			stderr, _ := plan.Stderr(ctx)
+			return "", fmt.Errorf("terraform plan of %s failed:\n%s", stack, stderr)
+		}
+	}
+
+	if len(drifted) == 0 {
+		return report.String(), nil
+	}
+	if !noNotify {
+		cli := withAwsAuth(dag.Container().From(awsCliImage), awsCreds, roleArn, webIdentityToken).
+			WithEnvVariable("AWS_REGION", region).
+			WithEnvVariable("AWS_PAGER", "")
+		topic, err := awsOutput(ctx, cli, "sns", "list-topics",
+			"--query", fmt.Sprintf("Topics[?ends_with(TopicArn, ':%s-alerts')].TopicArn | [0]", envPrefix(env)),
+			"--output", "text")
+		if err != nil {
+			return "", err
+		}
+		if topic == "" || topic == "None" {
+			return "", fmt.Errorf("no alerts topic for %s to report drift to:\n%s", env, report.String())
+		}
+		if _, err := awsOutput(ctx, cli, "sns", "publish", "--topic-arn", topic,
+			"--subject", fmt.Sprintf("Infrastructure drift in %s", env),
+			"--message", report.String()); err != nil {
+			return "", err
+		}
+	}
+	return report.String(), fmt.Errorf("drift detected in %s", strings.Join(drifted, ", "))
+}
+
+// planChanges keeps the resource headers and summary of a plan, e.g.
+// "# aws_ecs_service.x will be updated in-place" and "Plan: 0 to add, ..."
+func planChanges(plan string) string {
+	var lines []string
+	for _, line := range strings.Split(plan, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "# ") || strings.HasPrefix(trimmed, "Plan:") {
+			lines = append(lines, "  "+trimmed)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
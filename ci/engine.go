@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// minOpenFiles is the open files limit XTDB and the JVM want
+const minOpenFiles = 65536
+
+// EngineCheck reports known incompatibilities of the Dagger engine's
+// runtime with the local environments up front, e.g. under rootless Podman
+// or an old Docker Desktop VM: cgroups, open file limits and whether
+// service bindings work at all
+func (m *CljXtdbDevops) EngineCheck(ctx context.Context) (string, error) {
+	report := &healthReport{}
+	report.section("Dagger engine")
+
+	out, err := dag.Container().From("alpine:3.21").
+		WithEnvVariable("CACHE_BUSTER", time.Now().String()).
+		WithExec([]string{"sh", "-c", "uname -r; stat -fc %T /sys/fs/cgroup; ulimit -n; nproc; free -m | awk '/Mem:/ {print $2}'"}).
+		Stdout(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to run a container: %w", err)
+	}
+	facts := strings.Fields(out)
+	if len(facts) < 5 {
+		return "", fmt.Errorf("unexpected engine facts %q", out)
+	}
+	kernel, cgroup, nofile, cpus, memMiB := facts[0], facts[1], facts[2], facts[3], facts[4]
+	report.ok("kernel %s, %s CPUs, %s MiB memory", kernel, cpus, memMiB)
+	if cgroup == "cgroup2fs" {
+		report.ok("cgroup v2")
+	} else {
+		report.fail("cgroup v1 (%s): service health checks and limits are unreliable; enable cgroup v2 in the VM", cgroup)
+	}
+	if n, err := strconv.Atoi(nofile); err == nil && n < minOpenFiles {
+		report.fail("open files limit %d is below %d: XTDB can fail under load; raise the engine container's nofile ulimit", n, minOpenFiles)
+	} else {
+		report.ok("open files limit %s", nofile)
+	}
+	if mem, err := strconv.Atoi(memMiB); err == nil && mem < 4096 {
+		report.fail("%d MiB of memory: XTDB, the app and pgAdmin need about 4 GiB; give the Podman machine or Docker Desktop more", mem)
+	}
+
+	report.section("Service bindings")
+	probe := dag.Container().From("alpine:3.21").
+		WithNewFile("/www/index.html", "ok").
+		WithExposedPort(8080).
+		AsService(dagger.ContainerAsServiceOpts{Args: []string{"httpd", "-f", "-p", "8080", "-h", "/www"}})
+	_, err = dag.Container().From("alpine:3.21").
+		WithServiceBinding("probe", probe).
+		WithEnvVariable("CACHE_BUSTER", time.Now().String()).
+		WithExec([]string{"wget", "-q", "-O", "-", "http://probe:8080"}).
+		Sync(ctx)
+	if err != nil {
+		report.fail("a container could not reach a bound service, so the local environments cannot work; check the engine's networking, e.g. under rootless Podman (%v)", err)
+	} else {
+		report.ok("containers reach bound services by alias")
+	}
+
+	verdict := "✅ No known incompatibilities"
+	if report.unhealthy > 0 {
+		verdict = fmt.Sprintf("❌ The engine has %d problem(s) for the local environments", report.unhealthy)
+	}
+	return verdict + "\n" + report.lines.String(), nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// Environment describes a running local environment: its services, where
+// they are reachable and the credentials they use. Forward its ports with
+// `service up --ports`, or read it with `as-json`.
+type Environment struct {
+	// local-development or local-web-app
+	Name string `json:"name"`
+	// RFC 3339 time the environment was ready
+	StartedAt   string               `json:"startedAt"`
+	Services    []EnvironmentService `json:"services"`
+	Credentials []CredentialRef      `json:"credentials"`
+	// Service whose ports are forwarded to the host: XTDB, the app or the proxy
+	Service *dagger.Service `json:"service"`
+}
+
+// EnvironmentService is a service of a local environment
+type EnvironmentService struct {
+	// Hostname inside the session
+	Name      string     `json:"name"`
+	Image     string     `json:"image,omitempty"`
+	Endpoints []Endpoint `json:"endpoints"`
+}
+
+// Endpoint is where a service listens, inside the session and, when
+// forwarded, on the host
+type Endpoint struct {
+	Name     string `json:"name"`
+	Internal string `json:"internal"`
+	Host     string `json:"host,omitempty"`
+}
+
+// CredentialRef says where the credentials of a service are, without
+// holding secrets passed in by the caller
+type CredentialRef struct {
+	Service  string `json:"service"`
+	Username string `json:"username,omitempty"`
+	// Where the secret is: a development default, e.g. default:admin, or
+	// the app's environment variable holding it, e.g. env:OIDC_CLIENT_SECRET
+	Secret string `json:"secret"`
+}
+
+// newEnvironment starts describing an environment
+func newEnvironment(name string) *Environment {
+	return &Environment{Name: name}
+}
+
+// add describes a service with its endpoints
+func (e *Environment) add(service string, endpoints ...Endpoint) {
+	e.Services = append(e.Services, EnvironmentService{Name: service, Endpoints: endpoints})
+}
+
+// addExtraServices describes the extra services that were started. They
+// are only reachable from inside the session, unless the proxy routes them.
+func (e *Environment) addExtraServices(services map[string]*dagger.Service, specs []ServiceSpec) {
+	for _, spec := range specs {
+		if _, ok := services[spec.Name]; !ok {
+			continue
+		}
+		service := EnvironmentService{Name: spec.Name, Image: spec.Image}
+		for _, port := range slices.Sorted(slices.Values(spec.Ports)) {
+			service.Endpoints = append(service.Endpoints, Endpoint{
+				Name:     fmt.Sprintf("port %d", port),
+				Internal: fmt.Sprintf("%s:%d", spec.Name, port),
+			})
+		}
+		e.Services = append(e.Services, service)
+	}
+}
+
+// forward records the host URL of the endpoint listening on host:port
+// inside the session
+func (e *Environment) forward(hostPort, url string) {
+	for i := range e.Services {
+		for j, endpoint := range e.Services[i].Endpoints {
+			if strings.TrimPrefix(endpoint.Internal, "http://") == hostPort {
+				e.Services[i].Endpoints[j].Host = url
+			}
+		}
+	}
+}
+
+// route records the host URLs of the endpoints the reverse proxy serves
+func (e *Environment) route(routes []proxyRoute, proxyPort int) {
+	for _, route := range routes {
+		e.forward(fmt.Sprintf("%s:%d", route.Alias, route.Port), fmt.Sprintf("http://%s:%d", route.Host, proxyPort))
+	}
+}
+
+// credential records where a service's credentials are
+func (e *Environment) credential(service, username, secret string) {
+	e.Credentials = append(e.Credentials, CredentialRef{Service: service, Username: username, Secret: secret})
+}
+
+// ready marks the environment started with the service to forward and
+// prints its summary
+func (e *Environment) ready(service *dagger.Service) *Environment {
+	e.Service = service
+	e.StartedAt = time.Now().UTC().Format(time.RFC3339)
+	fmt.Print(e.Summary())
+	return e
+}
+
+// xtdbEndpoints are the ports of an XTDB service, forwarded or not
+func xtdbEndpoints(ports hostPorts, forwarded bool) []Endpoint {
+	endpoints := []Endpoint{
+		{Name: "HTTP API", Internal: "http://xtdb:3000"},
+		{Name: "PostgreSQL", Internal: "postgresql://xtdb:5432/xtdb"},
+		{Name: "Monitoring", Internal: "http://xtdb:8080"},
+	}
+	if forwarded {
+		endpoints[0].Host = fmt.Sprintf("http://localhost:%d", ports.host(3000, 3000))
+		endpoints[1].Host = fmt.Sprintf("postgresql://localhost:%d/xtdb", ports.host(5432, 5432))
+		endpoints[2].Host = fmt.Sprintf("http://localhost:%d", ports.host(8080, 8080))
+	}
+	return endpoints
+}
+
+// AsJSON returns the environment descriptor as JSON, for other tools
+func (e *Environment) AsJSON() (string, error) {
+	out, err := json.MarshalIndent(struct {
+		Name        string               `json:"name"`
+		StartedAt   string               `json:"startedAt"`
+		Services    []EnvironmentService `json:"services"`
+		Credentials []CredentialRef      `json:"credentials"`
+	}{e.Name, e.StartedAt, e.Services, e.Credentials}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// Summary lists where the services are reachable and their credentials
+func (e *Environment) Summary() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "🎉 %s environment ready!\n", e.Name)
+	b.WriteString("📝 Access points:\n")
+	for _, service := range e.Services {
+		for _, endpoint := range service.Endpoints {
+			where := endpoint.Host
+			if where == "" {
+				where = endpoint.Internal + " (inside the session)"
+			}
+			fmt.Fprintf(&b, "  - %s %s: %s\n", service.Name, endpoint.Name, where)
+		}
+	}
+	if len(e.Credentials) > 0 {
+		b.WriteString("🔑 Credentials:\n")
+		for _, c := range e.Credentials {
+			if c.Username != "" {
+				fmt.Fprintf(&b, "  - %s: %s, %s\n", c.Service, c.Username, c.Secret)
+			} else {
+				fmt.Fprintf(&b, "  - %s: %s\n", c.Service, c.Secret)
+			}
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// ttl.sh keeps images for the duration in their tag, up to a day
+const (
+	ephemeralRegistry = "ttl.sh"
+	maxEphemeralTTL   = 24 * time.Hour
+)
+
+// ephemeralTTLPattern matches the tags ttl.sh understands, e.g. 30m or 2h
+var ephemeralTTLPattern = regexp.MustCompile(`^[1-9][0-9]*[smh]$`)
+
+// imageNamePattern matches a lower-case repository name component
+var imageNamePattern = regexp.MustCompile(`^[a-z0-9]+(?:[._-][a-z0-9]+)*$`)
+
+// ephemeralRef returns a collision-resistant ttl.sh reference for a name,
+// since anyone can push to any ttl.sh name
+func ephemeralRef(name, ttl string) (string, error) {
+	if !imageNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid image name %q: use lower-case letters, digits and . _ -", name)
+	}
+	if !ephemeralTTLPattern.MatchString(ttl) {
+		return "", fmt.Errorf("invalid ttl %q: use seconds, minutes or hours, e.g. 30m or 2h", ttl)
+	}
+	if d, _ := time.ParseDuration(ttl); d > maxEphemeralTTL {
+		return "", fmt.Errorf("ttl %s is longer than the %s ttl.sh keeps images", ttl, maxEphemeralTTL)
+	}
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/%s-%s:%s", ephemeralRegistry, name, hex.EncodeToString(suffix), ttl), nil
+}
+
+// EphemeralPublish publishes a container to ttl.sh under a unique name that
+// expires after ttl, and returns the command to pull it
+func (m *CljXtdbDevops) EphemeralPublish(
+	ctx context.Context,
+	container *dagger.Container,
+	// +optional
+	// +default="my-app"
+	name string,
+	// How long ttl.sh keeps the image, at most 24h
+	// +optional
+	// +default="2h"
+	ttl string,
+) (pull string, err error) {
+	ref, err := ephemeralRef(name, ttl)
+	if err != nil {
+		return "", err
+	}
+	var published string
+	defer func() {
+		err = m.auditPublish(ctx, "EphemeralPublish", ref, published, err)
+	}()
+	err = m.retryPolicy().do(ctx, "publishing "+ref, func(ctx context.Context) error {
+		published, err = container.Publish(ctx, ref)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to publish %s: %w", ref, err)
+	}
+	fmt.Printf("⏳ %s expires in %s\n", ref, ttl)
+	return "docker pull " + published, nil
+}
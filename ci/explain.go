@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// planReport is both the output of ExplainQueries and the baseline format:
+// the plan of each query, one line per operator
+type planReport struct {
+	Queries map[string][]string `json:"queries"`
+}
+
+// planLines renders the rows EXPLAIN returns as plan lines. XTDB returns the
+// plan as text in a plan column; other shapes are kept as JSON rows.
+func planLines(rows []map[string]any) []string {
+	var lines []string
+	for _, row := range rows {
+		if plan, ok := xtdbValue(row["plan"]).(string); ok {
+			for _, line := range strings.Split(plan, "\n") {
+				if line = strings.TrimRight(line, " "); line != "" {
+					lines = append(lines, line)
+				}
+			}
+			continue
+		}
+		lines = append(lines, formatRow(row))
+	}
+	return lines
+}
+
+// diffLines returns a minimal line diff of two plans, removed lines
+// prefixed with - and added ones with +
+func diffLines(before, after []string) []string {
+	// Longest common subsequence table
+	lcs := make([][]int, len(before)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(after)+1)
+	}
+	for i := len(before) - 1; i >= 0; i-- {
+		for j := len(after) - 1; j >= 0; j-- {
+			if before[i] == after[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var diff []string
+	i, j := 0, 0
+	for i < len(before) && j < len(after) {
+		switch {
+		case before[i] == after[j]:
+			i, j = i+1, j+1
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			diff = append(diff, "- "+before[i])
+			i++
+		default:
+			diff = append(diff, "+ "+after[j])
+			j++
+		}
+	}
+	for ; i < len(before); i++ {
+		diff = append(diff, "- "+before[i])
+	}
+	for ; j < len(after); j++ {
+		diff = append(diff, "+ "+after[j])
+	}
+	return diff
+}
+
+// ExplainQueries runs EXPLAIN on critical queries against a seeded XTDB and
+// fails when a plan differs from the baseline, e.g. a lookup turning into a
+// full scan. XTDB's plans carry no cost estimates, so the plans themselves
+// are compared; commit the output as the new baseline once a change is
+// reviewed.
+func (m *CljXtdbDevops) ExplainQueries(
+	ctx context.Context,
+	// JSON array of {"name": ..., "sql": ...} queries
+	queries *dagger.File,
+	// Previous output to compare against
+	// +optional
+	baseline *dagger.File,
+	// SQL script loaded into a fresh XTDB before explaining
+	// +optional
+	seed *dagger.File,
+	// Explain against an existing XTDB service instead of a fresh one
+	// +optional
+	xtdb *dagger.Service,
+) (string, error) {
+	var suite []namedQuery
+	if err := readJSONFile(ctx, queries, &suite); err != nil {
+		return "", fmt.Errorf("failed to read queries: %w", err)
+	}
+
+	client, err := m.seededXtdb(ctx, xtdb, seed)
+	if err != nil {
+		return "", err
+	}
+
+	report := planReport{Queries: map[string][]string{}}
+	for _, q := range suite {
+		fmt.Printf("🧭 Explaining %s...\n", q.Name)
+		rows, err := client.query(ctx, "EXPLAIN "+q.SQL)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", q.Name, err)
+		}
+		report.Queries[q.Name] = planLines(rows)
+	}
+
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if baseline == nil {
+		return string(out), nil
+	}
+
+	var previous planReport
+	if err := readJSONFile(ctx, baseline, &previous); err != nil {
+		return "", fmt.Errorf("failed to read baseline: %w", err)
+	}
+	var regressions []string
+	for _, q := range suite {
+		before, ok := previous.Queries[q.Name]
+		if !ok {
+			fmt.Printf("🆕 %s has no baseline yet\n", q.Name)
+			continue
+		}
+		diff := diffLines(before, report.Queries[q.Name])
+		if len(diff) == 0 {
+			fmt.Printf("  %s: plan unchanged\n", q.Name)
+			continue
+		}
+		fmt.Printf("  %s: plan changed\n    %s\n", q.Name, strings.Join(diff, "\n    "))
+		regressions = append(regressions, q.Name)
+	}
+	if len(regressions) > 0 {
+		return string(out), fmt.Errorf("query plans changed for %s; review them and update the baseline", strings.Join(regressions, ", "))
+	}
+	fmt.Println("✅ No plan changes against the baseline")
+	return string(out), nil
+}
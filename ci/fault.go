@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// FaultInjectXtdbRestart creates items through the app while killing XTDB
+// partway through and restarting it on the same data, then checks that the
+// app reconnects and that every item it acknowledged is in XTDB
+func (m *CljXtdbDevops) FaultInjectXtdbRestart(
+	ctx context.Context,
+	srcDir *dagger.Directory,
+	// Number of items to create
+	// +optional
+	// +default=300
+	writes int,
+	// Kill XTDB after this many writes
+	// +optional
+	// +default=100
+	killAfter int,
+	// How long XTDB stays down
+	// +optional
+	// +default="15s"
+	downtime string,
+	// How long the app gets to reconnect once XTDB is back
+	// +optional
+	// +default="2m"
+	recoveryTimeout string,
+) (string, error) {
+	down, err := time.ParseDuration(downtime)
+	if err != nil {
+		return "", fmt.Errorf("invalid downtime %q: %w", downtime, err)
+	}
+	recovery, err := time.ParseDuration(recoveryTimeout)
+	if err != nil {
+		return "", fmt.Errorf("invalid recovery timeout %q: %w", recoveryTimeout, err)
+	}
+	if killAfter <= 0 || killAfter >= writes {
+		return "", fmt.Errorf("kill-after must be between 0 and writes")
+	}
+
+	// A fresh volume per run, so XTDB comes back with exactly what it had
+	// acknowledged before the kill
+	run := time.Now().UnixNano()
+	data := dag.CacheVolume(fmt.Sprintf("xtdb-fault-%d", run))
+	xtdb, err := m.BuildXTDB().
+		WithMountedCache(xtdbDataDir, data).
+		AsService().
+		Start(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to start XTDB: %w", err)
+	}
+	client, err := newXtdbClient(ctx, xtdb)
+	if err != nil {
+		return "", err
+	}
+	// The volume is never reused, so free it once the scenario is over
+	defer func() {
+		xtdb.Stop(ctx)
+		emptyCache(ctx, data)
+	}()
+	if err := client.waitReady(ctx, 60); err != nil {
+		return "", err
+	}
+
+	fmt.Println("📦 Building web application...")
+	app, err := m.BuildCljWebApp(srcDir).
+		WithEnvVariable("XTDB_HOST", "xtdb").
+		WithServiceBinding("xtdb", xtdb).
+		AsService().
+		Start(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to start the app: %w", err)
+	}
+	appURL, err := app.Endpoint(ctx, dagger.ServiceEndpointOpts{Port: 58950, Scheme: "http"})
+	if err != nil {
+		return "", err
+	}
+	appURL = strings.TrimSuffix(appURL, "/")
+
+	// The app acknowledges a created item with a redirect
+	web := &http.Client{
+		Timeout:       10 * time.Second,
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	create := func(name string) bool {
+		resp, err := web.PostForm(appURL+"/items", url.Values{"name": {name}})
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusSeeOther
+	}
+
+	var acked []string
+	failed := 0
+	restarted := make(chan error, 1)
+	prefix := fmt.Sprintf("fault-%d-", run)
+	fmt.Printf("✍️  Creating %d items, killing XTDB after %d...\n", writes, killAfter)
+	for i := 0; i < writes; i++ {
+		if i == killAfter {
+			fmt.Printf("💥 Killing XTDB for %s...\n", down)
+			if _, err := xtdb.Stop(ctx, dagger.ServiceStopOpts{Kill: true}); err != nil {
+				return "", fmt.Errorf("failed to kill XTDB: %w", err)
+			}
+			go func() {
+				time.Sleep(down)
+				fmt.Println("🔄 Restarting XTDB...")
+				_, err := xtdb.Start(ctx)
+				restarted <- err
+			}()
+		}
+		name := fmt.Sprintf("%s%04d", prefix, i)
+		if create(name) {
+			acked = append(acked, name)
+		} else {
+			failed++
+		}
+		// Spread the writes so some land while XTDB is down
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err := <-restarted; err != nil {
+		return "", fmt.Errorf("failed to restart XTDB: %w", err)
+	}
+
+	fmt.Println("⏳ Waiting for the app to reconnect...")
+	reconnected := false
+	for deadline := time.Now().Add(recovery); time.Now().Before(deadline); time.Sleep(2 * time.Second) {
+		name := prefix + "recovery"
+		if create(name) {
+			acked = append(acked, name)
+			reconnected = true
+			break
+		}
+	}
+	if !reconnected {
+		return "", fmt.Errorf("the app did not reconnect to XTDB within %s", recovery)
+	}
+
+	// Indexing catches up asynchronously after the restart
+	if err := client.waitReady(ctx, 60); err != nil {
+		return "", err
+	}
+	var missing []string
+	for attempt := 0; attempt < 30; attempt++ {
+		rows, err := client.query(ctx, "SELECT name FROM items")
+		if err != nil {
+			return "", err
+		}
+		stored := map[string]bool{}
+		for _, row := range rows {
+			if name, ok := xtdbValue(row["name"]).(string); ok {
+				stored[name] = true
+			}
+		}
+		missing = missing[:0]
+		for _, name := range acked {
+			if !stored[name] {
+				missing = append(missing, name)
+			}
+		}
+		if len(missing) == 0 {
+			break
+		}
+		time.Sleep(2 * time.Second)
+	}
+
+	report := fmt.Sprintf("%d writes acknowledged, %d rejected while XTDB was down, app reconnected\n", len(acked), failed)
+	if len(missing) > 0 {
+		return report, fmt.Errorf("%d acknowledged writes were lost across the XTDB restart, e.g. %s", len(missing), missing[0])
+	}
+	fmt.Println("✅ No acknowledged writes lost")
+	return report, nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// serviceNode is a service of a local environment and the services it needs
+// running first
+type serviceNode struct {
+	Name      string
+	DependsOn []string
+	// Start starts the service, given its dependencies already running and
+	// ready, by name
+	Start func(ctx context.Context, deps map[string]*dagger.Service) (*dagger.Service, error)
+	// Shell command that succeeds once the service is ready, run with the
+	// service bound under its name, e.g. redis-cli -h redis ping
+	Readiness string
+	// Image the readiness command runs in (default alpine)
+	ProbeImage string
+}
+
+// serviceGraph starts the services of a local environment in dependency
+// order, each as soon as its dependencies are ready, so independent
+// services start in parallel
+type serviceGraph struct {
+	nodes []serviceNode
+	// How starts that fail transiently, e.g. on an image pull, are retried
+	retry retryPolicy
+}
+
+// add adds a service to the graph
+func (g *serviceGraph) add(node serviceNode) {
+	g.nodes = append(g.nodes, node)
+}
+
+// check rejects duplicate services, dependencies on unknown services and
+// dependency cycles
+func (g *serviceGraph) check() error {
+	byName := map[string]serviceNode{}
+	for _, node := range g.nodes {
+		if _, ok := byName[node.Name]; ok {
+			return fmt.Errorf("service %q is defined twice", node.Name)
+		}
+		byName[node.Name] = node
+	}
+
+	state := map[string]int{} // 1 visiting, 2 done
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case 1:
+			return fmt.Errorf("services depend on each other: %s", strings.Join(append(path, name), " -> "))
+		case 2:
+			return nil
+		}
+		state[name] = 1
+		for _, dep := range byName[name].DependsOn {
+			if _, ok := byName[dep]; !ok {
+				return fmt.Errorf("service %q depends on unknown service %q", name, dep)
+			}
+			if err := visit(dep, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = 2
+		return nil
+	}
+	for _, node := range g.nodes {
+		if err := visit(node.Name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// start starts every service of the graph and waits for each to be ready,
+// recording them in down as they run. On the first failure the services
+// not yet started are skipped.
+func (g *serviceGraph) start(ctx context.Context, down *teardown) (map[string]*dagger.Service, error) {
+	if err := g.check(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ready := map[string]chan struct{}{}
+	for _, node := range g.nodes {
+		ready[node.Name] = make(chan struct{})
+	}
+	var mu sync.Mutex
+	started := map[string]*dagger.Service{}
+	var failure error
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if failure == nil {
+			failure = err
+			cancel()
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, node := range g.nodes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			deps := map[string]*dagger.Service{}
+			for _, dep := range node.DependsOn {
+				select {
+				case <-ready[dep]:
+				case <-ctx.Done():
+					return
+				}
+				mu.Lock()
+				deps[dep] = started[dep]
+				mu.Unlock()
+			}
+
+			fmt.Printf("🔄 Starting %s service...\n", node.Name)
+			var svc *dagger.Service
+			err := g.retry.do(ctx, "starting "+node.Name, func(ctx context.Context) error {
+				var err error
+				svc, err = node.Start(ctx, deps)
+				return err
+			})
+			if err != nil {
+				fail(fmt.Errorf("failed to start %s: %w", node.Name, err))
+				return
+			}
+			down.started(node.Name, svc)
+			if node.Readiness != "" {
+				if err := probe(ctx, node, svc); err != nil {
+					fail(err)
+					return
+				}
+			}
+			mu.Lock()
+			started[node.Name] = svc
+			mu.Unlock()
+			fmt.Printf("✅ %s service started successfully\n", node.Name)
+			close(ready[node.Name])
+		}()
+	}
+	wg.Wait()
+
+	if failure != nil {
+		return nil, failure
+	}
+	// A cancelled caller leaves services unstarted without a failure
+	if err := ctx.Err(); err != nil && len(started) < len(g.nodes) {
+		return nil, err
+	}
+	return started, nil
+}
+
+// probe waits up to a minute for a service's readiness command to succeed
+func probe(ctx context.Context, node serviceNode, svc *dagger.Service) error {
+	image := node.ProbeImage
+	if image == "" {
+		image = "alpine:3.21"
+	}
+	fmt.Printf("⏳ Waiting for %s to be ready...\n", node.Name)
+	_, err := dag.Container().From(image).
+		WithServiceBinding(node.Name, svc).
+		WithEnvVariable("CACHE_BUSTER", time.Now().String()).
+		WithExec([]string{"timeout", "60", "sh", "-c", "until " + node.Readiness + "; do sleep 1; done"}).
+		Sync(ctx)
+	if err != nil {
+		return fmt.Errorf("%s did not become ready: %w", node.Name, err)
+	}
+	return nil
+}
+
+// xtdbNode is the XTDB service of a local environment, ready once its HTTP
+// API answers
+func xtdbNode(xtdb *dagger.Service) serviceNode {
+	return serviceNode{
+		Name:      "xtdb",
+		Readiness: "wget -q -O /dev/null http://xtdb:3000/status",
+		Start: func(ctx context.Context, _ map[string]*dagger.Service) (*dagger.Service, error) {
+			return xtdb.Start(ctx)
+		},
+	}
+}
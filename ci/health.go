@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// ecsServices is the subset of `aws ecs describe-services` we report on
+type ecsServices struct {
+	Services []struct {
+		ServiceName  string `json:"serviceName"`
+		Status       string `json:"status"`
+		DesiredCount int    `json:"desiredCount"`
+		RunningCount int    `json:"runningCount"`
+		PendingCount int    `json:"pendingCount"`
+		Deployments  []struct {
+			Status       string `json:"status"`
+			RolloutState string `json:"rolloutState"`
+		} `json:"deployments"`
+		LoadBalancers []struct {
+			TargetGroupArn string `json:"targetGroupArn"`
+		} `json:"loadBalancers"`
+	} `json:"services"`
+}
+
+// targetHealth is the subset of `aws elbv2 describe-target-health` we report on
+type targetHealth struct {
+	TargetHealthDescriptions []struct {
+		Target struct {
+			ID   string `json:"Id"`
+			Port int    `json:"Port"`
+		} `json:"Target"`
+		TargetHealth struct {
+			State  string `json:"State"`
+			Reason string `json:"Reason"`
+		} `json:"TargetHealth"`
+	} `json:"TargetHealthDescriptions"`
+}
+
+// cloudWatchAlarms is the subset of `aws cloudwatch describe-alarms` we report on
+type cloudWatchAlarms struct {
+	MetricAlarms []struct {
+		AlarmName             string `json:"AlarmName"`
+		StateReason           string `json:"StateReason"`
+		StateUpdatedTimestamp string `json:"StateUpdatedTimestamp"`
+	} `json:"MetricAlarms"`
+}
+
+// healthReport collects report lines and whether anything was unhealthy
+type healthReport struct {
+	lines     strings.Builder
+	unhealthy int
+}
+
+func (r *healthReport) ok(format string, args ...any) {
+	fmt.Fprintf(&r.lines, "  ✅ "+format+"\n", args...)
+}
+
+func (r *healthReport) fail(format string, args ...any) {
+	r.unhealthy++
+	fmt.Fprintf(&r.lines, "  ❌ "+format+"\n", args...)
+}
+
+func (r *healthReport) section(title string) {
+	fmt.Fprintf(&r.lines, "\n%s\n", title)
+}
+
+// EnvHealth prints a consolidated health report for a deployed environment: ECS services, ALB targets, alarms and XTDB status
+func (m *CljXtdbDevops) EnvHealth(
+	ctx context.Context,
+	// Environment name, e.g. staging
+	env string,
+	// Shared AWS credentials file
+	awsCreds *dagger.Secret,
+	// XTDB endpoint reachable from the engine, e.g. tcp://xtdb.staging.internal:3000
+	// +optional
+	xtdb *dagger.Service,
+	// ECS cluster name (defaults to clj-xtdb-devops-<env>)
+	// +optional
+	cluster string,
+	// +optional
+	// +default="us-east-1"
+	region string,
+	// +optional
+	profile string,
+) (string, error) {
+	if cluster == "" {
+		cluster = envPrefix(env)
+	}
+	cli := awsCli(awsCreds, region, profile)
+	report := &healthReport{}
+	fmt.Printf("🩺 Checking health of %s...\n", env)
+
+	report.section("ECS services (" + cluster + ")")
+	arns, err := awsOutput(ctx, cli, "ecs", "list-services", "--cluster", cluster, "--query", "serviceArns", "--output", "text")
+	if err != nil {
+		return "", err
+	}
+	var targetGroups []string
+	if services := strings.Fields(arns); len(services) == 0 || arns == "None" {
+		report.fail("no services found")
+	} else {
+		var described ecsServices
+		if err := awsJSON(ctx, cli, &described, append([]string{"ecs", "describe-services", "--cluster", cluster, "--services"}, services...)...); err != nil {
+			return "", err
+		}
+		for _, svc := range described.Services {
+			rollout := "no deployment"
+			if len(svc.Deployments) > 0 {
+				rollout = strings.ToLower(svc.Deployments[0].RolloutState)
+			}
+			if svc.Status == "ACTIVE" && svc.RunningCount >= svc.DesiredCount && rollout != "failed" {
+				report.ok("%s: %d/%d running, deployment %s", svc.ServiceName, svc.RunningCount, svc.DesiredCount, rollout)
+			} else {
+				report.fail("%s: %s, %d/%d running (%d pending), deployment %s",
+					svc.ServiceName, svc.Status, svc.RunningCount, svc.DesiredCount, svc.PendingCount, rollout)
+			}
+			for _, lb := range svc.LoadBalancers {
+				targetGroups = append(targetGroups, lb.TargetGroupArn)
+			}
+		}
+	}
+
+	report.section("Load balancer targets")
+	if len(targetGroups) == 0 {
+		report.ok("no target groups attached")
+	}
+	for _, tg := range targetGroups {
+		var health targetHealth
+		if err := awsJSON(ctx, cli, &health, "elbv2", "describe-target-health", "--target-group-arn", tg); err != nil {
+			return "", err
+		}
+		name := tg[strings.LastIndex(tg, ":")+1:]
+		if len(health.TargetHealthDescriptions) == 0 {
+			report.fail("%s: no registered targets", name)
+		}
+		for _, t := range health.TargetHealthDescriptions {
+			if t.TargetHealth.State == "healthy" {
+				report.ok("%s %s:%d healthy", name, t.Target.ID, t.Target.Port)
+			} else {
+				report.fail("%s %s:%d %s %s", name, t.Target.ID, t.Target.Port, t.TargetHealth.State, t.TargetHealth.Reason)
+			}
+		}
+	}
+
+	report.section("CloudWatch alarms")
+	var alarms cloudWatchAlarms
+	if err := awsJSON(ctx, cli, &alarms, "cloudwatch", "describe-alarms",
+		"--alarm-name-prefix", envPrefix(env), "--state-value", "ALARM"); err != nil {
+		return "", err
+	}
+	if len(alarms.MetricAlarms) == 0 {
+		report.ok("no alarms firing")
+	}
+	for _, alarm := range alarms.MetricAlarms {
+		report.fail("%s since %s: %s", alarm.AlarmName, alarm.StateUpdatedTimestamp, alarm.StateReason)
+	}
+
+	if xtdb != nil {
+		report.section("XTDB")
+		client, err := newXtdbClient(ctx, xtdb)
+		if err != nil {
+			return "", err
+		}
+		if status, err := client.status(ctx); err != nil {
+			report.fail("status endpoint: %v", err)
+		} else {
+			submitted, err1 := txID(status["latestSubmittedTx"])
+			completed, err2 := txID(status["latestCompletedTx"])
+			if err := errors.Join(err1, err2); err != nil {
+				report.fail("status endpoint: %v", err)
+			} else {
+				report.ok("status endpoint up, latest submitted tx %d, indexed %d", submitted, completed)
+			}
+		}
+	}
+
+	verdict := fmt.Sprintf("✅ %s is healthy", env)
+	if report.unhealthy > 0 {
+		verdict = fmt.Sprintf("❌ %s has %d problem(s)", env, report.unhealthy)
+	}
+	return verdict + "\n" + report.lines.String(), nil
+}
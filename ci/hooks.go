@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// hookCheck is a module function a git hook runs through `dagger call`
+type hookCheck struct {
+	Name string
+	Call string
+}
+
+// hookScript renders a hook that runs each check from the repository root
+func hookScript(hook string, checks []hookCheck) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "#!/bin/sh\n# %s hook generated by `dagger call install-git-hooks`\nset -e\n\n", hook)
+	b.WriteString("cd \"$(git rev-parse --show-toplevel)\"\n")
+	for _, check := range checks {
+		fmt.Fprintf(&b, "\necho \"==> %s\"\ndagger call %s\n", check.Name, check.Call)
+	}
+	return b.String()
+}
+
+// preCommitConfig renders a pre-commit framework config running the same checks
+func preCommitConfig(checks map[string][]hookCheck) string {
+	var b strings.Builder
+	b.WriteString("# Generated by `dagger call install-git-hooks`\nrepos:\n  - repo: local\n    hooks:\n")
+	for _, stage := range []string{"pre-commit", "pre-push"} {
+		for _, check := range checks[stage] {
+			id := strings.ReplaceAll(strings.ToLower(check.Name), " ", "-")
+			fmt.Fprintf(&b, "      - id: %s-%s\n        name: %s\n        entry: dagger call %s\n        language: system\n        pass_filenames: false\n        stages: [%s]\n",
+				stage, id, check.Name, check.Call, stage)
+		}
+	}
+	return b.String()
+}
+
+// gitHookChecks lists the checks of each hook. The security TODO search
+// stays inside the application, since the module's own sources name the
+// marker.
+func gitHookChecks(appDir string) map[string][]hookCheck {
+	return map[string][]hookCheck{
+		"pre-commit": {
+			{Name: "Lint", Call: fmt.Sprintf("lint --src-dir %s", appDir)},
+			{Name: "Format check", Call: fmt.Sprintf("format-check --src-dir %s", appDir)},
+			{Name: "Secret scan", Call: fmt.Sprintf("secret-scan --src-dir %s", appDir)},
+		},
+		"pre-push": {
+			{Name: "Secret scan", Call: fmt.Sprintf("secret-scan --src-dir %s", appDir)},
+			{Name: "Security TODOs", Call: fmt.Sprintf("search --dir %s --pattern TODO-SECURITY --fail-on-match", appDir)},
+		},
+	}
+}
+
+// InstallGitHooks emits pre-commit/pre-push hooks running the module's checks; export them and set core.hooksPath
+func (m *CljXtdbDevops) InstallGitHooks(
+	// Path of the Clojure application relative to the repository root
+	// +optional
+	// +default="my-app"
+	appDir string,
+) *dagger.Directory {
+	checks := gitHookChecks(appDir)
+	hooks := dag.Directory()
+	for _, hook := range []string{"pre-commit", "pre-push"} {
+		hooks = hooks.WithNewFile(hook, hookScript(hook, checks[hook]), dagger.DirectoryWithNewFileOpts{Permissions: 0o755})
+	}
+	return hooks.
+		WithNewFile(".pre-commit-config.yaml", preCommitConfig(checks)).
+		WithNewFile("README", "Install with:\n\n"+
+			"  dagger call install-git-hooks export --path .githooks\n"+
+			"  git config core.hooksPath .githooks\n\n"+
+			"or copy .pre-commit-config.yaml to the repository root and run `pre-commit install --hook-type pre-commit --hook-type pre-push`.\n")
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestSecurityTodoHook runs the pre-push security TODO search over the
+// repository the way ripgrep does, so the hook can't fail on a clean tree
+func TestSecurityTodoHook(t *testing.T) {
+	var call string
+	for _, check := range gitHookChecks("my-app")["pre-push"] {
+		if check.Name == "Security TODOs" {
+			call = check.Call
+		}
+	}
+	fields := strings.Fields(call)
+	if len(fields) == 0 || fields[0] != "search" {
+		t.Fatalf("the pre-push hook has no security TODO search: %q", call)
+	}
+	flags := flag.NewFlagSet("search", flag.ContinueOnError)
+	dir := flags.String("dir", "", "")
+	pattern := flags.String("pattern", "", "")
+	flags.Bool("fail-on-match", false, "")
+	if err := flags.Parse(fields[1:]); err != nil {
+		t.Fatal(err)
+	}
+
+	root := filepath.Join("..", *dir)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if bytes.Contains(data, []byte(*pattern)) {
+			t.Errorf("%s contains %s, so the pre-push hook fails", path, *pattern)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
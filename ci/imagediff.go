@@ -0,0 +1,233 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// imageLayer is a layer of an image's OCI manifest
+type imageLayer struct {
+	Digest string `json:"digest"`
+	Size   int    `json:"size"`
+}
+
+// imageSnapshot is what ImageDiff compares of an image
+type imageSnapshot struct {
+	size     int
+	layers   []imageLayer
+	files    map[string]int
+	packages map[string]string
+}
+
+// listFiles prints the size and path of every file and symlink of /rootfs
+const listFiles = `cd /rootfs && find . -xdev \( -type f -o -type l \) -exec stat -c '%s %n' {} +`
+
+// listPackages prints the name and version of every dpkg or apk package of /rootfs
+const listPackages = `if [ -f /rootfs/var/lib/dpkg/status ]; then
+  awk '/^Package:/ {p = $2} /^Version:/ {print p, $2}' /rootfs/var/lib/dpkg/status
+elif [ -f /rootfs/lib/apk/db/installed ]; then
+  awk -F: '/^P:/ {p = $2} /^V:/ {print p, $2}' /rootfs/lib/apk/db/installed
+fi`
+
+// ociManifest reads the manifest of an OCI layout, following an image index
+// to its first manifest
+func ociManifest(ctx context.Context, layout *dagger.Directory) ([]imageLayer, error) {
+	var index struct {
+		Manifests []struct {
+			Digest string `json:"digest"`
+		} `json:"manifests"`
+		Layers []imageLayer `json:"layers"`
+	}
+	blob := layout.File("index.json")
+	for hops := 0; hops < 3; hops++ {
+		contents, err := blob.Contents(ctx)
+		if err != nil {
+			return nil, err
+		}
+		index.Manifests, index.Layers = nil, nil
+		if err := json.Unmarshal([]byte(contents), &index); err != nil {
+			return nil, fmt.Errorf("failed to decode the image manifest: %w", err)
+		}
+		if len(index.Manifests) == 0 {
+			return index.Layers, nil
+		}
+		blob = layout.File("blobs/" + strings.Replace(index.Manifests[0].Digest, ":", "/", 1))
+	}
+	return nil, fmt.Errorf("the image manifest is nested too deeply")
+}
+
+// snapshotImage lists the layers, files and packages of an image. Sizes are
+// uncompressed, as the image takes up on disk once pulled.
+func snapshotImage(ctx context.Context, ctr *dagger.Container) (*imageSnapshot, error) {
+	tarball := ctr.AsTarball(dagger.ContainerAsTarballOpts{ForcedCompression: dagger.ImageLayerCompressionUncompressed})
+	size, err := tarball.Size(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export the image: %w", err)
+	}
+	inspect := dag.Container().From("alpine:3.21").
+		WithMountedFile("/image.tar", tarball).
+		WithMountedDirectory("/rootfs", ctr.Rootfs())
+	layers, err := ociManifest(ctx, inspect.
+		WithExec([]string{"sh", "-c", "mkdir /oci && tar -xf /image.tar -C /oci"}).
+		Directory("/oci"))
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &imageSnapshot{size: size, layers: layers, files: map[string]int{}, packages: map[string]string{}}
+	files, err := inspect.WithExec([]string{"sh", "-c", listFiles}).Stdout(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the image's files: %w", err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(files), "\n") {
+		bytes, path, ok := strings.Cut(line, " ")
+		if n, err := strconv.Atoi(bytes); ok && err == nil {
+			snapshot.files[strings.TrimPrefix(path, ".")] = n
+		}
+	}
+	packages, err := inspect.WithExec([]string{"sh", "-c", listPackages}).Stdout(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the image's packages: %w", err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(packages), "\n") {
+		if name, version, ok := strings.Cut(line, " "); ok {
+			snapshot.packages[name] = version
+		}
+	}
+	return snapshot, nil
+}
+
+// formatDelta formats a change in bytes with its sign
+func formatDelta(bytes int) string {
+	sign := "+"
+	if bytes < 0 {
+		sign, bytes = "-", -bytes
+	}
+	if bytes < 1024 {
+		return fmt.Sprintf("%s%d B", sign, bytes)
+	}
+	return sign + formatKiB(bytes/1024)
+}
+
+// fileChange is a file added, removed or resized between two images
+type fileChange struct {
+	Path  string
+	Delta int
+	Mark  string
+}
+
+// ImageDiff compares two builds of an image, e.g. main's and a pull
+// request's, by size, layers, installed packages and files, to catch
+// accidental bloat or dropped files
+func (m *CljXtdbDevops) ImageDiff(
+	ctx context.Context,
+	// Image before, e.g. built from main
+	a *dagger.Container,
+	// Image after, e.g. built from the pull request
+	b *dagger.Container,
+	// Number of changed files listed
+	// +optional
+	// +default=20
+	limit int,
+) (string, error) {
+	fmt.Println("🔍 Comparing images...")
+	before, err := snapshotImage(ctx, a)
+	if err != nil {
+		return "", err
+	}
+	after, err := snapshotImage(ctx, b)
+	if err != nil {
+		return "", err
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "Image size: %s → %s (%s)\n",
+		formatKiB(before.size/1024), formatKiB(after.size/1024), formatDelta(after.size-before.size))
+
+	// Layers shared by both images have the same digest
+	fmt.Fprintf(&out, "\nLayers: %d → %d\n", len(before.layers), len(after.layers))
+	shared := map[string]bool{}
+	for _, layer := range before.layers {
+		shared[layer.Digest] = true
+	}
+	kept := map[string]bool{}
+	for _, layer := range after.layers {
+		kept[layer.Digest] = true
+		if !shared[layer.Digest] {
+			fmt.Fprintf(&out, "  + %.19s %s\n", layer.Digest, formatKiB(layer.Size/1024))
+		}
+	}
+	for _, layer := range before.layers {
+		if !kept[layer.Digest] {
+			fmt.Fprintf(&out, "  - %.19s %s\n", layer.Digest, formatKiB(layer.Size/1024))
+		}
+	}
+
+	var pkgLines []string
+	for _, name := range slices.Sorted(maps.Keys(after.packages)) {
+		switch old, ok := before.packages[name]; {
+		case !ok:
+			pkgLines = append(pkgLines, fmt.Sprintf("  + %s %s", name, after.packages[name]))
+		case old != after.packages[name]:
+			pkgLines = append(pkgLines, fmt.Sprintf("  ~ %s %s → %s", name, old, after.packages[name]))
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(before.packages)) {
+		if _, ok := after.packages[name]; !ok {
+			pkgLines = append(pkgLines, fmt.Sprintf("  - %s %s", name, before.packages[name]))
+		}
+	}
+	fmt.Fprintf(&out, "\nPackages: %d → %d, %d changes\n", len(before.packages), len(after.packages), len(pkgLines))
+	for _, line := range pkgLines {
+		out.WriteString(line + "\n")
+	}
+
+	var changes []fileChange
+	added, removed := 0, 0
+	for path, size := range after.files {
+		old, ok := before.files[path]
+		switch {
+		case !ok:
+			added++
+			changes = append(changes, fileChange{path, size, "+"})
+		case old != size:
+			changes = append(changes, fileChange{path, size - old, "~"})
+		}
+	}
+	for path, size := range before.files {
+		if _, ok := after.files[path]; !ok {
+			removed++
+			changes = append(changes, fileChange{path, -size, "-"})
+		}
+	}
+	// Biggest changes first, so bloat and large dropped files lead
+	slices.SortFunc(changes, func(x, y fileChange) int {
+		return cmp.Or(cmp.Compare(abs(y.Delta), abs(x.Delta)), strings.Compare(x.Path, y.Path))
+	})
+	fmt.Fprintf(&out, "\nFiles: %d → %d, %d added, %d removed, %d resized\n",
+		len(before.files), len(after.files), added, removed, len(changes)-added-removed)
+	for i, change := range changes {
+		if i == limit {
+			fmt.Fprintf(&out, "  … %d more\n", len(changes)-limit)
+			break
+		}
+		fmt.Fprintf(&out, "  %s %s (%s)\n", change.Mark, change.Path, formatDelta(change.Delta))
+	}
+	return out.String(), nil
+}
+
+// abs returns the absolute value of n
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
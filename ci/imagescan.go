@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// trivyImage is the Trivy release base images are scanned with
+const trivyImage = "aquasec/trivy:0.58.1"
+
+// trivyCacheVolume keeps Trivy's vulnerability database between scans
+const trivyCacheVolume = "clj-xtdb-devops-trivy"
+
+// imagesLock lists the third-party images run on developer machines and in AWS
+//
+//go:embed images.lock
+var imagesLock string
+
+// severities are Trivy's severities, lowest first
+var severities = []string{"UNKNOWN", "LOW", "MEDIUM", "HIGH", "CRITICAL"}
+
+// trivyReport is the part of Trivy's JSON report the scan summarizes
+type trivyReport struct {
+	Results []struct {
+		Vulnerabilities []struct {
+			VulnerabilityID  string `json:"VulnerabilityID"`
+			PkgName          string `json:"PkgName"`
+			InstalledVersion string `json:"InstalledVersion"`
+			FixedVersion     string `json:"FixedVersion"`
+			Severity         string `json:"Severity"`
+		} `json:"Vulnerabilities"`
+	} `json:"Results"`
+}
+
+// parseImagesLock reads image references, one per line, skipping blank
+// lines and # comments
+func parseImagesLock(lock string) []string {
+	var images []string
+	for _, line := range strings.Split(lock, "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		if line = strings.TrimSpace(line); line != "" && !slices.Contains(images, line) {
+			images = append(images, line)
+		}
+	}
+	return images
+}
+
+// lockedImage returns the reference images.lock pins for a repository, so
+// the module runs exactly the image that is scanned
+func lockedImage(repository string) string {
+	for _, image := range parseImagesLock(imagesLock) {
+		if strings.HasPrefix(image, repository+":") || strings.HasPrefix(image, repository+"@") {
+			return image
+		}
+	}
+	panic(repository + " is not in images.lock")
+}
+
+// ScanBaseImages scans every image of images.lock, the third-party images
+// the local environments and the infra stack run, for known CVEs. It fails
+// if any image has a vulnerability of failOn severity or above.
+func (m *CljXtdbDevops) ScanBaseImages(
+	ctx context.Context,
+	// Image list to scan instead of images.lock, in the same format
+	// +optional
+	lockfile *dagger.File,
+	// Lowest severity that fails the scan: LOW, MEDIUM, HIGH or CRITICAL
+	// +optional
+	// +default="CRITICAL"
+	failOn string,
+	// Leave out vulnerabilities without a fixed version
+	// +optional
+	ignoreUnfixed bool,
+) (string, error) {
+	threshold := slices.Index(severities, strings.ToUpper(failOn))
+	if threshold < 1 {
+		return "", fmt.Errorf("invalid severity %q, want LOW, MEDIUM, HIGH or CRITICAL", failOn)
+	}
+	lock := imagesLock
+	if lockfile != nil {
+		contents, err := lockfile.Contents(ctx)
+		if err != nil {
+			return "", fmt.Errorf("failed to read the image list: %w", err)
+		}
+		lock = contents
+	}
+	images := parseImagesLock(lock)
+	if len(images) == 0 {
+		return "", fmt.Errorf("no images to scan")
+	}
+
+	trivy := dag.Container().From(trivyImage).
+		WithMountedCache("/root/.cache/trivy", dag.CacheVolume(trivyCacheVolume)).
+		// New CVEs are published daily, so never reuse an old scan
+		WithEnvVariable("CACHE_BUSTER", time.Now().String()).
+		WithoutEntrypoint()
+
+	var b strings.Builder
+	failed := 0
+	fmt.Fprintf(&b, "Scanned %d base images, failing on %s and above\n", len(images), severities[threshold])
+	for _, image := range images {
+		fmt.Printf("🔍 Scanning %s...\n", image)
+		args := []string{"trivy", "image", "--quiet", "--scanners", "vuln", "--format", "json", "--output", "/tmp/report.json"}
+		if ignoreUnfixed {
+			args = append(args, "--ignore-unfixed")
+		}
+		out, err := trivy.
+			WithExec(append(args, image)).
+			File("/tmp/report.json").
+			Contents(ctx)
+		if err != nil {
+			return "", fmt.Errorf("failed to scan %s: %w", image, err)
+		}
+		var report trivyReport
+		if err := json.Unmarshal([]byte(out), &report); err != nil {
+			return "", fmt.Errorf("failed to decode the scan of %s: %w", image, err)
+		}
+
+		counts := make([]int, len(severities))
+		fixable := 0
+		var blocking []string
+		seen := map[string]bool{}
+		for _, result := range report.Results {
+			for _, v := range result.Vulnerabilities {
+				// The same CVE shows up once per affected package
+				key := v.VulnerabilityID + " " + v.PkgName
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				level := max(slices.Index(severities, v.Severity), 0)
+				counts[level]++
+				if v.FixedVersion != "" {
+					fixable++
+				}
+				if level >= threshold {
+					fix := "no fix yet"
+					if v.FixedVersion != "" {
+						fix = "fixed in " + v.FixedVersion
+					}
+					blocking = append(blocking, fmt.Sprintf("    %s (%s) %s %s, %s", v.VulnerabilityID, v.Severity, v.PkgName, v.InstalledVersion, fix))
+				}
+			}
+		}
+
+		verdict := "✅"
+		if len(blocking) > 0 {
+			verdict = "❌"
+			failed++
+		}
+		fmt.Fprintf(&b, "%s %s: %d critical, %d high, %d medium, %d low (%d fixable)\n",
+			verdict, image, counts[4], counts[3], counts[2], counts[1], fixable)
+		for _, line := range blocking {
+			b.WriteString(line + "\n")
+		}
+	}
+
+	if failed > 0 {
+		// A failed call prints only the error, so it carries the report
+		return b.String(), fmt.Errorf("%d of %d base images have %s or worse vulnerabilities\n%s", failed, len(images), severities[threshold], b.String())
+	}
+	fmt.Println("✅ No base image has blocking vulnerabilities")
+	return b.String(), nil
+}
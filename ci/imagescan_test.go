@@ -0,0 +1,15 @@
+package main
+
+import "testing"
+
+func TestLockedImage(t *testing.T) {
+	if got, want := lockedImage("public.ecr.aws/aws-cli/aws-cli"), "public.ecr.aws/aws-cli/aws-cli:2.22.35"; got != want {
+		t.Errorf("lockedImage() = %q, want %q", got, want)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("lockedImage() of an image missing from images.lock did not panic")
+		}
+	}()
+	lockedImage("amazon/aws-cli")
+}
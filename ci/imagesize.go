@@ -0,0 +1,72 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// bytesPerMB is a megabyte as docker images counts them
+const bytesPerMB = 1000 * 1000
+
+// checkImageSize fails if an image's uncompressed size exceeds maxMB,
+// listing its largest layers and files so the bloat can be tracked down
+func checkImageSize(ctx context.Context, ctr *dagger.Container, maxMB int, limit int) (string, error) {
+	fmt.Printf("📏 Checking image size against its %d MB budget...\n", maxMB)
+	snapshot, err := snapshotImage(ctx, ctr)
+	if err != nil {
+		return "", err
+	}
+	size := float64(snapshot.size) / bytesPerMB
+	if snapshot.size <= maxMB*bytesPerMB {
+		report := fmt.Sprintf("Image size: %.0f MB of a %d MB budget\n", size, maxMB)
+		fmt.Print("✅ " + report)
+		return report, nil
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Image size: %.0f MB, over its %d MB budget by %.0f MB\n", size, maxMB, size-float64(maxMB))
+
+	layers := slices.Clone(snapshot.layers)
+	slices.SortStableFunc(layers, func(x, y imageLayer) int { return cmp.Compare(y.Size, x.Size) })
+	fmt.Fprintf(&b, "\nLargest layers of %d:\n", len(layers))
+	for _, layer := range layers[:min(limit, len(layers))] {
+		fmt.Fprintf(&b, "  %.19s %s\n", layer.Digest, formatKiB(layer.Size/1024))
+	}
+
+	paths := make([]string, 0, len(snapshot.files))
+	for path := range snapshot.files {
+		paths = append(paths, path)
+	}
+	slices.SortFunc(paths, func(x, y string) int {
+		return cmp.Or(cmp.Compare(snapshot.files[y], snapshot.files[x]), strings.Compare(x, y))
+	})
+	fmt.Fprintf(&b, "\nLargest files of %d:\n", len(paths))
+	for _, path := range paths[:min(limit, len(paths))] {
+		fmt.Fprintf(&b, "  %s %s\n", path, formatKiB(snapshot.files[path]/1024))
+	}
+	// A failed call prints only the error, so it carries the report
+	return b.String(), fmt.Errorf("image exceeds its size budget\n%s", b.String())
+}
+
+// CheckImageSize fails if an image is larger than maxImageSizeMb
+// uncompressed, listing its largest layers and files
+func (m *CljXtdbDevops) CheckImageSize(
+	ctx context.Context,
+	container *dagger.Container,
+	// Size budget of the uncompressed image, in MB
+	maxImageSizeMb int,
+	// Number of layers and files listed
+	// +optional
+	// +default=10
+	limit int,
+) (string, error) {
+	if maxImageSizeMb < 1 {
+		return "", fmt.Errorf("max-image-size-mb must be at least 1")
+	}
+	return checkImageSize(ctx, container, maxImageSizeMb, limit)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// Toolchain versions used to synthesize and plan the CDKTF stacks
+const (
+	cdktfVersion     = "0.20.11"
+	terraformVersion = "1.10.4"
+)
+
+// cdktfContainer returns a container with Go, the CDKTF CLI and Terraform
+// with the infra module mounted and synthesized into cdktf.out
+func cdktfContainer(infraDir *dagger.Directory) *dagger.Container {
+	terraform := dag.Container().From("hashicorp/terraform:" + terraformVersion).File("/bin/terraform")
+
+	return dag.Container().From("golang:1.23-bookworm").
+		WithExec([]string{"sh", "-c", "apt-get update && apt-get install -y --no-install-recommends nodejs npm && rm -rf /var/lib/apt/lists/*"}).
+		WithExec([]string{"npm", "install", "-g", "cdktf-cli@" + cdktfVersion}).
+		WithFile("/usr/local/bin/terraform", terraform).
+		WithMountedCache("/go/pkg/mod", dag.CacheVolume(goModCacheVolume)).
+		WithMountedCache("/root/.cache/go-build", dag.CacheVolume(goBuildCacheVolume)).
+		WithEnvVariable("CHECKPOINT_DISABLE", "1").
+		WithDirectory("/infra", infraDir).
+		WithWorkdir("/infra").
+		WithExec([]string{"cdktf", "synth"})
+}
+
+// SynthInfra synthesizes every CDKTF stack and returns cdktf.out, so a stack
+// that no longer compiles or synthesizes fails the build before anything is
+// planned or deployed
+func (m *CljXtdbDevops) SynthInfra(infraDir *dagger.Directory) *dagger.Directory {
+	fmt.Println("🏗️ Synthesizing the CDKTF stacks...")
+	return cdktfContainer(infraDir).Directory("/infra/cdktf.out")
+}
+
+// terraformPlan plans a synthesized stack and leaves the JSON plan at plan.json
+func terraformPlan(synth *dagger.Container, stack string, awsCreds *dagger.Secret, roleArn string, webIdentityToken *dagger.Secret) *dagger.Container {
+	return withAwsAuth(synth, awsCreds, roleArn, webIdentityToken).
+		WithWorkdir(fmt.Sprintf("/infra/cdktf.out/stacks/%s", stack)).
+		WithExec([]string{"terraform", "init", "-input=false"}).
+		WithExec([]string{"terraform", "plan", "-input=false", "-lock=false", "-out=tfplan"}).
+		WithExec([]string{"sh", "-c", "terraform show -json tfplan > plan.json"})
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// EstimateCost synthesizes and plans a CDKTF stack and returns the infracost diff against the current state
+func (m *CljXtdbDevops) EstimateCost(
+	ctx context.Context,
+	infraDir *dagger.Directory,
+	// Infracost API key (default: infracost-api-key from the secrets backend)
+	// +optional
+	infracostKey *dagger.Secret,
+	// Shared AWS credentials file used to read the current state
+	// +optional
+	awsCreds *dagger.Secret,
+	// Stack to estimate, infra-<env>-<layer>
+	// +optional
+	// +default="infra-dev-app"
+	stack string,
+	// Role to assume with webIdentityToken instead of using awsCreds, e.g. the deploy role
+	// +optional
+	roleArn string,
+	// OIDC token for roleArn, e.g. a GitHub Actions ID token
+	// +optional
+	webIdentityToken *dagger.Secret,
+) (string, error) {
+	if awsCreds == nil && (roleArn == "" || webIdentityToken == nil) {
+		return "", fmt.Errorf("pass either aws-creds or role-arn with web-identity-token")
+	}
+	infracostKey, err := m.secret(ctx, infracostKey, infracostKeySecret)
+	if err != nil {
+		return "", err
+	}
+	fmt.Printf("💸 Estimating cost of changes to %s...\n", stack)
+	plan := terraformPlan(cdktfContainer(infraDir), stack, awsCreds, roleArn, webIdentityToken).File("plan.json")
+
+	return dag.Container().From("infracost/infracost:ci-0.10").
+		WithSecretVariable("INFRACOST_API_KEY", infracostKey).
+		WithEnvVariable("INFRACOST_SKIP_UPDATE_CHECK", "true").
+		WithMountedFile("/plan/plan.json", plan).
+		WithExec([]string{"infracost", "diff", "--path", "/plan/plan.json", "--show-skipped"}).
+		Stdout(ctx)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// keycloakImage is the Keycloak release of the local OIDC provider
+const keycloakImage = "quay.io/keycloak/keycloak:26.0"
+
+// defaultRealm is imported when no realm export is passed: a confidential
+// client for the app and a test user, dev/dev
+const defaultRealm = `{
+  "realm": "local",
+  "enabled": true,
+  "clients": [
+    {
+      "clientId": "my-app",
+      "secret": "my-app-secret",
+      "publicClient": false,
+      "standardFlowEnabled": true,
+      "directAccessGrantsEnabled": true,
+      "redirectUris": ["http://localhost:58950/*", "http://app.localhost:8000/*"],
+      "webOrigins": ["+"]
+    }
+  ],
+  "users": [
+    {
+      "username": "dev",
+      "email": "dev@example.com",
+      "emailVerified": true,
+      "firstName": "Dev",
+      "lastName": "User",
+      "enabled": true,
+      "credentials": [{"type": "password", "value": "dev", "temporary": false}]
+    }
+  ]
+}
+`
+
+// keycloakRoute serves Keycloak through the local reverse proxy, so browser
+// redirects of the login flow resolve on the host
+var keycloakRoute = proxyRoute{Name: "Keycloak", Host: "keycloak.localhost", Alias: "keycloak", Port: 8080}
+
+// keycloakRealm is the part of a realm export the app is configured from
+type keycloakRealm struct {
+	Realm   string `json:"realm"`
+	Clients []struct {
+		ClientID string `json:"clientId"`
+		Secret   string `json:"secret"`
+	} `json:"clients"`
+}
+
+// keycloak is a running local OIDC provider and what the app needs to use it
+type keycloak struct {
+	service  *dagger.Service
+	issuer   string
+	internal string
+	clientID string
+	secret   string
+}
+
+// withOIDC passes the issuer and client credentials to a container. Tokens
+// carry the issuer, while OIDC_INTERNAL_URL is where the container reaches
+// the same realm for discovery, token and JWKS calls.
+func (k *keycloak) withOIDC(ctr *dagger.Container) *dagger.Container {
+	return ctr.
+		WithServiceBinding("keycloak", k.service).
+		WithEnvVariable("OIDC_ISSUER", k.issuer).
+		WithEnvVariable("OIDC_INTERNAL_URL", k.internal).
+		WithEnvVariable("OIDC_CLIENT_ID", k.clientID).
+		WithSecretVariable("OIDC_CLIENT_SECRET", dag.SetSecret("oidc-client-secret", k.secret))
+}
+
+// startKeycloak starts Keycloak in dev mode with a realm export, or
+// defaultRealm, imported, and waits for the realm to be served. frontendURL
+// is the URL browsers reach Keycloak on, if not http://keycloak:8080.
+func startKeycloak(ctx context.Context, realmExport *dagger.File, frontendURL string) (*keycloak, error) {
+	if realmExport == nil {
+		realmExport = dag.Directory().WithNewFile("realm.json", defaultRealm).File("realm.json")
+	}
+	var realm keycloakRealm
+	if err := readJSONFile(ctx, realmExport, &realm); err != nil {
+		return nil, fmt.Errorf("failed to read realm export: %w", err)
+	}
+	if realm.Realm == "" || len(realm.Clients) == 0 {
+		return nil, fmt.Errorf("the realm export needs a realm name and a client for the app")
+	}
+
+	ctr := dag.Container().From(keycloakImage).
+		WithEnvVariable("KC_BOOTSTRAP_ADMIN_USERNAME", "admin").
+		WithEnvVariable("KC_BOOTSTRAP_ADMIN_PASSWORD", "admin").
+		WithFile("/opt/keycloak/data/import/realm.json", realmExport).
+		WithExposedPort(8080)
+	if frontendURL != "" {
+		ctr = ctr.
+			WithEnvVariable("KC_HOSTNAME", frontendURL).
+			WithEnvVariable("KC_HOSTNAME_BACKCHANNEL_DYNAMIC", "true")
+	}
+	svc, err := ctr.AsService(dagger.ContainerAsServiceOpts{UseEntrypoint: true, Args: []string{"start-dev", "--import-realm"}}).Start(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start Keycloak: %w", err)
+	}
+
+	internal := "http://keycloak:8080/realms/" + realm.Realm
+	fmt.Printf("⏳ Waiting for the %s realm...\n", realm.Realm)
+	_, err = dag.Container().From("alpine:3.21").
+		WithServiceBinding("keycloak", svc).
+		WithEnvVariable("CACHE_BUSTER", time.Now().String()).
+		WithExec([]string{"timeout", "120", "sh", "-c",
+			"until wget -q -O /dev/null " + internal + "/.well-known/openid-configuration; do sleep 2; done"}).
+		Sync(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Keycloak did not serve the %s realm: %w", realm.Realm, err)
+	}
+
+	issuer := internal
+	if frontendURL != "" {
+		issuer = frontendURL + "/realms/" + realm.Realm
+	}
+	client := realm.Clients[0]
+	return &keycloak{service: svc, issuer: issuer, internal: internal, clientID: client.ClientID, secret: client.Secret}, nil
+}
+
+// keycloakNode is Keycloak in a service graph. The returned keycloak is
+// filled in once Keycloak serves the realm.
+func keycloakNode(realmExport *dagger.File, frontendURL string) (serviceNode, *keycloak) {
+	oidc := &keycloak{}
+	return serviceNode{
+		Name: "keycloak",
+		Start: func(ctx context.Context, _ map[string]*dagger.Service) (*dagger.Service, error) {
+			started, err := startKeycloak(ctx, realmExport, frontendURL)
+			if err != nil {
+				return nil, err
+			}
+			*oidc = *started
+			return oidc.service, nil
+		},
+	}, oidc
+}
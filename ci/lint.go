@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// sourcePaths are the app's directories linted and format checked
+var sourcePaths = []string{"src", "test"}
+
+// runCheck runs a checker over the app's sources in the builder image and
+// returns its output, failing with the output if it exits non-zero
+func (m *CljXtdbDevops) runCheck(ctx context.Context, srcDir *dagger.Directory, what string, args []string) (string, error) {
+	check := m.BuilderImage().
+		WithMountedDirectory("/app", srcDir).
+		WithWorkdir("/app").
+		WithExec(args, dagger.ContainerWithExecOpts{Expect: dagger.ReturnTypeAny})
+	code, err := check.ExitCode(ctx)
+	if err != nil {
+		return "", err
+	}
+	stdout, err := check.Stdout(ctx)
+	if err != nil {
+		return "", err
+	}
+	stderr, err := check.Stderr(ctx)
+	if err != nil {
+		return "", err
+	}
+	out := strings.TrimSpace(stdout + "\n" + stderr)
+	if code != 0 {
+		// A failed call prints only the error, so it carries the output
+		return out, fmt.Errorf("%s failed:\n%s", what, out)
+	}
+	return out, nil
+}
+
+// Lint runs clj-kondo over the app's sources and tests
+func (m *CljXtdbDevops) Lint(
+	ctx context.Context,
+	srcDir *dagger.Directory,
+	// Lowest finding level that fails the lint: warning or error
+	// +optional
+	// +default="error"
+	failLevel string,
+) (string, error) {
+	if failLevel != "warning" && failLevel != "error" {
+		return "", fmt.Errorf("invalid fail level %q, want warning or error", failLevel)
+	}
+	fmt.Println("🧹 Linting with clj-kondo...")
+	args := []string{"clj-kondo", "--fail-level", failLevel}
+	for _, path := range sourcePaths {
+		args = append(args, "--lint", path)
+	}
+	out, err := m.runCheck(ctx, srcDir, "clj-kondo", args)
+	if err == nil {
+		fmt.Println("✅ No lint findings at or above", failLevel)
+	}
+	return out, err
+}
+
+// FormatCheck fails if cljfmt would reformat any of the app's sources or
+// tests, printing the diff to apply
+func (m *CljXtdbDevops) FormatCheck(
+	ctx context.Context,
+	srcDir *dagger.Directory,
+) (string, error) {
+	fmt.Println("📐 Checking formatting with cljfmt...")
+	out, err := m.runCheck(ctx, srcDir, "cljfmt check", append([]string{"cljfmt", "check"}, sourcePaths...))
+	if err == nil {
+		fmt.Println("✅ All files formatted correctly")
+	}
+	return out, err
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"slices"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// JVM settings of the lite profile, which keeps XTDB and the app within
+// about 1.5 GiB so the stack runs on 8 GB laptops and default Colima VMs
+const (
+	liteXtdbJavaOpts = "-Xms256m -Xmx768m -XX:MaxDirectMemorySize=512m"
+	liteAppJavaOpts  = "-Xms128m -Xmx256m"
+)
+
+// withLiteXtdb shrinks XTDB's heap, connection pool and query cache
+func withLiteXtdb(xtdb *dagger.Container) *dagger.Container {
+	return xtdb.
+		WithEnvVariable("JDK_JAVA_OPTIONS", liteXtdbJavaOpts).
+		WithEnvVariable("XTDB_POSTGRESQL_POOL_SIZE", "5").
+		WithEnvVariable("XTDB_QUERY_CACHE_SIZE", "1000")
+}
+
+// withLiteApp shrinks the app's heap
+func withLiteApp(app *dagger.Container) *dagger.Container {
+	return app.WithEnvVariable("JDK_JAVA_OPTIONS", liteAppJavaOpts)
+}
+
+// withoutRoute drops a route from the reverse proxy, e.g. pgAdmin's when it
+// is not running
+func withoutRoute(routes []proxyRoute, alias string) []proxyRoute {
+	return slices.DeleteFunc(slices.Clone(routes), func(r proxyRoute) bool { return r.Alias == alias })
+}
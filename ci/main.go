@@ -17,17 +17,66 @@ package main
 import (
 	"context"
 	"fmt"
-	"log"
+	"slices"
 	"time"
 
 	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
 )
 
-type CljXtdbDevops struct{}
+type CljXtdbDevops struct {
+	// Backend resolving the credentials functions are not passed, set by
+	// the with-*-secrets functions; see SecretsProvider
+	// +private
+	SecretsBackend string
+	// +private
+	VaultAddr string
+	// +private
+	VaultMount string
+	// +private
+	VaultToken *dagger.Secret
+	// +private
+	AwsSecretsCreds *dagger.Secret
+	// +private
+	AwsSecretsRoleArn string
+	// +private
+	AwsSecretsWebIdentityToken *dagger.Secret
+	// +private
+	AwsSecretsRegion string
+	// +private
+	AwsSecretsPrefix string
+	// +private
+	SopsFile *dagger.File
+	// +private
+	SopsAgeKey *dagger.Secret
+	// Services added to the local environments by with-extra-service(s)
+	// +private
+	ExtraServices []ServiceSpec
+	// Stub mappings served by WireMock, set by with-mock-apis
+	// +private
+	MockApiStubs *dagger.Directory
+	// Retry policy set by with-retry-policy
+	// +private
+	RetryAttempts int
+	// +private
+	RetryBackoff string
+	// +private
+	OperationTimeout string
+	// Where publishes are audited, set by with-audit-log
+	// +private
+	AuditCreds *dagger.Secret
+	// +private
+	AuditRoleArn string
+	// +private
+	AuditWebIdentityToken *dagger.Secret
+	// +private
+	AuditLogGroup string
+	// +private
+	AuditRegion string
+}
 
 func (m *CljXtdbDevops) BuildCljWebApp(srcDir *dagger.Directory) *dagger.Container {
 	fmt.Println("🔨 Building Clojure web application...")
-	buildStage := dag.Container().From("clojure:openjdk-17").
+	buildStage := m.BuilderImage().
 		WithMountedDirectory("/app", srcDir).
 		WithWorkdir("/app").
 		WithExec([]string{"clojure", "-T:build", "jar"})
@@ -36,7 +85,13 @@ func (m *CljXtdbDevops) BuildCljWebApp(srcDir *dagger.Directory) *dagger.Contain
 	jarFile := buildStage.File("target/my_app.jar")
 
 	fmt.Println("🚀 Preparing runtime container...")
-	return dag.Container().From("openjdk:20-slim").
+	return cljWebAppRuntime(jarFile, "")
+}
+
+// cljWebAppRuntime packages the application JAR into a runtime container for
+// a platform, or the engine's own platform when empty
+func cljWebAppRuntime(jarFile *dagger.File, platform dagger.Platform) *dagger.Container {
+	return dag.Container(dagger.ContainerOpts{Platform: platform}).From("openjdk:20-slim").
 		WithExec([]string{"mkdir", "-p", "/app/target"}).
 		WithFile("/app/target/my_app.jar", jarFile).
 		WithExposedPort(58950).
@@ -44,26 +99,114 @@ func (m *CljXtdbDevops) BuildCljWebApp(srcDir *dagger.Directory) *dagger.Contain
 }
 
 // PublishCljWebApp publishes the Clojure web application container
-func (m *CljXtdbDevops) PublishCljWebApp(container *dagger.Container, tag string) (string, error) {
-	return container.Publish(context.Background(), tag)
+func (m *CljXtdbDevops) PublishCljWebApp(ctx context.Context, container *dagger.Container, tag string) (ref string, err error) {
+	defer func() {
+		err = m.auditPublish(ctx, "PublishCljWebApp", tag, ref, err)
+	}()
+	err = m.retryPolicy().do(ctx, "publishing "+tag, func(ctx context.Context) error {
+		var err error
+		ref, err = container.Publish(ctx, tag)
+		return err
+	})
+	return ref, err
 }
 
-// BuildAndPublishCljWebApp combines building and publishing
-func (m *CljXtdbDevops) BuildAndPublishCljWebApp(srcDir *dagger.Directory) {
+// BuildAndPublishCljWebApp combines building and publishing to ttl.sh
+func (m *CljXtdbDevops) BuildAndPublishCljWebApp(
+	ctx context.Context,
+	srcDir *dagger.Directory,
+	// +optional
+	// +default="my-app"
+	name string,
+	// How long ttl.sh keeps the image, at most 24h
+	// +optional
+	// +default="2h"
+	ttl string,
+	// Size budget of the uncompressed runtime image in MB, failing the build
+	// before publishing if it's exceeded (default none)
+	// +optional
+	maxImageSizeMb int,
+) (string, error) {
+	// Never publish an image built from a tree with committed credentials
+	if _, err := m.SecretScan(ctx, srcDir, nil); err != nil {
+		return "", err
+	}
+
 	webApp := m.BuildCljWebApp(srcDir)
+	if maxImageSizeMb > 0 {
+		if _, err := checkImageSize(ctx, webApp, maxImageSizeMb, 10); err != nil {
+			return "", err
+		}
+	}
 
 	// Publish image
-	publishedImage, err := m.PublishCljWebApp(webApp, "ttl.sh/my-app:2h")
+	pull, err := m.EphemeralPublish(ctx, webApp, name, ttl)
 	if err != nil {
-		log.Fatal(err)
+		return "", err
 	}
-	fmt.Printf("Successfully published image: %s\n", publishedImage)
+	fmt.Printf("Successfully published image: %s\n", pull)
+	return pull, nil
 }
 
+// TestCljWebApp runs the Clojure web application's tests against a fresh XTDB
+func (m *CljXtdbDevops) TestCljWebApp(
+	ctx context.Context,
+	srcDir *dagger.Directory,
+	// Run Keycloak for the OAuth integration tests, passing OIDC_ISSUER,
+	// OIDC_CLIENT_ID and OIDC_CLIENT_SECRET to them
+	// +optional
+	withKeycloak bool,
+	// Realm export to import into Keycloak, its first client being the app's
+	// +optional
+	keycloakRealm *dagger.File,
+) (string, error) {
+	fmt.Println("🧪 Testing Clojure web application...")
+	xtdb := m.BuildXTDB().AsService()
+	tests := m.BuilderImage().
+		WithMountedCache("/root/.m2", dag.CacheVolume(m2CacheVolume)).
+		WithMountedDirectory("/app", srcDir).
+		WithWorkdir("/app").
+		WithEnvVariable("XTDB_HOST", "xtdb").
+		WithServiceBinding("xtdb", xtdb)
+
+	// Keycloak and WireMock start side by side
+	graph := &serviceGraph{retry: m.retryPolicy()}
+	var oidc *keycloak
+	if withKeycloak || keycloakRealm != nil {
+		var node serviceNode
+		node, oidc = keycloakNode(keycloakRealm, "")
+		graph.add(node)
+	}
+	if m.MockApiStubs != nil {
+		graph.add(m.mockApisNode())
+	}
+	services, err := graph.start(ctx, &teardown{})
+	if err != nil {
+		return "", err
+	}
+	if oidc != nil {
+		tests = oidc.withOIDC(tests)
+	}
+	if m.MockApiStubs != nil {
+		tests = withMockApis(tests, services["wiremock"])
+	}
+	return tests.
+		WithExec([]string{"clojure", "-M:dev:test"}).
+		Stdout(ctx)
+}
+
+// xtdbVersion is the XTDB release used by the local environments
+const xtdbVersion = "2.0.0-beta6"
+
 // BuildXTDB creates an XTDB container
 func (m *CljXtdbDevops) BuildXTDB() *dagger.Container {
-	fmt.Println("🏗️  Creating XTDB container...")
-	return dag.Container().From("ghcr.io/xtdb/xtdb:2.0.0-beta6").
+	return m.xtdbContainer(xtdbVersion)
+}
+
+// xtdbContainer creates an XTDB container for a specific release
+func (m *CljXtdbDevops) xtdbContainer(version string) *dagger.Container {
+	fmt.Printf("🏗️  Creating XTDB %s container...\n", version)
+	return dag.Container().From("ghcr.io/xtdb/xtdb:"+version).
 		WithEnvVariable("POSTGRES_USER", "postgres").
 		WithEnvVariable("POSTGRES_PASSWORD", "postgres").
 		WithEnvVariable("POSTGRES_DB", "postgres").
@@ -78,86 +221,309 @@ func (m *CljXtdbDevops) BuildXTDB() *dagger.Container {
 		WithExposedPort(8080)  // Monitoring/healthz endpoints
 }
 
-// RunLocalDevelopment spins up XTDB container
-func (m *CljXtdbDevops) RunLocalDevelopment(ctx context.Context) *dagger.Service {
+// RunLocalDevelopment spins up XTDB container and describes it; forward its
+// ports with `service up`. XTDB starts empty, unless backups are on: then
+// its data is kept in the backup environment's cache volume and persists
+// across runs, so name another backup environment to start afresh.
+func (m *CljXtdbDevops) RunLocalDevelopment(
+	ctx context.Context,
+	// Snapshot XTDB data on this interval (e.g. 30m); export with local-backups
+	// +optional
+	backupInterval string,
+	// Number of snapshots to keep
+	// +optional
+	// +default=24
+	backupRetention int,
+	// Backup environment whose data XTDB runs on and snapshots go to, when
+	// backups are on, e.g. one per branch
+	// +optional
+	// +default="default"
+	backupEnv string,
+	// Run with small heaps and without the extra services, for 8 GB laptops and Colima VMs
+	// +optional
+	lite bool,
+	// Also keep the output of XTDB in a cache volume, exported with
+	// local-logs, e.g. to look into a failed or cancelled start
+	// +optional
+	keepLogs bool,
+	// Host ports the caller forwards with service up --ports, as
+	// HOST:CONTAINER, for the described endpoints (default the container ports)
+	// +optional
+	hostPorts []string,
+) (env *Environment, err error) {
 	fmt.Println("🚀 Starting local development environment...")
+	ports, err := parseHostPorts(hostPorts)
+	if err != nil {
+		return nil, err
+	}
+	down := &teardown{}
+	defer down.onFailure(ctx, &err)
 
 	fmt.Println("📦 Building XTDB container...")
-	xtdb := m.BuildXTDB().
+	xtdbContainer := m.BuildXTDB()
+	if lite {
+		fmt.Println("🪶 Lite profile: small heaps, no extra services")
+		xtdbContainer = withLiteXtdb(xtdbContainer)
+	}
+	if backupInterval != "" {
+		interval, err := time.ParseDuration(backupInterval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid backup interval %q: %w", backupInterval, err)
+		}
+		fmt.Printf("💾 Snapshotting XTDB data of %s every %s, keeping %d snapshots\n", backupEnv, interval, backupRetention)
+		xtdbContainer = withLocalBackups(xtdbContainer, backupEnv, interval, backupRetention)
+	}
+	xtdb, err := asLocalService(ctx, xtdbContainer.
 		WithExposedPort(3000). // HTTP API
 		WithExposedPort(5432). // PostgreSQL
-		WithExposedPort(8080). // Monitoring/healthz endpoints
-		AsService()
+		WithExposedPort(8080), // Monitoring/healthz endpoints
+		"xtdb", keepLogs)
+	if err != nil {
+		return nil, err
+	}
 
-	fmt.Println("🔄 Starting XTDB service...")
-	xtdbService, err := xtdb.Start(ctx)
+	graph := &serviceGraph{retry: m.retryPolicy()}
+	graph.add(xtdbNode(xtdb))
+	if lite {
+		skipExtraServices(m.ExtraServices)
+	} else if err := m.graphExtraServices(graph); err != nil {
+		return nil, err
+	}
+	services, err := graph.start(ctx, down)
 	if err != nil {
-		log.Fatalf("❌ failed to start XTDB: %v", err)
+		return nil, err
 	}
-	fmt.Println("✅ XTDB service started successfully")
 
-	fmt.Println("🎉 Local development environment ready!")
-	fmt.Println("📝 Access points:")
-	fmt.Println("  - XTDB HTTP API: http://localhost:3000")
-	fmt.Println("  - XTDB PostgreSQL: localhost:5432")
-	fmt.Println("  - XTDB Monitoring: http://localhost:8080")
+	env = newEnvironment("local-development")
+	env.add("xtdb", xtdbEndpoints(ports, true)...)
+	env.credential("xtdb", "postgres", "default:postgres")
+	env.addExtraServices(services, m.ExtraServices)
+	return env.ready(services["xtdb"]), nil
+}
 
-	return xtdbService
+// BuildPgAdmin creates a pgAdmin container preconfigured to connect to XTDB over pgwire
+func (m *CljXtdbDevops) BuildPgAdmin() *dagger.Container {
+	fmt.Println("🏗️  Creating pgAdmin container...")
+	return dag.Container().From("dpage/pgadmin4:8.14").
+		WithEnvVariable("PGADMIN_DEFAULT_EMAIL", "admin@example.com").
+		WithEnvVariable("PGADMIN_DEFAULT_PASSWORD", "admin").
+		WithEnvVariable("PGADMIN_CONFIG_SERVER_MODE", "False").
+		WithEnvVariable("PGADMIN_CONFIG_MASTER_PASSWORD_REQUIRED", "False").
+		WithNewFile("/pgadmin4/servers.json", pgAdminServers).
+		WithExposedPort(80)
 }
 
-// RunLocalWebApp runs the Clojure web application locally with XTDB
-func (m *CljXtdbDevops) RunLocalWebApp(ctx context.Context, srcDir *dagger.Directory) *dagger.Service {
+// RunLocalWebApp runs the Clojure web application locally with XTDB and
+// describes the environment; forward its ports with `service up`
+func (m *CljXtdbDevops) RunLocalWebApp(
+	ctx context.Context,
+	srcDir *dagger.Directory,
+	// Front the app, XTDB and pgAdmin with a reverse proxy on port 80
+	// +optional
+	withProxy bool,
+	// Run a Vault dev server and pass its VAULT_ADDR and VAULT_TOKEN to the app
+	// +optional
+	withVault bool,
+	// KV secrets to seed the Vault dev server with, as JSON {"<path>": {"<key>": "<value>"}}
+	// +optional
+	vaultSecrets *dagger.File,
+	// Capture the app's outbound email in Mailhog, passing SMTP_HOST and
+	// SMTP_PORT to the app; the UI is at mailhog.localhost with withProxy
+	// +optional
+	withMailhog bool,
+	// Run Keycloak as the app's OIDC provider, passing OIDC_ISSUER,
+	// OIDC_CLIENT_ID and OIDC_CLIENT_SECRET to the app
+	// +optional
+	withKeycloak bool,
+	// Realm export to import into Keycloak, its first client being the app's;
+	// defaults to a local realm with a my-app client and a dev/dev user
+	// +optional
+	keycloakRealm *dagger.File,
+	// Run with small heaps, without pgAdmin and without the extra services of
+	// with-extra-services, for 8 GB laptops and Colima VMs
+	// +optional
+	lite bool,
+	// Also keep the output of XTDB and the app in a cache volume, exported
+	// with local-logs, e.g. to look into a failed or cancelled start
+	// +optional
+	keepLogs bool,
+	// Host ports the caller forwards with service up --ports, as
+	// HOST:CONTAINER, for the described endpoints (default 58950, or 8000 for
+	// the proxy's 80)
+	// +optional
+	hostPorts []string,
+) (env *Environment, err error) {
 	fmt.Println("🚀 Starting local web application environment...")
+	ports, err := parseHostPorts(hostPorts)
+	if err != nil {
+		return nil, err
+	}
+	down := &teardown{}
+	defer down.onFailure(ctx, &err)
+	proxyPort := ports.host(80, 8000)
 
 	fmt.Println("📦 Building XTDB container...")
-	xtdb := m.BuildXTDB().
+	xtdbContainer := m.BuildXTDB()
+	if lite {
+		fmt.Println("🪶 Lite profile: small heaps, no pgAdmin, no extra services")
+		xtdbContainer = withLiteXtdb(xtdbContainer)
+	}
+	xtdb, err := asLocalService(ctx, xtdbContainer.
 		WithExposedPort(3000). // HTTP API
 		WithExposedPort(5432). // PostgreSQL
-		WithExposedPort(8080). // Monitoring/healthz endpoints
-		AsService()
+		WithExposedPort(8080), // Monitoring/healthz endpoints
+		"xtdb", keepLogs)
+	if err != nil {
+		return nil, err
+	}
 
-	fmt.Println("🔄 Starting XTDB service...")
-	if _, err := xtdb.Start(ctx); err != nil {
-		log.Fatalf("❌ failed to start XTDB: %v", err)
+	// XTDB, the extra services and the app's other dependencies start in
+	// dependency order, in parallel where they don't depend on each other
+	graph := &serviceGraph{retry: m.retryPolicy()}
+	graph.add(xtdbNode(xtdb))
+	if lite {
+		skipExtraServices(m.ExtraServices)
+		m.ExtraServices = nil
+	}
+	if withMailhog {
+		m.ExtraServices = append(m.ExtraServices, mailhogSpec)
+	}
+	if err := m.graphExtraServices(graph); err != nil {
+		return nil, err
+	}
+	if withVault {
+		graph.add(vaultNode())
+	}
+	var oidc *keycloak
+	if withKeycloak || keycloakRealm != nil {
+		// Behind the proxy the browser is sent to Keycloak on keycloak.localhost
+		frontendURL := ""
+		if withProxy {
+			frontendURL = fmt.Sprintf("http://%s:%d", keycloakRoute.Host, proxyPort)
+		}
+		var node serviceNode
+		node, oidc = keycloakNode(keycloakRealm, frontendURL)
+		graph.add(node)
+	}
+	if m.MockApiStubs != nil {
+		graph.add(m.mockApisNode())
+	}
+	services, err := graph.start(ctx, down)
+	if err != nil {
+		return nil, err
 	}
-	fmt.Println("✅ XTDB service started successfully")
-	fmt.Println("⏳ Waiting for XTDB to be ready...")
-	time.Sleep(5 * time.Second)
 
 	fmt.Println("📦 Building web application...")
-	webApp := m.BuildCljWebApp(srcDir).
+	webAppContainer := m.BuildCljWebApp(srcDir).
 		WithExposedPort(58950).
 		WithEnvVariable("XTDB_HOST", "xtdb").
-		WithServiceBinding("xtdb", xtdb).
-		AsService()
+		WithServiceBinding("xtdb", xtdb)
+	if lite {
+		webAppContainer = withLiteApp(webAppContainer)
+	}
+	for _, spec := range m.ExtraServices {
+		webAppContainer = webAppContainer.WithServiceBinding(spec.Name, services[spec.Name])
+	}
+	if withMailhog {
+		webAppContainer = webAppContainer.
+			WithEnvVariable("SMTP_HOST", "mailhog").
+			WithEnvVariable("SMTP_PORT", "1025")
+	}
+	if withVault {
+		if vaultSecrets != nil {
+			if err := seedVault(ctx, services["vault"], vaultSecrets); err != nil {
+				return nil, err
+			}
+		}
+		// Like in production, the app gets its credentials as environment variables
+		webAppContainer = webAppContainer.
+			WithServiceBinding("vault", services["vault"]).
+			WithEnvVariable("VAULT_ADDR", "http://vault:8200").
+			WithSecretVariable("VAULT_TOKEN", dag.SetSecret("vault-dev-token", vaultDevToken))
+	}
+	if oidc != nil {
+		webAppContainer = oidc.withOIDC(webAppContainer)
+	}
+	if m.MockApiStubs != nil {
+		webAppContainer = withMockApis(webAppContainer, services["wiremock"])
+	}
+	webApp, err := asLocalService(ctx, webAppContainer, "app", keepLogs)
+	if err != nil {
+		return nil, err
+	}
+
+	env = newEnvironment("local-web-app")
+	env.add("app", Endpoint{Name: "Web Application", Internal: "http://app:58950"})
+	env.add("xtdb", xtdbEndpoints(ports, false)...)
+	env.credential("xtdb", "postgres", "default:postgres")
+	env.addExtraServices(services, m.ExtraServices)
+	if withVault {
+		env.add("vault", Endpoint{Name: "API", Internal: "http://vault:8200"})
+		env.credential("vault", "", "default:"+vaultDevToken)
+	}
+	if oidc != nil {
+		issuer := Endpoint{Name: "OIDC Issuer", Internal: oidc.internal}
+		if oidc.issuer != oidc.internal {
+			issuer.Host = oidc.issuer
+		}
+		env.add("keycloak", Endpoint{Name: "Admin Console", Internal: "http://keycloak:8080"}, issuer)
+		env.credential("keycloak", "admin", "default:admin")
+		env.credential("app", oidc.clientID, "env:OIDC_CLIENT_SECRET")
+	}
+	if m.MockApiStubs != nil {
+		env.add("wiremock", Endpoint{Name: "Mock APIs", Internal: mockApiURL})
+	}
+
+	if withProxy {
+		routes := proxyRoutes
+		var pgAdmin *dagger.Service
+		if lite {
+			routes = withoutRoute(routes, "pgadmin")
+		} else {
+			fmt.Println("📦 Building pgAdmin container...")
+			pgAdmin = m.BuildPgAdmin().
+				WithServiceBinding("xtdb", xtdb).
+				AsService()
+			env.add("pgadmin", Endpoint{Name: "pgAdmin", Internal: "http://pgadmin:80"})
+			env.credential("pgadmin", "admin@example.com", "default:admin")
+		}
+
+		fmt.Println("🔄 Starting reverse proxy...")
+		proxy := m.BuildProxy(webApp, xtdb, pgAdmin)
+		if withMailhog {
+			routes = append(slices.Clone(routes), mailhogRoute)
+			proxy = proxy.WithServiceBinding("mailhog", services["mailhog"])
+		}
+		if oidc != nil {
+			routes = append(slices.Clone(routes), keycloakRoute)
+			proxy = proxy.WithServiceBinding("keycloak", oidc.service)
+		}
+		if withMailhog || oidc != nil {
+			proxy = proxy.WithNewFile("/etc/caddy/Caddyfile", caddyfile(routes))
+		}
+		proxyService, err := proxy.AsService().Start(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start reverse proxy: %w", err)
+		}
+		down.started("proxy", proxyService)
+		fmt.Println("✅ Reverse proxy started successfully")
+		env.route(routes, proxyPort)
+		return env.ready(proxyService), nil
+	}
 
 	fmt.Println("🔄 Starting web application service...")
 	webAppService, err := webApp.Start(ctx)
 	if err != nil {
-		log.Fatalf("❌ failed to start web application: %v", err)
+		return nil, fmt.Errorf("failed to start web application: %w", err)
 	}
+	down.started("app", webAppService)
 	fmt.Println("✅ Web application service started successfully")
-
-	fmt.Println("🎉 Local web application environment ready!")
-	fmt.Println("📝 Access points:")
-	fmt.Println("  - Web Application: http://localhost:58950")
-	fmt.Println("  - XTDB HTTP API: http://localhost:3000")
-	fmt.Println("  - XTDB PostgreSQL: localhost:5432")
-	fmt.Println("  - XTDB Monitoring: http://localhost:8080")
-	return webAppService
+	// Only the app's port is forwarded; the other services stay inside the session
+	env.forward("app:58950", fmt.Sprintf("http://localhost:%d", ports.host(58950, 58950)))
+	return env.ready(webAppService), nil
 }
 
 // Returns a container that echoes whatever string argument is provided
 func (m *CljXtdbDevops) ContainerEcho(stringArg string) *dagger.Container {
 	return dag.Container().From("alpine:latest").WithExec([]string{"echo", stringArg})
 }
-
-// Returns lines that match a pattern in the files of the provided Directory
-func (m *CljXtdbDevops) GrepDir(ctx context.Context, directoryArg *dagger.Directory, pattern string) (string, error) {
-	return dag.Container().
-		From("alpine:latest").
-		WithMountedDirectory("/mnt", directoryArg).
-		WithWorkdir("/mnt").
-		WithExec([]string{"grep", "-R", pattern, "."}).
-		Stdout(ctx)
-}
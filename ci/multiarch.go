@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// defaultPlatforms are the architectures images are published for, matching
+// the X86_64 and ARM64 (Graviton) Fargate task definitions
+var defaultPlatforms = []dagger.Platform{"linux/amd64", "linux/arm64"}
+
+// PublishMultiArchCljWebApp builds the web application once per platform and
+// publishes the variants as a single multi-arch image
+func (m *CljXtdbDevops) PublishMultiArchCljWebApp(
+	ctx context.Context,
+	srcDir *dagger.Directory,
+	tag string,
+	// Platforms to publish (default linux/amd64 and linux/arm64)
+	// +optional
+	platforms []dagger.Platform,
+	// User to log in to the tag's registry as, with registryPassword
+	// +optional
+	registryUsername string,
+	// Password of registryUsername (default: registry-password from the secrets backend)
+	// +optional
+	registryPassword *dagger.Secret,
+) (ref string, err error) {
+	defer func() {
+		err = m.auditPublish(ctx, "PublishMultiArchCljWebApp", tag, ref, err)
+	}()
+	if len(platforms) == 0 {
+		platforms = defaultPlatforms
+	}
+	publisher := dag.Container()
+	if registryUsername != "" {
+		password, err := m.secret(ctx, registryPassword, registryPasswordSecret)
+		if err != nil {
+			return "", err
+		}
+		registry, _, _ := strings.Cut(tag, "/")
+		publisher = publisher.WithRegistryAuth(registry, registryUsername, password)
+	}
+
+	// Never publish an image built from a tree with committed credentials
+	if _, err := m.SecretScan(ctx, srcDir, nil); err != nil {
+		return "", err
+	}
+
+	// The JAR is platform independent, so it is only built once
+	fmt.Println("🔨 Building Clojure web application...")
+	jarFile := m.BuilderImage().
+		WithMountedDirectory("/app", srcDir).
+		WithWorkdir("/app").
+		WithExec([]string{"clojure", "-T:build", "jar"}).
+		File("target/my_app.jar")
+
+	variants := make([]*dagger.Container, 0, len(platforms))
+	for _, platform := range platforms {
+		fmt.Printf("🚀 Preparing %s runtime container...\n", platform)
+		variants = append(variants, cljWebAppRuntime(jarFile, platform))
+	}
+
+	err = m.retryPolicy().do(ctx, "publishing "+tag, func(ctx context.Context) error {
+		var err error
+		ref, err = publisher.Publish(ctx, tag, dagger.ContainerPublishOpts{
+			PlatformVariants: variants,
+		})
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to publish %s: %w", tag, err)
+	}
+	fmt.Printf("✅ Published %s for %d platforms\n", ref, len(platforms))
+	return ref, nil
+}
+
+// CheckImagePlatforms fails unless an image has a variant for every platform,
+// e.g. before switching a stack's cpuArchitecture to ARM64. Images in ECR are
+// pulled with a login token fetched using awsCreds.
+func (m *CljXtdbDevops) CheckImagePlatforms(
+	ctx context.Context,
+	imageRef string,
+	// Platforms the image must provide (default linux/amd64 and linux/arm64)
+	// +optional
+	platforms []dagger.Platform,
+	// Shared AWS credentials file, required for private ECR images
+	// +optional
+	awsCreds *dagger.Secret,
+	// +optional
+	// +default="us-east-1"
+	region string,
+	// +optional
+	profile string,
+) error {
+	if len(platforms) == 0 {
+		platforms = defaultPlatforms
+	}
+
+	registry, _, _ := strings.Cut(imageRef, "/")
+	var password *dagger.Secret
+	if strings.Contains(registry, ".dkr.ecr.") && awsCreds != nil {
+		token, err := awsOutput(ctx, awsCli(awsCreds, region, profile), "ecr", "get-login-password")
+		if err != nil {
+			return err
+		}
+		password = dag.SetSecret("ecr-login-password", token)
+	}
+
+	var missing []string
+	for _, platform := range platforms {
+		ctr := dag.Container(dagger.ContainerOpts{Platform: platform})
+		if password != nil {
+			ctr = ctr.WithRegistryAuth(registry, "AWS", password)
+		}
+		// Resolving the platform only fetches the manifest, not the layers
+		got, err := ctr.From(imageRef).Platform(ctx)
+		if err != nil || got != platform {
+			missing = append(missing, string(platform))
+			fmt.Printf("❌ %s: no %s variant\n", imageRef, platform)
+			continue
+		}
+		fmt.Printf("✅ %s: %s\n", imageRef, platform)
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("%s is missing platforms: %s", imageRef, strings.Join(missing, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// oasdiffImage is the oasdiff release used to detect breaking API changes
+const oasdiffImage = "tufin/oasdiff:v1.10.25"
+
+// fetchSpec waits up to two minutes for the app to answer and downloads its
+// spec, giving up at once if the app answers that it serves none
+const fetchSpec = `for i in $(seq 60); do
+  if out=$(wget -q -O /openapi.json "http://app:58950$SPEC_PATH" 2>&1); then exit 0; fi
+  case "$out" in *404*)
+    echo "the app serves no OpenAPI spec at $SPEC_PATH (404): add the endpoint or pass --spec" >&2
+    exit 1 ;;
+  esac
+  sleep 2
+done
+echo "the app did not answer on port 58950 within 2 minutes: $out" >&2
+exit 1`
+
+// OpenApiSpec runs the app against a fresh XTDB and fetches the OpenAPI spec
+// it serves
+func (m *CljXtdbDevops) OpenApiSpec(
+	srcDir *dagger.Directory,
+	// Path the app serves its spec on
+	// +optional
+	// +default="/openapi.json"
+	path string,
+) *dagger.File {
+	fmt.Println("📦 Building web application...")
+	xtdb := m.BuildXTDB().AsService()
+	app := m.BuildCljWebApp(srcDir).
+		WithEnvVariable("XTDB_HOST", "xtdb").
+		WithServiceBinding("xtdb", xtdb).
+		AsService()
+
+	fmt.Printf("📄 Fetching the OpenAPI spec from %s...\n", path)
+	return dag.Container().From("alpine:3.21").
+		WithServiceBinding("app", app).
+		WithEnvVariable("CACHE_BUSTER", time.Now().String()).
+		WithEnvVariable("SPEC_PATH", path).
+		WithExec([]string{"sh", "-c", fetchSpec}).
+		File("/openapi.json")
+}
+
+// OpenApiDiff compares the app's OpenAPI spec with the previously published
+// one and fails on breaking changes, e.g. removed endpoints or new required
+// parameters
+func (m *CljXtdbDevops) OpenApiDiff(
+	ctx context.Context,
+	// Spec to check, e.g. a build artifact; fetched from the running app if not passed
+	// +optional
+	spec *dagger.File,
+	// App to fetch the spec from, when spec is not passed
+	// +optional
+	srcDir *dagger.Directory,
+	// Previously published spec
+	// +optional
+	base *dagger.File,
+	// Previously published spec artifact, see publish-open-api-spec, when base is not passed
+	// +optional
+	baseRef string,
+	// Path the app serves its spec on
+	// +optional
+	// +default="/openapi.json"
+	path string,
+) (string, error) {
+	if spec == nil {
+		if srcDir == nil {
+			return "", fmt.Errorf("pass either spec or src-dir")
+		}
+		spec = m.OpenApiSpec(srcDir, path)
+	}
+	if base == nil {
+		if baseRef == "" {
+			return "", fmt.Errorf("pass either base or base-ref")
+		}
+		base = dag.Container().From(baseRef).File("/openapi.json")
+		// Nothing has been published before the first release
+		if _, err := base.Contents(ctx); err != nil {
+			msg := fmt.Sprintf("no OpenAPI spec published as %s yet, nothing to compare: %v", baseRef, err)
+			fmt.Println("⚠️  " + msg)
+			return msg, nil
+		}
+	}
+
+	fmt.Println("🔍 Checking the OpenAPI spec for breaking changes...")
+	out, err := dag.Container().From(oasdiffImage).
+		WithFile("/specs/base.json", base).
+		WithFile("/specs/revision.json", spec).
+		WithExec([]string{"breaking", "/specs/base.json", "/specs/revision.json", "--fail-on", "ERR"},
+			dagger.ContainerWithExecOpts{UseEntrypoint: true}).
+		Stdout(ctx)
+	if err != nil {
+		return "", fmt.Errorf("the OpenAPI spec has breaking changes: %w", err)
+	}
+	fmt.Println("✅ No breaking API changes")
+	return out, nil
+}
+
+// PublishOpenApiSpec publishes the app's OpenAPI spec as a one-file image,
+// /openapi.json, for clients and for open-api-diff of the next release
+func (m *CljXtdbDevops) PublishOpenApiSpec(
+	ctx context.Context,
+	// e.g. ttl.sh/my-app-openapi:2h
+	ref string,
+	// Spec to publish, e.g. a build artifact; fetched from the running app if not passed
+	// +optional
+	spec *dagger.File,
+	// App to fetch the spec from, when spec is not passed
+	// +optional
+	srcDir *dagger.Directory,
+	// Path the app serves its spec on
+	// +optional
+	// +default="/openapi.json"
+	path string,
+) (string, error) {
+	if spec == nil {
+		if srcDir == nil {
+			return "", fmt.Errorf("pass either spec or src-dir")
+		}
+		spec = m.OpenApiSpec(srcDir, path)
+	}
+	var published string
+	err := m.retryPolicy().do(ctx, "publishing "+ref, func(ctx context.Context) error {
+		var err error
+		published, err = dag.Container().WithFile("/openapi.json", spec).Publish(ctx, ref)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to publish the OpenAPI spec: %w", err)
+	}
+	fmt.Printf("✅ Published the OpenAPI spec as %s\n", published)
+	return published, nil
+}
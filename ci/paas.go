@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// flyctlImage is the flyctl release used to deploy to Fly.io
+const flyctlImage = "flyio/flyctl:v0.3.66"
+
+// doctlImage is the doctl release used to deploy to DigitalOcean
+const doctlImage = "digitalocean/doctl:1.120.0"
+
+// appPort is the port the Clojure app listens on
+const appPort = 58950
+
+// flyConfig is the fly.toml of an app that only runs a published image
+const flyConfig = `app = %q
+primary_region = %q
+
+[http_service]
+  internal_port = %d
+  force_https = true
+  auto_stop_machines = "stop"
+  auto_start_machines = true
+  min_machines_running = 0
+
+  [[http_service.checks]]
+    method = "GET"
+    path = %q
+    interval = "30s"
+    timeout = "5s"
+    grace_period = "30s"
+`
+
+// DeployToFly deploys a published app image to a Fly.io app, creating the
+// app on first deploy. Machines stop when idle, so a demo costs next to
+// nothing between visits. Returns the app's URL.
+func (m *CljXtdbDevops) DeployToFly(
+	ctx context.Context,
+	// Published image to run, e.g. from PublishCljWebApp
+	imageRef string,
+	// Fly.io app name, which is also its fly.dev hostname
+	appName string,
+	// Fly.io API token, e.g. from `fly tokens create deploy` (default: fly-api-token from the secrets backend)
+	// +optional
+	token *dagger.Secret,
+	// +optional
+	// +default="iad"
+	region string,
+	// Path the health check requests
+	// +optional
+	// +default="/"
+	healthCheckPath string,
+	// Fly.io organization to create the app in
+	// +optional
+	// +default="personal"
+	org string,
+) (string, error) {
+	token, err := m.secret(ctx, token, flyTokenSecret)
+	if err != nil {
+		return "", err
+	}
+	fmt.Printf("🎈 Deploying %s to Fly.io app %s...\n", imageRef, appName)
+	_, err = dag.Container().From(flyctlImage).
+		WithSecretVariable("FLY_API_TOKEN", token).
+		WithNewFile("/deploy/fly.toml", fmt.Sprintf(flyConfig, appName, region, appPort, healthCheckPath)).
+		WithWorkdir("/deploy").
+		WithEnvVariable("APP", appName).
+		WithEnvVariable("ORG", org).
+		WithExec([]string{"sh", "-c", `flyctl status --app "$APP" >/dev/null 2>&1 || flyctl apps create "$APP" --org "$ORG"`}).
+		WithExec([]string{"flyctl", "deploy", "--app", appName, "--image", imageRef, "--ha=false", "--yes"}).
+		Sync(ctx)
+	if err != nil {
+		return "", fmt.Errorf("fly deploy of %s failed: %w", appName, err)
+	}
+
+	url := "https://" + appName + ".fly.dev"
+	fmt.Printf("✅ Deployed to %s\n", url)
+	return url, nil
+}
+
+// DeployToDigitalOcean deploys a published app image to a DigitalOcean App
+// Platform app, creating it or updating its spec in place. The image must
+// be in DOCR, Docker Hub or GHCR. Returns the app's URL.
+func (m *CljXtdbDevops) DeployToDigitalOcean(
+	ctx context.Context,
+	// Published image to run, e.g. registry.digitalocean.com/demos/my-app:1.2.0
+	imageRef string,
+	// App Platform app name
+	appName string,
+	// DigitalOcean API token (default: digitalocean-token from the secrets backend)
+	// +optional
+	token *dagger.Secret,
+	// App Platform region slug
+	// +optional
+	// +default="nyc"
+	region string,
+	// Path the health check requests
+	// +optional
+	// +default="/"
+	healthCheckPath string,
+	// Instance size slug of the service
+	// +optional
+	// +default="apps-s-1vcpu-0.5gb"
+	instanceSize string,
+) (string, error) {
+	image, err := appPlatformImage(imageRef)
+	if err != nil {
+		return "", err
+	}
+	token, err = m.secret(ctx, token, digitalOceanSecret)
+	if err != nil {
+		return "", err
+	}
+	spec, err := json.Marshal(map[string]any{
+		"name":   appName,
+		"region": region,
+		"services": []map[string]any{{
+			"name":               "app",
+			"image":              image,
+			"http_port":          appPort,
+			"instance_count":     1,
+			"instance_size_slug": instanceSize,
+			"health_check":       map[string]any{"http_path": healthCheckPath},
+		}},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	fmt.Printf("🌊 Deploying %s to App Platform app %s...\n", imageRef, appName)
+	out, err := dag.Container().From(doctlImage).
+		WithSecretVariable("DIGITALOCEAN_ACCESS_TOKEN", token).
+		WithNewFile("/deploy/app.json", string(spec)).
+		WithExec([]string{"doctl", "apps", "create", "--spec", "/deploy/app.json", "--upsert", "--wait",
+			"--format", "DefaultIngress", "--no-header"}).
+		Stdout(ctx)
+	if err != nil {
+		return "", fmt.Errorf("doctl deploy of %s failed: %w", appName, err)
+	}
+
+	url := strings.TrimSpace(out)
+	fmt.Printf("✅ Deployed to %s\n", url)
+	return url, nil
+}
+
+// appPlatformImage splits an image reference into an App Platform image
+// spec. App Platform pulls from DOCR, Docker Hub and GHCR only.
+func appPlatformImage(ref string) (map[string]any, error) {
+	repository, tag, ok := strings.Cut(ref, ":")
+	if !ok || strings.Contains(tag, "/") {
+		return nil, fmt.Errorf("image %s needs a tag", ref)
+	}
+	parts := strings.Split(repository, "/")
+	switch {
+	case parts[0] == "registry.digitalocean.com" && len(parts) == 3:
+		return map[string]any{"registry_type": "DOCR", "repository": parts[2], "tag": tag}, nil
+	case parts[0] == "ghcr.io" && len(parts) == 3:
+		return map[string]any{"registry_type": "GHCR", "registry": parts[1], "repository": parts[2], "tag": tag}, nil
+	case parts[0] == "docker.io" && len(parts) == 3:
+		return map[string]any{"registry_type": "DOCKER_HUB", "registry": parts[1], "repository": parts[2], "tag": tag}, nil
+	case len(parts) == 2 && !strings.Contains(parts[0], "."):
+		return map[string]any{"registry_type": "DOCKER_HUB", "registry": parts[0], "repository": parts[1], "tag": tag}, nil
+	}
+	return nil, fmt.Errorf("App Platform cannot pull %s, publish it to DOCR, Docker Hub or GHCR", ref)
+}
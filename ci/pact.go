@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// pactCliImage bundles the Pact provider verifier and broker client
+const pactCliImage = "pactfoundation/pact-cli:1.4.0"
+
+// pactBrokerTokenSecret is the name the broker token is resolved by when it
+// is not passed
+const pactBrokerTokenSecret = "pact-broker-token"
+
+// pactCli returns a Pact CLI container authenticated against a broker
+func (m *CljXtdbDevops) pactCli(ctx context.Context, brokerURL string, token *dagger.Secret) (*dagger.Container, error) {
+	token, err := m.secret(ctx, token, pactBrokerTokenSecret)
+	if err != nil {
+		return nil, err
+	}
+	return dag.Container().From(pactCliImage).
+		WithEnvVariable("PACT_BROKER_BASE_URL", brokerURL).
+		WithSecretVariable("PACT_BROKER_TOKEN", token).
+		WithEnvVariable("CACHE_BUSTER", time.Now().String()), nil
+}
+
+// VerifyPacts runs the app against a fresh XTDB and verifies it honours the
+// pacts its consumers published to a Pact broker
+func (m *CljXtdbDevops) VerifyPacts(
+	ctx context.Context,
+	srcDir *dagger.Directory,
+	// Pact broker, e.g. https://example.pactflow.io
+	brokerURL string,
+	// Broker token, resolved as pact-broker-token through the secrets backend if not passed
+	// +optional
+	token *dagger.Secret,
+	// Provider name the consumers' pacts are for
+	// +optional
+	// +default="my-app"
+	provider string,
+	// Version of the app being verified, e.g. the git SHA
+	// +optional
+	providerVersion string,
+	// Branch of the app being verified, for the consumer version selectors
+	// +optional
+	branch string,
+	// Endpoint of the app that sets up provider states, e.g. /pact/provider-states
+	// +optional
+	providerStatesPath string,
+	// Publish the results to the broker, for can-i-deploy; needs providerVersion
+	// +optional
+	publishResults bool,
+) (string, error) {
+	if publishResults && providerVersion == "" {
+		return "", fmt.Errorf("publishing verification results needs a provider version")
+	}
+	cli, err := m.pactCli(ctx, brokerURL, token)
+	if err != nil {
+		return "", err
+	}
+
+	fmt.Println("📦 Building web application...")
+	xtdb := m.BuildXTDB().AsService()
+	app := m.BuildCljWebApp(srcDir).
+		WithEnvVariable("XTDB_HOST", "xtdb").
+		WithServiceBinding("xtdb", xtdb).
+		AsService()
+
+	args := []string{"verify",
+		"--provider-base-url", "http://app:58950",
+		"--pact-broker-base-url", brokerURL,
+		"--provider", provider,
+		// Pacts of consumers not yet verified against this provider do not fail the build
+		"--enable-pending",
+		"--consumer-version-selector", `{"mainBranch": true}`,
+		"--consumer-version-selector", `{"deployedOrReleased": true}`,
+	}
+	if providerVersion != "" {
+		args = append(args, "--provider-app-version", providerVersion)
+	}
+	if branch != "" {
+		args = append(args, "--provider-version-branch", branch,
+			"--consumer-version-selector", `{"matchingBranch": true}`)
+	}
+	if providerStatesPath != "" {
+		args = append(args, "--provider-states-setup-url", "http://app:58950"+providerStatesPath)
+	}
+	if publishResults {
+		args = append(args, "--publish-verification-results")
+	}
+
+	fmt.Printf("🤝 Verifying %s against its consumers' pacts...\n", provider)
+	out, err := cli.
+		WithServiceBinding("app", app).
+		WithExec(args, dagger.ContainerWithExecOpts{UseEntrypoint: true}).
+		Stdout(ctx)
+	if err != nil {
+		return "", fmt.Errorf("pact verification failed: %w", err)
+	}
+	return out, nil
+}
+
+// PublishPacts publishes the pacts a consumer's tests wrote to a Pact broker
+func (m *CljXtdbDevops) PublishPacts(
+	ctx context.Context,
+	// Pact files, e.g. target/pacts
+	pacts *dagger.Directory,
+	// Pact broker, e.g. https://example.pactflow.io
+	brokerURL string,
+	// Version of the consumer, e.g. the git SHA
+	consumerVersion string,
+	// Broker token, resolved as pact-broker-token through the secrets backend if not passed
+	// +optional
+	token *dagger.Secret,
+	// Branch of the consumer
+	// +optional
+	branch string,
+) (string, error) {
+	cli, err := m.pactCli(ctx, brokerURL, token)
+	if err != nil {
+		return "", err
+	}
+	args := []string{"publish", "/pacts", "--consumer-app-version", consumerVersion}
+	if branch != "" {
+		args = append(args, "--branch", branch)
+	}
+	fmt.Printf("📤 Publishing pacts of %s...\n", consumerVersion)
+	return cli.
+		WithMountedDirectory("/pacts", pacts).
+		WithExec(args, dagger.ContainerWithExecOpts{UseEntrypoint: true}).
+		Stdout(ctx)
+}
+
+// CanIDeploy asks the Pact broker whether a version of a pacticipant is
+// compatible with everything deployed to an environment
+func (m *CljXtdbDevops) CanIDeploy(
+	ctx context.Context,
+	// Pact broker, e.g. https://example.pactflow.io
+	brokerURL string,
+	// Version to deploy, e.g. the git SHA
+	version string,
+	env string,
+	// Broker token, resolved as pact-broker-token through the secrets backend if not passed
+	// +optional
+	token *dagger.Secret,
+	// +optional
+	// +default="my-app"
+	pacticipant string,
+) (string, error) {
+	cli, err := m.pactCli(ctx, brokerURL, token)
+	if err != nil {
+		return "", err
+	}
+	out, err := cli.
+		WithExec([]string{"broker", "can-i-deploy", "--pacticipant", pacticipant, "--version", version,
+			"--to-environment", env}, dagger.ContainerWithExecOpts{UseEntrypoint: true}).
+		Stdout(ctx)
+	if err != nil {
+		return "", fmt.Errorf("%s %s is not compatible with %s: %w", pacticipant, version, env, err)
+	}
+	return out, nil
+}
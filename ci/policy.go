@@ -0,0 +1,466 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// deployWindow is when an environment may be deployed without approval:
+// the listed days from hour From up to hour To, UTC
+type deployWindow struct {
+	Days     []time.Weekday
+	From, To int
+}
+
+func (w deployWindow) contains(t time.Time) bool {
+	t = t.UTC()
+	return slices.Contains(w.Days, t.Weekday()) && t.Hour() >= w.From && t.Hour() < w.To
+}
+
+func (w deployWindow) String() string {
+	days := make([]string, len(w.Days))
+	for i, d := range w.Days {
+		days[i] = d.String()[:3]
+	}
+	return fmt.Sprintf("%s %02d:00-%02d:00 UTC", strings.Join(days, ","), w.From, w.To)
+}
+
+// deployPolicy is which environments are gated and when they may deploy
+// without approval. Outside the window a deployment to a gated environment
+// needs an approval token from ApproveDeploy or a change ticket.
+type deployPolicy struct {
+	Gated  []string
+	Window deployWindow
+}
+
+// weekdays maps the day abbreviations deploy windows are written with
+var weekdays = map[string]time.Weekday{
+	"Sun": time.Sunday, "Mon": time.Monday, "Tue": time.Tuesday, "Wed": time.Wednesday,
+	"Thu": time.Thursday, "Fri": time.Friday, "Sat": time.Saturday,
+}
+
+// deployWindowPattern matches deploy windows, e.g. Mon-Thu 14-20 or Mon,Wed 9-17
+var deployWindowPattern = regexp.MustCompile(`^([A-Z][a-z]{2}(?:[-,][A-Z][a-z]{2})*) ([0-9]{1,2})-([0-9]{1,2})$`)
+
+// newDeployPolicy gates the environments with a window of days and UTC
+// hours, e.g. Mon-Thu 14-20 for Monday to Thursday from 14:00 to 20:00
+func newDeployPolicy(gated []string, window string) (deployPolicy, error) {
+	match := deployWindowPattern.FindStringSubmatch(window)
+	if match == nil {
+		return deployPolicy{}, fmt.Errorf("invalid deploy window %q, e.g. Mon-Thu 14-20", window)
+	}
+	policy := deployPolicy{Gated: gated}
+	for _, days := range strings.Split(match[1], ",") {
+		first, last, isRange := strings.Cut(days, "-")
+		if !isRange {
+			last = first
+		}
+		from, ok := weekdays[first]
+		to, ok2 := weekdays[last]
+		if !ok || !ok2 {
+			return deployPolicy{}, fmt.Errorf("invalid days %q in deploy window %q", days, window)
+		}
+		for d := from; ; d = (d + 1) % 7 {
+			if !slices.Contains(policy.Window.Days, d) {
+				policy.Window.Days = append(policy.Window.Days, d)
+			}
+			if d == to {
+				break
+			}
+		}
+	}
+	policy.Window.From, _ = strconv.Atoi(match[2])
+	policy.Window.To, _ = strconv.Atoi(match[3])
+	if policy.Window.From >= policy.Window.To || policy.Window.To > 24 {
+		return deployPolicy{}, fmt.Errorf("invalid hours in deploy window %q", window)
+	}
+	return policy, nil
+}
+
+func (p deployPolicy) gates(env string) bool {
+	return slices.Contains(p.Gated, env)
+}
+
+// errPolicyRejected is wrapped by the errors of deployments the policy rejects
+var errPolicyRejected = errors.New("rejected by the deploy policy")
+
+// changeTicketPattern matches change ticket references, e.g. CHG-1234
+var changeTicketPattern = regexp.MustCompile(`^[A-Z][A-Z0-9]*-[0-9]+$`)
+
+// approvalParameter is the SSM parameter holding an environment's pending
+// approval. The deploy role may only read and consume it.
+func approvalParameter(env string) string {
+	return "/" + envPrefix(env) + "/deploy-approval"
+}
+
+// changeTicketParameter is the SSM parameter recording that a change ticket
+// was approved for an environment. The deploy role may only read it.
+func changeTicketParameter(env string, ticket string) string {
+	return "/" + envPrefix(env) + "/change-tickets/" + ticket
+}
+
+// changeApproval records until when an approved change ticket authorizes
+// deployments. Unlike a deployApproval it isn't consumed, since one change
+// may deploy several services.
+type changeApproval struct {
+	Ticket  string `json:"ticket"`
+	Expires string `json:"expires"`
+}
+
+// deployApproval is a single-use approval to deploy one image
+type deployApproval struct {
+	Token   string `json:"token"`
+	Image   string `json:"image"`
+	Expires string `json:"expires"`
+}
+
+// checkDeployPolicy returns how a deployment of imageRef to env is allowed,
+// or why the policy rejects it. A valid approval token is consumed.
+func checkDeployPolicy(ctx context.Context, cli *dagger.Container, policy deployPolicy, env string, imageRef string, approvalToken *dagger.Secret, changeTicket string) (allowed string, rejected string, err error) {
+	window := policy.Window
+	switch {
+	case !policy.gates(env):
+		return "ungated", "", nil
+	case approvalToken != nil:
+		token, err := approvalToken.Plaintext(ctx)
+		if err != nil {
+			return "", "", err
+		}
+		value, err := awsOutput(ctx, cli, "ssm", "get-parameter", "--name", approvalParameter(env), "--with-decryption",
+			"--query", "Parameter.Value", "--output", "text")
+		if err != nil && strings.Contains(err.Error(), "ParameterNotFound") {
+			return "", "no pending approval for " + env, nil
+		} else if err != nil {
+			return "", "", err
+		}
+		var approval deployApproval
+		if err := json.Unmarshal([]byte(value), &approval); err != nil {
+			return "", "", fmt.Errorf("invalid approval in %s: %w", approvalParameter(env), err)
+		}
+		expires, _ := time.Parse(time.RFC3339, approval.Expires)
+		switch {
+		case subtle.ConstantTimeCompare([]byte(token), []byte(approval.Token)) != 1:
+			return "", "approval token does not match the pending approval", nil
+		case approval.Image != imageRef:
+			return "", "approval is for " + approval.Image, nil
+		case time.Now().After(expires):
+			return "", "approval expired at " + approval.Expires, nil
+		}
+		if _, err := awsOutput(ctx, cli, "ssm", "delete-parameter", "--name", approvalParameter(env)); err != nil {
+			return "", "", err
+		}
+		return "approval token", "", nil
+	case changeTicket != "":
+		if !changeTicketPattern.MatchString(changeTicket) {
+			return "", fmt.Sprintf("%q is not a change ticket reference, e.g. CHG-1234", changeTicket), nil
+		}
+		// Anyone can make up a ticket number, so it only counts once an
+		// approver has recorded it with ApproveChangeTicket
+		value, err := awsOutput(ctx, cli, "ssm", "get-parameter", "--name", changeTicketParameter(env, changeTicket),
+			"--query", "Parameter.Value", "--output", "text")
+		if err != nil && strings.Contains(err.Error(), "ParameterNotFound") {
+			return "", fmt.Sprintf("change ticket %s is not approved for %s", changeTicket, env), nil
+		} else if err != nil {
+			return "", "", err
+		}
+		var approval changeApproval
+		if err := json.Unmarshal([]byte(value), &approval); err != nil {
+			return "", "", fmt.Errorf("invalid approval in %s: %w", changeTicketParameter(env, changeTicket), err)
+		}
+		expires, _ := time.Parse(time.RFC3339, approval.Expires)
+		if approval.Ticket != changeTicket || time.Now().After(expires) {
+			return "", "approval of change ticket " + changeTicket + " expired at " + approval.Expires, nil
+		}
+		return "change ticket " + changeTicket, "", nil
+	case window.contains(time.Now()):
+		return "deploy window " + window.String(), "", nil
+	}
+	return "", fmt.Sprintf("%s is outside its deploy window (%s); pass an approval token from approve-deploy or a change ticket approved with approve-change-ticket", env, window), nil
+}
+
+// maxRejectedDeploys is how many rejected deployments an environment's item
+// keeps, so repeated rejections can't grow it past DynamoDB's 400 KB item limit
+const maxRejectedDeploys = 20
+
+// recordRejectedDeploy appends a deployment the policy rejected to the
+// environment's item in the deploy table, dropping the oldest rejection once
+// maxRejectedDeploys are kept
+func recordRejectedDeploy(ctx context.Context, cli *dagger.Container, table string, env string, imageRef string, gitSha string, reason string) error {
+	attempt := map[string]dynamoValue{
+		"At":     {S: time.Now().UTC().Format(time.RFC3339)},
+		"Image":  {S: imageRef},
+		"GitSha": {S: gitSha},
+		"Reason": {S: reason},
+	}
+	values, err := json.Marshal(map[string]any{
+		":none":    map[string]any{"L": []any{}},
+		":attempt": map[string]any{"L": []any{map[string]any{"M": attempt}}},
+		":max":     map[string]any{"N": strconv.Itoa(maxRejectedDeploys)},
+	})
+	if err != nil {
+		return err
+	}
+	key, err := json.Marshal(map[string]dynamoValue{"Environment": {S: env}})
+	if err != nil {
+		return err
+	}
+	appendRejected := func() error {
+		_, err := awsOutput(ctx, cli.WithNewFile("/deploy/values.json", string(values)),
+			"dynamodb", "update-item", "--table-name", table, "--key", string(key),
+			"--update-expression", "SET Rejected = list_append(if_not_exists(Rejected, :none), :attempt)",
+			"--condition-expression", "attribute_not_exists(Rejected) OR size(Rejected) < :max",
+			"--expression-attribute-values", "file:///deploy/values.json")
+		return err
+	}
+	err = appendRejected()
+	if err == nil || !strings.Contains(err.Error(), "ConditionalCheckFailedException") {
+		return err
+	}
+	// A list can't be appended to and trimmed in one update expression, so
+	// drop the oldest rejections first to make room for this one
+	count, err := awsOutput(ctx, cli, "dynamodb", "get-item", "--table-name", table, "--key", string(key),
+		"--projection-expression", "Rejected", "--query", "length(Item.Rejected.L)", "--output", "text")
+	if err != nil {
+		return err
+	}
+	kept, err := strconv.Atoi(count)
+	if err != nil {
+		return fmt.Errorf("failed to count the rejected deployments of %s: %w", env, err)
+	}
+	var oldest []string
+	for i := 0; i <= kept-maxRejectedDeploys; i++ {
+		oldest = append(oldest, fmt.Sprintf("Rejected[%d]", i))
+	}
+	if len(oldest) > 0 {
+		if _, err := awsOutput(ctx, cli, "dynamodb", "update-item", "--table-name", table, "--key", string(key),
+			"--update-expression", "REMOVE "+strings.Join(oldest, ", ")); err != nil {
+			return err
+		}
+	}
+	return appendRejected()
+}
+
+// ApproveDeploy issues a single-use token approving one image for a gated
+// environment, e.g. prod outside its deploy window. Pass the token to
+// DeployToECS with --approval-token; it expires after validFor. Approvals
+// are recorded in the audit log.
+func (m *CljXtdbDevops) ApproveDeploy(
+	ctx context.Context,
+	// Environment name, e.g. prod
+	env string,
+	// Image the approval is for
+	imageRef string,
+	// Shared AWS credentials file of the approver
+	awsCreds *dagger.Secret,
+	// +optional
+	// +default="1h"
+	validFor string,
+	// +optional
+	// +default="us-east-1"
+	region string,
+	// Log group of the audit log
+	// +optional
+	// +default="clj-xtdb-devops-audit"
+	auditLogGroup string,
+	// Region of the audit log, the state region
+	// +optional
+	// +default="us-east-1"
+	stateRegion string,
+	// +optional
+	profile string,
+	// Environments whose deployments need approval outside the deploy window
+	// +optional
+	// +default=["prod"]
+	gated []string,
+) (token string, err error) {
+	if !slices.Contains(gated, env) {
+		return "", fmt.Errorf("%s deploys without approval", env)
+	}
+	ttl, err := time.ParseDuration(validFor)
+	if err != nil {
+		return "", fmt.Errorf("invalid validity %q: %w", validFor, err)
+	}
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	cli := awsCli(awsCreds, region, profile).WithEnvVariable("CACHE_BUSTER", time.Now().String())
+	defer func() {
+		event := auditEvent{Function: "ApproveDeploy", Env: env, Params: map[string]string{"image": imageRef, "validFor": validFor}}
+		if auditErr := audit(ctx, cli.WithEnvVariable("AWS_REGION", stateRegion), auditLogGroup, event, err); auditErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to write the audit log: %w", auditErr))
+		}
+	}()
+
+	approval, err := json.Marshal(deployApproval{
+		Token:   hex.EncodeToString(raw),
+		Image:   imageRef,
+		Expires: time.Now().Add(ttl).UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := awsOutput(ctx, cli.WithNewFile("/deploy/approval.json", string(approval)),
+		"ssm", "put-parameter", "--name", approvalParameter(env), "--type", "SecureString", "--overwrite",
+		"--value", "file:///deploy/approval.json"); err != nil {
+		return "", err
+	}
+	fmt.Printf("✅ Approved %s for %s for %s\n", imageRef, env, ttl)
+	return hex.EncodeToString(raw), nil
+}
+
+// ApproveChangeTicket records that a change ticket was approved for a gated
+// environment, so deployments passing it with --change-ticket are allowed
+// outside the deploy window until the approval expires after validFor.
+// Approvals are recorded in the audit log.
+func (m *CljXtdbDevops) ApproveChangeTicket(
+	ctx context.Context,
+	// Environment name, e.g. prod
+	env string,
+	// Approved change ticket, e.g. CHG-1234
+	changeTicket string,
+	// Shared AWS credentials file of the approver
+	awsCreds *dagger.Secret,
+	// +optional
+	// +default="8h"
+	validFor string,
+	// +optional
+	// +default="us-east-1"
+	region string,
+	// Log group of the audit log
+	// +optional
+	// +default="clj-xtdb-devops-audit"
+	auditLogGroup string,
+	// Region of the audit log, the state region
+	// +optional
+	// +default="us-east-1"
+	stateRegion string,
+	// +optional
+	profile string,
+	// Environments whose deployments need approval outside the deploy window
+	// +optional
+	// +default=["prod"]
+	gated []string,
+) (err error) {
+	if !slices.Contains(gated, env) {
+		return fmt.Errorf("%s deploys without approval", env)
+	}
+	if !changeTicketPattern.MatchString(changeTicket) {
+		return fmt.Errorf("%q is not a change ticket reference, e.g. CHG-1234", changeTicket)
+	}
+	ttl, err := time.ParseDuration(validFor)
+	if err != nil {
+		return fmt.Errorf("invalid validity %q: %w", validFor, err)
+	}
+	cli := awsCli(awsCreds, region, profile).WithEnvVariable("CACHE_BUSTER", time.Now().String())
+	defer func() {
+		event := auditEvent{Function: "ApproveChangeTicket", Env: env, Params: map[string]string{"changeTicket": changeTicket, "validFor": validFor}}
+		if auditErr := audit(ctx, cli.WithEnvVariable("AWS_REGION", stateRegion), auditLogGroup, event, err); auditErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to write the audit log: %w", auditErr))
+		}
+	}()
+
+	approval, err := json.Marshal(changeApproval{
+		Ticket:  changeTicket,
+		Expires: time.Now().Add(ttl).UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := awsOutput(ctx, cli.WithNewFile("/deploy/approval.json", string(approval)),
+		"ssm", "put-parameter", "--name", changeTicketParameter(env, changeTicket), "--type", "String", "--overwrite",
+		"--value", "file:///deploy/approval.json"); err != nil {
+		return err
+	}
+	fmt.Printf("✅ Approved change ticket %s for %s for %s\n", changeTicket, env, ttl)
+	return nil
+}
+
+// AuthorizeDeploy checks a deployment of imageRef to env against the deploy
+// policy without deploying anything, for deployments that do not go through
+// DeployToECS, e.g. `xtdbops infra deploy`. It fails like DeployToECS when the
+// policy rejects the deployment and records the rejection in the deploy
+// table. A valid approval token is consumed. Every call is recorded in the
+// audit log.
+func (m *CljXtdbDevops) AuthorizeDeploy(
+	ctx context.Context,
+	// Environment name, e.g. prod
+	env string,
+	// What is deployed, e.g. the image tag the stacks pin
+	imageRef string,
+	// Commit being deployed
+	// +optional
+	gitSha string,
+	// Token from ApproveDeploy, for gated environments outside their deploy window
+	// +optional
+	approvalToken *dagger.Secret,
+	// Change ticket authorizing the deployment, e.g. CHG-1234
+	// +optional
+	changeTicket string,
+	// Shared AWS credentials file
+	awsCreds *dagger.Secret,
+	// +optional
+	// +default="us-east-1"
+	region string,
+	// Table recording what is deployed to each environment
+	// +optional
+	// +default="clj-xtdb-devops-deploys"
+	deployTable string,
+	// Log group of the audit log
+	// +optional
+	// +default="clj-xtdb-devops-audit"
+	auditLogGroup string,
+	// Region of the deploy table and audit log, the state region
+	// +optional
+	// +default="us-east-1"
+	stateRegion string,
+	// +optional
+	profile string,
+	// Environments whose deployments need approval outside the deploy window
+	// +optional
+	// +default=["prod"]
+	gated []string,
+	// When gated environments deploy without approval: days and UTC hours, e.g. Mon-Thu 14-20
+	// +optional
+	// +default="Mon-Thu 14-20"
+	deployWindow string,
+) (allowed string, err error) {
+	policy, err := newDeployPolicy(gated, deployWindow)
+	if err != nil {
+		return "", err
+	}
+	cli := awsCli(awsCreds, region, profile).WithEnvVariable("CACHE_BUSTER", time.Now().String())
+	state := cli.WithEnvVariable("AWS_REGION", stateRegion)
+	params := map[string]string{"image": imageRef, "gitSha": gitSha}
+	defer func() {
+		params["approval"] = allowed
+		if auditErr := audit(ctx, state, auditLogGroup, auditEvent{Function: "AuthorizeDeploy", Env: env, Params: params}, err); auditErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to write the audit log: %w", auditErr))
+		}
+	}()
+
+	allowed, rejected, err := checkDeployPolicy(ctx, cli, policy, env, imageRef, approvalToken, changeTicket)
+	if err != nil {
+		return "", err
+	}
+	if rejected != "" {
+		if err := recordRejectedDeploy(ctx, state, deployTable, env, imageRef, gitSha, rejected); err != nil {
+			return "", err
+		}
+		return "", fmt.Errorf("deploy of %s to %s %w: %s", imageRef, env, errPolicyRejected, rejected)
+	}
+	return allowed, nil
+}
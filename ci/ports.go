@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// hostPorts maps the ports of the returned service to the host ports the
+// caller forwards them to with `service up --ports HOST:CONTAINER`
+type hostPorts map[int]int
+
+// parseHostPorts reads HOST:CONTAINER pairs, as passed to `service up --ports`
+func parseHostPorts(pairs []string) (hostPorts, error) {
+	ports := hostPorts{}
+	for _, pair := range pairs {
+		host, container, ok := strings.Cut(pair, ":")
+		h, hostErr := strconv.Atoi(host)
+		c, containerErr := strconv.Atoi(container)
+		if !ok || hostErr != nil || containerErr != nil {
+			return nil, fmt.Errorf("invalid port mapping %q, want HOST:CONTAINER", pair)
+		}
+		ports[c] = h
+	}
+	return ports, nil
+}
+
+// host returns the host port a container port is forwarded to, fallback
+// when the caller did not say
+func (p hostPorts) host(container, fallback int) int {
+	if h, ok := p[container]; ok {
+		return h
+	}
+	return fallback
+}
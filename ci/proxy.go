@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// proxyRoute maps a hostname served by the reverse proxy to a bound service
+type proxyRoute struct {
+	Name  string
+	Host  string
+	Alias string
+	Port  int
+}
+
+// proxyRoutes are the hostnames the local reverse proxy answers on.
+// *.localhost resolves to the loopback address in browsers, so no hosts
+// file changes are needed.
+var proxyRoutes = []proxyRoute{
+	{Name: "Web Application", Host: "app.localhost", Alias: "app", Port: 58950},
+	{Name: "XTDB HTTP API", Host: "xtdb.localhost", Alias: "xtdb", Port: 3000},
+	{Name: "pgAdmin", Host: "pgadmin.localhost", Alias: "pgadmin", Port: 80},
+}
+
+// pgAdminServers registers the local XTDB pgwire endpoint with pgAdmin
+const pgAdminServers = `{
+  "Servers": {
+    "1": {
+      "Name": "XTDB (local)",
+      "Group": "Servers",
+      "Host": "xtdb",
+      "Port": 5432,
+      "MaintenanceDB": "xtdb",
+      "Username": "postgres",
+      "SSLMode": "disable"
+    }
+  }
+}
+`
+
+// caddyfile renders a Caddyfile with one plain-HTTP site per route
+func caddyfile(routes []proxyRoute) string {
+	var b strings.Builder
+	b.WriteString("{\n\tauto_https off\n}\n")
+	for _, route := range routes {
+		fmt.Fprintf(&b, "\nhttp://%s {\n\treverse_proxy %s:%d\n}\n", route.Host, route.Alias, route.Port)
+	}
+	return b.String()
+}
+
+// BuildProxy creates a Caddy container routing app.localhost, xtdb.localhost
+// and pgadmin.localhost to the matching services
+func (m *CljXtdbDevops) BuildProxy(
+	app *dagger.Service,
+	xtdb *dagger.Service,
+	// Without pgAdmin, pgadmin.localhost is not routed
+	// +optional
+	pgAdmin *dagger.Service,
+) *dagger.Container {
+	fmt.Println("🏗️  Creating reverse proxy container...")
+	routes := proxyRoutes
+	if pgAdmin == nil {
+		routes = withoutRoute(routes, "pgadmin")
+	}
+	proxy := dag.Container().From("caddy:2.9-alpine").
+		WithNewFile("/etc/caddy/Caddyfile", caddyfile(routes)).
+		WithServiceBinding("app", app).
+		WithServiceBinding("xtdb", xtdb)
+	if pgAdmin != nil {
+		proxy = proxy.WithServiceBinding("pgadmin", pgAdmin)
+	}
+	return proxy.WithExposedPort(80)
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// envManifest is the desired state Reconcile converges the environments to,
+// e.g. {"environments": {"staging": {"gitSha": "4f2c1e0", "services":
+// {"app": {"image": "<repo>@sha256:...", "desiredCount": 2}}}}}
+type envManifest struct {
+	Environments map[string]struct {
+		// Region of the environment, when not the one passed to Reconcile
+		Region string `json:"region"`
+		GitSha string `json:"gitSha"`
+		// Services keyed by app or xtdb. A zero desiredCount, or any on a
+		// service with a scaling target, is left to autoscaling.
+		Services map[string]struct {
+			Image        string `json:"image"`
+			DesiredCount int    `json:"desiredCount"`
+		} `json:"services"`
+	} `json:"environments"`
+}
+
+// Reconcile compares the image and desired count of each service in the
+// manifest with what ECS is set to run and converges the services: images
+// are rolled out with DeployToECS, which records the deployment, and
+// desired counts are updated in place. Protected environments are only
+// reported unless approved for the run. Meant to run on a schedule against
+// a manifest kept in git.
+func (m *CljXtdbDevops) Reconcile(
+	ctx context.Context,
+	// Desired state of the environments, see envManifest
+	manifest *dagger.File,
+	// Only reconcile this environment, e.g. when each has its own deploy role
+	// +optional
+	env string,
+	// Only report the differences
+	// +optional
+	dryRun bool,
+	// Environments that are only reported unless listed in approve
+	// +optional
+	// +default=["prod"]
+	protected []string,
+	// Protected environments approved to converge in this run
+	// +optional
+	approve []string,
+	// Change ticket authorizing the run's deployments to gated environments, e.g. CHG-1234
+	// +optional
+	changeTicket string,
+	// Shared AWS credentials file
+	// +optional
+	awsCreds *dagger.Secret,
+	// Role to assume with webIdentityToken instead of using awsCreds, e.g. the deploy role
+	// +optional
+	roleArn string,
+	// OIDC token for roleArn, e.g. a GitHub Actions ID token
+	// +optional
+	webIdentityToken *dagger.Secret,
+	// +optional
+	// +default="us-east-1"
+	region string,
+	// Bucket holding the stacks' Terraform state
+	// +optional
+	// +default="clj-xtdb-devops-tfstate"
+	stateBucket string,
+	// Table recording what is deployed to each environment
+	// +optional
+	// +default="clj-xtdb-devops-deploys"
+	deployTable string,
+	// Log group of the audit log
+	// +optional
+	// +default="clj-xtdb-devops-audit"
+	auditLogGroup string,
+	// Region of the state bucket, deploy table and audit log
+	// +optional
+	// +default="us-east-1"
+	stateRegion string,
+	// Environments whose deployments need approval outside the deploy window
+	// +optional
+	// +default=["prod"]
+	gated []string,
+	// When gated environments deploy without approval: days and UTC hours, e.g. Mon-Thu 14-20
+	// +optional
+	// +default="Mon-Thu 14-20"
+	deployWindow string,
+) (string, error) {
+	if awsCreds == nil && (roleArn == "" || webIdentityToken == nil) {
+		return "", fmt.Errorf("pass either aws-creds or role-arn with web-identity-token")
+	}
+	contents, err := manifest.Contents(ctx)
+	if err != nil {
+		return "", err
+	}
+	var desired envManifest
+	if err := json.Unmarshal([]byte(contents), &desired); err != nil {
+		return "", fmt.Errorf("invalid manifest: %w", err)
+	}
+	if _, ok := desired.Environments[env]; env != "" && !ok {
+		return "", fmt.Errorf("environment %s is not in the manifest", env)
+	}
+	// Every run must see the live services, never a cached result
+	cli := withAwsAuth(dag.Container().From(awsCliImage), awsCreds, roleArn, webIdentityToken).
+		WithEnvVariable("AWS_PAGER", "").
+		WithEnvVariable("CACHE_BUSTER", time.Now().String())
+	state := cli.WithEnvVariable("AWS_REGION", stateRegion)
+
+	var report strings.Builder
+	for _, name := range slices.Sorted(maps.Keys(desired.Environments)) {
+		if env != "" && name != env {
+			continue
+		}
+		target := desired.Environments[name]
+		envRegion := region
+		if target.Region != "" {
+			envRegion = target.Region
+		}
+		ecs := cli.WithEnvVariable("AWS_REGION", envRegion)
+		approved := !slices.Contains(protected, name) || slices.Contains(approve, name)
+		fmt.Printf("🔁 Reconciling %s...\n", name)
+		deployed, err := appStackTarget(ctx, state, stateBucket, name)
+		if err != nil {
+			return report.String(), err
+		}
+
+		for _, service := range slices.Sorted(maps.Keys(target.Services)) {
+			want := target.Services[service]
+			container, ok := serviceContainers[service]
+			if !ok {
+				return report.String(), fmt.Errorf("%s: unknown service %q (use app or xtdb)", name, service)
+			}
+			id := name + "/" + service
+			cluster, ecsName := deployed.Cluster, deployed.Services[service]
+			if ecsName == "" {
+				return report.String(), fmt.Errorf("%s: the app stack has no %s service", name, service)
+			}
+			current, err := describeService(ctx, ecs, cluster, ecsName)
+			if err != nil {
+				return report.String(), err
+			}
+			taskDef, err := describeTaskDefinition(ctx, ecs, current.TaskDefinition)
+			if err != nil {
+				return report.String(), err
+			}
+			def := containerDefinition(taskDef, container)
+			if def == nil {
+				return report.String(), fmt.Errorf("task definition %s has no %s container", current.TaskDefinition, container)
+			}
+
+			var changes []string
+			image, _ := def["image"].(string)
+			if want.Image != "" && want.Image != image {
+				changes = append(changes, fmt.Sprintf("image %s → %s", image, want.Image))
+			}
+			resetCount := want.DesiredCount > 0 && want.DesiredCount != current.DesiredCount
+			if resetCount {
+				// A service with a scaling target is sized by its policies;
+				// resetting it would fight them on every run
+				scaled, err := awsOutput(ctx, ecs, "application-autoscaling", "describe-scalable-targets", "--service-namespace", "ecs",
+					"--resource-ids", "service/"+cluster+"/"+ecsName, "--query", "length(ScalableTargets)", "--output", "text")
+				if err != nil {
+					return report.String(), err
+				}
+				if scaled != "0" {
+					resetCount = false
+					fmt.Fprintf(&report, "📈 %s is autoscaled, leaving its desired count at %d\n", id, current.DesiredCount)
+				}
+			}
+			if resetCount {
+				changes = append(changes, fmt.Sprintf("desired count %d → %d", current.DesiredCount, want.DesiredCount))
+			}
+			switch {
+			case len(changes) == 0:
+				fmt.Fprintf(&report, "✅ %s matches the manifest\n", id)
+				continue
+			case dryRun:
+				fmt.Fprintf(&report, "🔍 %s would change: %s\n", id, strings.Join(changes, ", "))
+				continue
+			case !approved:
+				fmt.Fprintf(&report, "⏸️  %s needs approval to change: %s\n", id, strings.Join(changes, ", "))
+				continue
+			}
+
+			if resetCount {
+				_, err := awsOutput(ctx, ecs, "ecs", "update-service", "--cluster", cluster, "--service", ecsName,
+					"--desired-count", fmt.Sprint(want.DesiredCount), "--query", "service.serviceName", "--output", "text")
+				event := auditEvent{Function: "Reconcile", Env: name, Params: map[string]string{
+					"service": service, "desiredCount": fmt.Sprint(want.DesiredCount),
+				}}
+				if auditErr := audit(ctx, state, auditLogGroup, event, err); auditErr != nil {
+					err = errors.Join(err, fmt.Errorf("failed to write the audit log: %w", auditErr))
+				}
+				if err != nil {
+					return report.String(), err
+				}
+			}
+			if want.Image != "" && want.Image != image {
+				if _, err := m.DeployToECS(ctx, name, want.Image, target.GitSha, service, nil, changeTicket, awsCreds, roleArn, webIdentityToken,
+					envRegion, stateBucket, deployTable, auditLogGroup, stateRegion, gated, deployWindow); err != nil {
+					return report.String(), err
+				}
+			}
+			fmt.Fprintf(&report, "🔄 %s converged: %s\n", id, strings.Join(changes, ", "))
+		}
+	}
+	return report.String(), nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Retry policy used unless with-retry-policy says otherwise
+const (
+	defaultRetryAttempts = 3
+	defaultRetryBackoff  = 2 * time.Second
+)
+
+// awsMaxAttempts is how often the AWS CLI tries a call, backing off on
+// throttling and transient errors itself
+const awsMaxAttempts = 5
+
+// transientErrors are the error messages worth another attempt: network
+// blips, registry and API rate limits, and overloaded endpoints
+var transientErrors = []string{
+	"timeout",
+	"timed out",
+	"connection refused",
+	"connection reset",
+	"broken pipe",
+	"unexpected eof",
+	"tls handshake",
+	"temporary failure",
+	"too many requests",
+	"toomanyrequests",
+	"throttl",
+	"service unavailable",
+	"bad gateway",
+	"gateway timeout",
+	" 429 ",
+	" 502 ",
+	" 503 ",
+	" 504 ",
+}
+
+// retryPolicy is how operations retry transient failures. The zero value
+// uses the defaults with no limit per attempt.
+type retryPolicy struct {
+	attempts int
+	backoff  time.Duration
+	timeout  time.Duration
+}
+
+// WithRetryPolicy sets how the functions chained after it retry transient
+// failures of registry pushes and local service starts, and how long each
+// attempt may take
+func (m *CljXtdbDevops) WithRetryPolicy(
+	// Attempts per operation, 1 to never retry
+	// +optional
+	// +default=3
+	attempts int,
+	// Wait before the second attempt, doubling for each further one
+	// +optional
+	// +default="2s"
+	backoff string,
+	// Limit on each attempt, e.g. 10m (default none)
+	// +optional
+	timeout string,
+) (*CljXtdbDevops, error) {
+	if attempts < 1 {
+		return nil, fmt.Errorf("attempts must be at least 1")
+	}
+	if _, err := time.ParseDuration(backoff); err != nil {
+		return nil, fmt.Errorf("invalid backoff %q: %w", backoff, err)
+	}
+	if timeout != "" {
+		if _, err := time.ParseDuration(timeout); err != nil {
+			return nil, fmt.Errorf("invalid timeout %q: %w", timeout, err)
+		}
+	}
+	m.RetryAttempts = attempts
+	m.RetryBackoff = backoff
+	m.OperationTimeout = timeout
+	return m, nil
+}
+
+// retryPolicy returns the policy set by with-retry-policy, already validated
+func (m *CljXtdbDevops) retryPolicy() retryPolicy {
+	policy := retryPolicy{attempts: m.RetryAttempts}
+	policy.backoff, _ = time.ParseDuration(m.RetryBackoff)
+	policy.timeout, _ = time.ParseDuration(m.OperationTimeout)
+	return policy
+}
+
+// transient tells whether an error is worth another attempt
+func transient(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, s := range transientErrors {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// do runs an operation until it succeeds, fails for good or runs out of
+// attempts, each attempt limited to the policy's timeout
+func (p retryPolicy) do(ctx context.Context, what string, op func(ctx context.Context) error) error {
+	attempts := p.attempts
+	if attempts < 1 {
+		attempts = defaultRetryAttempts
+	}
+	backoff := p.backoff
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if p.timeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, p.timeout)
+		}
+		err = op(attemptCtx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil || !transient(err) {
+			return err
+		}
+		if attempt == attempts {
+			break
+		}
+		fmt.Printf("⚠️  %s failed (attempt %d of %d), retrying in %s: %v\n", what, attempt, attempts, backoff, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	return fmt.Errorf("%s failed after %d attempts: %w", what, attempts, err)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// searchLine is a line of surrounding context
+type searchLine struct {
+	Line int    `json:"line"`
+	Text string `json:"text"`
+}
+
+// searchMatch is a single matching line
+type searchMatch struct {
+	File   string       `json:"file"`
+	Line   int          `json:"line"`
+	Text   string       `json:"text"`
+	Before []searchLine `json:"before,omitempty"`
+	After  []searchLine `json:"after,omitempty"`
+}
+
+// rgEvent is the subset of ripgrep's --json output we read
+type rgEvent struct {
+	Type string `json:"type"`
+	Data struct {
+		Path struct {
+			Text string `json:"text"`
+		} `json:"path"`
+		Lines struct {
+			Text string `json:"text"`
+		} `json:"lines"`
+		LineNumber int `json:"line_number"`
+	} `json:"data"`
+}
+
+// parseRipgrep folds ripgrep's match and context events into matches
+func parseRipgrep(output string, contextLines int) ([]searchMatch, error) {
+	matches := []searchMatch{}
+	var pending []searchLine
+	last := -1
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var event rgEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return nil, fmt.Errorf("failed to decode ripgrep output: %w", err)
+		}
+		line := searchLine{Line: event.Data.LineNumber, Text: strings.TrimRight(event.Data.Lines.Text, "\r\n")}
+
+		switch event.Type {
+		case "begin":
+			pending, last = nil, -1
+		case "context":
+			if last >= 0 && line.Line-matches[last].Line <= contextLines {
+				matches[last].After = append(matches[last].After, line)
+			}
+			pending = append(pending, line)
+		case "match":
+			match := searchMatch{File: strings.TrimPrefix(event.Data.Path.Text, "./"), Line: line.Line, Text: line.Text}
+			for _, before := range pending {
+				if match.Line-before.Line <= contextLines {
+					match.Before = append(match.Before, before)
+				}
+			}
+			if last >= 0 && match.Line-matches[last].Line <= contextLines {
+				matches[last].After = append(matches[last].After, line)
+			}
+			pending = nil
+			matches = append(matches, match)
+			last = len(matches) - 1
+		}
+	}
+	return matches, scanner.Err()
+}
+
+// Search finds pattern matches in a directory and returns them as JSON (file, line, text and context)
+func (m *CljXtdbDevops) Search(
+	ctx context.Context,
+	dir *dagger.Directory,
+	pattern string,
+	// Only search files matching these globs, e.g. **/*.clj
+	// +optional
+	includeGlobs []string,
+	// Lines of context to include around each match
+	// +optional
+	contextLines int,
+	// Treat the pattern as a regular expression instead of a literal string
+	// +optional
+	regex bool,
+	// Return an error when anything matches, for pipeline checks
+	// +optional
+	failOnMatch bool,
+) (string, error) {
+	args := []string{"rg", "--json", "--context", fmt.Sprint(contextLines)}
+	if !regex {
+		args = append(args, "--fixed-strings")
+	}
+	for _, glob := range includeGlobs {
+		args = append(args, "--glob", glob)
+	}
+	args = append(args, "--", pattern, ".")
+
+	rg := dag.Container().From("alpine:3.21").
+		WithExec([]string{"apk", "add", "--no-cache", "ripgrep"}).
+		WithMountedDirectory("/mnt", dir).
+		WithWorkdir("/mnt").
+		WithExec(args, dagger.ContainerWithExecOpts{Expect: dagger.ReturnTypeAny})
+
+	// ripgrep exits 1 when nothing matched and 2 on errors
+	code, err := rg.ExitCode(ctx)
+	if err != nil {
+		return "", err
+	}
+	if code > 1 {
+		stderr, _ := rg.Stderr(ctx)
+		return "", fmt.Errorf("search failed: %s", stderr)
+	}
+	stdout, err := rg.Stdout(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	matches, err := parseRipgrep(stdout, contextLines)
+	if err != nil {
+		return "", err
+	}
+	out, err := json.MarshalIndent(matches, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if failOnMatch && len(matches) > 0 {
+		for _, match := range matches {
+			fmt.Printf("  %s:%d: %s\n", match.File, match.Line, match.Text)
+		}
+		return string(out), fmt.Errorf("found %d match(es) for %q", len(matches), pattern)
+	}
+	return string(out), nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// gitleaksFinding is the subset of a gitleaks JSON report entry we print
+type gitleaksFinding struct {
+	RuleID      string `json:"RuleID"`
+	File        string `json:"File"`
+	StartLine   int    `json:"StartLine"`
+	Fingerprint string `json:"Fingerprint"`
+}
+
+// SecretScan fails if gitleaks finds credentials or tokens in the source tree
+func (m *CljXtdbDevops) SecretScan(
+	ctx context.Context,
+	srcDir *dagger.Directory,
+	// .gitleaksignore file listing fingerprints of known false positives
+	// +optional
+	allowlist *dagger.File,
+) (string, error) {
+	fmt.Println("🔐 Scanning source tree for secrets...")
+	scanner := dag.Container().From("zricethezav/gitleaks:v8.22.1").
+		WithMountedDirectory("/src", srcDir).
+		WithWorkdir("/src")
+
+	args := []string{"gitleaks", "detect", "--no-git", "--source", ".", "--redact",
+		"--report-format", "json", "--report-path", "/tmp/gitleaks.json", "--exit-code", "1"}
+	if allowlist != nil {
+		scanner = scanner.WithMountedFile("/tmp/.gitleaksignore", allowlist)
+		args = append(args, "--gitleaks-ignore-path", "/tmp/.gitleaksignore")
+	}
+	scanner = scanner.
+		WithoutEntrypoint().
+		WithExec(args, dagger.ContainerWithExecOpts{Expect: dagger.ReturnTypeAny})
+
+	code, err := scanner.ExitCode(ctx)
+	if err != nil {
+		return "", err
+	}
+	switch code {
+	case 0:
+		fmt.Println("✅ No secrets found")
+		return "no secrets found", nil
+	case 1:
+	default:
+		stderr, _ := scanner.Stderr(ctx)
+		return "", fmt.Errorf("gitleaks failed with exit code %d: %s", code, stderr)
+	}
+
+	report, err := scanner.File("/tmp/gitleaks.json").Contents(ctx)
+	if err != nil {
+		return "", err
+	}
+	var findings []gitleaksFinding
+	if err := json.Unmarshal([]byte(report), &findings); err != nil {
+		return "", fmt.Errorf("failed to decode gitleaks report: %w", err)
+	}
+	for _, f := range findings {
+		fmt.Printf("  ❌ %s:%d %s (fingerprint %s)\n", f.File, f.StartLine, f.RuleID, f.Fingerprint)
+	}
+	return report, fmt.Errorf("found %d potential secret(s); add false positives to the allowlist by fingerprint", len(findings))
+}
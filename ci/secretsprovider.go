@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// Releases of the secrets backend clients
+const (
+	vaultImage  = "hashicorp/vault:1.18"
+	sopsVersion = "3.9.4"
+)
+
+// secretFile is where the backends' clients write a secret value, keeping it
+// out of the exec's stdout and so out of the engine's progress output
+const secretFile = "/tmp/secret"
+
+// readSecret runs a backend client that prints a secret value and reads the
+// value back from secretFile
+func readSecret(ctx context.Context, ctr *dagger.Container, args []string) (string, error) {
+	value, err := ctr.
+		WithExec(args, dagger.ContainerWithExecOpts{RedirectStdout: secretFile}).
+		File(secretFile).
+		Contents(ctx)
+	return strings.TrimSpace(value), err
+}
+
+// sopsBinary returns the sops release for the engine's platform, e.g. the
+// arm64 build on Apple Silicon
+func sopsBinary(ctx context.Context) (*dagger.File, error) {
+	platform, err := dag.DefaultPlatform(ctx)
+	if err != nil {
+		return nil, err
+	}
+	arch := "amd64"
+	if strings.HasPrefix(strings.TrimPrefix(string(platform), "linux/"), "arm64") {
+		arch = "arm64"
+	}
+	return dag.HTTP(fmt.Sprintf("https://github.com/getsops/sops/releases/download/v%s/sops-v%s.linux.%s", sopsVersion, sopsVersion, arch)), nil
+}
+
+// Names of the credentials functions resolve when they are not passed them
+const (
+	flyTokenSecret         = "fly-api-token"
+	digitalOceanSecret     = "digitalocean-token"
+	infracostKeySecret     = "infracost-api-key"
+	registryPasswordSecret = "registry-password"
+)
+
+// SecretsProvider resolves named credentials, e.g. fly-api-token, for the
+// functions that are not passed them directly. The backends read values in
+// a container, so the engine holds them like any exec output; credentials
+// passed as vault://, cmd:// or env: secrets on the command line never
+// enter a container in plaintext.
+type SecretsProvider interface {
+	Secret(ctx context.Context, name string) (*dagger.Secret, error)
+}
+
+// daggerSecrets only has the secrets passed to the call, so every lookup
+// fails with a hint to pass the credential or configure a backend
+type daggerSecrets struct{}
+
+func (daggerSecrets) Secret(ctx context.Context, name string) (*dagger.Secret, error) {
+	return nil, fmt.Errorf("no %s passed and no secrets backend configured; pass it, or chain with-vault-secrets, with-aws-secrets or with-sops-secrets", name)
+}
+
+// awsSecretsManager reads the SecretString of <prefix><name>
+type awsSecretsManager struct {
+	cli    *dagger.Container
+	prefix string
+}
+
+func (p awsSecretsManager) Secret(ctx context.Context, name string) (*dagger.Secret, error) {
+	value, err := readSecret(ctx, p.cli, []string{"aws", "secretsmanager", "get-secret-value", "--secret-id", p.prefix + name,
+		"--query", "SecretString", "--output", "text"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s from secrets manager: %w", name, err)
+	}
+	return dag.SetSecret(name, value), nil
+}
+
+// vaultSecrets reads a field of a KV secret, name being path or path#field
+// with the field defaulting to value
+type vaultSecrets struct {
+	addr  string
+	mount string
+	token *dagger.Secret
+}
+
+func (p vaultSecrets) Secret(ctx context.Context, name string) (*dagger.Secret, error) {
+	path, field, ok := strings.Cut(name, "#")
+	if !ok {
+		field = "value"
+	}
+	vault := dag.Container().From(vaultImage).
+		WithEnvVariable("VAULT_ADDR", p.addr).
+		WithSecretVariable("VAULT_TOKEN", p.token).
+		WithEnvVariable("CACHE_BUSTER", time.Now().String())
+	value, err := readSecret(ctx, vault, []string{"vault", "kv", "get", "-mount=" + p.mount, "-field=" + field, path})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s from vault: %w", name, err)
+	}
+	return dag.SetSecret(name, value), nil
+}
+
+// sopsSecrets reads a top-level key of a SOPS-encrypted file with an age key
+type sopsSecrets struct {
+	file   *dagger.File
+	ageKey *dagger.Secret
+}
+
+func (p sopsSecrets) Secret(ctx context.Context, name string) (*dagger.Secret, error) {
+	// sops tells the format from the extension
+	filename, err := p.file.Name(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sops, err := sopsBinary(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctr := dag.Container().From("alpine:3.21").
+		WithFile("/usr/local/bin/sops", sops, dagger.ContainerWithFileOpts{Permissions: 0o755}).
+		WithMountedFile("/secrets/"+filename, p.file).
+		WithSecretVariable("SOPS_AGE_KEY", p.ageKey)
+	value, err := readSecret(ctx, ctr, []string{"sops", "--decrypt", "--extract", fmt.Sprintf("[%q]", name), "/secrets/" + filename})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s from %s: %w", name, filename, err)
+	}
+	return dag.SetSecret(name, value), nil
+}
+
+// secrets returns the backend configured by the with-*-secrets functions
+func (m *CljXtdbDevops) secrets() SecretsProvider {
+	switch m.SecretsBackend {
+	case "aws":
+		cli := withAwsAuth(dag.Container().From(awsCliImage), m.AwsSecretsCreds, m.AwsSecretsRoleArn, m.AwsSecretsWebIdentityToken).
+			WithEnvVariable("AWS_REGION", m.AwsSecretsRegion).
+			WithEnvVariable("AWS_PAGER", "").
+			WithEnvVariable("CACHE_BUSTER", time.Now().String())
+		return awsSecretsManager{cli: cli, prefix: m.AwsSecretsPrefix}
+	case "vault":
+		return vaultSecrets{addr: m.VaultAddr, mount: m.VaultMount, token: m.VaultToken}
+	case "sops":
+		return sopsSecrets{file: m.SopsFile, ageKey: m.SopsAgeKey}
+	}
+	return daggerSecrets{}
+}
+
+// secret returns the credential passed to a function, or resolves it by name
+// through the configured backend
+func (m *CljXtdbDevops) secret(ctx context.Context, passed *dagger.Secret, name string) (*dagger.Secret, error) {
+	if passed != nil {
+		return passed, nil
+	}
+	return m.secrets().Secret(ctx, name)
+}
+
+// WithVaultSecrets resolves the credentials functions are not passed from
+// a HashiCorp Vault KV engine, e.g. fly-api-token or ci/fly#token. Passing a
+// credential itself as vault://ci/fly.token keeps it out of any container.
+func (m *CljXtdbDevops) WithVaultSecrets(
+	// Vault address, e.g. https://vault.example.com:8200
+	addr string,
+	token *dagger.Secret,
+	// KV secrets engine mount
+	// +optional
+	// +default="secret"
+	mount string,
+) *CljXtdbDevops {
+	m.SecretsBackend = "vault"
+	m.VaultAddr, m.VaultMount, m.VaultToken = addr, mount, token
+	return m
+}
+
+// WithAwsSecrets resolves the credentials functions are not passed from AWS
+// Secrets Manager, as the secret <prefix><name>
+func (m *CljXtdbDevops) WithAwsSecrets(
+	// Shared AWS credentials file
+	// +optional
+	awsCreds *dagger.Secret,
+	// Role to assume with webIdentityToken instead of using awsCreds, e.g. the deploy role
+	// +optional
+	roleArn string,
+	// OIDC token for roleArn, e.g. a GitHub Actions ID token
+	// +optional
+	webIdentityToken *dagger.Secret,
+	// +optional
+	// +default="us-east-1"
+	region string,
+	// Prefix of the secret names
+	// +optional
+	// +default="clj-xtdb-devops/ci/"
+	prefix string,
+) (*CljXtdbDevops, error) {
+	if awsCreds == nil && (roleArn == "" || webIdentityToken == nil) {
+		return nil, fmt.Errorf("pass either aws-creds or role-arn with web-identity-token")
+	}
+	m.SecretsBackend = "aws"
+	m.AwsSecretsCreds, m.AwsSecretsRoleArn, m.AwsSecretsWebIdentityToken = awsCreds, roleArn, webIdentityToken
+	m.AwsSecretsRegion, m.AwsSecretsPrefix = region, prefix
+	return m, nil
+}
+
+// WithSopsSecrets resolves the credentials functions are not passed from
+// the top-level keys of a SOPS-encrypted YAML, JSON or dotenv file
+func (m *CljXtdbDevops) WithSopsSecrets(
+	file *dagger.File,
+	// age private key the file is encrypted to
+	ageKey *dagger.Secret,
+) *CljXtdbDevops {
+	m.SecretsBackend = "sops"
+	m.SopsFile, m.SopsAgeKey = file, ageKey
+	return m
+}
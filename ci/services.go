@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// ServiceSpec describes an extra service for the local environments, e.g.
+// Redis, Mailhog or a mock SMS gateway
+type ServiceSpec struct {
+	// Hostname the app and dependent services reach it on
+	Name  string `json:"name"`
+	Image string `json:"image"`
+	// Environment variables, as KEY=value
+	Env   []string `json:"env,omitempty"`
+	Ports []int    `json:"ports,omitempty"`
+	// Services to start first and bind: xtdb, other extra services, or
+	// vault, keycloak and wiremock when the environment runs them
+	DependsOn []string `json:"dependsOn,omitempty"`
+	// Shell command, run in the same image, that succeeds once the service
+	// is ready, e.g. redis-cli -h redis ping
+	Readiness string `json:"readiness,omitempty"`
+}
+
+// mailhogSpec captures the app's outbound email, over SMTP on 1025, and
+// shows it in a web UI on 8025
+var mailhogSpec = ServiceSpec{
+	Name:      "mailhog",
+	Image:     "mailhog/mailhog:v1.0.1",
+	Ports:     []int{1025, 8025},
+	Readiness: "wget -q -O /dev/null http://mailhog:8025",
+}
+
+// mailhogRoute serves the Mailhog UI through the local reverse proxy
+var mailhogRoute = proxyRoute{Name: "Mailhog", Host: "mailhog.localhost", Alias: "mailhog", Port: 8025}
+
+// WithExtraService adds a service to the local environments started by
+// run-local-development and run-local-web-app
+func (m *CljXtdbDevops) WithExtraService(
+	name string,
+	image string,
+	// Environment variables, as KEY=value
+	// +optional
+	env []string,
+	// +optional
+	ports []int,
+	// Services to start first and bind: xtdb, other extra services, or
+	// vault, keycloak and wiremock when the environment runs them
+	// +optional
+	dependsOn []string,
+	// Shell command, run in the same image, that succeeds once the service is ready
+	// +optional
+	readiness string,
+) *CljXtdbDevops {
+	m.ExtraServices = append(m.ExtraServices, ServiceSpec{
+		Name: name, Image: image, Env: env, Ports: ports, DependsOn: dependsOn, Readiness: readiness,
+	})
+	return m
+}
+
+// WithExtraServices adds the services of a JSON array of ServiceSpec, e.g.
+// [{"name": "redis", "image": "redis:7", "ports": [6379]}], to the local
+// environments
+func (m *CljXtdbDevops) WithExtraServices(ctx context.Context, specs *dagger.File) (*CljXtdbDevops, error) {
+	var extra []ServiceSpec
+	if err := readJSONFile(ctx, specs, &extra); err != nil {
+		return nil, fmt.Errorf("failed to read service specs: %w", err)
+	}
+	m.ExtraServices = append(m.ExtraServices, extra...)
+	return m, nil
+}
+
+// graphExtraServices adds the extra services to the service graph of a
+// local environment, after checking their specs
+func (m *CljXtdbDevops) graphExtraServices(g *serviceGraph) error {
+	for _, spec := range m.ExtraServices {
+		if spec.Name == "" || spec.Image == "" {
+			return fmt.Errorf("extra services need a name and an image")
+		}
+		if spec.Name == "app" {
+			return fmt.Errorf("extra service name %q is reserved", spec.Name)
+		}
+		for _, kv := range spec.Env {
+			if !strings.Contains(kv, "=") {
+				return fmt.Errorf("env %q of extra service %q is not KEY=value", kv, spec.Name)
+			}
+		}
+		g.add(serviceNode{
+			Name:       spec.Name,
+			DependsOn:  spec.DependsOn,
+			Readiness:  spec.Readiness,
+			ProbeImage: spec.Image,
+			Start: func(ctx context.Context, deps map[string]*dagger.Service) (*dagger.Service, error) {
+				ctr := dag.Container().From(spec.Image)
+				for _, kv := range spec.Env {
+					key, value, _ := strings.Cut(kv, "=")
+					ctr = ctr.WithEnvVariable(key, value)
+				}
+				for _, port := range spec.Ports {
+					ctr = ctr.WithExposedPort(port)
+				}
+				for _, dep := range spec.DependsOn {
+					ctr = ctr.WithServiceBinding(dep, deps[dep])
+				}
+				// Use the image's own entrypoint and command
+				return ctr.AsService(dagger.ContainerAsServiceOpts{UseEntrypoint: true}).Start(ctx)
+			},
+		})
+	}
+	return nil
+}
+
+// skipExtraServices notes the extra services the lite profile leaves out
+func skipExtraServices(specs []ServiceSpec) {
+	for _, spec := range specs {
+		fmt.Printf("⏭️  Skipping %s in the lite profile\n", spec.Name)
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// jmxExporterURL is the Prometheus JMX exporter agent the app is run with
+// during soak tests, serving JVM metrics on port 9404
+const jmxExporterURL = "https://repo1.maven.org/maven2/io/prometheus/jmx/jmx_prometheus_javaagent/1.0.1/jmx_prometheus_javaagent-1.0.1.jar"
+
+// jmxExporterConfig exports the gauges of HikariCP pools, which the app
+// registers as MBeans with registerMbeans, next to the JVM metrics
+const jmxExporterConfig = `lowercaseOutputName: true
+rules:
+  - pattern: 'com.zaxxer.hikari<type=Pool \((.+)\)><>(ActiveConnections|IdleConnections|TotalConnections|ThreadsAwaitingConnection)'
+    name: hikaricp_$2
+    labels:
+      pool: $1
+`
+
+// soakMetrics are the metrics sampled during a soak test, by the names of
+// the 1.x exporter and then the 0.x one. Growth of the gauges from the first
+// to the last quarter of the run fails it; GC seconds is a counter, checked
+// as the share of time spent in GC instead.
+var soakMetrics = []struct {
+	Name   string
+	Series []string
+	Labels string
+}{
+	{"heap bytes", []string{"jvm_memory_used_bytes", "jvm_memory_bytes_used"}, `area="heap"`},
+	{"GC seconds", []string{"jvm_gc_collection_seconds_sum"}, ""},
+	{"threads", []string{"jvm_threads_live_threads", "jvm_threads_current"}, ""},
+	{"open files", []string{"process_open_fds"}, ""},
+	// Connections checked out and never returned, and requests queuing for them
+	{"pool active connections", []string{"hikaricp_activeconnections"}, ""},
+	{"pool pending threads", []string{"hikaricp_threadsawaitingconnection"}, ""},
+}
+
+// gcShare is the sample metric of the percentage of time spent in GC since
+// the previous sample
+const gcShare = "GC share"
+
+// soakSample is one sampling window of a soak test
+type soakSample struct {
+	At       time.Duration
+	Requests int
+	Errors   int
+	Metrics  map[string]float64
+}
+
+func (s soakSample) errorRate() float64 {
+	if s.Requests == 0 {
+		return 0
+	}
+	return float64(s.Errors) / float64(s.Requests) * 100
+}
+
+// promValue sums the samples of the first of a metric's series names found
+// in Prometheus text output, restricted to series with a label
+func promValue(text string, series []string, label string) (float64, bool) {
+	for _, name := range series {
+		total, found := 0.0, false
+		scanner := bufio.NewScanner(strings.NewReader(text))
+		for scanner.Scan() {
+			line := scanner.Text()
+			if !strings.HasPrefix(line, name+"{") && !strings.HasPrefix(line, name+" ") {
+				continue
+			}
+			if label != "" && !strings.Contains(line, label) {
+				continue
+			}
+			fields := strings.Fields(line)
+			value, err := strconv.ParseFloat(fields[len(fields)-1], 64)
+			if err != nil {
+				continue
+			}
+			total, found = total+value, true
+		}
+		if found {
+			return total, true
+		}
+	}
+	return 0, false
+}
+
+// windowMean averages a metric over a slice of samples
+func windowMean(samples []soakSample, metric func(soakSample) float64) float64 {
+	if len(samples) == 0 {
+		return 0
+	}
+	total := 0.0
+	for _, s := range samples {
+		total += metric(s)
+	}
+	return total / float64(len(samples))
+}
+
+// SoakTest keeps the app and XTDB under steady synthetic load for hours,
+// sampling the app's JVM and connection pool metrics, and fails when heap,
+// threads, open files or pool connections keep growing (leaks), or the
+// error rate or time spent in GC drifts up. Meant for a nightly job.
+func (m *CljXtdbDevops) SoakTest(
+	ctx context.Context,
+	srcDir *dagger.Directory,
+	// +optional
+	// +default=4
+	hours int,
+	// Requests per second
+	// +optional
+	// +default=5
+	rps int,
+	// Paths requested in turn (default /)
+	// +optional
+	paths []string,
+	// Time between metric samples
+	// +optional
+	// +default="5m"
+	sampleInterval string,
+	// Maximum growth of heap, threads and open files from the first to the
+	// last quarter of the run, in percent
+	// +optional
+	// +default=50
+	maxGrowth int,
+	// Maximum rise of the error rate from the first to the last quarter of
+	// the run, in percentage points
+	// +optional
+	// +default=1
+	maxErrorDrift int,
+	// Maximum rise of the share of time spent in GC from the first to the
+	// last quarter of the run, in percentage points
+	// +optional
+	// +default=5
+	maxGcDrift int,
+) (string, error) {
+	interval, err := time.ParseDuration(sampleInterval)
+	if err != nil {
+		return "", fmt.Errorf("invalid sample interval %q: %w", sampleInterval, err)
+	}
+	if rps < 1 {
+		return "", fmt.Errorf("rps must be at least 1")
+	}
+	if len(paths) == 0 {
+		paths = []string{"/"}
+	}
+	duration := time.Duration(hours) * time.Hour
+	if duration < 4*interval {
+		return "", fmt.Errorf("a %s soak test needs a sample interval under %s", duration, duration/4)
+	}
+
+	fmt.Println("📦 Building web application...")
+	xtdb := m.BuildXTDB().AsService()
+	app, err := m.BuildCljWebApp(srcDir).
+		WithFile("/jmx/agent.jar", dag.HTTP(jmxExporterURL)).
+		WithNewFile("/jmx/config.yaml", jmxExporterConfig).
+		WithEnvVariable("JAVA_TOOL_OPTIONS", "-javaagent:/jmx/agent.jar=9404:/jmx/config.yaml").
+		WithExposedPort(9404).
+		WithEnvVariable("XTDB_HOST", "xtdb").
+		WithServiceBinding("xtdb", xtdb).
+		AsService().
+		Start(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to start the app: %w", err)
+	}
+	appURL, err := app.Endpoint(ctx, dagger.ServiceEndpointOpts{Port: 58950, Scheme: "http"})
+	if err != nil {
+		return "", err
+	}
+	metricsURL, err := app.Endpoint(ctx, dagger.ServiceEndpointOpts{Port: 9404, Scheme: "http"})
+	if err != nil {
+		return "", err
+	}
+	appURL = strings.TrimSuffix(appURL, "/")
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	var mu sync.Mutex
+	var requests, failures int
+	loadCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(time.Second / time.Duration(rps))
+		defer ticker.Stop()
+		for i := 0; ; i++ {
+			select {
+			case <-loadCtx.Done():
+				return
+			case <-ticker.C:
+			}
+			path := paths[i%len(paths)]
+			go func() {
+				failed := true
+				req, err := http.NewRequestWithContext(loadCtx, http.MethodGet, appURL+path, nil)
+				if err == nil {
+					if resp, err := client.Do(req); err == nil {
+						io.Copy(io.Discard, resp.Body)
+						resp.Body.Close()
+						failed = resp.StatusCode >= 500
+					}
+				}
+				mu.Lock()
+				requests++
+				if failed {
+					failures++
+				}
+				mu.Unlock()
+			}()
+		}
+	}()
+
+	fmt.Printf("🔥 Soaking %s at %d req/s for %s, sampling every %s...\n", strings.Join(paths, ", "), rps, duration, interval)
+	var samples []soakSample
+	start := time.Now()
+	for time.Since(start) < duration {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(interval):
+		}
+		mu.Lock()
+		sample := soakSample{At: time.Since(start).Round(time.Second), Requests: requests, Errors: failures, Metrics: map[string]float64{}}
+		requests, failures = 0, 0
+		mu.Unlock()
+
+		if resp, err := client.Get(metricsURL + "/metrics"); err == nil {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			for _, metric := range soakMetrics {
+				if value, ok := promValue(string(body), metric.Series, metric.Labels); ok {
+					sample.Metrics[metric.Name] = value
+				}
+			}
+		}
+		if len(samples) > 0 {
+			prev := samples[len(samples)-1]
+			gc, prevGC := sample.Metrics["GC seconds"], prev.Metrics["GC seconds"]
+			sample.Metrics[gcShare] = (gc - prevGC) / (sample.At - prev.At).Seconds() * 100
+		}
+		samples = append(samples, sample)
+		fmt.Printf("  %s: %d requests, %.2f%% errors, heap %.0f MiB, %.1f%% in GC, %.0f threads, %.0f open files, %.0f pool connections\n",
+			sample.At, sample.Requests, sample.errorRate(), sample.Metrics["heap bytes"]/(1<<20), sample.Metrics[gcShare],
+			sample.Metrics["threads"], sample.Metrics["open files"], sample.Metrics["pool active connections"])
+	}
+	stop()
+	wg.Wait()
+
+	// Compare the first and last quarters, so warm-up and a single noisy
+	// sample don't decide the outcome
+	quarter := max(len(samples)/4, 1)
+	first, last := samples[:quarter], samples[len(samples)-quarter:]
+	var b strings.Builder
+	var problems []string
+	fmt.Fprintf(&b, "Soak test: %s at %d req/s, %d samples\n", duration, rps, len(samples))
+	for _, metric := range soakMetrics {
+		if metric.Name == "GC seconds" {
+			continue
+		}
+		value := func(s soakSample) float64 { return s.Metrics[metric.Name] }
+		before, after := windowMean(first, value), windowMean(last, value)
+		if before == 0 {
+			if after > 0 {
+				fmt.Fprintf(&b, "  %s: 0 → %.0f\n", metric.Name, after)
+			} else {
+				fmt.Fprintf(&b, "  %s: not reported\n", metric.Name)
+			}
+			continue
+		}
+		growth := (after - before) / before * 100
+		fmt.Fprintf(&b, "  %s: %.0f → %.0f (%+.1f%%)\n", metric.Name, before, after, growth)
+		if growth > float64(maxGrowth) {
+			problems = append(problems, fmt.Sprintf("%s grew %.1f%%, a likely leak", metric.Name, growth))
+		}
+	}
+	gcTotal := samples[len(samples)-1].Metrics["GC seconds"] - samples[0].Metrics["GC seconds"]
+	// The first sample has no previous one to measure its GC share against
+	gcFirst := first
+	if len(samples) > quarter {
+		gcFirst = samples[1 : quarter+1]
+	}
+	share := func(s soakSample) float64 { return s.Metrics[gcShare] }
+	gcBefore, gcAfter := windowMean(gcFirst, share), windowMean(last, share)
+	fmt.Fprintf(&b, "  GC time: %.1fs over the run, %.1f%% → %.1f%% of the time\n", gcTotal, gcBefore, gcAfter)
+	if gcAfter-gcBefore > float64(maxGcDrift) {
+		problems = append(problems, fmt.Sprintf("time spent in GC drifted from %.1f%% to %.1f%%", gcBefore, gcAfter))
+	}
+	errorsBefore, errorsAfter := windowMean(first, soakSample.errorRate), windowMean(last, soakSample.errorRate)
+	fmt.Fprintf(&b, "  error rate: %.2f%% → %.2f%%\n", errorsBefore, errorsAfter)
+	if errorsAfter-errorsBefore > float64(maxErrorDrift) {
+		problems = append(problems, fmt.Sprintf("error rate drifted from %.2f%% to %.2f%%", errorsBefore, errorsAfter))
+	}
+
+	if len(problems) > 0 {
+		return b.String(), fmt.Errorf("soak test failed:\n  - %s", strings.Join(problems, "\n  - "))
+	}
+	fmt.Println("✅ No leaks, error-rate or GC drift")
+	return b.String(), nil
+}
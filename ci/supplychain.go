@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// m2Repository is the local Maven repository inside the Clojure build image
+const m2Repository = "/root/.m2/repository"
+
+// wellKnownArtifacts are popular coordinates that typosquatters imitate
+var wellKnownArtifacts = []string{
+	"org.clojure/clojure", "org.clojure/core.async", "org.clojure/tools.logging", "org.clojure/data.json",
+	"org.clojure/tools.cli", "org.clojure/test.check", "org.clojure/java.jdbc", "org.clojure/tools.build",
+	"ring/ring-core", "ring/ring-jetty-adapter", "ring/ring-json", "ring/ring-defaults", "ring/ring-mock",
+	"ring-cors/ring-cors", "metosin/reitit", "metosin/malli", "metosin/muuntaja", "cheshire/cheshire",
+	"hiccup/hiccup", "mount/mount", "integrant/integrant", "http-kit/http-kit", "clj-http/clj-http",
+	"com.github.seancorfield/honeysql", "com.github.seancorfield/next.jdbc", "tick/tick",
+	"com.xtdb/xtdb-api", "com.xtdb/xtdb-core", "com.xtdb/xtdb-http-client-jvm",
+	"ch.qos.logback/logback-classic", "org.slf4j/slf4j-api", "org.postgresql/postgresql",
+	"com.fasterxml.jackson.core/jackson-databind", "org.apache.logging.log4j/log4j-core",
+}
+
+// resolvedArtifact is a downloaded artifact and the repository id that served it
+type resolvedArtifact struct {
+	Coordinate string
+	Version    string
+	Repo       string
+}
+
+// parseRemoteRepositories reads `path|file>repo=` lines produced from the
+// _remote.repositories markers Maven writes next to every download
+func parseRemoteRepositories(listing string) []resolvedArtifact {
+	seen := map[string]bool{}
+	var artifacts []resolvedArtifact
+	scanner := bufio.NewScanner(strings.NewReader(listing))
+	for scanner.Scan() {
+		path, entry, ok := strings.Cut(scanner.Text(), "|")
+		if !ok || !strings.Contains(entry, ">") {
+			continue
+		}
+		// <group path>/<artifact>/<version>/_remote.repositories
+		parts := strings.Split(strings.TrimPrefix(path, m2Repository+"/"), "/")
+		if len(parts) < 4 {
+			continue
+		}
+		version := parts[len(parts)-2]
+		artifact := parts[len(parts)-3]
+		group := strings.Join(parts[:len(parts)-3], ".")
+		repo := strings.TrimSuffix(entry[strings.Index(entry, ">")+1:], "=")
+
+		key := group + "/" + artifact + "@" + version + "@" + repo
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		artifacts = append(artifacts, resolvedArtifact{Coordinate: group + "/" + artifact, Version: version, Repo: repo})
+	}
+	return artifacts
+}
+
+// levenshtein returns the edit distance between two strings
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr := make([]int, len(b)+1)
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev = curr
+	}
+	return prev[len(b)]
+}
+
+// typosquatOf returns the well-known coordinate a coordinate imitates, if
+// any. Artifacts of a well-known coordinate's own group are its publisher's,
+// e.g. ring/ring-codec next to ring/ring-core, so only coordinates of other
+// groups within an edit distance of 2 are suspects.
+func typosquatOf(coordinate string, allowed map[string]bool) string {
+	if allowed[coordinate] || slices.Contains(wellKnownArtifacts, coordinate) {
+		return ""
+	}
+	group, _, _ := strings.Cut(coordinate, "/")
+	for _, popular := range wellKnownArtifacts {
+		popularGroup, _, _ := strings.Cut(popular, "/")
+		if group == popularGroup {
+			continue
+		}
+		if levenshtein(coordinate, popular) <= 2 {
+			return popular
+		}
+	}
+	return ""
+}
+
+// CheckDependencies resolves deps.edn and flags internal group IDs served by public repositories and likely typosquats
+func (m *CljXtdbDevops) CheckDependencies(
+	ctx context.Context,
+	srcDir *dagger.Directory,
+	// Group ID prefixes that must only come from private repositories, e.g. com.example
+	// +optional
+	internalGroups []string,
+	// Repository ids considered public
+	// +optional
+	// +default=["central", "clojars"]
+	publicRepos []string,
+	// Coordinates never reported as typosquats, e.g. com.example/ring-core
+	// +optional
+	allowlist []string,
+) (string, error) {
+	fmt.Println("📦 Resolving dependencies from a clean Maven repository...")
+	listing, err := m.BuilderImage().
+		WithMountedDirectory("/app", srcDir).
+		WithWorkdir("/app").
+		WithExec([]string{"clojure", "-P"}).
+		WithExec([]string{"sh", "-c", fmt.Sprintf(
+			`find %s -name _remote.repositories | while read f; do grep -v '^#' "$f" | sed "s|^|$f\||"; done`, m2Repository)}).
+		Stdout(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve dependencies: %w", err)
+	}
+
+	public := map[string]bool{}
+	for _, repo := range publicRepos {
+		public[repo] = true
+	}
+	allowed := map[string]bool{}
+	for _, coordinate := range allowlist {
+		allowed[coordinate] = true
+	}
+
+	artifacts := parseRemoteRepositories(listing)
+	var report strings.Builder
+	var problems []string
+	for _, a := range artifacts {
+		fmt.Fprintf(&report, "%s %s (%s)\n", a.Coordinate, a.Version, a.Repo)
+		group, _, _ := strings.Cut(a.Coordinate, "/")
+		for _, internal := range internalGroups {
+			if (group == internal || strings.HasPrefix(group, internal+".")) && public[a.Repo] {
+				problems = append(problems, fmt.Sprintf("%s %s matches internal group %s but resolved from public repository %q",
+					a.Coordinate, a.Version, internal, a.Repo))
+			}
+		}
+		if popular := typosquatOf(a.Coordinate, allowed); popular != "" {
+			problems = append(problems, fmt.Sprintf("%s %s looks like a typosquat of %s", a.Coordinate, a.Version, popular))
+		}
+	}
+
+	fmt.Printf("🔎 Checked %d resolved artifacts\n", len(artifacts))
+	if len(problems) > 0 {
+		return report.String(), fmt.Errorf("dependency check failed:\n  - %s", strings.Join(problems, "\n  - "))
+	}
+	fmt.Println("✅ No dependency confusion or typosquatting candidates")
+	return report.String(), nil
+}
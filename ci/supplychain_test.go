@@ -0,0 +1,53 @@
+package main
+
+import "testing"
+
+func TestLevenshtein(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"ring", "", 4},
+		{"", "ring", 4},
+		{"ring", "ring", 0},
+		{"ring", "rinq", 1},
+		{"ring", "rings", 1},
+		{"ring/ring-core", "ring/ring-codec", 2},
+		{"kitten", "sitting", 3},
+	} {
+		if got := levenshtein(tc.a, tc.b); got != tc.want {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestTyposquatOf(t *testing.T) {
+	for _, tc := range []struct {
+		coordinate string
+		allowlist  []string
+		want       string
+	}{
+		// Well-known coordinates themselves
+		{"ring/ring-core", nil, ""},
+		// Transitive dependencies of the app, published by the same group
+		{"ring/ring-codec", nil, ""},
+		{"org.clojure/tools.reader", nil, ""},
+		// Near misses from another group
+		{"rinq/ring-core", nil, "ring/ring-core"},
+		{"cheshlre/cheshire", nil, "cheshire/cheshire"},
+		{"org.clojure/clojure", nil, ""},
+		{"org.cloiure/clojure", nil, "org.clojure/clojure"},
+		{"rinq/ring-core", []string{"rinq/ring-core"}, ""},
+		// Unrelated coordinates
+		{"com.example/billing", nil, ""},
+	} {
+		allowed := map[string]bool{}
+		for _, c := range tc.allowlist {
+			allowed[c] = true
+		}
+		if got := typosquatOf(tc.coordinate, allowed); got != tc.want {
+			t.Errorf("typosquatOf(%q) = %q, want %q", tc.coordinate, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// localLogsVolume keeps the output of the local services when asked to, so
+// it outlives a torn down environment
+const localLogsVolume = "clj-xtdb-devops-logs"
+
+// logWrapper runs a service's command with its output also appended to
+// /logs/$LOG_NAME.log, passing SIGTERM on so the service still shuts down
+// gracefully
+const logWrapper = `mkfifo /tmp/log.fifo
+tee -a "/logs/$LOG_NAME.log" < /tmp/log.fifo &
+"$@" > /tmp/log.fifo 2>&1 &
+pid=$!
+trap 'kill -TERM $pid' TERM INT
+wait $pid
+status=$?
+wait
+exit $status`
+
+// teardown stops the services a local environment started when starting it
+// fails or is cancelled, e.g. with Ctrl-C. Once the environment is returned,
+// `service up` and the end of the session stop them instead.
+type teardown struct {
+	mu       sync.Mutex
+	names    []string
+	services []*dagger.Service
+}
+
+// started records a running service; services started in parallel may
+// record themselves concurrently
+func (t *teardown) started(name string, svc *dagger.Service) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.names = append(t.names, name)
+	t.services = append(t.services, svc)
+}
+
+// onFailure stops the started services if *err is set, newest first, so each
+// service stops before the services it depends on. Deferred by the
+// functions that start them.
+func (t *teardown) onFailure(ctx context.Context, err *error) {
+	if *err == nil {
+		return
+	}
+	if ctx.Err() != nil {
+		fmt.Println("🛑 Cancelled, tearing down the environment...")
+	} else {
+		fmt.Println("🛑 Failed, tearing down the environment...")
+	}
+	// ctx is usually cancelled by now, but the engine still takes requests
+	stopCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+	defer cancel()
+	for i := len(t.services) - 1; i >= 0; i-- {
+		fmt.Printf("🔄 Stopping %s...\n", t.names[i])
+		if _, err := t.services[i].Stop(stopCtx); err != nil {
+			fmt.Printf("⚠️  failed to stop %s: %v\n", t.names[i], err)
+		}
+	}
+}
+
+// asLocalService turns a container into a service, its output also kept in
+// the logs volume as <name>.log with keepLogs
+func asLocalService(ctx context.Context, ctr *dagger.Container, name string, keepLogs bool) (*dagger.Service, error) {
+	if !keepLogs {
+		return ctr.AsService(), nil
+	}
+	entrypoint, err := ctr.Entrypoint(ctx)
+	if err != nil {
+		return nil, err
+	}
+	args, err := ctr.DefaultArgs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	command := append(entrypoint, args...)
+	if len(command) == 0 {
+		return nil, fmt.Errorf("%s has no command to keep the logs of", name)
+	}
+	return ctr.
+		WithMountedCache("/logs", dag.CacheVolume(localLogsVolume)).
+		WithEnvVariable("LOG_NAME", name).
+		AsService(dagger.ContainerAsServiceOpts{Args: append([]string{"sh", "-c", logWrapper, "sh"}, command...)}), nil
+}
+
+// LocalLogs returns the logs kept by the local environments run with
+// keep-logs; export them with `export --path ./logs`
+func (m *CljXtdbDevops) LocalLogs() *dagger.Directory {
+	return dag.Container().From("alpine:3.21").
+		WithMountedCache("/logs", dag.CacheVolume(localLogsVolume)).
+		// Cache volumes change outside the DAG, so never reuse an old copy
+		WithEnvVariable("CACHE_BUSTER", time.Now().String()).
+		WithExec([]string{"sh", "-c", "mkdir -p /export && cp -a /logs/. /export/"}).
+		Directory("/export")
+}
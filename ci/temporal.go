@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// sqlIdentifier matches the plain (optionally schema-qualified) table names
+// the temporal helpers accept
+var sqlIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// timestampLayouts are the formats accepted for valid/system time arguments
+var timestampLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"}
+
+// xtdbTimestamp turns a user supplied time into an XTDB TIMESTAMP literal
+func xtdbTimestamp(value string) (string, error) {
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return fmt.Sprintf("TIMESTAMP '%s'", t.UTC().Format(time.RFC3339Nano)), nil
+		}
+	}
+	return "", fmt.Errorf("invalid timestamp %q: use RFC 3339 (2024-02-01T10:00:00Z) or a date (2024-02-01)", value)
+}
+
+// QueryAsOf runs a SQL query as of the given valid time and/or system time
+func (m *CljXtdbDevops) QueryAsOf(
+	ctx context.Context,
+	// XTDB service to query, e.g. tcp://localhost:3000
+	xtdb *dagger.Service,
+	sql string,
+	// Valid time to query as of (defaults to now)
+	// +optional
+	validTime string,
+	// System time to query as of (defaults to the latest transaction)
+	// +optional
+	systemTime string,
+) (string, error) {
+	var settings []string
+	if validTime != "" {
+		ts, err := xtdbTimestamp(validTime)
+		if err != nil {
+			return "", err
+		}
+		settings = append(settings, "DEFAULT VALID_TIME AS OF "+ts)
+	}
+	if systemTime != "" {
+		ts, err := xtdbTimestamp(systemTime)
+		if err != nil {
+			return "", err
+		}
+		settings = append(settings, "DEFAULT SYSTEM_TIME AS OF "+ts)
+	}
+
+	query := sql
+	if len(settings) > 0 {
+		query = fmt.Sprintf("SETTING %s %s", strings.Join(settings, ", "), sql)
+	}
+	fmt.Printf("🕰️  %s\n", query)
+
+	client, err := newXtdbClient(ctx, xtdb)
+	if err != nil {
+		return "", err
+	}
+	rows, err := client.query(ctx, query)
+	if err != nil {
+		return "", err
+	}
+	return formatRows(rows), nil
+}
+
+// QueryValidTimeRange returns every version of a table's rows that was valid between two points in time
+func (m *CljXtdbDevops) QueryValidTimeRange(
+	ctx context.Context,
+	// XTDB service to query, e.g. tcp://localhost:3000
+	xtdb *dagger.Service,
+	table string,
+	// Start of the valid time range (inclusive)
+	from string,
+	// End of the valid time range (inclusive)
+	to string,
+	// Optional SQL predicate to filter rows, e.g. status = 'active'
+	// +optional
+	where string,
+) (string, error) {
+	if !sqlIdentifier.MatchString(table) {
+		return "", fmt.Errorf("invalid table name %q", table)
+	}
+	fromTs, err := xtdbTimestamp(from)
+	if err != nil {
+		return "", err
+	}
+	toTs, err := xtdbTimestamp(to)
+	if err != nil {
+		return "", err
+	}
+
+	query := fmt.Sprintf("SELECT *, _valid_from, _valid_to FROM %s FOR VALID_TIME BETWEEN %s AND %s", table, fromTs, toTs)
+	if where != "" {
+		query += " WHERE " + where
+	}
+	query += " ORDER BY _id, _valid_from"
+	fmt.Printf("🕰️  %s\n", query)
+
+	client, err := newXtdbClient(ctx, xtdb)
+	if err != nil {
+		return "", err
+	}
+	rows, err := client.query(ctx, query)
+	if err != nil {
+		return "", err
+	}
+	return formatRows(rows), nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// TailTxLog follows the XTDB transaction log and prints the documents written by each transaction
+func (m *CljXtdbDevops) TailTxLog(
+	ctx context.Context,
+	// XTDB service to follow, e.g. tcp://localhost:3000
+	xtdb *dagger.Service,
+	// How often to poll for new transactions
+	// +optional
+	// +default="2s"
+	interval string,
+	// Replay the whole log instead of starting at the latest transaction
+	// +optional
+	fromStart bool,
+) error {
+	pollEvery, err := time.ParseDuration(interval)
+	if err != nil {
+		return fmt.Errorf("invalid interval %q: %w", interval, err)
+	}
+
+	client, err := newXtdbClient(ctx, xtdb)
+	if err != nil {
+		return err
+	}
+
+	lastTx := int64(-1)
+	if !fromStart {
+		rows, err := client.query(ctx, "SELECT MAX(_id) AS last_tx FROM xt.txs")
+		if err != nil {
+			return err
+		}
+		if len(rows) > 0 {
+			if id, ok := xtdbValue(rows[0]["last_tx"]).(float64); ok {
+				lastTx = int64(id)
+			}
+		}
+	}
+
+	fmt.Printf("📜 Following XTDB transaction log after tx %d (Ctrl-C to stop)...\n", lastTx)
+	ticker := time.NewTicker(pollEvery)
+	defer ticker.Stop()
+
+	for {
+		txs, err := client.query(ctx, fmt.Sprintf(
+			"SELECT _id, system_time, committed, error FROM xt.txs WHERE _id > %d ORDER BY _id", lastTx))
+		if err != nil {
+			return err
+		}
+
+		for _, tx := range txs {
+			id, _ := xtdbValue(tx["_id"]).(float64)
+			lastTx = int64(id)
+			if err := printTx(ctx, client, lastTx, tx); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			fmt.Println("👋 Stopped following the transaction log")
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// printTx prints a transaction header followed by every row version it wrote
+func printTx(ctx context.Context, client *xtdbClient, id int64, tx map[string]any) error {
+	if committed, _ := xtdbValue(tx["committed"]).(bool); !committed {
+		fmt.Printf("❌ tx %d @ %v aborted: %v\n", id, xtdbValue(tx["system_time"]), xtdbValue(tx["error"]))
+		return nil
+	}
+	fmt.Printf("✅ tx %d @ %v\n", id, xtdbValue(tx["system_time"]))
+
+	tables, err := client.userTables(ctx)
+	if err != nil {
+		return err
+	}
+	for _, table := range tables {
+		rows, err := client.query(ctx, fmt.Sprintf(
+			"SELECT *, _valid_from, _valid_to FROM %s FOR ALL SYSTEM_TIME FOR ALL VALID_TIME "+
+				"WHERE _system_from = (SELECT system_time FROM xt.txs WHERE _id = %d)", table, id))
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			fmt.Printf("  %s ← %s\n", table, formatRow(row))
+		}
+	}
+	return nil
+}
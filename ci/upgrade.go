@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// xtdbDataDir is where the XTDB image keeps its local storage
+const xtdbDataDir = "/var/lib/xtdb"
+
+// smokeCheck runs the smoke queries and returns their results keyed by name.
+// Without explicit queries every user table is counted.
+func smokeCheck(ctx context.Context, client *xtdbClient, queries []namedQuery) (map[string]string, error) {
+	if len(queries) == 0 {
+		tables, err := client.userTables(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, table := range tables {
+			queries = append(queries, namedQuery{Name: "count " + table, SQL: fmt.Sprintf("SELECT COUNT(*) AS n FROM %s", table)})
+		}
+	}
+	results := map[string]string{}
+	for _, q := range queries {
+		rows, err := client.query(ctx, q.SQL)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", q.Name, err)
+		}
+		results[q.Name] = tableChecksum(rows)
+		fmt.Printf("  ✔ %s (%d rows)\n", q.Name, len(rows))
+	}
+	return results, nil
+}
+
+// runSmokeCheck starts XTDB on the shared data volume, runs the smoke queries and stops it again
+func (m *CljXtdbDevops) runSmokeCheck(ctx context.Context, version string, data *dagger.CacheVolume, queries []namedQuery) (map[string]string, error) {
+	svc, err := m.xtdbContainer(version).
+		WithMountedCache(xtdbDataDir, data).
+		AsService().
+		Start(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start XTDB %s: %w", version, err)
+	}
+	defer svc.Stop(ctx)
+
+	client, err := newXtdbClient(ctx, svc)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.waitReady(ctx, 60); err != nil {
+		return nil, fmt.Errorf("XTDB %s: %w", version, err)
+	}
+	fmt.Printf("🧪 Running smoke queries against XTDB %s...\n", version)
+	return smokeCheck(ctx, client, queries)
+}
+
+// RehearseUpgrade restores a backup into one XTDB version, upgrades to another on the same data and compares smoke query results
+func (m *CljXtdbDevops) RehearseUpgrade(
+	ctx context.Context,
+	// XTDB version the backup was taken from, e.g. 2.0.0-beta6
+	fromVersion string,
+	// XTDB version to upgrade to
+	toVersion string,
+	// tar.gz archive of the XTDB data directory
+	backup *dagger.File,
+	// JSON array of {"name": ..., "sql": ...} smoke queries (defaults to row counts per table)
+	// +optional
+	smokeQueries *dagger.File,
+) (string, error) {
+	var queries []namedQuery
+	if smokeQueries != nil {
+		if err := readJSONFile(ctx, smokeQueries, &queries); err != nil {
+			return "", fmt.Errorf("failed to read smoke queries: %w", err)
+		}
+	}
+
+	// A fresh volume per rehearsal so runs never see each other's data
+	data := dag.CacheVolume(fmt.Sprintf("xtdb-upgrade-%s-%s-%d", fromVersion, toVersion, time.Now().UnixNano()))
+	defer emptyCache(ctx, data)
+
+	fmt.Println("📥 Restoring backup...")
+	_, err := dag.Container().From("alpine:3.21").
+		WithMountedCache(xtdbDataDir, data).
+		WithMountedFile("/tmp/backup.tar.gz", backup).
+		WithExec([]string{"tar", "-xzf", "/tmp/backup.tar.gz", "-C", xtdbDataDir}).
+		Sync(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to restore backup: %w", err)
+	}
+
+	before, err := m.runSmokeCheck(ctx, fromVersion, data, queries)
+	if err != nil {
+		return "", err
+	}
+
+	fmt.Printf("⬆️  Upgrading XTDB %s → %s...\n", fromVersion, toVersion)
+	after, err := m.runSmokeCheck(ctx, toVersion, data, queries)
+	if err != nil {
+		return "", err
+	}
+
+	var report strings.Builder
+	var mismatches []string
+	for _, name := range slices.Sorted(maps.Keys(before)) {
+		want := before[name]
+		got, ok := after[name]
+		switch {
+		case !ok:
+			mismatches = append(mismatches, fmt.Sprintf("%s: missing after upgrade", name))
+		case got != want:
+			mismatches = append(mismatches, fmt.Sprintf("%s: results differ after upgrade", name))
+		default:
+			fmt.Fprintf(&report, "✅ %s\n", name)
+		}
+	}
+	if len(mismatches) > 0 {
+		return report.String(), fmt.Errorf("upgrade rehearsal %s → %s failed:\n  - %s", fromVersion, toVersion, strings.Join(mismatches, "\n  - "))
+	}
+	fmt.Printf("🎉 Upgrade %s → %s rehearsed successfully\n", fromVersion, toVersion)
+	return report.String(), nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// vaultDevToken is the root token of the local Vault dev server
+const vaultDevToken = "root"
+
+// vaultDevServer returns a Vault dev server: unsealed, in memory, with a KV
+// v2 engine mounted at secret/
+func vaultDevServer() *dagger.Service {
+	return dag.Container().From(vaultImage).
+		WithExposedPort(8200).
+		AsService(dagger.ContainerAsServiceOpts{Args: []string{
+			"vault", "server", "-dev",
+			"-dev-listen-address=0.0.0.0:8200",
+			"-dev-root-token-id=" + vaultDevToken,
+		}})
+}
+
+// vaultNode is the Vault dev server in a service graph
+func vaultNode() serviceNode {
+	return serviceNode{
+		Name:      "vault",
+		Readiness: "wget -q -O /dev/null http://vault:8200/v1/sys/health",
+		Start: func(ctx context.Context, _ map[string]*dagger.Service) (*dagger.Service, error) {
+			return vaultDevServer().Start(ctx)
+		},
+	}
+}
+
+// seedVault writes KV secrets, given as {"<path>": {"<key>": "<value>"}},
+// to the secret/ engine of a running Vault dev server. Values are passed in
+// files so they stay out of the logs.
+func seedVault(ctx context.Context, vault *dagger.Service, secrets *dagger.File) error {
+	var seeds map[string]map[string]string
+	if err := readJSONFile(ctx, secrets, &seeds); err != nil {
+		return fmt.Errorf("failed to read vault secrets: %w", err)
+	}
+	seeder := dag.Container().From(vaultImage).
+		WithServiceBinding("vault", vault).
+		WithEnvVariable("VAULT_ADDR", "http://vault:8200").
+		WithEnvVariable("VAULT_TOKEN", vaultDevToken)
+	for i, path := range slices.Sorted(maps.Keys(seeds)) {
+		data, err := json.Marshal(seeds[path])
+		if err != nil {
+			return err
+		}
+		file := fmt.Sprintf("/seed/%d.json", i)
+		seeder = seeder.
+			WithNewFile(file, string(data)).
+			WithExec([]string{"vault", "kv", "put", "-mount=secret", path, "@" + file})
+	}
+	if _, err := seeder.Sync(ctx); err != nil {
+		return fmt.Errorf("failed to seed vault: %w", err)
+	}
+	fmt.Printf("🔑 Seeded Vault with %d secret(s)\n", len(seeds))
+	return nil
+}
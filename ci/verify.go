@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// temporalColumns are stripped from history rows before they are compared
+// with the current state of a table
+var temporalColumns = []string{"_valid_from", "_valid_to", "_system_from", "_system_to"}
+
+// tableChecksum hashes rows independently of their order
+func tableChecksum(rows []map[string]any) string {
+	lines := make([]string, 0, len(rows))
+	for _, row := range rows {
+		lines = append(lines, formatRow(row))
+	}
+	sort.Strings(lines)
+	sum := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// xtdbTime parses a temporal column, treating null as the end of time
+func xtdbTime(v any) (time.Time, bool) {
+	s, ok := xtdbValue(v).(string)
+	if !ok {
+		return time.Time{}, false
+	}
+	// Zoned timestamps are rendered as 2024-02-01T10:00Z[UTC]
+	if i := strings.IndexByte(s, '['); i >= 0 {
+		s = s[:i]
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04Z07:00"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// replayCurrent rebuilds the rows visible at basis, in both system and
+// valid time, from a table's full bitemporal history
+func replayCurrent(history []map[string]any, basis time.Time) []map[string]any {
+	var current []map[string]any
+	for _, row := range history {
+		if from, ok := xtdbTime(row["_system_from"]); ok && from.After(basis) {
+			continue
+		}
+		if to, ok := xtdbTime(row["_system_to"]); ok && !to.After(basis) {
+			continue
+		}
+		if from, ok := xtdbTime(row["_valid_from"]); ok && from.After(basis) {
+			continue
+		}
+		if to, ok := xtdbTime(row["_valid_to"]); ok && !to.After(basis) {
+			continue
+		}
+		visible := map[string]any{}
+		for k, v := range row {
+			visible[k] = v
+		}
+		for _, col := range temporalColumns {
+			delete(visible, col)
+		}
+		current = append(current, visible)
+	}
+	return current
+}
+
+// VerifyXTDB replays table histories and compares them with current query results, failing on index lag or mismatches
+func (m *CljXtdbDevops) VerifyXTDB(
+	ctx context.Context,
+	// XTDB service to verify, e.g. tcp://localhost:3000
+	xtdb *dagger.Service,
+	// Number of recent transactions to inspect for aborts
+	// +optional
+	// +default=100
+	recentTxs int,
+	// Maximum tolerated gap between submitted and indexed transactions
+	// +optional
+	// +default=10
+	maxLag int,
+) (string, error) {
+	fmt.Println("🔍 Verifying XTDB consistency...")
+	client, err := newXtdbClient(ctx, xtdb)
+	if err != nil {
+		return "", err
+	}
+
+	var report strings.Builder
+	var problems []string
+
+	status, err := client.status(ctx)
+	if err != nil {
+		return "", err
+	}
+	submitted, err := txID(status["latestSubmittedTx"])
+	if err != nil {
+		return "", fmt.Errorf("failed to read the latest submitted transaction: %w", err)
+	}
+	completed, err := txID(status["latestCompletedTx"])
+	if err != nil {
+		return "", fmt.Errorf("failed to read the latest indexed transaction: %w", err)
+	}
+	lag := submitted - completed
+	fmt.Fprintf(&report, "tx-log: latest submitted %d, latest indexed %d (lag %d)\n", submitted, completed, lag)
+	if lag > int64(maxLag) {
+		problems = append(problems, fmt.Sprintf("indexer is %d transactions behind the log (max %d)", lag, maxLag))
+	}
+
+	txs, err := client.query(ctx, fmt.Sprintf(
+		"SELECT _id, error FROM xt.txs WHERE committed = false AND _id > %d ORDER BY _id", submitted-int64(recentTxs)))
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(&report, "tx-log: %d aborted transaction(s) in the last %d\n", len(txs), recentTxs)
+	for _, tx := range txs {
+		fmt.Fprintf(&report, "  tx %v: %v\n", xtdbValue(tx["_id"]), xtdbValue(tx["error"]))
+	}
+
+	tables, err := client.userTables(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	// Pin the current rows to the system time of the latest indexed
+	// transaction and replay the history as of the same instant, so a
+	// transaction landing between the two queries can't make them disagree
+	latest, err := client.query(ctx, "SELECT MAX(system_time) AS basis FROM xt.txs")
+	if err != nil {
+		return "", err
+	}
+	basis := time.Now().UTC()
+	if len(latest) > 0 {
+		if t, ok := xtdbTime(latest[0]["basis"]); ok {
+			basis = t.UTC()
+		}
+	}
+	ts := basis.Format(time.RFC3339Nano)
+	asOf := fmt.Sprintf("SETTING DEFAULT SYSTEM_TIME AS OF TIMESTAMP '%s', DEFAULT VALID_TIME AS OF TIMESTAMP '%s' ", ts, ts)
+	fmt.Fprintf(&report, "basis: %s\n", ts)
+
+	for _, table := range tables {
+		history, err := client.query(ctx, fmt.Sprintf(
+			"SELECT *, _valid_from, _valid_to, _system_from, _system_to FROM %s FOR ALL SYSTEM_TIME FOR ALL VALID_TIME", table))
+		if err != nil {
+			return "", err
+		}
+		current, err := client.query(ctx, asOf+fmt.Sprintf("SELECT * FROM %s", table))
+		if err != nil {
+			return "", err
+		}
+
+		replayed := replayCurrent(history, basis)
+		expected, actual := tableChecksum(replayed), tableChecksum(current)
+		fmt.Fprintf(&report, "%s: %d versions, %d replayed rows, %d current rows, checksum %s\n",
+			table, len(history), len(replayed), len(current), actual[:12])
+		switch {
+		case len(replayed) != len(current):
+			problems = append(problems, fmt.Sprintf("%s: replayed %d rows but query returned %d", table, len(replayed), len(current)))
+		case expected != actual:
+			problems = append(problems, fmt.Sprintf("%s: checksum mismatch (replayed %s, current %s)", table, expected[:12], actual[:12]))
+		}
+	}
+
+	if len(problems) > 0 {
+		fmt.Print(report.String())
+		return "", fmt.Errorf("XTDB consistency check failed:\n  - %s", strings.Join(problems, "\n  - "))
+	}
+	fmt.Println("✅ XTDB is consistent")
+	return report.String(), nil
+}
+
+// txID reads a transaction id from the status response, which reports
+// either a bare number or a {"txId": ...} map, and null before the first
+// transaction
+func txID(v any) (int64, error) {
+	switch id := xtdbValue(v).(type) {
+	case nil:
+		return -1, nil
+	case float64:
+		return int64(id), nil
+	case map[string]any:
+		if _, ok := id["txId"]; ok {
+			return txID(id["txId"])
+		}
+	}
+	return 0, fmt.Errorf("unrecognised transaction id %v", v)
+}
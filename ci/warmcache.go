@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// WarmCache runs a set of hot queries against a freshly started XTDB node so real requests don't pay cold-cache latency
+func (m *CljXtdbDevops) WarmCache(
+	ctx context.Context,
+	// XTDB service to warm, e.g. tcp://localhost:3000 or tcp://xtdb.internal:3000
+	xtdb *dagger.Service,
+	// JSON array of {"name": ..., "sql": ...} queries
+	queries *dagger.File,
+	// Number of times to run each query
+	// +optional
+	// +default=3
+	passes int,
+) (string, error) {
+	var hot []namedQuery
+	if err := readJSONFile(ctx, queries, &hot); err != nil {
+		return "", fmt.Errorf("failed to read queries: %w", err)
+	}
+
+	client, err := newXtdbClient(ctx, xtdb)
+	if err != nil {
+		return "", err
+	}
+	if err := client.waitReady(ctx, 60); err != nil {
+		return "", err
+	}
+
+	fmt.Printf("🔥 Warming XTDB query cache with %d queries...\n", len(hot))
+	var report strings.Builder
+	for _, q := range hot {
+		var first, last time.Duration
+		for pass := 0; pass < passes; pass++ {
+			start := time.Now()
+			if _, err := client.query(ctx, q.SQL); err != nil {
+				return report.String(), fmt.Errorf("%s: %w", q.Name, err)
+			}
+			last = time.Since(start)
+			if pass == 0 {
+				first = last
+			}
+		}
+		fmt.Fprintf(&report, "%s: cold %s, warm %s\n", q.Name, first.Round(time.Millisecond), last.Round(time.Millisecond))
+	}
+	fmt.Println("✅ Query cache warmed")
+	return report.String(), nil
+}
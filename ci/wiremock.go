@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// wiremockImage is the WireMock release serving the mock APIs
+const wiremockImage = "wiremock/wiremock:3.9.2"
+
+// mockApiURL is where the app and its tests reach WireMock
+const mockApiURL = "http://wiremock:8080"
+
+// WithMockApis serves stub mappings with WireMock to run-local-web-app and
+// test-clj-web-app, which pass its URL to the app as MOCK_API_URL. Point
+// the app's third-party API base URLs at it for deterministic fakes.
+func (m *CljXtdbDevops) WithMockApis(
+	// WireMock root: stub mappings in mappings/, response bodies in __files/
+	stubs *dagger.Directory,
+) *CljXtdbDevops {
+	m.MockApiStubs = stubs
+	return m
+}
+
+// mockApisNode is WireMock, serving the stubs of with-mock-apis, in a
+// service graph; it is ready once it has loaded them
+func (m *CljXtdbDevops) mockApisNode() serviceNode {
+	return serviceNode{
+		Name:      "wiremock",
+		Readiness: "wget -q -O /dev/null " + mockApiURL + "/__admin/health",
+		Start: func(ctx context.Context, _ map[string]*dagger.Service) (*dagger.Service, error) {
+			return dag.Container().From(wiremockImage).
+				WithDirectory("/home/wiremock", m.MockApiStubs).
+				WithExposedPort(8080).
+				AsService(dagger.ContainerAsServiceOpts{UseEntrypoint: true}).
+				Start(ctx)
+		},
+	}
+}
+
+// withMockApis binds WireMock into a container and passes it MOCK_API_URL
+func withMockApis(ctr *dagger.Container, wiremock *dagger.Service) *dagger.Container {
+	return ctr.
+		WithServiceBinding("wiremock", wiremock).
+		WithEnvVariable("MOCK_API_URL", mockApiURL)
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/chiefkemist/clj-xtdb-devops/ci/internal/dagger"
+)
+
+// xtdbClient talks to the XTDB 2 HTTP API of a running XTDB service
+type xtdbClient struct {
+	baseURL string
+	http    *http.Client
+}
+
+// newXtdbClient resolves the HTTP endpoint of an XTDB service. Pass
+// tcp://localhost:3000 from the CLI to reach an environment started with
+// run-local-development.
+func newXtdbClient(ctx context.Context, xtdb *dagger.Service) (*xtdbClient, error) {
+	endpoint, err := xtdb.Endpoint(ctx, dagger.ServiceEndpointOpts{Port: 3000, Scheme: "http"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve XTDB endpoint: %w", err)
+	}
+	return &xtdbClient{baseURL: strings.TrimSuffix(endpoint, "/"), http: &http.Client{}}, nil
+}
+
+// query runs a SQL query and decodes every JSON line of the result
+func (c *xtdbClient) query(ctx context.Context, sql string) ([]map[string]any, error) {
+	body, err := json.Marshal(map[string]any{"sql": sql})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/query", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/jsonl")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("XTDB query failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var rows []map[string]any
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	if resp.StatusCode != http.StatusOK {
+		var msg strings.Builder
+		for scanner.Scan() {
+			msg.WriteString(scanner.Text())
+		}
+		return nil, fmt.Errorf("XTDB query returned %s: %s", resp.Status, msg.String())
+	}
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		row := map[string]any{}
+		if err := json.Unmarshal(line, &row); err != nil {
+			return nil, fmt.Errorf("failed to decode XTDB row: %w", err)
+		}
+		rows = append(rows, row)
+	}
+	return rows, scanner.Err()
+}
+
+// submitSQL submits SQL DML statements as a single transaction
+func (c *xtdbClient) submitSQL(ctx context.Context, statements []string) error {
+	ops := make([]map[string]any, 0, len(statements))
+	for _, stmt := range statements {
+		ops = append(ops, map[string]any{"sql": stmt})
+	}
+	return c.submit(ctx, ops)
+}
+
+// putDocs puts rows into a table as a single transaction. The rows keep
+// XTDB's typed JSON values, so an export round-trips with its types.
+func (c *xtdbClient) putDocs(ctx context.Context, table string, docs []map[string]any) error {
+	return c.submit(ctx, []map[string]any{{"into": table, "putDocs": docs}})
+}
+
+// submit submits transaction operations to the tx endpoint
+func (c *xtdbClient) submit(ctx context.Context, ops []map[string]any) error {
+	body, err := json.Marshal(map[string]any{"txOps": ops})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/tx", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("XTDB transaction failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("XTDB transaction returned %s", resp.Status)
+	}
+	return nil
+}
+
+// status fetches the node status, including the latest submitted and completed transactions
+func (c *xtdbClient) status(ctx context.Context) (map[string]any, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/status", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("XTDB status request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("XTDB status returned %s", resp.Status)
+	}
+
+	status := map[string]any{}
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, fmt.Errorf("failed to decode XTDB status: %w", err)
+	}
+	return status, nil
+}
+
+// userTables lists the tables in the public schema
+func (c *xtdbClient) userTables(ctx context.Context) ([]string, error) {
+	rows, err := c.query(ctx, "SELECT table_name FROM information_schema.tables WHERE table_schema = 'public' ORDER BY table_name")
+	if err != nil {
+		return nil, err
+	}
+	tables := make([]string, 0, len(rows))
+	for _, row := range rows {
+		tables = append(tables, fmt.Sprint(row["table_name"]))
+	}
+	return tables, nil
+}
+
+// xtdbValue unwraps XTDB's typed JSON values ({"@type": ..., "@value": ...})
+func xtdbValue(v any) any {
+	if typed, ok := v.(map[string]any); ok {
+		if value, ok := typed["@value"]; ok {
+			return value
+		}
+	}
+	return v
+}
+
+// formatRow renders a result row as compact JSON
+func formatRow(row map[string]any) string {
+	out, err := json.Marshal(row)
+	if err != nil {
+		return fmt.Sprint(row)
+	}
+	return string(out)
+}
+
+// formatRows renders query results as JSON lines
+func formatRows(rows []map[string]any) string {
+	var b strings.Builder
+	for _, row := range rows {
+		b.WriteString(formatRow(row))
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// splitStatements splits a SQL script on semicolons at the end of a line
+func splitStatements(script string) []string {
+	var statements []string
+	for _, stmt := range strings.Split(script, ";\n") {
+		stmt = strings.TrimSuffix(strings.TrimSpace(stmt), ";")
+		if stmt != "" {
+			statements = append(statements, stmt)
+		}
+	}
+	return statements
+}
+
+// seededXtdb returns the given XTDB service, or starts a fresh one loaded with the seed script
+func (m *CljXtdbDevops) seededXtdb(ctx context.Context, xtdb *dagger.Service, seed *dagger.File) (*xtdbClient, error) {
+	if xtdb == nil {
+		fmt.Println("📦 Starting a fresh XTDB service...")
+		started, err := m.BuildXTDB().AsService().Start(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start XTDB: %w", err)
+		}
+		xtdb = started
+	}
+	client, err := newXtdbClient(ctx, xtdb)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.waitReady(ctx, 30); err != nil {
+		return nil, err
+	}
+	if seed != nil {
+		script, err := seed.Contents(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read seed script: %w", err)
+		}
+		statements := splitStatements(script)
+		fmt.Printf("🌱 Seeding XTDB with %d statement(s)...\n", len(statements))
+		if err := client.submitSQL(ctx, statements); err != nil {
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+// waitReady polls the status endpoint until XTDB answers or retries run out
+func (c *xtdbClient) waitReady(ctx context.Context, retries int) error {
+	var err error
+	for attempt := 0; attempt < retries; attempt++ {
+		if _, err = c.status(ctx); err == nil {
+			return nil
+		}
+		fmt.Printf("⏳ Waiting for XTDB to be ready... retries left: %d\n", retries-attempt-1)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+	return fmt.Errorf("XTDB did not become ready: %w", err)
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"path/filepath"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+// appDir is the Clojure app, relative to the checkout root
+const appDir = "my-app"
+
+func buildCmd() *cobra.Command {
+	var publish bool
+	var ttl string
+	var maxSizeMB int
+	cmd := &cobra.Command{
+		Use:   "build",
+		Short: "Build the app image, optionally publishing it",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if publish {
+				return dagger("build-and-publish-clj-web-app", "--src-dir", appDir, "--ttl", ttl,
+					"--max-image-size-mb", strconv.Itoa(maxSizeMB))
+			}
+			return dagger("build-clj-web-app", "--src-dir", appDir, "sync")
+		},
+	}
+	cmd.Flags().BoolVar(&publish, "publish", false, "scan for secrets and publish the image to ttl.sh")
+	cmd.Flags().StringVar(&ttl, "ttl", "2h", "how long ttl.sh keeps the published image, at most 24h")
+	cmd.Flags().IntVar(&maxSizeMB, "max-size-mb", 0, "with --publish, fail if the uncompressed image is larger than this many MB")
+	return cmd
+}
+
+func testCmd() *cobra.Command {
+	var mocks string
+	cmd := &cobra.Command{
+		Use:   "test",
+		Short: "Run the app's tests against a fresh XTDB",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var call []string
+			if mocks != "" {
+				abs, err := filepath.Abs(mocks)
+				if err != nil {
+					return err
+				}
+				call = append(call, "with-mock-apis", "--stubs", abs)
+			}
+			return dagger(append(call, "test-clj-web-app", "--src-dir", appDir)...)
+		},
+	}
+	cmd.Flags().StringVar(&mocks, "mock-apis", "", "WireMock root directory of stub mappings to serve at MOCK_API_URL")
+	return cmd
+}
+
+func devCmd() *cobra.Command {
+	dev := &cobra.Command{
+		Use:   "dev",
+		Short: "Run the local development environment",
+	}
+
+	var proxy, vault, mailhog, keycloak, lite, keepLogs bool
+	var vaultSecrets, services, realm, mocks string
+	var appPort, proxyPort int
+	up := &cobra.Command{
+		Use:   "up",
+		Short: "Run XTDB and the app locally",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			call, err := withServices(services)
+			if err != nil {
+				return err
+			}
+			if mocks != "" {
+				abs, err := filepath.Abs(mocks)
+				if err != nil {
+					return err
+				}
+				call = append(call, "with-mock-apis", "--stubs", abs)
+			}
+			call = append(call, "run-local-web-app", "--src-dir", appDir)
+			if lite {
+				call = append(call, "--lite")
+			}
+			if keepLogs {
+				call = append(call, "--keep-logs")
+			}
+			if mailhog {
+				call = append(call, "--with-mailhog")
+			}
+			if keycloak || realm != "" {
+				call = append(call, "--with-keycloak")
+			}
+			if realm != "" {
+				abs, err := filepath.Abs(realm)
+				if err != nil {
+					return err
+				}
+				call = append(call, "--keycloak-realm", abs)
+			}
+			if vault || vaultSecrets != "" {
+				call = append(call, "--with-vault")
+			}
+			if vaultSecrets != "" {
+				abs, err := filepath.Abs(vaultSecrets)
+				if err != nil {
+					return err
+				}
+				call = append(call, "--vault-secrets", abs)
+			}
+			mapping := portMapping{"web application", appPort, 58950}
+			if proxy {
+				call = append(call, "--with-proxy")
+				mapping = portMapping{"reverse proxy", proxyPort, 80}
+			}
+			hostPorts, upPorts, err := forward(mapping)
+			if err != nil {
+				return err
+			}
+			return dagger(append(append(append(call, hostPorts...), "service", "up"), upPorts...)...)
+		},
+	}
+	up.Flags().BoolVar(&proxy, "proxy", false, "front the app, XTDB and pgAdmin with a reverse proxy on port 8000")
+	up.Flags().BoolVar(&lite, "lite", false, "small heaps, no pgAdmin and no extra services, for 8 GB laptops and Colima")
+	up.Flags().BoolVar(&keepLogs, "keep-logs", false, "keep the output of XTDB and the app for xtdbops logs")
+	up.Flags().BoolVar(&vault, "vault", false, "run a Vault dev server and pass VAULT_ADDR and VAULT_TOKEN to the app")
+	up.Flags().StringVar(&vaultSecrets, "vault-secrets", "", "JSON file of KV secrets to seed Vault with, implies --vault")
+	up.Flags().BoolVar(&mailhog, "mailhog", false, "capture the app's email in Mailhog, with its UI at mailhog.localhost under --proxy")
+	up.Flags().BoolVar(&keycloak, "keycloak", false, "run Keycloak as the app's OIDC provider, logging in at keycloak.localhost under --proxy")
+	up.Flags().StringVar(&realm, "keycloak-realm", "", "realm export to import into Keycloak, implies --keycloak")
+	up.Flags().StringVar(&mocks, "mock-apis", "", "WireMock root directory of stub mappings to serve at MOCK_API_URL")
+	up.Flags().IntVar(&appPort, "app-port", 58950, "host port of the app, or a free one if taken")
+	up.Flags().IntVar(&proxyPort, "proxy-port", 8000, "host port of the reverse proxy, or a free one if taken")
+	up.Flags().StringVar(&services, "services", "", "JSON file of extra services to run, e.g. Redis or Mailhog")
+
+	var backupInterval, backupEnv, dbServices string
+	var dbLite, dbKeepLogs bool
+	var httpPort, pgPort, monitoringPort int
+	db := &cobra.Command{
+		Use:   "db",
+		Short: "Run only XTDB locally",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			call, err := withServices(dbServices)
+			if err != nil {
+				return err
+			}
+			call = append(call, "run-local-development")
+			if backupInterval != "" {
+				call = append(call, "--backup-interval", backupInterval, "--backup-env", backupEnv)
+			}
+			if dbLite {
+				call = append(call, "--lite")
+			}
+			if dbKeepLogs {
+				call = append(call, "--keep-logs")
+			}
+			hostPorts, upPorts, err := forward(
+				portMapping{"XTDB HTTP API", httpPort, 3000},
+				portMapping{"XTDB PostgreSQL", pgPort, 5432},
+				portMapping{"XTDB monitoring", monitoringPort, 8080},
+			)
+			if err != nil {
+				return err
+			}
+			return dagger(append(append(append(call, hostPorts...), "service", "up"), upPorts...)...)
+		},
+	}
+	db.Flags().StringVar(&backupInterval, "backup-interval", "", "snapshot XTDB data on this interval, e.g. 30m; the data then persists across runs")
+	db.Flags().StringVar(&backupEnv, "backup-env", "default", "with --backup-interval, the environment whose data XTDB runs on, e.g. one per branch")
+	db.Flags().BoolVar(&dbLite, "lite", false, "small heaps and no extra services, for 8 GB laptops and Colima")
+	db.Flags().BoolVar(&dbKeepLogs, "keep-logs", false, "keep the output of XTDB for xtdbops logs")
+	db.Flags().IntVar(&httpPort, "http-port", 3000, "host port of the XTDB HTTP API, or a free one if taken")
+	db.Flags().IntVar(&pgPort, "pg-port", 5432, "host port of XTDB's PostgreSQL wire protocol, or a free one if taken")
+	db.Flags().IntVar(&monitoringPort, "monitoring-port", 8080, "host port of XTDB monitoring, or a free one if taken")
+	db.Flags().StringVar(&dbServices, "services", "", "JSON file of extra services to run, e.g. Redis or Mailhog")
+
+	dev.AddCommand(up, db)
+	return dev
+}
+
+// withServices chains the extra services of a JSON file, if any, before a
+// local environment function
+func withServices(path string) ([]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	return []string{"with-extra-services", "--specs", abs}, nil
+}
+
+func backupCmd() *cobra.Command {
+	var path, backupEnv string
+	cmd := &cobra.Command{
+		Use:   "backup",
+		Short: "Export the snapshots of the local XTDB",
+		Long:  "Export the snapshots `xtdbops dev db --backup-interval` takes of the local XTDB.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			abs, err := filepath.Abs(path)
+			if err != nil {
+				return err
+			}
+			return dagger("local-backups", "--backup-env", backupEnv, "export", "--path", abs)
+		},
+	}
+	cmd.Flags().StringVar(&path, "path", "backups", "directory to export the snapshots to")
+	cmd.Flags().StringVar(&backupEnv, "backup-env", "default", "environment the snapshots were taken in")
+	return cmd
+}
+
+func logsCmd() *cobra.Command {
+	var path string
+	cmd := &cobra.Command{
+		Use:   "logs",
+		Short: "Export the logs kept by the local environments",
+		Long:  "Export the logs `xtdbops dev up --keep-logs` and `xtdbops dev db --keep-logs` keep.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			abs, err := filepath.Abs(path)
+			if err != nil {
+				return err
+			}
+			return dagger("local-logs", "export", "--path", abs)
+		},
+	}
+	cmd.Flags().StringVar(&path, "path", "logs", "directory to export the logs to")
+	return cmd
+}
+
+func cacheCmd() *cobra.Command {
+	cache := &cobra.Command{
+		Use:   "cache",
+		Short: "Inspect and prune the Dagger caches of this project",
+	}
+
+	var statsAge string
+	stats := &cobra.Command{
+		Use:   "stats",
+		Short: "Show the size of the cache volumes and the engine cache",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return dagger("cache-stats", "--older-than", statsAge)
+		},
+	}
+	stats.Flags().StringVar(&statsAge, "older-than", "720h", "age after which files count as stale")
+
+	var pruneAge string
+	var engine bool
+	prune := &cobra.Command{
+		Use:   "prune",
+		Short: "Delete stale dependency cache files",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			call := []string{"cache-prune", "--older-than", pruneAge}
+			if engine {
+				call = append(call, "--engine")
+			}
+			return dagger(call...)
+		},
+	}
+	prune.Flags().StringVar(&pruneAge, "older-than", "720h", "age after which files are deleted")
+	prune.Flags().BoolVar(&engine, "engine", false, "also prune every cache entry the Dagger engine is not using")
+
+	cache.AddCommand(stats, prune)
+	return cache
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// localPorts are the host ports `xtdbops dev` forwards
+var localPorts = map[int]string{
+	3000:  "XTDB HTTP API",
+	5432:  "XTDB PostgreSQL",
+	8000:  "reverse proxy",
+	8080:  "XTDB monitoring",
+	58950: "web application",
+}
+
+// output runs a command and returns its trimmed output, or "" if it fails
+func output(name string, args ...string) string {
+	out, err := exec.Command(name, args...).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// containerRuntime tells which runtime the Dagger CLI starts its engine
+// with: docker, or podman when the runner host or docker itself is Podman
+func containerRuntime() string {
+	if host := os.Getenv("_EXPERIMENTAL_DAGGER_RUNNER_HOST"); host != "" {
+		if strings.HasPrefix(host, "podman") {
+			return "podman"
+		}
+		return "custom runner " + host
+	}
+	if _, err := exec.LookPath("docker"); err == nil {
+		if strings.Contains(strings.ToLower(output("docker", "--version")), "podman") {
+			return "podman"
+		}
+		return "docker"
+	}
+	if _, err := exec.LookPath("podman"); err == nil {
+		return "podman"
+	}
+	return ""
+}
+
+func doctorCmd() *cobra.Command {
+	var skipEngine bool
+	cmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Check the host and the Dagger engine for known problems",
+		Long: "Check the host and the Dagger engine for known problems with the local\n" +
+			"environments, e.g. under Podman or Docker Desktop on Windows.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			problems := 0
+			ok := func(format string, args ...any) { fmt.Printf("  ✅ "+format+"\n", args...) }
+			warn := func(format string, args ...any) {
+				problems++
+				fmt.Printf("  ⚠️  "+format+"\n", args...)
+			}
+
+			fmt.Println("Host")
+			if _, err := exec.LookPath("dagger"); err != nil {
+				warn("dagger is not on the PATH")
+			} else {
+				ok("%s", output("dagger", "version"))
+			}
+			switch rt := containerRuntime(); rt {
+			case "":
+				warn("neither docker nor podman is on the PATH, so the Dagger CLI cannot start its engine")
+			case "podman":
+				ok("Podman runs the Dagger engine")
+				if output("podman", "info", "--format", "{{.Host.Security.Rootless}}") == "true" {
+					warn("Podman is rootless: the engine's privileged container can lack capabilities;\n" +
+						"      if services fail to start, run `podman machine set --rootful` and restart the machine")
+				}
+			default:
+				ok("%s runs the Dagger engine", rt)
+			}
+			if _, err := exec.LookPath("cdktf"); err != nil {
+				hint := "install it with `npm install -g cdktf-cli` to use `xtdbops infra`"
+				if runtime.GOOS == "windows" {
+					hint += ", or run it from WSL2"
+				}
+				warn("cdktf is not on the PATH: %s", hint)
+			}
+			for _, port := range slices.Sorted(maps.Keys(localPorts)) {
+				l, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+				if err != nil {
+					warn("port %d (%s) is in use: `xtdbops dev` forwards a free port instead, `dagger ... up --ports` fails", port, localPorts[port])
+					continue
+				}
+				l.Close()
+			}
+
+			if !skipEngine {
+				fmt.Println()
+				if err := dagger("engine-check"); err != nil {
+					return err
+				}
+			}
+			if problems > 0 {
+				return fmt.Errorf("%d host problem(s)", problems)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&skipEngine, "skip-engine", false, "only check the host, without starting the Dagger engine")
+	return cmd
+}
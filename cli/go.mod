@@ -0,0 +1,10 @@
+module github.com/chiefkemist/clj-xtdb-devops/cli
+
+go 1.23
+
+require github.com/spf13/cobra v1.8.1
+
+require (
+	github.com/inconshreveable/mousetrap v1.1.0 // indirect
+	github.com/spf13/pflag v1.0.5 // indirect
+)
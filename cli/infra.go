@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// envStacks selects every CDKTF stack of an environment, infra-<env>-<layer>.
+// cdktf orders them by their dependencies.
+func envStacks(env string) string {
+	return fmt.Sprintf("infra-%s-*", env)
+}
+
+func deployCmd() *cobra.Command {
+	var opts deployOptions
+	cmd := &cobra.Command{
+		Use:   "deploy <env>",
+		Short: "Build the images and deploy every stack of an environment",
+		Long: "Build the images and deploy every stack of an environment. The stacks build\n" +
+			"and push the images themselves, so this is `xtdbops infra deploy`.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return deployStacks(args[0], opts)
+		},
+	}
+	opts.flags(cmd)
+	return cmd
+}
+
+func infraCmd() *cobra.Command {
+	infra := &cobra.Command{
+		Use:   "infra",
+		Short: "Plan and deploy the CDKTF stacks of an environment",
+	}
+
+	plan := &cobra.Command{
+		Use:   "plan <env>",
+		Short: "Show the changes deploying an environment would make",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cdktf("diff", envStacks(args[0]))
+		},
+	}
+
+	var opts deployOptions
+	deploy := &cobra.Command{
+		Use:   "deploy <env>",
+		Short: "Deploy every stack of an environment",
+		Long: "Deploy every stack of an environment. Gated environments, e.g. prod, deploy\n" +
+			"inside their deploy window, with a change ticket recorded by\n" +
+			"`dagger call approve-change-ticket`, or with an approval token from\n" +
+			"`dagger call approve-deploy` in " + approvalTokenEnv + ".",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return deployStacks(args[0], opts)
+		},
+	}
+	opts.flags(deploy)
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List the stacks of every environment",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cdktf("list")
+		},
+	}
+
+	infra.AddCommand(plan, deploy, list)
+	return infra
+}
+
+// approvalTokenEnv is the variable holding an approval token for a deploy
+// outside the deploy window, kept out of the command line and shell history
+const approvalTokenEnv = "XTDBOPS_APPROVAL_TOKEN"
+
+// deployOptions are the flags of the deploy commands
+type deployOptions struct {
+	autoApprove  bool
+	changeTicket string
+	awsCreds     string
+}
+
+func (o *deployOptions) flags(cmd *cobra.Command) {
+	home, _ := os.UserHomeDir()
+	cmd.Flags().BoolVar(&o.autoApprove, "auto-approve", false, "deploy without asking to confirm the plan")
+	cmd.Flags().StringVar(&o.changeTicket, "change-ticket", "", "approved change ticket authorizing a deploy outside the deploy window, e.g. CHG-1234")
+	cmd.Flags().StringVar(&o.awsCreds, "aws-creds", filepath.Join(home, ".aws", "credentials"), "shared AWS credentials file")
+}
+
+// deployStacks deploys the stacks of an environment once the deploy policy
+// allows it, the same check DeployToECS makes, so --auto-approve only skips
+// confirming the plan. The outcome is recorded in the audit log.
+func deployStacks(env string, opts deployOptions) error {
+	imageTag := os.Getenv("STACK_" + strings.ToUpper(env) + "_IMAGE_TAG")
+	if imageTag == "" {
+		imageTag = "latest"
+	}
+	authorize := []string{"authorize-deploy", "--env", env, "--image-ref", imageTag, "--aws-creds", "file:" + opts.awsCreds}
+	if opts.changeTicket != "" {
+		authorize = append(authorize, "--change-ticket", opts.changeTicket)
+	}
+	if os.Getenv(approvalTokenEnv) != "" {
+		authorize = append(authorize, "--approval-token", "env:"+approvalTokenEnv)
+	}
+	if err := dagger(authorize...); err != nil {
+		return fmt.Errorf("%s may not be deployed now: %w", env, err)
+	}
+
+	args := []string{"deploy", envStacks(env)}
+	if opts.autoApprove {
+		args = append(args, "--auto-approve")
+	}
+	deployErr := cdktf(args...)
+	record := []string{"record-infra-deploy", "--env", env, "--stacks", envStacks(env), "--image-ref", imageTag,
+		"--aws-creds", "file:" + opts.awsCreds}
+	if deployErr != nil {
+		record = append(record, "--failure", deployErr.Error())
+	}
+	if err := dagger(record...); err != nil {
+		return errors.Join(deployErr, fmt.Errorf("failed to write the audit log: %w", err))
+	}
+	return deployErr
+}
+
+func envsCmd() *cobra.Command {
+	var awsCreds string
+	cmd := &cobra.Command{
+		Use:   "envs",
+		Short: "Show what was last deployed to each environment",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return dagger("env-list", "--aws-creds", "file:"+awsCreds)
+		},
+	}
+	home, _ := os.UserHomeDir()
+	cmd.Flags().StringVar(&awsCreds, "aws-creds", filepath.Join(home, ".aws", "credentials"), "shared AWS credentials file")
+	return cmd
+}
@@ -0,0 +1,110 @@
+// xtdbops runs the project's Dagger functions and CDKTF stacks behind one
+// CLI, so day-to-day work doesn't need `dagger call` and `cdktf` by heart.
+// It shells out to the dagger and cdktf CLIs, which must be on the PATH.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+// repoRoot is the checkout the commands run in, found from the working
+// directory unless --root is passed
+var repoRoot string
+
+// Retry policy of the Dagger functions, passed with --retries and --timeout
+var (
+	retries   int
+	opTimeout string
+)
+
+func main() {
+	root := &cobra.Command{
+		Use:           "xtdbops",
+		Short:         "Build, test, run and deploy the Clojure XTDB app",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if repoRoot != "" {
+				return nil
+			}
+			dir, err := findRepoRoot()
+			repoRoot = dir
+			return err
+		},
+	}
+	root.PersistentFlags().StringVar(&repoRoot, "root", "", "repository checkout to run in (default: the one containing the working directory)")
+	root.PersistentFlags().IntVar(&retries, "retries", 0, "attempts at registry pushes and service starts that fail transiently (default 3)")
+	root.PersistentFlags().StringVar(&opTimeout, "timeout", "", "limit on each attempt at a registry push or service start, e.g. 10m")
+
+	root.AddCommand(buildCmd(), testCmd(), devCmd(), backupCmd(), logsCmd(), cacheCmd(), doctorCmd(), deployCmd(), infraCmd(), envsCmd())
+	if err := root.Execute(); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// findRepoRoot walks up from the working directory to the dagger.json of
+// the Dagger module
+func findRepoRoot() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "dagger.json")); err == nil {
+			return dir, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", errors.New("not inside the clj-xtdb-devops checkout, run from it or pass --root")
+		}
+		dir = parent
+	}
+}
+
+// run runs a command in a directory of the checkout with the terminal
+// attached, echoing it first so users learn the underlying call
+func run(dir string, name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = filepath.Join(repoRoot, dir)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	fmt.Printf("==> %s\n", cmd.String())
+	// Ctrl-C reaches the command too; wait for it to tear down what it
+	// started instead of exiting first
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s %s failed: %w", name, args[0], err)
+	}
+	return nil
+}
+
+// dagger calls a function of the Dagger module from the checkout root,
+// with the retry policy of --retries and --timeout
+func dagger(args ...string) error {
+	call := []string{"call"}
+	if retries > 0 || opTimeout != "" {
+		call = append(call, "with-retry-policy")
+		if retries > 0 {
+			call = append(call, "--attempts", strconv.Itoa(retries))
+		}
+		if opTimeout != "" {
+			call = append(call, "--timeout", opTimeout)
+		}
+	}
+	return run(".", "dagger", append(call, args...)...)
+}
+
+// cdktf runs the CDKTF CLI in the infra module
+func cdktf(args ...string) error {
+	return run("infra", "cdktf", args...)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+// freePort returns want if it is free on the host, or else a free port
+// picked by the OS
+func freePort(want int) (int, error) {
+	if l, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", want)); err == nil {
+		l.Close()
+		return want, nil
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// portMapping is a port of the returned service and the host port asked for
+type portMapping struct {
+	Name      string
+	Host      int
+	Container int
+}
+
+// forward resolves the host ports, falling back to free ones when taken,
+// and returns the --host-ports arguments telling the function where its
+// ports end up followed by the up --ports arguments forwarding them
+func forward(mappings ...portMapping) (hostPorts, upPorts []string, err error) {
+	for _, m := range mappings {
+		host, err := freePort(m.Host)
+		if err != nil {
+			return nil, nil, err
+		}
+		if host != m.Host {
+			fmt.Printf("⚠️  port %d (%s) is in use, forwarding %d instead\n", m.Host, m.Name, host)
+		}
+		pair := fmt.Sprintf("%d:%d", host, m.Container)
+		hostPorts = append(hostPorts, "--host-ports", pair)
+		upPorts = append(upPorts, "--ports", pair)
+	}
+	return hostPorts, upPorts, nil
+}
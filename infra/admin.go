@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/aws/jsii-runtime-go"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/cognitouserpool"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/cognitouserpoolclient"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/cognitouserpooldomain"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/kmskey"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/lblistenerrule"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/lbtargetgroup"
+	"github.com/hashicorp/terraform-cdk-go/cdktf"
+)
+
+// pgAdminImage is the pgAdmin release deployed as an internal tool
+const pgAdminImage = "dpage/pgadmin4:8.14"
+
+// pgAdminPath is where pgAdmin is served on the app's domain
+const pgAdminPath = "/pgadmin"
+
+// AdminTools is the Cognito user pool guarding the internal tools
+type AdminTools struct {
+	UserPool cognitouserpool.CognitoUserPool
+}
+
+// NewAdminTools deploys pgAdmin under pgAdminPath on the app's HTTPS
+// listener. The ALB only forwards to it after a Cognito sign-in against a
+// user pool without self sign-up, so admins are created in the pool by
+// hand, and pgAdmin's own login uses a generated password instead of its
+// defaults. The pool is protected from deletion, so a destroy keeps the
+// admins. It returns nil when no tools are enabled.
+func NewAdminTools(stack cdktf.TerraformStack, cfg StackConfig, key kmskey.KmsKey, cluster *Cluster, alb *AppLoadBalancer, network *NetworkStack) *AdminTools {
+	if !cfg.Admin.PgAdmin {
+		return nil
+	}
+	vpc, sg := network.Vpc, network.SecurityGroups
+
+	pool := cognitouserpool.NewCognitoUserPool(stack, jsii.String("AdminUserPool"), &cognitouserpool.CognitoUserPoolConfig{
+		Name:                          cfg.Name("admin"),
+		AdminCreateUserConfig:         &cognitouserpool.CognitoUserPoolAdminCreateUserConfig{AllowAdminCreateUserOnly: jsii.Bool(true)},
+		UsernameAttributes:            jsii.Strings("email"),
+		AutoVerifiedAttributes:        jsii.Strings("email"),
+		MfaConfiguration:              jsii.String("ON"),
+		SoftwareTokenMfaConfiguration: &cognitouserpool.CognitoUserPoolSoftwareTokenMfaConfiguration{Enabled: jsii.Bool(true)},
+		AccountRecoverySetting: &cognitouserpool.CognitoUserPoolAccountRecoverySetting{
+			RecoveryMechanism: &[]*cognitouserpool.CognitoUserPoolAccountRecoverySettingRecoveryMechanism{
+				{Name: jsii.String("verified_email"), Priority: jsii.Number(1)},
+			},
+		},
+		DeletionProtection: jsii.String("ACTIVE"),
+	})
+	domain := cognitouserpooldomain.NewCognitoUserPoolDomain(stack, jsii.String("AdminUserPoolDomain"), &cognitouserpooldomain.CognitoUserPoolDomainConfig{
+		Domain:     cfg.Name("admin"),
+		UserPoolId: pool.Id(),
+	})
+	client := cognitouserpoolclient.NewCognitoUserPoolClient(stack, jsii.String("AdminAlbClient"), &cognitouserpoolclient.CognitoUserPoolClientConfig{
+		Name:                            cfg.Name("admin-alb"),
+		UserPoolId:                      pool.Id(),
+		GenerateSecret:                  jsii.Bool(true),
+		AllowedOauthFlowsUserPoolClient: jsii.Bool(true),
+		AllowedOauthFlows:               jsii.Strings("code"),
+		AllowedOauthScopes:              jsii.Strings("openid", "email"),
+		CallbackUrls:                    jsii.Strings("https://" + cfg.Domain.DomainName + "/oauth2/idpresponse"),
+		SupportedIdentityProviders:      jsii.Strings("COGNITO"),
+	})
+
+	password := newGeneratedSecret(stack, key, "PgAdminPassword", cfg.Name("pgadmin-password"),
+		"pgAdmin login for "+cfg.Environment, randomPassword(stack, "PgAdminPasswordValue"))
+	roles := NewServiceRoles(stack, cfg, key, "PgAdmin", "pgadmin", nil, password)
+
+	taskDef := newTaskDefinition(stack, cfg, "PgAdminTaskDef", "pgadmin", 256, 512, roles)
+	// Pre-register the XTDB pgwire endpoint so nobody has to type it in
+	servers, _ := json.Marshal(map[string]any{
+		"Servers": map[string]any{
+			"1": map[string]any{
+				"Name":          "XTDB " + cfg.Environment,
+				"Group":         "Servers",
+				"Host":          "xtdb-service.local",
+				"Port":          5432,
+				"MaintenanceDB": "xtdb",
+				"Username":      cfg.Secrets.DatabaseUsername,
+				"SSLMode":       "prefer",
+			},
+		},
+	})
+	pgAdmin := taskDef.AddContainer(&Container{
+		Name:      "PgAdminContainer",
+		Image:     pgAdminImage,
+		Essential: true,
+		// The image reads the server list from a file, so write it first
+		EntryPoint:       []string{"sh", "-c"},
+		Command:          []string{`printf '%s' "$SERVERS_JSON" > /tmp/servers.json && exec /entrypoint.sh`},
+		PortMappings:     []PortMapping{{ContainerPort: 5050, HostPort: 5050}},
+		LogConfiguration: awsLogs(cfg, roles.LogGroup, "pgadmin"),
+	})
+	pgAdmin.AddEnvironment("PGADMIN_DEFAULT_EMAIL", "admin@"+cfg.Domain.DomainName)
+	pgAdmin.AddEnvironment("PGADMIN_LISTEN_PORT", "5050")
+	pgAdmin.AddEnvironment("PGADMIN_SERVER_JSON_FILE", "/tmp/servers.json")
+	pgAdmin.AddEnvironment("PGADMIN_DISABLE_POSTFIX", "true")
+	pgAdmin.AddEnvironment("SCRIPT_NAME", pgAdminPath)
+	pgAdmin.AddEnvironment("SERVERS_JSON", string(servers))
+	pgAdmin.AddSecret("PGADMIN_DEFAULT_PASSWORD", *password.Arn())
+
+	adminSg := newSecurityGroup(stack, cfg, vpc, "PgAdminSecurityGroup", "pgadmin", "pgAdmin tasks, reachable from the ALB only")
+	allowTraffic(stack, "AlbToPgAdmin", sg.Alb, adminSg, tcp(5050), "Forward to pgAdmin")
+	allowTraffic(stack, "PgAdminToXTDB", adminSg, sg.XTDB, tcp(5432), "XTDB pgwire from pgAdmin")
+	allowToInternet(stack, "PgAdminToInternet", adminSg, tcp(443), "HTTPS to AWS APIs and registries")
+	// The ALB completes sign-ins with Cognito over HTTPS
+	allowToInternet(stack, "AlbToCognito", sg.Alb, tcp(443), "HTTPS to Cognito")
+
+	service := newFargateService(stack, cfg, "PgAdminService", "pgadmin", cluster, taskDef, ServiceSizing{DesiredCount: 1, SpotPercent: 100}, vpc, adminSg)
+
+	targets := lbtargetgroup.NewLbTargetGroup(stack, jsii.String("PgAdminTargets"), &lbtargetgroup.LbTargetGroupConfig{
+		Name:       cfg.Name("pgadmin"),
+		Port:       jsii.Number(5050),
+		Protocol:   jsii.String("HTTP"),
+		TargetType: jsii.String("ip"),
+		VpcId:      vpc.ID,
+		HealthCheck: &lbtargetgroup.LbTargetGroupHealthCheck{
+			Path:    jsii.String(pgAdminPath + "/misc/ping"),
+			Matcher: jsii.String("200"),
+		},
+		DeregistrationDelay: jsii.String("10"),
+	})
+
+	rule := lblistenerrule.NewLbListenerRule(stack, jsii.String("PgAdminAuth"), &lblistenerrule.LbListenerRuleConfig{
+		ListenerArn: alb.Listener.Arn(),
+		Priority:    jsii.Number(10),
+		Condition: &[]*lblistenerrule.LbListenerRuleCondition{{
+			PathPattern: &lblistenerrule.LbListenerRuleConditionPathPattern{Values: jsii.Strings(pgAdminPath, pgAdminPath+"/*")},
+		}},
+		Action: &[]*lblistenerrule.LbListenerRuleAction{
+			{
+				Type:  jsii.String("authenticate-cognito"),
+				Order: jsii.Number(1),
+				AuthenticateCognito: &lblistenerrule.LbListenerRuleActionAuthenticateCognito{
+					UserPoolArn:      pool.Arn(),
+					UserPoolClientId: client.Id(),
+					UserPoolDomain:   domain.Domain(),
+					SessionTimeout:   jsii.Number(8 * 60 * 60),
+				},
+			},
+			{
+				Type:           jsii.String("forward"),
+				Order:          jsii.Number(2),
+				TargetGroupArn: targets.Arn(),
+			},
+		},
+	})
+	registerTargets(service, targets, "PgAdminContainer", 5050, rule)
+
+	return &AdminTools{UserPool: pool}
+}
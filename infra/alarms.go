@@ -0,0 +1,203 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/aws/jsii-runtime-go"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/chatbotslackchannelconfiguration"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/cloudwatchmetricalarm"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/ecsservice"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/kmskey"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/snstopic"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/snstopicpolicy"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/snstopicsubscription"
+	"github.com/hashicorp/terraform-cdk-go/cdktf"
+)
+
+// alb5xxAlarmName is the ALB error rate alarm that also rolls back app deploys
+const alb5xxAlarmName = "alb-5xx-rate"
+
+// alarmPeriod is the period, in seconds, of the metrics the alarms watch
+const alarmPeriod = 60
+
+// MonitoredService is an ECS service together with its short name, e.g. xtdb
+type MonitoredService struct {
+	Name    string
+	Service ecsservice.EcsService
+}
+
+// newTopic creates a topic encrypted with the stack key that the services
+// may publish to
+func newTopic(stack cdktf.TerraformStack, id string, name *string, key kmskey.KmsKey, publishers ...string) snstopic.SnsTopic {
+	topic := snstopic.NewSnsTopic(stack, jsii.String(id), &snstopic.SnsTopicConfig{
+		Name:           name,
+		KmsMasterKeyId: key.Arn(),
+	})
+	if len(publishers) > 0 {
+		snstopicpolicy.NewSnsTopicPolicy(stack, jsii.String(id+"Policy"), &snstopicpolicy.SnsTopicPolicyConfig{
+			Arn: topic.Arn(),
+			Policy: policyDocument(statement{
+				Sid:       "Publishers",
+				Effect:    "Allow",
+				Principal: servicePrincipal(publishers...),
+				Action:    []string{"sns:Publish"},
+				Resource:  []*string{topic.Arn()},
+			}),
+		})
+	}
+	return topic
+}
+
+// newSlackChannel posts the topic's notifications to the Slack channel
+// through AWS Chatbot, as a role that may do nothing else
+func newSlackChannel(stack cdktf.TerraformStack, cfg StackConfig, id string, name string, channelID string, topicArn *string) {
+	role := newRole(stack, id+"Role", cfg.Name(name+"-chatbot"), "Posts the "+name+" notifications to Slack", servicePrincipal("chatbot.amazonaws.com"), nil)
+	chatbotslackchannelconfiguration.NewChatbotSlackChannelConfiguration(stack, jsii.String(id), &chatbotslackchannelconfiguration.ChatbotSlackChannelConfigurationConfig{
+		ConfigurationName: cfg.Name(name),
+		IamRoleArn:        role.Arn(),
+		SlackTeamId:       jsii.String(cfg.Alerting.SlackWorkspaceID),
+		SlackChannelId:    jsii.String(channelID),
+		SnsTopicArns:      &[]*string{topicArn},
+	})
+}
+
+// metricQuery is one metric of an alarm's math expression
+func metricQuery(id string, namespace string, name string, stat string, dimensions map[string]*string) *cloudwatchmetricalarm.CloudwatchMetricAlarmMetricQuery {
+	return &cloudwatchmetricalarm.CloudwatchMetricAlarmMetricQuery{
+		Id: jsii.String(id),
+		Metric: &cloudwatchmetricalarm.CloudwatchMetricAlarmMetricQueryMetric{
+			Namespace:  jsii.String(namespace),
+			MetricName: jsii.String(name),
+			Dimensions: &dimensions,
+			Stat:       jsii.String(stat),
+			Period:     jsii.Number(alarmPeriod),
+		},
+	}
+}
+
+// expressionQuery is the math expression an alarm watches, over the queries
+func expressionQuery(expression string, label string, queries ...*cloudwatchmetricalarm.CloudwatchMetricAlarmMetricQuery) *[]*cloudwatchmetricalarm.CloudwatchMetricAlarmMetricQuery {
+	all := []*cloudwatchmetricalarm.CloudwatchMetricAlarmMetricQuery{{
+		Id:         jsii.String("expression"),
+		Expression: jsii.String(expression),
+		Label:      jsii.String(label),
+		ReturnData: jsii.Bool(true),
+	}}
+	all = append(all, queries...)
+	return &all
+}
+
+// NewServiceAlarms creates the environment's alert topic and alarms on ECS
+// CPU/memory, running tasks below desired, the ALB 5xx rate and target
+// response time. Without an ALB only the service alarms are created. Alarm
+// names share the environment's name prefix, which is what the CI module's
+// env-health looks for.
+func NewServiceAlarms(stack cdktf.TerraformStack, cfg StackConfig, key kmskey.KmsKey, services []MonitoredService, alb *AppLoadBalancer) snstopic.SnsTopic {
+	// Alarms, EventBridge rules and cost alerts publish to the topic
+	topic := newTopic(stack, "AlertTopic", cfg.Name("alerts"), key,
+		"cloudwatch.amazonaws.com", "events.amazonaws.com", "budgets.amazonaws.com", "costalerts.amazonaws.com")
+	for i, email := range cfg.Alerting.Emails {
+		snstopicsubscription.NewSnsTopicSubscription(stack, jsii.Sprintf("AlertEmail%d", i), &snstopicsubscription.SnsTopicSubscriptionConfig{
+			TopicArn: topic.Arn(),
+			Protocol: jsii.String("email"),
+			Endpoint: jsii.String(strings.TrimSpace(email)),
+		})
+	}
+	if cfg.Alerting.SlackChannelID != "" {
+		newSlackChannel(stack, cfg, "AlertSlackChannel", "alerts", cfg.Alerting.SlackChannelID, topic.Arn())
+	}
+
+	actions := &[]*string{topic.Arn()}
+	alarm := func(id string, name string, description string, config *cloudwatchmetricalarm.CloudwatchMetricAlarmConfig) {
+		config.AlarmName = cfg.Name(name)
+		config.AlarmDescription = jsii.String(description)
+		config.AlarmActions = actions
+		config.OkActions = actions
+		if config.ComparisonOperator == nil {
+			config.ComparisonOperator = jsii.String("GreaterThanOrEqualToThreshold")
+		}
+		cloudwatchmetricalarm.NewCloudwatchMetricAlarm(stack, jsii.String(id), config)
+	}
+
+	for _, svc := range services {
+		id := strings.ToUpper(svc.Name[:1]) + svc.Name[1:]
+		dimensions := &map[string]*string{
+			"ClusterName": jsii.String(cfg.ClusterName()),
+			"ServiceName": cfg.Name(svc.Name),
+		}
+
+		alarm(id+"CpuAlarm", svc.Name+"-cpu-high", svc.Name+" CPU utilization is high",
+			&cloudwatchmetricalarm.CloudwatchMetricAlarmConfig{
+				Namespace:         jsii.String("AWS/ECS"),
+				MetricName:        jsii.String("CPUUtilization"),
+				Dimensions:        dimensions,
+				Statistic:         jsii.String("Average"),
+				Period:            jsii.Number(alarmPeriod),
+				Threshold:         jsii.Number(cfg.Alerting.CpuPercent),
+				EvaluationPeriods: jsii.Number(5),
+				DatapointsToAlarm: jsii.Number(3),
+			})
+
+		alarm(id+"MemoryAlarm", svc.Name+"-memory-high", svc.Name+" memory utilization is high",
+			&cloudwatchmetricalarm.CloudwatchMetricAlarmConfig{
+				Namespace:         jsii.String("AWS/ECS"),
+				MetricName:        jsii.String("MemoryUtilization"),
+				Dimensions:        dimensions,
+				Statistic:         jsii.String("Average"),
+				Period:            jsii.Number(alarmPeriod),
+				Threshold:         jsii.Number(cfg.Alerting.MemoryPercent),
+				EvaluationPeriods: jsii.Number(5),
+				DatapointsToAlarm: jsii.Number(3),
+			})
+
+		// Task counts come from Container Insights, enabled on the cluster
+		alarm(id+"TaskCountAlarm", svc.Name+"-tasks-below-desired", svc.Name+" is running fewer tasks than desired",
+			&cloudwatchmetricalarm.CloudwatchMetricAlarmConfig{
+				MetricQuery: expressionQuery("desired - running", "Missing tasks",
+					metricQuery("desired", "ECS/ContainerInsights", "DesiredTaskCount", "Average", *dimensions),
+					metricQuery("running", "ECS/ContainerInsights", "RunningTaskCount", "Average", *dimensions),
+				),
+				Threshold:          jsii.Number(0),
+				ComparisonOperator: jsii.String("GreaterThanThreshold"),
+				EvaluationPeriods:  jsii.Number(5),
+				DatapointsToAlarm:  jsii.Number(5),
+				TreatMissingData:   jsii.String("breaching"),
+			})
+	}
+	if alb == nil {
+		return topic
+	}
+
+	// 5xx rate as a percentage of requests; quiet periods are not errors
+	lbDimensions := map[string]*string{"LoadBalancer": alb.ArnSuffix()}
+	alarm("Alb5xxAlarm", alb5xxAlarmName, "The app is answering too many requests with 5xx",
+		&cloudwatchmetricalarm.CloudwatchMetricAlarmConfig{
+			MetricQuery: expressionQuery("100 * (FILL(target, 0) + FILL(elb, 0)) / requests", "5xx %",
+				metricQuery("target", "AWS/ApplicationELB", "HTTPCode_Target_5XX_Count", "Sum", lbDimensions),
+				metricQuery("elb", "AWS/ApplicationELB", "HTTPCode_ELB_5XX_Count", "Sum", lbDimensions),
+				metricQuery("requests", "AWS/ApplicationELB", "RequestCount", "Sum", lbDimensions),
+			),
+			Threshold:         jsii.Number(cfg.Alerting.Error5xxPercent),
+			EvaluationPeriods: jsii.Number(5),
+			DatapointsToAlarm: jsii.Number(3),
+			TreatMissingData:  jsii.String("notBreaching"),
+		})
+
+	alarm("ResponseTimeAlarm", "app-response-time", "p95 app response time is high",
+		&cloudwatchmetricalarm.CloudwatchMetricAlarmConfig{
+			Namespace:  jsii.String("AWS/ApplicationELB"),
+			MetricName: jsii.String("TargetResponseTime"),
+			Dimensions: &map[string]*string{
+				"LoadBalancer": alb.ArnSuffix(),
+				"TargetGroup":  alb.Targets.ArnSuffix(),
+			},
+			ExtendedStatistic: jsii.String("p95"),
+			Period:            jsii.Number(alarmPeriod),
+			Threshold:         jsii.Number(cfg.Alerting.ResponseTimeSeconds),
+			EvaluationPeriods: jsii.Number(5),
+			DatapointsToAlarm: jsii.Number(3),
+			TreatMissingData:  jsii.String("notBreaching"),
+		})
+
+	return topic
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/jsii-runtime-go"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/acmcertificate"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/acmcertificatevalidation"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/dataawsroute53zone"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/ecsservice"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/lb"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/lblistener"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/lbtargetgroup"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/route53record"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/securitygroup"
+	"github.com/hashicorp/terraform-cdk-go/cdktf"
+)
+
+// appPort is the port the app container serves on
+const appPort = 58950
+
+// AppLoadBalancer is the internet-facing ALB in front of the app, the
+// listener serving the app and the target group the app tasks register with
+type AppLoadBalancer struct {
+	lb.Lb
+	Listener lblistener.LbListener
+	Targets  lbtargetgroup.LbTargetGroup
+}
+
+// NewAppLoadBalancer puts an internet-facing ALB in front of the app service.
+// With a domain name configured it terminates TLS with an ACM certificate,
+// redirects HTTP to HTTPS and aliases the domain to the ALB in Route53.
+func NewAppLoadBalancer(stack cdktf.TerraformStack, cfg StackConfig, vpc *Vpc, sg securitygroup.SecurityGroup, appService ecsservice.EcsService) *AppLoadBalancer {
+	alb := lb.NewLb(stack, jsii.String("AppLoadBalancer"), &lb.LbConfig{
+		Name:             cfg.Name("alb"),
+		LoadBalancerType: jsii.String("application"),
+		Internal:         jsii.Bool(false),
+		Subnets:          vpc.PublicSubnetIDs,
+		SecurityGroups:   &[]*string{sg.Id()},
+	})
+
+	targets := lbtargetgroup.NewLbTargetGroup(stack, jsii.String("AppTargets"), &lbtargetgroup.LbTargetGroupConfig{
+		Name:       cfg.Name("app"),
+		Port:       jsii.Number(appPort),
+		Protocol:   jsii.String("HTTP"),
+		TargetType: jsii.String("ip"),
+		VpcId:      vpc.ID,
+		HealthCheck: &lbtargetgroup.LbTargetGroupHealthCheck{
+			Path:               jsii.String(cfg.Domain.HealthCheckPath),
+			Matcher:            jsii.String("200"),
+			Interval:           jsii.Number(15),
+			HealthyThreshold:   jsii.Number(2),
+			UnhealthyThreshold: jsii.Number(3),
+		},
+		DeregistrationDelay: jsii.String("30"),
+	})
+	forward := &[]*lblistener.LbListenerDefaultAction{{Type: jsii.String("forward"), TargetGroupArn: targets.Arn()}}
+
+	loadBalancer := &AppLoadBalancer{Lb: alb, Targets: targets}
+	if cfg.Domain.DomainName == "" {
+		loadBalancer.Listener = lblistener.NewLbListener(stack, jsii.String("HttpListener"), &lblistener.LbListenerConfig{
+			LoadBalancerArn: alb.Arn(),
+			Port:            jsii.Number(80),
+			Protocol:        jsii.String("HTTP"),
+			DefaultAction:   forward,
+		})
+		loadBalancer.register(appService, "AppContainer", appPort)
+		return loadBalancer
+	}
+
+	zone := hostedZone(stack, cfg, "HostedZone", cfg.Domain.DomainName)
+	cert := newCertificate(stack, "AppCertificate", cfg.Domain.DomainName, zone, nil)
+
+	loadBalancer.Listener = lblistener.NewLbListener(stack, jsii.String("HttpsListener"), &lblistener.LbListenerConfig{
+		LoadBalancerArn: alb.Arn(),
+		Port:            jsii.Number(443),
+		Protocol:        jsii.String("HTTPS"),
+		CertificateArn:  cert,
+		SslPolicy:       jsii.String("ELBSecurityPolicy-TLS13-1-2-2021-06"),
+		DefaultAction:   forward,
+	})
+	loadBalancer.register(appService, "AppContainer", appPort)
+
+	// Port 80 only redirects to HTTPS
+	lblistener.NewLbListener(stack, jsii.String("HttpRedirectListener"), &lblistener.LbListenerConfig{
+		LoadBalancerArn: alb.Arn(),
+		Port:            jsii.Number(80),
+		Protocol:        jsii.String("HTTP"),
+		DefaultAction: &[]*lblistener.LbListenerDefaultAction{{
+			Type: jsii.String("redirect"),
+			Redirect: &lblistener.LbListenerDefaultActionRedirect{
+				Port:       jsii.String("443"),
+				Protocol:   jsii.String("HTTPS"),
+				StatusCode: jsii.String("HTTP_301"),
+			},
+		}},
+	})
+
+	if cfg.Failover.Enabled {
+		newFailoverRecord(stack, cfg, zone, alb)
+	} else {
+		route53record.NewRoute53Record(stack, jsii.String("AppAliasRecord"), &route53record.Route53RecordConfig{
+			ZoneId: zone.ZoneId(),
+			Name:   jsii.String(cfg.Domain.DomainName),
+			Type:   jsii.String("A"),
+			Alias: &route53record.Route53RecordAlias{
+				Name:                 alb.DnsName(),
+				ZoneId:               alb.ZoneId(),
+				EvaluateTargetHealth: jsii.Bool(false),
+			},
+		})
+	}
+
+	return loadBalancer
+}
+
+// register has the service's tasks join the app target group
+func (l *AppLoadBalancer) register(service ecsservice.EcsService, container string, port float64) {
+	registerTargets(service, l.Targets, container, port, l.Listener)
+}
+
+// registerTargets has the service's tasks join the target group once the
+// listener or rule forwarding to it exists, which ECS requires of a target
+// group before a service can use it
+func registerTargets(service ecsservice.EcsService, targets lbtargetgroup.LbTargetGroup, container string, port float64, forwarder cdktf.ITerraformDependable) {
+	service.PutLoadBalancer(&[]*ecsservice.EcsServiceLoadBalancer{{
+		TargetGroupArn: targets.Arn(),
+		ContainerName:  jsii.String(container),
+		ContainerPort:  jsii.Number(port),
+	}})
+	dependsOn := []*string{forwarder.Fqn()}
+	if existing := service.DependsOn(); existing != nil {
+		dependsOn = append(*existing, dependsOn...)
+	}
+	service.SetDependsOn(&dependsOn)
+}
+
+// hostedZone finds the zone serving domain: the existing zone when one is
+// configured, otherwise a lookup of hostedZoneName or, by default, of the
+// parent of the domain, e.g. example.com for app.example.com
+func hostedZone(stack cdktf.TerraformStack, cfg StackConfig, id string, domain string) dataawsroute53zone.DataAwsRoute53Zone {
+	if zoneID := cfg.Existing.HostedZoneID; zoneID != "" {
+		return dataawsroute53zone.NewDataAwsRoute53Zone(stack, jsii.String(id), &dataawsroute53zone.DataAwsRoute53ZoneConfig{
+			ZoneId: jsii.String(zoneID),
+		})
+	}
+	zoneName := cfg.Domain.HostedZoneName
+	if zoneName == "" {
+		_, zoneName, _ = strings.Cut(domain, ".")
+	}
+	return dataawsroute53zone.NewDataAwsRoute53Zone(stack, jsii.String(id), &dataawsroute53zone.DataAwsRoute53ZoneConfig{
+		Name: jsii.String(zoneName),
+	})
+}
+
+// newCertificate requests an ACM certificate for the domain, validated by
+// a DNS record in the zone, and returns its ARN once it is issued. With a
+// provider the certificate is requested through it, for another region.
+func newCertificate(stack cdktf.TerraformStack, id string, domain string, zone dataawsroute53zone.DataAwsRoute53Zone, provider cdktf.TerraformProvider) *string {
+	cert := acmcertificate.NewAcmCertificate(stack, jsii.String(id), &acmcertificate.AcmCertificateConfig{
+		DomainName:       jsii.String(domain),
+		ValidationMethod: jsii.String("DNS"),
+		Lifecycle:        &cdktf.TerraformResourceLifecycle{CreateBeforeDestroy: jsii.Bool(true)},
+		Provider:         provider,
+	})
+	// A certificate for a single name has a single validation record
+	option := cert.DomainValidationOptions().Get(jsii.Number(0))
+	record := route53record.NewRoute53Record(stack, jsii.String(id+"Validation"), &route53record.Route53RecordConfig{
+		ZoneId:         zone.ZoneId(),
+		Name:           option.ResourceRecordName(),
+		Type:           option.ResourceRecordType(),
+		Records:        &[]*string{option.ResourceRecordValue()},
+		Ttl:            jsii.Number(60),
+		AllowOverwrite: jsii.Bool(true),
+	})
+	validation := acmcertificatevalidation.NewAcmCertificateValidation(stack, jsii.String(id+"Issued"), &acmcertificatevalidation.AcmCertificateValidationConfig{
+		CertificateArn:        cert.Arn(),
+		ValidationRecordFqdns: &[]*string{record.Fqdn()},
+		Provider:              provider,
+	})
+	return validation.CertificateArn()
+}
+
+// targetGroupLabel is how ALB request-count scaling names a target group
+func targetGroupLabel(l *AppLoadBalancer) *string {
+	return jsii.String(fmt.Sprintf("%s/%s", *l.ArnSuffix(), *l.Targets.ArnSuffix()))
+}
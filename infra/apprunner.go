@@ -0,0 +1,150 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/aws/constructs-go/constructs/v10"
+	"github.com/aws/jsii-runtime-go"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/apprunnerautoscalingconfigurationversion"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/apprunnerservice"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/apprunnervpcconnector"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/ecsservice"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/servicediscoveryprivatednsnamespace"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/servicediscoveryservice"
+	"github.com/hashicorp/terraform-cdk-go/cdktf"
+)
+
+// NewAppRunnerStack creates the app layer of a low-ops environment. XTDB runs
+// on Fargate as in the app stack, but the app runs on App Runner, which
+// serves HTTPS on its own domain and scales with requests, so there is no
+// load balancer to pay for. The app reaches XTDB through a VPC connector in
+// the app security group and finds it in Cloud Map.
+func NewAppRunnerStack(scope constructs.Construct, cfg StackConfig, network *NetworkStack, data *DataStack) cdktf.TerraformStack {
+	stack := newEnvironmentStack(scope, cfg, LayerAppRunner)
+	stack.AddDependency(data.Stack)
+	vpc, securityGroups := network.Vpc, network.SecurityGroups
+	key, storage, database, dbCredentials := data.Key, data.Storage, data.Database, data.Credentials
+
+	// CI pushes the app image; App Runner only runs x86_64 images
+	appRepo := NewAppRepository(stack, cfg, key)
+
+	cluster := NewCluster(stack, cfg, key)
+	xtdb := NewXTDBService(stack, cfg, network, data, cluster, NewLogShipping(cfg))
+	NewXTDBHibernation(stack, cfg, xtdb.Service)
+
+	// App Runner resolves names through the VPC, so XTDB registers in a
+	// private namespace
+	namespace := servicediscoveryprivatednsnamespace.NewServiceDiscoveryPrivateDnsNamespace(stack, jsii.String("XTDBNamespace"), &servicediscoveryprivatednsnamespace.ServiceDiscoveryPrivateDnsNamespaceConfig{
+		Name:        jsii.String(cfg.NamePrefix + ".internal"),
+		Description: jsii.String("Service discovery of " + cfg.NamePrefix),
+		Vpc:         vpc.ID,
+	})
+	discovery := servicediscoveryservice.NewServiceDiscoveryService(stack, jsii.String("XTDBDiscovery"), &servicediscoveryservice.ServiceDiscoveryServiceConfig{
+		Name: jsii.String("xtdb"),
+		DnsConfig: &servicediscoveryservice.ServiceDiscoveryServiceDnsConfig{
+			NamespaceId:   namespace.Id(),
+			RoutingPolicy: jsii.String("MULTIVALUE"),
+			DnsRecords: &[]*servicediscoveryservice.ServiceDiscoveryServiceDnsConfigDnsRecords{
+				{Type: jsii.String("A"), Ttl: jsii.Number(60)},
+			},
+		},
+		HealthCheckCustomConfig: &servicediscoveryservice.ServiceDiscoveryServiceHealthCheckCustomConfig{FailureThreshold: jsii.Number(1)},
+	})
+	xtdb.Service.PutServiceRegistries(&ecsservice.EcsServiceServiceRegistries{RegistryArn: discovery.Arn()})
+
+	connector := apprunnervpcconnector.NewApprunnerVpcConnector(stack, jsii.String("AppVpcConnector"), &apprunnervpcconnector.ApprunnerVpcConnectorConfig{
+		VpcConnectorName: cfg.Name("app"),
+		Subnets:          vpc.PrivateSubnetIDs,
+		SecurityGroups:   &[]*string{securityGroups.App.Id()},
+	})
+
+	// Named like the ECS service roles, so the stack key allows them
+	accessRole := newRole(stack, "AppRunnerAccessRole", cfg.Name("apprunner-execution"), "Pulls the app image for App Runner", servicePrincipal("build.apprunner.amazonaws.com"), nil)
+	grantPull(accessRole, appRepo)
+	instanceRole := newRole(stack, "AppRunnerInstanceRole", cfg.Name("apprunner-task"), "Runs the app on App Runner", servicePrincipal("tasks.apprunner.amazonaws.com"), nil)
+	grantSecretRead(instanceRole, "Credentials", dbCredentials.Arn(), key)
+
+	secrets := map[string]*string{
+		"XTDB_USERNAME": jsii.String(*dbCredentials.Arn() + ":username::"),
+		"XTDB_PASSWORD": jsii.String(*dbCredentials.Arn() + ":password::"),
+	}
+	settings := NewAppSettings(stack, cfg)
+	if len(settings.names) > 0 {
+		var arns []*string
+		for _, name := range settings.names {
+			secrets[name] = settings.Parameters[name].Arn()
+			arns = append(arns, settings.Parameters[name].Arn())
+		}
+		instanceRole.Allow("AppSettings", []string{"ssm:GetParameters"}, arns...)
+	}
+
+	scaling := apprunnerautoscalingconfigurationversion.NewApprunnerAutoScalingConfigurationVersion(stack, jsii.String("AppRunnerScaling"), &apprunnerautoscalingconfigurationversion.ApprunnerAutoScalingConfigurationVersionConfig{
+		AutoScalingConfigurationName: cfg.Name("app"),
+		MinSize:                      jsii.Number(cfg.Scaling.MinCapacity),
+		MaxSize:                      jsii.Number(cfg.Scaling.MaxCapacity),
+	})
+
+	service := apprunnerservice.NewApprunnerService(stack, jsii.String("AppRunnerService"), &apprunnerservice.ApprunnerServiceConfig{
+		ServiceName: cfg.Name("app"),
+		SourceConfiguration: &apprunnerservice.ApprunnerServiceSourceConfiguration{
+			AuthenticationConfiguration: &apprunnerservice.ApprunnerServiceSourceConfigurationAuthenticationConfiguration{
+				AccessRoleArn: accessRole.Arn(),
+			},
+			// Deploys go through Terraform, which pins the image tag
+			AutoDeploymentsEnabled: jsii.Bool(false),
+			ImageRepository: &apprunnerservice.ApprunnerServiceSourceConfigurationImageRepository{
+				ImageIdentifier:     jsii.String(imageURI(cfg, appRepo)),
+				ImageRepositoryType: jsii.String("ECR"),
+				ImageConfiguration: &apprunnerservice.ApprunnerServiceSourceConfigurationImageRepositoryImageConfiguration{
+					Port: jsii.String(strconv.Itoa(appPort)),
+					RuntimeEnvironmentVariables: &map[string]*string{
+						"XTDB_ADDR": jsii.String("xtdb." + cfg.NamePrefix + ".internal:3000"),
+					},
+					RuntimeEnvironmentSecrets: &secrets,
+				},
+			},
+		},
+		InstanceConfiguration: &apprunnerservice.ApprunnerServiceInstanceConfiguration{
+			Cpu:             jsii.String(strconv.FormatFloat(cfg.App.Cpu, 'f', -1, 64)),
+			Memory:          jsii.String(strconv.FormatFloat(cfg.App.MemoryMiB, 'f', -1, 64)),
+			InstanceRoleArn: instanceRole.Arn(),
+		},
+		NetworkConfiguration: &apprunnerservice.ApprunnerServiceNetworkConfiguration{
+			EgressConfiguration: &apprunnerservice.ApprunnerServiceNetworkConfigurationEgressConfiguration{
+				EgressType:      jsii.String("VPC"),
+				VpcConnectorArn: connector.Arn(),
+			},
+		},
+		HealthCheckConfiguration: &apprunnerservice.ApprunnerServiceHealthCheckConfiguration{
+			Protocol: jsii.String("HTTP"),
+			Path:     jsii.String(cfg.Domain.HealthCheckPath),
+		},
+		EncryptionConfiguration: &apprunnerservice.ApprunnerServiceEncryptionConfiguration{
+			KmsKey: key.Arn(),
+		},
+		AutoScalingConfigurationArn: scaling.Arn(),
+	})
+
+	// XTDB keeps its alarms, restarts and nightly export; the app is watched
+	// by App Runner's own health check
+	services := []MonitoredService{{Name: "xtdb", Service: xtdb.Service}}
+	alerts := NewServiceAlarms(stack, cfg, key, services, nil)
+	NewCostAlerts(stack, cfg, alerts)
+	NewPagerDutyRouting(stack, cfg, alerts)
+	NewDatadogMonitors(stack, cfg, services, nil)
+	NewDeployNotifications(stack, cfg, key, services)
+	if database != nil {
+		NewRotationRestarts(stack, cfg, services, dbCredentials.Arn(), databaseSecretArn(database))
+	} else {
+		NewRotationRestarts(stack, cfg, services, dbCredentials.Arn())
+	}
+	NewExecOutputs(stack, cfg, services)
+	NewXTDBBackups(stack, cfg, key, cluster, storage, vpc, securityGroups.XTDB, alerts)
+
+	output(stack, "app_url", jsii.String("https://"+*service.ServiceUrl()), "URL the app is served on")
+	output(stack, "app_runner_service_arn", service.Arn(), "App Runner service running the app")
+	output(stack, "cluster_name", cluster.Name, "ECS cluster running XTDB")
+	output(stack, "xtdb_service_name", xtdb.Service.Name(), "ECS service name of xtdb")
+	output(stack, "app_repository_url", appRepo.RepositoryUrl(), "ECR repository of the app image")
+	return stack
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/aws/constructs-go/constructs/v10"
+	"github.com/aws/jsii-runtime-go"
+	"github.com/hashicorp/terraform-cdk-go/cdktf"
+)
+
+// CloudAzure deploys an environment to Azure Container Apps
+const CloudAzure = "azure"
+
+// azureWorkloadProfile is the serverless profile both container apps run on
+const azureWorkloadProfile = "Consumption"
+
+// azureStack collects the resources of the azure stack
+type azureStack struct {
+	stack         cdktf.TerraformStack
+	resourceGroup *string
+	location      string
+}
+
+// resource declares an azurerm resource in the environment's resource group
+func (a *azureStack) resource(id string, resourceType string, attributes map[string]interface{}) cdktf.TerraformResource {
+	attributes["resource_group_name"] = a.resourceGroup
+	return providerResource(a.stack, id, resourceType, attributes)
+}
+
+// grant assigns a built-in role on scope to an identity's principal
+func (a *azureStack) grant(id string, scope *string, role string, principalID *string) cdktf.TerraformResource {
+	return providerResource(a.stack, id, "azurerm_role_assignment", map[string]interface{}{
+		"scope":                scope,
+		"role_definition_name": role,
+		"principal_id":         principalID,
+	})
+}
+
+// NewAzureStack creates an environment on Azure in one stack, with the same
+// shape as the AWS layers:
+//   - a VNet whose subnet hosts the Container Apps environment
+//   - a container registry CI pushes the images to, tagged azure.imageTag
+//   - XTDB as an internal container app, with its object store in Blob
+//     storage and its local transaction log on an Azure Files share
+//   - the app as a public container app, scaled between the scaling capacities
+//   - Key Vault holding the credentials, read by the apps' managed identities
+//
+// Its state stays in the environment's S3 backend.
+func NewAzureStack(scope constructs.Construct, cfg StackConfig) cdktf.TerraformStack {
+	stack := cdktf.NewTerraformStack(scope, jsii.String(cfg.LayerStackID(LayerAzure)))
+	configureBackend(stack, cfg, LayerAzure)
+	requireProvider(stack, "azurerm", "hashicorp/azurerm", "~> 4.0")
+	stack.AddOverride(jsii.String("provider.azurerm"), []map[string]interface{}{
+		{"features": map[string]interface{}{}, "subscription_id": cfg.Azure.SubscriptionID},
+	})
+	client := cdktf.NewTerraformDataSource(stack, jsii.String("ClientConfig"), &cdktf.TerraformResourceConfig{
+		TerraformResourceType: jsii.String("azurerm_client_config"),
+	})
+
+	tags := azureTags(cfg)
+	group := providerResource(stack, "ResourceGroup", "azurerm_resource_group", map[string]interface{}{
+		"name":     cfg.NamePrefix,
+		"location": cfg.Azure.Location,
+		"tags":     tags,
+	})
+	a := &azureStack{stack: stack, resourceGroup: group.GetStringAttribute(jsii.String("name")), location: cfg.Azure.Location}
+
+	network := a.resource("Network", "azurerm_virtual_network", map[string]interface{}{
+		"name":          cfg.NamePrefix,
+		"location":      a.location,
+		"address_space": []string{cfg.Network.Cidr},
+		"tags":          tags,
+	})
+	subnet := a.resource("AppsSubnet", "azurerm_subnet", map[string]interface{}{
+		"name":                 *cfg.Name("apps"),
+		"virtual_network_name": network.GetStringAttribute(jsii.String("name")),
+		"address_prefixes":     []*string{cdktf.Fn_Cidrsubnet(jsii.String(cfg.Network.Cidr), jsii.Number(23-cidrPrefixLength(cfg.Network.Cidr)), jsii.Number(0))},
+		"delegation": []map[string]interface{}{{
+			"name": "container-apps",
+			"service_delegation": map[string]interface{}{
+				"name":    "Microsoft.App/environments",
+				"actions": []string{"Microsoft.Network/virtualNetworks/subnets/join/action"},
+			},
+		}},
+	})
+
+	logs := a.resource("Logs", "azurerm_log_analytics_workspace", map[string]interface{}{
+		"name":              cfg.NamePrefix,
+		"location":          a.location,
+		"sku":               "PerGB2018",
+		"retention_in_days": min(730, max(30, cfg.Observability.LogRetentionDays)), // what a workspace allows
+		"tags":              tags,
+	})
+	environment := a.resource("AppsEnvironment", "azurerm_container_app_environment", map[string]interface{}{
+		"name":                       cfg.NamePrefix,
+		"location":                   a.location,
+		"log_analytics_workspace_id": logs.GetStringAttribute(jsii.String("id")),
+		"infrastructure_subnet_id":   subnet.GetStringAttribute(jsii.String("id")),
+		"workload_profile": []map[string]string{{
+			"name":                  azureWorkloadProfile,
+			"workload_profile_type": azureWorkloadProfile,
+		}},
+		"tags": tags,
+	})
+	environmentID := environment.GetStringAttribute(jsii.String("id"))
+
+	registry := a.resource("Registry", "azurerm_container_registry", map[string]interface{}{
+		"name":          azureName(cfg, "", 50),
+		"location":      a.location,
+		"sku":           "Basic",
+		"admin_enabled": false,
+		"tags":          tags,
+	})
+	registryServer := registry.GetStringAttribute(jsii.String("login_server"))
+
+	xtdbIdentity := a.resource("XTDBIdentity", "azurerm_user_assigned_identity", map[string]interface{}{
+		"name":     *cfg.Name("xtdb"),
+		"location": a.location,
+		"tags":     tags,
+	})
+	appIdentity := a.resource("AppIdentity", "azurerm_user_assigned_identity", map[string]interface{}{
+		"name":     *cfg.Name("app"),
+		"location": a.location,
+		"tags":     tags,
+	})
+	xtdbPrincipal := xtdbIdentity.GetStringAttribute(jsii.String("principal_id"))
+	appPrincipal := appIdentity.GetStringAttribute(jsii.String("principal_id"))
+	a.grant("XTDBRegistryPull", registry.GetStringAttribute(jsii.String("id")), "AcrPull", xtdbPrincipal)
+	a.grant("AppRegistryPull", registry.GetStringAttribute(jsii.String("id")), "AcrPull", appPrincipal)
+
+	// XTDB's object store and the share its local log and disk cache live on
+	storage := a.resource("Storage", "azurerm_storage_account", map[string]interface{}{
+		"name":                            azureName(cfg, "data", 24),
+		"location":                        a.location,
+		"account_tier":                    "Standard",
+		"account_replication_type":        "ZRS",
+		"min_tls_version":                 "TLS1_2",
+		"https_traffic_only_enabled":      true,
+		"allow_nested_items_to_be_public": false,
+		"blob_properties": map[string]interface{}{
+			"versioning_enabled": true,
+		},
+		"tags": tags,
+	})
+	storageID := storage.GetStringAttribute(jsii.String("id"))
+	objects := providerResource(stack, "XTDBObjectStore", "azurerm_storage_container", map[string]interface{}{
+		"name":                  "xtdb-objects",
+		"storage_account_id":    storageID,
+		"container_access_type": "private",
+	})
+	share := providerResource(stack, "XTDBData", "azurerm_storage_share", map[string]interface{}{
+		"name":               "xtdb-data",
+		"storage_account_id": storageID,
+		"quota":              100,
+	})
+	a.grant("XTDBObjectStoreAccess", storageID, "Storage Blob Data Contributor", xtdbPrincipal)
+	dataStorage := providerResource(stack, "XTDBDataStorage", "azurerm_container_app_environment_storage", map[string]interface{}{
+		"name":                         "xtdb-data",
+		"container_app_environment_id": environmentID,
+		"account_name":                 storage.GetStringAttribute(jsii.String("name")),
+		"share_name":                   share.GetStringAttribute(jsii.String("name")),
+		"access_key":                   storage.GetStringAttribute(jsii.String("primary_access_key")),
+		"access_mode":                  "ReadWrite",
+	})
+
+	// Key Vault authorizes with Azure RBAC; whoever applies the stack writes
+	// the secrets, the identities only read them
+	vault := a.resource("KeyVault", "azurerm_key_vault", map[string]interface{}{
+		"name":                       azureName(cfg, "kv", 24),
+		"location":                   a.location,
+		"tenant_id":                  client.GetStringAttribute(jsii.String("tenant_id")),
+		"sku_name":                   "standard",
+		"enable_rbac_authorization":  true,
+		"purge_protection_enabled":   cfg.PreventDestroy,
+		"soft_delete_retention_days": 7,
+		"tags":                       tags,
+	})
+	vaultID := vault.GetStringAttribute(jsii.String("id"))
+	writer := a.grant("KeyVaultWriter", vaultID, "Key Vault Secrets Officer", client.GetStringAttribute(jsii.String("object_id")))
+	a.grant("XTDBKeyVaultRead", vaultID, "Key Vault Secrets User", xtdbPrincipal)
+	a.grant("AppKeyVaultRead", vaultID, "Key Vault Secrets User", appPrincipal)
+	secret := func(id string, name string, value *string) *string {
+		s := providerResource(stack, id, "azurerm_key_vault_secret", map[string]interface{}{
+			"name":         name,
+			"value":        value,
+			"key_vault_id": vaultID,
+			"depends_on":   []string{*writer.Fqn()},
+		})
+		return s.GetStringAttribute(jsii.String("versionless_id"))
+	}
+	username := secret("XTDBUsername", "xtdb-username", jsii.String(cfg.Secrets.DatabaseUsername))
+	password := secret("XTDBPassword", "xtdb-password", randomPassword(stack, "XTDBPasswordValue"))
+
+	xtdbIdentityID := xtdbIdentity.GetStringAttribute(jsii.String("id"))
+	xtdb := a.resource("XTDBApp", "azurerm_container_app", map[string]interface{}{
+		"name":                         *cfg.Name("xtdb"),
+		"container_app_environment_id": environmentID,
+		"revision_mode":                "Single",
+		"workload_profile_name":        azureWorkloadProfile,
+		"identity":                     map[string]interface{}{"type": "UserAssigned", "identity_ids": []*string{xtdbIdentityID}},
+		"registry":                     []map[string]interface{}{{"server": registryServer, "identity": xtdbIdentityID}},
+		"secret": []map[string]interface{}{
+			{"name": "xtdb-username", "key_vault_secret_id": username, "identity": xtdbIdentityID},
+			{"name": "xtdb-password", "key_vault_secret_id": password, "identity": xtdbIdentityID},
+			{"name": "xtdb-config", "value": azureNodeConfig(storage, objects, xtdbIdentity)},
+		},
+		"ingress": map[string]interface{}{
+			"external_enabled": false,
+			"target_port":      3000,
+			"transport":        "http",
+			"traffic_weight":   []map[string]interface{}{{"latest_revision": true, "percentage": 100}},
+		},
+		"template": map[string]interface{}{
+			// The local log has a single writer
+			"min_replicas": 1,
+			"max_replicas": 1,
+			"container": []map[string]interface{}{{
+				"name":   "xtdb",
+				"image":  *registryServer + "/xtdb:" + cfg.Azure.ImageTag,
+				"args":   []string{"-f", "/etc/xtdb/xtdb-config"},
+				"cpu":    azureCpu(cfg.XTDB),
+				"memory": azureMemory(cfg.XTDB),
+				"env": []map[string]string{
+					{"name": "POSTGRES_USER", "secret_name": "xtdb-username"},
+					{"name": "POSTGRES_PASSWORD", "secret_name": "xtdb-password"},
+				},
+				"volume_mounts": []map[string]string{
+					{"name": "xtdb-data", "path": xtdbDataDir},
+					{"name": "xtdb-config", "path": "/etc/xtdb"},
+				},
+			}},
+			"volume": []map[string]interface{}{
+				{"name": "xtdb-data", "storage_type": "AzureFile", "storage_name": dataStorage.GetStringAttribute(jsii.String("name"))},
+				{"name": "xtdb-config", "storage_type": "Secret"},
+			},
+		},
+		"tags": tags,
+	})
+
+	appIdentityID := appIdentity.GetStringAttribute(jsii.String("id"))
+	appEnv := []map[string]interface{}{
+		// Apps in the environment reach each other's ingress by name
+		{"name": "XTDB_ADDR", "value": *cfg.Name("xtdb") + ":80"},
+		{"name": "XTDB_USERNAME", "secret_name": "xtdb-username"},
+		{"name": "XTDB_PASSWORD", "secret_name": "xtdb-password"},
+	}
+	for _, name := range slices.Sorted(maps.Keys(cfg.AppSettings)) {
+		appEnv = append(appEnv, map[string]interface{}{"name": name, "value": cfg.AppSettings[name]})
+	}
+	app := a.resource("App", "azurerm_container_app", map[string]interface{}{
+		"name":                         *cfg.Name("app"),
+		"container_app_environment_id": environmentID,
+		"revision_mode":                "Single",
+		"workload_profile_name":        azureWorkloadProfile,
+		"identity":                     map[string]interface{}{"type": "UserAssigned", "identity_ids": []*string{appIdentityID}},
+		"registry":                     []map[string]interface{}{{"server": registryServer, "identity": appIdentityID}},
+		"secret": []map[string]interface{}{
+			{"name": "xtdb-username", "key_vault_secret_id": username, "identity": appIdentityID},
+			{"name": "xtdb-password", "key_vault_secret_id": password, "identity": appIdentityID},
+		},
+		"ingress": map[string]interface{}{
+			"external_enabled": true,
+			"target_port":      58950,
+			"transport":        "http",
+			"traffic_weight":   []map[string]interface{}{{"latest_revision": true, "percentage": 100}},
+		},
+		"template": map[string]interface{}{
+			"min_replicas": cfg.Scaling.MinCapacity,
+			"max_replicas": cfg.Scaling.MaxCapacity,
+			"container": []map[string]interface{}{{
+				"name":   "app",
+				"image":  *registryServer + "/app:" + cfg.Azure.ImageTag,
+				"cpu":    azureCpu(cfg.App),
+				"memory": azureMemory(cfg.App),
+				"env":    appEnv,
+				"startup_probe": []map[string]interface{}{{
+					"transport": "HTTP",
+					"port":      58950,
+					"path":      cfg.Domain.HealthCheckPath,
+				}},
+			}},
+		},
+		"tags": tags,
+	})
+
+	output(stack, "app_url", jsii.String("https://"+*app.GetStringAttribute(jsii.String("latest_revision_fqdn"))), "URL the app is served on")
+	output(stack, "xtdb_app_name", xtdb.GetStringAttribute(jsii.String("name")), "Container app running xtdb")
+	output(stack, "image_registry", registryServer, "Container registry to push the app and xtdb images to")
+	output(stack, "xtdb_storage_account", storage.GetStringAttribute(jsii.String("name")), "Storage account of the XTDB object store")
+	output(stack, "key_vault_name", vault.GetStringAttribute(jsii.String("name")), "Key Vault holding the credentials")
+	return stack
+}
+
+// azureNodeConfig is the XTDB node config with its object store in the blob
+// container, read as the XTDB identity, and its log and disk cache on the
+// file share
+func azureNodeConfig(storage cdktf.TerraformResource, container cdktf.TerraformResource, identity cdktf.TerraformResource) string {
+	return fmt.Sprintf(`server:
+  port: 5432
+healthz:
+  port: 8080
+modules:
+  - !HttpServer
+    port: 3000
+log: !Local
+  path: %[1]s/log
+storage: !Remote
+  objectStore: !Azure
+    storageAccount: %[2]s
+    container: %[3]s
+    prefix: xtdb
+    userManagedIdentityClientId: %[4]s
+  localDiskCache: %[1]s/cache
+`, xtdbDataDir,
+		*storage.GetStringAttribute(jsii.String("name")),
+		*container.GetStringAttribute(jsii.String("name")),
+		*identity.GetStringAttribute(jsii.String("client_id")))
+}
+
+// azureName is a name for the resources Azure restricts to lowercase letters
+// and digits, unique per environment within maxLength
+func azureName(cfg StackConfig, suffix string, maxLength int) string {
+	name := strings.ReplaceAll(cfg.NamePrefix, "-", "")
+	if len(name)+len(suffix) > maxLength {
+		name = name[:maxLength-len(suffix)]
+	}
+	return name + suffix
+}
+
+// azureCpu converts Fargate CPU units to Container Apps cores
+func azureCpu(sizing ServiceSizing) float64 {
+	return sizing.Cpu / 1024
+}
+
+// azureMemory is the memory Container Apps pairs with the CPU: 2Gi per core
+func azureMemory(sizing ServiceSizing) string {
+	return strconv.FormatFloat(sizing.Cpu/512, 'f', -1, 64) + "Gi"
+}
+
+// azureTags are the stack's ownership tags
+func azureTags(cfg StackConfig) map[string]string {
+	tags := map[string]string{}
+	for i, value := range []string{cfg.Environment, cfg.Tags.Service, cfg.Tags.Owner, cfg.Tags.CostCenter} {
+		tags[costAllocationTags[i]] = value
+	}
+	return tags
+}
+
+// cidrPrefixLength is the prefix length of a CIDR block, e.g. 16 for 10.0.0.0/16
+func cidrPrefixLength(cidr string) float64 {
+	_, bits, _ := strings.Cut(cidr, "/")
+	n, _ := strconv.ParseFloat(bits, 64)
+	return n
+}
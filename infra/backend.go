@@ -0,0 +1,89 @@
+package main
+
+import (
+	"github.com/aws/constructs-go/constructs/v10"
+	"github.com/aws/jsii-runtime-go"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/cloudwatchloggroup"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/dynamodbtable"
+	"github.com/hashicorp/terraform-cdk-go/cdktf"
+)
+
+// NewBootstrapStack creates the state bucket and lock table used by the
+// environment stacks' S3 backend, the table of deployments and the audit log. It keeps its own state locally, so apply it
+// once per account before the first `cdktf deploy` of an environment. With a
+// state role it is created in that role's account. Terraform refuses to
+// destroy any of it.
+func NewBootstrapStack(scope constructs.Construct, id string, state StateBackendConfig, tags TagsConfig) cdktf.TerraformStack {
+	stack := cdktf.NewTerraformStack(scope, jsii.String(id))
+
+	newAwsProvider(stack, "", state.Region, state.RoleArn, "", defaultTags(tags, "shared"))
+
+	// Versioned so a bad apply can be rolled back to a previous state file
+	newPrivateBucket(stack, "StateBucket", jsii.String(state.Bucket), nil, true)
+
+	// Terraform's S3 backend locks on a table keyed by LockID
+	newTable(stack, "LockTable", state.LockTable, "LockID")
+
+	// DeployToECS keeps one item per environment, the latest deployment
+	newTable(stack, "DeployTable", state.DeployTable, "Environment")
+
+	// Kept over a year as SOC 2 evidence; log events cannot be edited
+	cloudwatchloggroup.NewCloudwatchLogGroup(stack, jsii.String("AuditLogGroup"), &cloudwatchloggroup.CloudwatchLogGroupConfig{
+		Name:            jsii.String(state.AuditLogGroup),
+		RetentionInDays: jsii.Number(400),
+	})
+
+	cdktf.Aspects_Of(stack).Add(&preventDestroy{})
+	return stack
+}
+
+// newTable creates an on-demand table keyed by a string, with point-in-time recovery
+func newTable(stack cdktf.TerraformStack, id string, name string, key string) dynamodbtable.DynamodbTable {
+	return dynamodbtable.NewDynamodbTable(stack, jsii.String(id), &dynamodbtable.DynamodbTableConfig{
+		Name:        jsii.String(name),
+		HashKey:     jsii.String(key),
+		Attribute:   &[]*dynamodbtable.DynamodbTableAttribute{{Name: jsii.String(key), Type: jsii.String("S")}},
+		BillingMode: jsii.String("PAY_PER_REQUEST"),
+		PointInTimeRecovery: &dynamodbtable.DynamodbTablePointInTimeRecovery{
+			Enabled: jsii.Bool(true),
+		},
+	})
+}
+
+// configureBackend stores a layer's state in the shared bucket under its own key
+func configureBackend(stack cdktf.TerraformStack, cfg StackConfig, layer string) {
+	if cfg.State.Local {
+		return
+	}
+	backend := &cdktf.S3BackendConfig{
+		Bucket:        jsii.String(cfg.State.Bucket),
+		Key:           jsii.String(cfg.LayerStackID(layer) + "/terraform.tfstate"),
+		Region:        jsii.String(cfg.State.Region),
+		DynamodbTable: jsii.String(cfg.State.LockTable),
+		Encrypt:       jsii.Bool(true),
+	}
+	if cfg.State.RoleArn != "" {
+		backend.AssumeRole = &cdktf.S3BackendAssumeRoleConfig{
+			RoleArn:     jsii.String(cfg.State.RoleArn),
+			SessionName: jsii.String(cfg.LayerStackID(layer)),
+		}
+	}
+	cdktf.NewS3Backend(stack, backend)
+}
+
+// bootstrapTargets returns each distinct state backend used by the configs,
+// one per account when the accounts keep their own state
+func bootstrapTargets(configs []StackConfig) []StateBackendConfig {
+	seen := map[StateBackendConfig]bool{}
+	var targets []StateBackendConfig
+	for _, cfg := range configs {
+		state := cfg.State
+		state.Local = false
+		if cfg.State.Local || seen[state] {
+			continue
+		}
+		seen[state] = true
+		targets = append(targets, state)
+	}
+	return targets
+}
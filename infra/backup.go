@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/aws/jsii-runtime-go"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/cloudwatcheventtarget"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/kmskey"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/s3bucketlifecycleconfiguration"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/securitygroup"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/snstopic"
+	"github.com/hashicorp/terraform-cdk-go/cdktf"
+)
+
+// backupImage streams the data volume to S3; the CLI image ships tar and gzip
+const backupImage = "public.ecr.aws/aws-cli/aws-cli:2.22.35"
+
+// backupScript archives the data directory the same way the CI upgrade
+// rehearsal does, so an export restores with a plain tar -xzf
+const backupScript = `set -eu
+key="xtdb/$(date -u +%%Y-%%m-%%dT%%H%%M%%SZ).tar.gz"
+tar -czf - -C %s . | aws s3 cp --only-show-errors --expected-size 107374182400 - "s3://%s/$key"
+echo "exported $key"`
+
+// NewXTDBBackups runs a one-off Fargate task on a nightly schedule that
+// exports the XTDB data volume to a dedicated bucket. Exports expire after
+// the configured retention, and a task that exits non-zero publishes to the
+// alerts topic. Returns nil when backups are disabled.
+//
+// The volume is copied while XTDB is running, so an export is only as
+// consistent as a crash; the AWS Backup snapshots of the volume remain the
+// point-in-time copy.
+func NewXTDBBackups(stack cdktf.TerraformStack, cfg StackConfig, key kmskey.KmsKey, cluster *Cluster, storage *XTDBStorage, vpc *Vpc, sg securitygroup.SecurityGroup, alerts snstopic.SnsTopic) *Bucket {
+	if !cfg.Backup.Enabled {
+		return nil
+	}
+
+	bucket := newPrivateBucket(stack, "XTDBBackupBucket", cfg.Name("xtdb-backups"), key, false)
+	s3bucketlifecycleconfiguration.NewS3BucketLifecycleConfiguration(stack, jsii.String("XTDBBackupBucketLifecycle"), &s3bucketlifecycleconfiguration.S3BucketLifecycleConfigurationConfig{
+		Bucket: bucket.Id(),
+		Rule: &[]*s3bucketlifecycleconfiguration.S3BucketLifecycleConfigurationRule{{
+			Id:         jsii.String("expire-backups"),
+			Status:     jsii.String("Enabled"),
+			Filter:     &s3bucketlifecycleconfiguration.S3BucketLifecycleConfigurationRuleFilter{},
+			Expiration: &s3bucketlifecycleconfiguration.S3BucketLifecycleConfigurationRuleExpiration{Days: jsii.Number(cfg.Backup.RetentionDays)},
+			AbortIncompleteMultipartUpload: &s3bucketlifecycleconfiguration.S3BucketLifecycleConfigurationRuleAbortIncompleteMultipartUpload{
+				DaysAfterInitiation: jsii.Number(1),
+			},
+		}},
+	})
+
+	// The backup task pulls a public image and reads no secrets
+	roles := NewServiceRoles(stack, cfg, key, "XTDBBackup", "xtdb-backup", nil, nil)
+	roles.TaskRole.Grant("Backup",
+		allow([]string{"elasticfilesystem:ClientMount"}, storage.FileSystem.Arn()),
+		allow([]string{"s3:PutObject", "s3:AbortMultipartUpload"}, jsii.String(*bucket.Arn()+"/*")),
+		allow([]string{"kms:Encrypt", "kms:GenerateDataKey*"}, key.Arn()),
+	)
+
+	taskDef := newTaskDefinition(stack, cfg, "XTDBBackupTaskDef", "xtdb-backup", 256, 512, roles, xtdbVolume(storage))
+	taskDef.AddContainer(&Container{
+		Name:             "BackupContainer",
+		Image:            backupImage,
+		Essential:        true,
+		EntryPoint:       []string{"sh", "-c"},
+		Command:          []string{fmt.Sprintf(backupScript, xtdbDataDir, *cfg.Name("xtdb-backups"))},
+		MountPoints:      []MountPoint{{SourceVolume: "xtdb-data", ContainerPath: xtdbDataDir, ReadOnly: true}},
+		LogConfiguration: awsLogs(cfg, roles.LogGroup, "backup"),
+	})
+
+	// EventBridge starts the task as a role that may only run it
+	events := newRole(stack, "XTDBBackupEventsRole", cfg.Name("xtdb-backup-events"), "Starts the XTDB backup task", servicePrincipal("events.amazonaws.com"), nil)
+	events.Grant("RunTask",
+		statement{
+			Effect:    "Allow",
+			Action:    []string{"ecs:RunTask"},
+			Resource:  []*string{taskDef.ArnWithoutRevision()},
+			Condition: map[string]interface{}{"ArnEquals": map[string]interface{}{"ecs:cluster": cluster.Arn}},
+		},
+		allow([]string{"iam:PassRole"}, roles.TaskRole.Arn(), roles.ExecutionRole.Arn()),
+	)
+
+	// Run in the XTDB security group, which already reaches EFS and S3
+	schedule := newScheduledRule(stack, "XTDBBackupSchedule", cfg.Name("xtdb-backup"),
+		"Exports the XTDB data volume of "+cfg.Environment+" to S3", fmt.Sprintf("cron(0 %v * * ? *)", cfg.Backup.Hour))
+	schedule.AddTarget("Task", &cloudwatcheventtarget.CloudwatchEventTargetConfig{
+		Arn:     cluster.Arn,
+		RoleArn: events.Arn(),
+		EcsTarget: &cloudwatcheventtarget.CloudwatchEventTargetEcsTarget{
+			TaskDefinitionArn: taskDef.ArnWithoutRevision(),
+			LaunchType:        jsii.String("FARGATE"),
+			NetworkConfiguration: &cloudwatcheventtarget.CloudwatchEventTargetEcsTargetNetworkConfiguration{
+				Subnets:        vpc.PrivateSubnetIDs,
+				SecurityGroups: &[]*string{sg.Id()},
+			},
+		},
+		RetryPolicy: &cloudwatcheventtarget.CloudwatchEventTargetRetryPolicy{MaximumRetryAttempts: jsii.Number(2)},
+	})
+
+	failed := newEventRule(stack, "XTDBBackupFailed", cfg.Name("xtdb-backup-failed"), "Alerts when an XTDB backup task exits with an error", map[string]interface{}{
+		"source":      []string{"aws.ecs"},
+		"detail-type": []string{"ECS Task State Change"},
+		"detail": map[string]interface{}{
+			"clusterArn": []*string{cluster.Arn},
+			"group":      []string{"family:" + *cfg.Name("xtdb-backup")},
+			"lastStatus": []string{"STOPPED"},
+			"containers": map[string]interface{}{
+				"exitCode": []interface{}{map[string]interface{}{"anything-but": 0}},
+			},
+		},
+	})
+	failed.Notify("Alert", alerts.Arn(), "XTDB backup in "+cfg.Environment+" failed: <stoppedReason>", map[string]*string{
+		"stoppedReason": jsii.String("$.detail.stoppedReason"),
+	})
+	return bucket
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"github.com/aws/jsii-runtime-go"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/kmskey"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/s3bucket"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/s3bucketpolicy"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/s3bucketpublicaccessblock"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/s3bucketserversideencryptionconfiguration"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/s3bucketversioning"
+	"github.com/hashicorp/terraform-cdk-go/cdktf"
+)
+
+// Bucket is a private bucket and, when versioned, the versioning that
+// replication has to wait for
+type Bucket struct {
+	s3bucket.S3Bucket
+	Versioning s3bucketversioning.S3BucketVersioningA
+}
+
+// newPrivateBucket creates a bucket with public access blocked that only
+// accepts TLS requests, encrypted with the key or, without one, with
+// S3-managed keys. Terraform refuses to delete a bucket that still holds
+// objects, so buckets outlive a destroy unless they are emptied first. The
+// statements are added to the bucket policy, which a bucket only has one of.
+func newPrivateBucket(stack cdktf.TerraformStack, id string, name *string, key kmskey.KmsKey, versioned bool, statements ...statement) *Bucket {
+	bucket := s3bucket.NewS3Bucket(stack, jsii.String(id), &s3bucket.S3BucketConfig{
+		Bucket: name,
+	})
+	s3bucketpublicaccessblock.NewS3BucketPublicAccessBlock(stack, jsii.String(id+"PublicAccess"), &s3bucketpublicaccessblock.S3BucketPublicAccessBlockConfig{
+		Bucket:                bucket.Id(),
+		BlockPublicAcls:       jsii.Bool(true),
+		BlockPublicPolicy:     jsii.Bool(true),
+		IgnorePublicAcls:      jsii.Bool(true),
+		RestrictPublicBuckets: jsii.Bool(true),
+	})
+
+	encryption := &s3bucketserversideencryptionconfiguration.S3BucketServerSideEncryptionConfigurationRuleApplyServerSideEncryptionByDefaultA{
+		SseAlgorithm: jsii.String("AES256"),
+	}
+	if key != nil {
+		encryption = &s3bucketserversideencryptionconfiguration.S3BucketServerSideEncryptionConfigurationRuleApplyServerSideEncryptionByDefaultA{
+			SseAlgorithm:   jsii.String("aws:kms"),
+			KmsMasterKeyId: key.Arn(),
+		}
+	}
+	s3bucketserversideencryptionconfiguration.NewS3BucketServerSideEncryptionConfigurationA(stack, jsii.String(id+"Encryption"), &s3bucketserversideencryptionconfiguration.S3BucketServerSideEncryptionConfigurationAConfig{
+		Bucket: bucket.Id(),
+		Rule: &[]*s3bucketserversideencryptionconfiguration.S3BucketServerSideEncryptionConfigurationRuleA{{
+			ApplyServerSideEncryptionByDefault: encryption,
+			BucketKeyEnabled:                   jsii.Bool(key != nil),
+		}},
+	})
+
+	b := &Bucket{S3Bucket: bucket}
+	if versioned {
+		b.Versioning = s3bucketversioning.NewS3BucketVersioningA(stack, jsii.String(id+"Versioning"), &s3bucketversioning.S3BucketVersioningAConfig{
+			Bucket:                  bucket.Id(),
+			VersioningConfiguration: &s3bucketversioning.S3BucketVersioningVersioningConfiguration{Status: jsii.String("Enabled")},
+		})
+	}
+
+	objects := jsii.String(*bucket.Arn() + "/*")
+	statements = append([]statement{{
+		Sid:       "EnforceSSL",
+		Effect:    "Deny",
+		Principal: map[string]interface{}{"AWS": "*"},
+		Action:    []string{"s3:*"},
+		Resource:  []*string{bucket.Arn(), objects},
+		Condition: map[string]interface{}{"Bool": map[string]interface{}{"aws:SecureTransport": "false"}},
+	}}, statements...)
+	s3bucketpolicy.NewS3BucketPolicy(stack, jsii.String(id+"Policy"), &s3bucketpolicy.S3BucketPolicyConfig{
+		Bucket: bucket.Id(),
+		Policy: policyDocument(statements...),
+	})
+	return b
+}
+
+// grantBucketReadWrite lets the role read, write and delete the bucket's
+// objects, and use the key they are encrypted with
+func grantBucketReadWrite(role *Role, name string, bucket *Bucket, key kmskey.KmsKey) {
+	objects := jsii.String(*bucket.Arn() + "/*")
+	statements := []statement{
+		allow([]string{"s3:GetObject*", "s3:PutObject*", "s3:DeleteObject*", "s3:AbortMultipartUpload"}, objects),
+		allow([]string{"s3:ListBucket*", "s3:GetBucket*"}, bucket.Arn()),
+	}
+	if key != nil {
+		statements = append(statements, allow([]string{"kms:Decrypt", "kms:Encrypt", "kms:ReEncrypt*", "kms:GenerateDataKey*"}, key.Arn()))
+	}
+	role.Grant(name, statements...)
+}
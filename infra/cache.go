@@ -0,0 +1,69 @@
+package main
+
+import (
+	"github.com/aws/jsii-runtime-go"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/elasticachereplicationgroup"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/elasticachesubnetgroup"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/kmskey"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/secretsmanagersecret"
+	"github.com/hashicorp/terraform-cdk-go/cdktf"
+)
+
+// AppCache is the Redis replication group the app keeps sessions and cached data in
+type AppCache struct {
+	Group     elasticachereplicationgroup.ElasticacheReplicationGroup
+	AuthToken secretsmanagersecret.SecretsmanagerSecret
+	key       kmskey.KmsKey
+}
+
+// NewAppCache creates a Redis replication group in the private subnets when
+// the cache is enabled, reachable from the app only, with TLS and an auth
+// token generated into Secrets Manager. It returns nil otherwise.
+func NewAppCache(stack cdktf.TerraformStack, cfg StackConfig, key kmskey.KmsKey, vpc *Vpc, sg *SecurityGroups) *AppCache {
+	if !cfg.Cache.Enabled {
+		return nil
+	}
+
+	cacheSg := newSecurityGroup(stack, cfg, vpc, "CacheSecurityGroup", "cache", "Redis, reachable from the app only")
+	allowTraffic(stack, "AppToCache", sg.App, cacheSg, tcp(6379), "Redis")
+
+	subnets := elasticachesubnetgroup.NewElasticacheSubnetGroup(stack, jsii.String("CacheSubnetGroup"), &elasticachesubnetgroup.ElasticacheSubnetGroupConfig{
+		Name:        cfg.Name("cache"),
+		Description: jsii.String("Private subnets of " + cfg.NamePrefix),
+		SubnetIds:   vpc.PrivateSubnetIDs,
+	})
+
+	// Redis auth tokens only allow a limited set of printable characters
+	password := randomPassword(stack, "CacheAuthTokenValue")
+	token := newGeneratedSecret(stack, key, "CacheAuthToken", cfg.Name("cache-auth-token"), "Redis auth token for "+cfg.Environment, password)
+
+	replicated := cfg.Cache.Replicas > 0
+	group := elasticachereplicationgroup.NewElasticacheReplicationGroup(stack, jsii.String("CacheReplicationGroup"), &elasticachereplicationgroup.ElasticacheReplicationGroupConfig{
+		ReplicationGroupId:       cfg.Name("cache"),
+		Description:              jsii.String("App sessions and cache for " + cfg.Environment),
+		Engine:                   jsii.String("redis"),
+		EngineVersion:            jsii.String(cfg.Cache.EngineVersion),
+		NodeType:                 jsii.String(cfg.Cache.NodeType),
+		NumCacheClusters:         jsii.Number(cfg.Cache.Replicas + 1),
+		AutomaticFailoverEnabled: jsii.Bool(replicated),
+		MultiAzEnabled:           jsii.Bool(replicated),
+		SubnetGroupName:          subnets.Name(),
+		SecurityGroupIds:         &[]*string{cacheSg.Id()},
+		AtRestEncryptionEnabled:  jsii.String("true"),
+		KmsKeyId:                 key.Arn(),
+		TransitEncryptionEnabled: jsii.Bool(true),
+		AuthToken:                password,
+		SnapshotRetentionLimit:   jsii.Number(cfg.Cache.SnapshotRetentionDays),
+	})
+
+	return &AppCache{Group: group, AuthToken: token, key: key}
+}
+
+// Connect passes the connection settings to the app container
+func (c *AppCache) Connect(app *Container, roles *ServiceRoles) {
+	app.AddEnvironment("REDIS_HOST", *c.Group.PrimaryEndpointAddress())
+	app.AddEnvironment("REDIS_PORT", "6379")
+	app.AddEnvironment("REDIS_TLS", "true")
+	app.AddSecret("REDIS_AUTH_TOKEN", *c.AuthToken.Arn())
+	grantSecretRead(roles.ExecutionRole, "CacheAuthToken", c.AuthToken.Arn(), c.key)
+}
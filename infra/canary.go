@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/jsii-runtime-go"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/cloudwatchmetricalarm"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/kmskey"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/lb"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/s3bucketlifecycleconfiguration"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/snstopic"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/syntheticscanary"
+	"github.com/hashicorp/terraform-cdk-go/cdktf"
+)
+
+// canaryScript requests the health check path and the items list, which
+// queries XTDB, and fails on any non-2xx response
+const canaryScript = `const synthetics = require('Synthetics');
+
+const get = (path) => async () => {
+  const url = new URL(path, process.env.APP_URL);
+  await synthetics.executeHttpStep(path, {
+    hostname: url.hostname,
+    port: url.port || (url.protocol === 'https:' ? 443 : 80),
+    protocol: url.protocol,
+    path: url.pathname,
+    method: 'GET',
+  });
+};
+
+exports.handler = async () => {
+  await get(process.env.HEALTH_PATH)();
+  await get('/items')();
+};
+`
+
+// NewUptimeCanary probes the app from outside the VPC every minute through
+// its public endpoint, the domain when set or else the ALB, and alarms to
+// the alerts topic when runs fail. In environments that hibernate it only
+// runs during business hours.
+func NewUptimeCanary(stack cdktf.TerraformStack, cfg StackConfig, key kmskey.KmsKey, alb lb.Lb, alerts snstopic.SnsTopic) syntheticscanary.SyntheticsCanary {
+	appURL := "http://" + *alb.DnsName()
+	if cfg.Domain.DomainName != "" {
+		appURL = "https://" + cfg.Domain.DomainName
+	}
+	name := cfg.Environment + "-uptime" // At most 21 characters
+
+	artifacts := newPrivateBucket(stack, "UptimeCanaryArtifacts", cfg.Name("canary-artifacts"), key, false)
+	s3bucketlifecycleconfiguration.NewS3BucketLifecycleConfiguration(stack, jsii.String("UptimeCanaryArtifactsLifecycle"), &s3bucketlifecycleconfiguration.S3BucketLifecycleConfigurationConfig{
+		Bucket: artifacts.Id(),
+		Rule: &[]*s3bucketlifecycleconfiguration.S3BucketLifecycleConfigurationRule{{
+			Id:         jsii.String("expire-runs"),
+			Status:     jsii.String("Enabled"),
+			Filter:     &s3bucketlifecycleconfiguration.S3BucketLifecycleConfigurationRuleFilter{},
+			Expiration: &s3bucketlifecycleconfiguration.S3BucketLifecycleConfigurationRuleExpiration{Days: jsii.Number(cfg.Observability.LogRetentionDays)},
+		}},
+	})
+
+	// What the CDK granted its canaries: store the run artifacts, publish
+	// the run metrics and write the logs of the canary's function
+	role := newRole(stack, "UptimeCanaryRole", cfg.Name("uptime-canary"), "Runs the uptime canary", servicePrincipal("lambda.amazonaws.com"), nil)
+	role.Grant("Canary",
+		allow([]string{"s3:ListAllMyBuckets"}, jsii.String("*")),
+		allow([]string{"s3:GetBucketLocation"}, artifacts.Arn()),
+		allow([]string{"s3:PutObject"}, jsii.String(*artifacts.Arn()+"/*")),
+		allow([]string{"kms:GenerateDataKey"}, key.Arn()),
+		statement{
+			Effect:    "Allow",
+			Action:    []string{"cloudwatch:PutMetricData"},
+			Resource:  []*string{jsii.String("*")},
+			Condition: map[string]interface{}{"StringEquals": map[string]interface{}{"cloudwatch:namespace": "CloudWatchSynthetics"}},
+		},
+		allow([]string{"logs:CreateLogGroup", "logs:CreateLogStream", "logs:PutLogEvents"},
+			jsii.String("arn:aws:logs:"+cfg.Region+":"+*stackAccount(stack)+":log-group:/aws/lambda/cwsyn-"+name+"-*")),
+	)
+
+	// Node.js canaries load their handler from nodejs/node_modules
+	code := inlineArchive(stack, "UptimeCanaryCode", map[string]string{"nodejs/node_modules/index.js": canaryScript})
+	canary := syntheticscanary.NewSyntheticsCanary(stack, jsii.String("UptimeCanary"), &syntheticscanary.SyntheticsCanaryConfig{
+		Name:               jsii.String(name),
+		RuntimeVersion:     jsii.String("syn-nodejs-puppeteer-9.1"),
+		Handler:            jsii.String("index.handler"),
+		ZipFile:            code.GetStringAttribute(jsii.String("output_path")),
+		ExecutionRoleArn:   role.Arn(),
+		ArtifactS3Location: jsii.String("s3://" + *artifacts.Bucket() + "/"),
+		ArtifactConfig: &syntheticscanary.SyntheticsCanaryArtifactConfig{
+			S3Encryption: &syntheticscanary.SyntheticsCanaryArtifactConfigS3Encryption{
+				EncryptionMode: jsii.String("SSE_KMS"),
+				KmsKeyArn:      key.Arn(),
+			},
+		},
+		Schedule: &syntheticscanary.SyntheticsCanarySchedule{Expression: jsii.String(canarySchedule(cfg))},
+		RunConfig: &syntheticscanary.SyntheticsCanaryRunConfig{
+			TimeoutInSeconds: jsii.Number(30),
+			EnvironmentVariables: &map[string]*string{
+				"APP_URL":     jsii.String(appURL),
+				"HEALTH_PATH": jsii.String(cfg.Domain.HealthCheckPath),
+			},
+		},
+		StartCanary: jsii.Bool(true),
+	})
+
+	// No runs while hibernating is not an outage
+	actions := &[]*string{alerts.Arn()}
+	cloudwatchmetricalarm.NewCloudwatchMetricAlarm(stack, jsii.String("UptimeCanaryAlarm"), &cloudwatchmetricalarm.CloudwatchMetricAlarmConfig{
+		AlarmName:          cfg.Name("uptime"),
+		AlarmDescription:   jsii.String("The app is not reachable from outside the VPC"),
+		Namespace:          jsii.String("CloudWatchSynthetics"),
+		MetricName:         jsii.String("SuccessPercent"),
+		Dimensions:         &map[string]*string{"CanaryName": canary.Name()},
+		Statistic:          jsii.String("Average"),
+		Period:             jsii.Number(alarmPeriod),
+		Threshold:          jsii.Number(100),
+		ComparisonOperator: jsii.String("LessThanThreshold"),
+		EvaluationPeriods:  jsii.Number(5),
+		DatapointsToAlarm:  jsii.Number(3),
+		TreatMissingData:   jsii.String("notBreaching"),
+		AlarmActions:       actions,
+		OkActions:          actions,
+	})
+	return canary
+}
+
+// canarySchedule runs the canary every minute, or in hibernating
+// environments every minute of the weekday business hours. Canary schedules
+// are in UTC, so the hours are narrowed to those inside business hours both
+// in winter and in summer time. If they do not fit in one UTC day the canary
+// runs around the clock.
+func canarySchedule(cfg StackConfig) string {
+	every := "rate(1 minute)"
+	if !cfg.Scaling.OffHoursScaleDown {
+		return every
+	}
+	location, err := time.LoadLocation(cfg.Scaling.TimeZone)
+	if err != nil {
+		return every
+	}
+	start, end := -24, 48
+	for _, month := range []time.Month{time.January, time.July} {
+		_, offset := time.Date(2025, month, 1, 12, 0, 0, 0, location).Zone()
+		start = max(start, int(cfg.Scaling.BusinessHoursStart)-offset/3600)
+		end = min(end, int(cfg.Scaling.BusinessHoursEnd)-offset/3600)
+	}
+	if start < 0 || end > 24 || start >= end {
+		return every
+	}
+	return fmt.Sprintf("cron(0/1 %d-%d ? * MON-FRI *)", start, end-1)
+}
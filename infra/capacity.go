@@ -0,0 +1,38 @@
+package main
+
+import (
+	"github.com/aws/jsii-runtime-go"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/ecsservice"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/ecstaskdefinition"
+)
+
+// capacityProviderStrategies splits a service's tasks between FARGATE_SPOT
+// and FARGATE by its SpotPercent. Services with no Spot share keep the
+// default FARGATE launch type.
+func capacityProviderStrategies(sizing ServiceSizing) *[]*ecsservice.EcsServiceCapacityProviderStrategy {
+	if sizing.SpotPercent <= 0 {
+		return nil
+	}
+
+	strategies := []*ecsservice.EcsServiceCapacityProviderStrategy{
+		{
+			CapacityProvider: jsii.String("FARGATE_SPOT"),
+			Weight:           jsii.Number(sizing.SpotPercent),
+		},
+	}
+	if onDemand := 100 - sizing.SpotPercent; onDemand > 0 {
+		strategies = append(strategies, &ecsservice.EcsServiceCapacityProviderStrategy{
+			CapacityProvider: jsii.String("FARGATE"),
+			Weight:           jsii.Number(onDemand),
+		})
+	}
+	return &strategies
+}
+
+// runtimePlatform runs the task definitions on the configured CPU architecture
+func runtimePlatform(cfg StackConfig) *ecstaskdefinition.EcsTaskDefinitionRuntimePlatform {
+	return &ecstaskdefinition.EcsTaskDefinitionRuntimePlatform{
+		OperatingSystemFamily: jsii.String("LINUX"),
+		CpuArchitecture:       jsii.String(cfg.CpuArchitecture),
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"github.com/aws/jsii-runtime-go"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/cloudfrontdistribution"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/cloudfrontoriginaccesscontrol"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/dataawscloudfrontcachepolicy"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/dataawscloudfrontoriginrequestpolicy"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/dataawsroute53zone"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/kmskey"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/lb"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/route53record"
+	"github.com/hashicorp/terraform-cdk-go/cdktf"
+)
+
+// cdnAssetsPath is where fingerprinted static assets are served from the
+// assets bucket; everything else goes to the app
+const cdnAssetsPath = "/assets/*"
+
+// AppCdn is the CloudFront distribution in front of the app and the bucket
+// its static assets are uploaded to
+type AppCdn struct {
+	Distribution cloudfrontdistribution.CloudfrontDistribution
+	Assets       *Bucket
+}
+
+// NewAppCdn puts a CloudFront distribution in front of the ALB. Requests
+// under cdnAssetsPath are served from a private assets bucket and cached
+// for as long as CloudFront allows, which is safe because asset names carry
+// their content hash; everything else is passed to the app uncached. With a
+// CDN domain name the distribution terminates TLS for it and Route53
+// aliases it. It returns nil when the CDN is disabled.
+func NewAppCdn(stack cdktf.TerraformStack, cfg StackConfig, key kmskey.KmsKey, alb lb.Lb) *AppCdn {
+	if !cfg.Cdn.Enabled {
+		return nil
+	}
+
+	// CloudFront reads the assets through origin access control. Scoped to
+	// any distribution of the account, like the key policy, as the
+	// distribution already depends on the bucket.
+	assets := newPrivateBucket(stack, "AppAssetsBucket", cfg.Name("assets"), key, false, statement{
+		Sid:       "CloudFrontAssets",
+		Effect:    "Allow",
+		Principal: servicePrincipal("cloudfront.amazonaws.com"),
+		Action:    []string{"s3:GetObject"},
+		Resource:  []*string{jsii.String("arn:aws:s3:::" + *cfg.Name("assets") + "/*")},
+		Condition: map[string]interface{}{
+			"ArnLike": map[string]interface{}{"aws:SourceArn": "arn:aws:cloudfront::" + *stackAccount(stack) + ":distribution/*"},
+		},
+	})
+	access := cloudfrontoriginaccesscontrol.NewCloudfrontOriginAccessControl(stack, jsii.String("AppAssetsAccess"), &cloudfrontoriginaccesscontrol.CloudfrontOriginAccessControlConfig{
+		Name:                          cfg.Name("assets"),
+		OriginAccessControlOriginType: jsii.String("s3"),
+		SigningBehavior:               jsii.String("always"),
+		SigningProtocol:               jsii.String("sigv4"),
+	})
+
+	// With a domain the ALB only has a certificate for that name
+	appOrigin := &cloudfrontdistribution.CloudfrontDistributionOrigin{
+		OriginId:   jsii.String("app"),
+		DomainName: alb.DnsName(),
+		CustomOriginConfig: &cloudfrontdistribution.CloudfrontDistributionOriginCustomOriginConfig{
+			HttpPort:             jsii.Number(80),
+			HttpsPort:            jsii.Number(443),
+			OriginProtocolPolicy: jsii.String("http-only"),
+			OriginSslProtocols:   jsii.Strings("TLSv1.2"),
+		},
+	}
+	if cfg.Domain.DomainName != "" {
+		appOrigin.DomainName = jsii.String(cfg.Domain.DomainName)
+		appOrigin.CustomOriginConfig.OriginProtocolPolicy = jsii.String("https-only")
+	}
+
+	managedCachePolicy := func(id string, name string) *string {
+		return dataawscloudfrontcachepolicy.NewDataAwsCloudfrontCachePolicy(stack, jsii.String(id), &dataawscloudfrontcachepolicy.DataAwsCloudfrontCachePolicyConfig{
+			Name: jsii.String(name),
+		}).Id()
+	}
+	allViewer := dataawscloudfrontoriginrequestpolicy.NewDataAwsCloudfrontOriginRequestPolicy(stack, jsii.String("AllViewerExceptHostHeader"), &dataawscloudfrontoriginrequestpolicy.DataAwsCloudfrontOriginRequestPolicyConfig{
+		Name: jsii.String("Managed-AllViewerExceptHostHeader"),
+	})
+
+	config := &cloudfrontdistribution.CloudfrontDistributionConfig{
+		Enabled: jsii.Bool(true),
+		Comment: jsii.String("App and static assets of " + cfg.Environment),
+		Origin: &[]*cloudfrontdistribution.CloudfrontDistributionOrigin{
+			appOrigin,
+			{
+				OriginId:              jsii.String("assets"),
+				DomainName:            assets.BucketRegionalDomainName(),
+				OriginAccessControlId: access.Id(),
+			},
+		},
+		DefaultCacheBehavior: &cloudfrontdistribution.CloudfrontDistributionDefaultCacheBehavior{
+			TargetOriginId:        jsii.String("app"),
+			ViewerProtocolPolicy:  jsii.String("redirect-to-https"),
+			AllowedMethods:        jsii.Strings("GET", "HEAD", "OPTIONS", "PUT", "PATCH", "POST", "DELETE"),
+			CachedMethods:         jsii.Strings("GET", "HEAD"),
+			CachePolicyId:         managedCachePolicy("CachingDisabled", "Managed-CachingDisabled"),
+			OriginRequestPolicyId: allViewer.Id(),
+		},
+		OrderedCacheBehavior: &[]*cloudfrontdistribution.CloudfrontDistributionOrderedCacheBehavior{{
+			PathPattern:          jsii.String(cdnAssetsPath),
+			TargetOriginId:       jsii.String("assets"),
+			ViewerProtocolPolicy: jsii.String("redirect-to-https"),
+			AllowedMethods:       jsii.Strings("GET", "HEAD"),
+			CachedMethods:        jsii.Strings("GET", "HEAD"),
+			CachePolicyId:        managedCachePolicy("CachingOptimized", "Managed-CachingOptimized"),
+			Compress:             jsii.Bool(true),
+		}},
+		PriceClass:    jsii.String("PriceClass_100"),
+		IsIpv6Enabled: jsii.Bool(true),
+		Restrictions: &cloudfrontdistribution.CloudfrontDistributionRestrictions{
+			GeoRestriction: &cloudfrontdistribution.CloudfrontDistributionRestrictionsGeoRestriction{RestrictionType: jsii.String("none")},
+		},
+		ViewerCertificate: &cloudfrontdistribution.CloudfrontDistributionViewerCertificate{CloudfrontDefaultCertificate: jsii.Bool(true)},
+	}
+
+	var zone dataawsroute53zone.DataAwsRoute53Zone
+	if domain := cfg.Cdn.DomainName; domain != "" {
+		zone = hostedZone(stack, cfg, "CdnHostedZone", domain)
+
+		// CloudFront only uses certificates from us-east-1
+		cert := jsii.String(cfg.Cdn.CertificateArn)
+		if cfg.Cdn.CertificateArn == "" {
+			usEast1 := newAwsProvider(stack, "us-east-1", "us-east-1", cfg.Account.RoleArn, cfg.Account.ID, defaultTags(cfg.Tags, cfg.Environment))
+			cert = newCertificate(stack, "CdnCertificate", domain, zone, usEast1)
+		}
+		config.Aliases = jsii.Strings(domain)
+		config.ViewerCertificate = &cloudfrontdistribution.CloudfrontDistributionViewerCertificate{
+			AcmCertificateArn:      cert,
+			SslSupportMethod:       jsii.String("sni-only"),
+			MinimumProtocolVersion: jsii.String("TLSv1.2_2021"),
+		}
+	}
+
+	distribution := cloudfrontdistribution.NewCloudfrontDistribution(stack, jsii.String("AppDistribution"), config)
+	if zone != nil {
+		for _, recordType := range []string{"A", "AAAA"} {
+			route53record.NewRoute53Record(stack, jsii.String("CdnAliasRecord"+recordType), &route53record.Route53RecordConfig{
+				ZoneId: zone.ZoneId(),
+				Name:   jsii.String(cfg.Cdn.DomainName),
+				Type:   jsii.String(recordType),
+				Alias: &route53record.Route53RecordAlias{
+					Name:                 distribution.DomainName(),
+					ZoneId:               distribution.HostedZoneId(),
+					EvaluateTargetHealth: jsii.Bool(false),
+				},
+			})
+		}
+	}
+
+	return &AppCdn{Distribution: distribution, Assets: assets}
+}
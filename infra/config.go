@@ -0,0 +1,1155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/aws/jsii-runtime-go"
+	"github.com/hashicorp/terraform-cdk-go/cdktf"
+)
+
+// resourcePrefix prefixes the names of everything the stacks create
+const resourcePrefix = "clj-xtdb-devops"
+
+// settingName restricts app setting names to environment variable names
+var settingName = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+
+// accountID matches a 12-digit AWS account id
+var accountID = regexp.MustCompile(`^[0-9]{12}$`)
+
+// queueName restricts queue names to what both SQS and env var names accept
+var queueName = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+// environments are the stacks synthesized by main, in promotion order
+var environments = []string{"dev", "staging", "prod"}
+
+// CPU architectures of the Fargate tasks. ARM64 runs on Graviton and needs
+// images with an arm64 variant (see the CI module's check-image-platforms).
+const (
+	ArchX86_64 = "X86_64"
+	ArchArm64  = "ARM64"
+)
+
+// ServiceSizing controls the Fargate task size and count of one service.
+// SpotPercent is the share of tasks placed on FARGATE_SPOT; the rest run
+// on regular FARGATE.
+type ServiceSizing struct {
+	Cpu          float64 `json:"cpu"`
+	MemoryMiB    float64 `json:"memoryMiB"`
+	DesiredCount float64 `json:"desiredCount"`
+	SpotPercent  float64 `json:"spotPercent"`
+}
+
+// StateBackendConfig locates the S3 bucket and DynamoDB lock table holding
+// Terraform state. Local disables the remote backend, e.g. for first runs.
+// RoleArn is assumed to reach the bucket and defaults to the account's role,
+// so each account keeps its own state. DeployTable records what DeployToECS
+// last deployed to each environment of the account, and AuditLogGroup every
+// deployment and approval made through the CI module.
+type StateBackendConfig struct {
+	Bucket        string `json:"bucket"`
+	LockTable     string `json:"lockTable"`
+	DeployTable   string `json:"deployTable"`
+	AuditLogGroup string `json:"auditLogGroup"`
+	Region        string `json:"region"`
+	RoleArn       string `json:"roleArn"`
+	Local         bool   `json:"local"`
+}
+
+// AccountConfig pins the environment to an AWS account. Terraform refuses to
+// apply when the credentials belong to any other account. With a RoleArn it
+// assumes that role first, so one set of CI credentials can deploy to every
+// account.
+type AccountConfig struct {
+	ID      string `json:"id"`
+	RoleArn string `json:"roleArn"`
+}
+
+// ExistingConfig adopts resources the landing zone already manages instead
+// of creating them. The public subnets of an existing VPC are the ones that
+// assign public IPs on launch and every other subnet is private; the network
+// sizing settings are then ignored. An existing cluster needs the FARGATE
+// and FARGATE_SPOT capacity providers and keeps its own Container Insights
+// and ECS Exec settings.
+type ExistingConfig struct {
+	VpcID          string `json:"vpcId"`
+	ClusterName    string `json:"clusterName"`
+	HostedZoneID   string `json:"hostedZoneId"`
+	AppRepository  string `json:"appRepository"`
+	XTDBRepository string `json:"xtdbRepository"`
+}
+
+// MeshConfig puts ECS Service Connect proxies in front of both services, so
+// the app reaches XTDB through them and the traffic between the tasks is
+// encrypted with TLS. Without a PrivateCaArn, a short-lived AWS Private CA is
+// created for the environment.
+type MeshConfig struct {
+	Enabled      bool   `json:"enabled"`
+	PrivateCaArn string `json:"privateCaArn"`
+}
+
+// EksConfig runs the services on EKS instead of ECS, either on a small
+// managed node group or on an existing cluster named ClusterName. The eks
+// stack creates the cluster side and outputs the values the services are
+// installed with through Helm. The database, cache, queues, mesh and
+// failover are only available on ECS.
+type EksConfig struct {
+	Enabled     bool   `json:"enabled"`
+	ClusterName string `json:"clusterName"`
+	Version     string `json:"version"`
+	// InstanceType defaults to a medium burstable instance of the CPU architecture
+	InstanceType  string   `json:"instanceType"`
+	NodeCount     float64  `json:"nodeCount"`
+	AdminRoleArns []string `json:"adminRoleArns"`
+}
+
+// AppRunnerConfig runs the app on App Runner instead of behind an ALB on
+// Fargate, for small internal tools where a load balancer costs more than
+// the app. XTDB still runs on Fargate. App Runner serves the app on its own
+// HTTPS domain, so the domain, CDN, WAF and admin tools are not available.
+type AppRunnerConfig struct {
+	Enabled bool `json:"enabled"`
+}
+
+// GcpConfig is where an environment with cloud gcp runs. CI pushes the app
+// and xtdb images to the stack's Artifact Registry repository with ImageTag.
+// Region, account and the other AWS settings are ignored, except the state
+// backend, which stays in S3.
+type GcpConfig struct {
+	Project  string `json:"project"`
+	Region   string `json:"region"`
+	ImageTag string `json:"imageTag"`
+}
+
+// AzureConfig is where an environment with cloud azure runs. CI pushes the
+// app and xtdb images to the stack's container registry with ImageTag. The
+// services get 2Gi of memory per core, the only ratio Container Apps offers
+// on the consumption profile, so only their cpu is used.
+type AzureConfig struct {
+	SubscriptionID string `json:"subscriptionId"`
+	Location       string `json:"location"`
+	ImageTag       string `json:"imageTag"`
+}
+
+// appRunnerMemory lists the memory sizes App Runner allows for each CPU size
+var appRunnerMemory = map[float64][]float64{
+	256:  {512, 1024},
+	512:  {1024},
+	1024: {2048, 3072, 4096},
+	2048: {4096},
+	4096: {10240, 12288},
+}
+
+// DomainConfig controls how the app is exposed through the load balancer.
+// Without a DomainName the ALB only serves plain HTTP on its own DNS name.
+type DomainConfig struct {
+	DomainName      string `json:"domainName"`
+	HostedZoneName  string `json:"hostedZoneName"`
+	HealthCheckPath string `json:"healthCheckPath"`
+}
+
+// SecretsConfig controls the generated database credentials.
+// RotationDays of 0 disables automatic rotation.
+type SecretsConfig struct {
+	DatabaseUsername string  `json:"databaseUsername"`
+	RotationDays     float64 `json:"rotationDays"`
+}
+
+// NAT strategies for the private subnets' outbound traffic
+const (
+	NatNone   = "none"   // no NAT gateways, private subnets are isolated
+	NatSingle = "single" // one shared NAT gateway, cheapest
+	NatPerAz  = "per-az" // one NAT gateway per availability zone, highly available
+)
+
+// NetworkConfig controls the layout of the environment's VPC
+type NetworkConfig struct {
+	Cidr              string  `json:"cidr"`
+	MaxAzs            float64 `json:"maxAzs"`
+	PublicSubnetMask  float64 `json:"publicSubnetMask"`
+	PrivateSubnetMask float64 `json:"privateSubnetMask"`
+	NatStrategy       string  `json:"natStrategy"`
+	VpcEndpoints      bool    `json:"vpcEndpoints"`
+}
+
+// ScalingConfig controls autoscaling of the app service. Target tracking
+// keeps CPU and per-target ALB request count under their targets; with
+// OffHoursScaleDown the app and XTDB services hibernate at zero tasks outside
+// business hours (weekdays from BusinessHoursStart to BusinessHoursEnd in TimeZone).
+type ScalingConfig struct {
+	MinCapacity        float64 `json:"minCapacity"`
+	MaxCapacity        float64 `json:"maxCapacity"`
+	TargetCpuPercent   float64 `json:"targetCpuPercent"`
+	RequestsPerTarget  float64 `json:"requestsPerTarget"`
+	OffHoursScaleDown  bool    `json:"offHoursScaleDown"`
+	BusinessHoursStart float64 `json:"businessHoursStart"`
+	BusinessHoursEnd   float64 `json:"businessHoursEnd"`
+	TimeZone           string  `json:"timeZone"`
+}
+
+// AlertingConfig controls where service alarms are sent and when they fire.
+// Slack notifications go through AWS Chatbot, which needs the workspace
+// authorized in the Chatbot console first. Deployments of the services are
+// posted to DeploySlackChannelID in the same workspace.
+type AlertingConfig struct {
+	Emails               []string `json:"emails"`
+	SlackWorkspaceID     string   `json:"slackWorkspaceId"`
+	SlackChannelID       string   `json:"slackChannelId"`
+	DeploySlackChannelID string   `json:"deploySlackChannelId"`
+	CpuPercent           float64  `json:"cpuPercent"`
+	MemoryPercent        float64  `json:"memoryPercent"`
+	Error5xxPercent      float64  `json:"error5xxPercent"`
+	ResponseTimeSeconds  float64  `json:"responseTimeSeconds"`
+}
+
+// DatadogConfig adds Datadog monitors and a dashboard of the environment
+// next to the CloudWatch alarms, for orgs where Datadog is the standard.
+// Site is the Datadog site, e.g. datadoghq.eu; Notify are the @-handles
+// the monitors mention. XTDBLagThreshold is in transactions and needs the
+// XTDB metrics.
+type DatadogConfig struct {
+	Enabled          bool     `json:"enabled"`
+	Site             string   `json:"site"`
+	Notify           []string `json:"notify"`
+	XTDBLagThreshold float64  `json:"xtdbLagThreshold"`
+}
+
+// PagerDutyConfig routes the alert topic to a PagerDuty service, so alarms
+// page on-call. The service escalates through EscalationPolicyID, or through
+// a new policy that pages the ScheduleID schedule.
+type PagerDutyConfig struct {
+	Enabled            bool   `json:"enabled"`
+	EscalationPolicyID string `json:"escalationPolicyId"`
+	ScheduleID         string `json:"scheduleId"`
+}
+
+// Container Insights modes of the ECS cluster. The task-count alarms rely on
+// its metrics, so it cannot be turned off.
+const (
+	InsightsEnabled  = "enabled"
+	InsightsEnhanced = "enhanced" // adds per-task and per-container metrics, at extra cost
+)
+
+// ObservabilityConfig controls what the stack records and for how long.
+// LogRetentionDays must be a retention CloudWatch Logs supports, e.g. 7 or 30.
+// XTDBMetrics runs a CloudWatch agent next to XTDB that publishes its
+// Prometheus metrics to the XTDB namespace. Tracing adds an ADOT collector
+// to every task that forwards OTLP traces to X-Ray, and OTLP metrics to the
+// Amazon Managed Prometheus workspace AmpWorkspaceArn when set.
+type ObservabilityConfig struct {
+	LogRetentionDays  float64 `json:"logRetentionDays"`
+	ContainerInsights string  `json:"containerInsights"`
+	XTDBMetrics       bool    `json:"xtdbMetrics"`
+	Tracing           bool    `json:"tracing"`
+	AmpWorkspaceArn   string  `json:"ampWorkspaceArn"`
+}
+
+// Log destinations of the application containers
+const (
+	LogsCloudWatch = "cloudwatch" // awslogs, no sidecar
+	LogsDatadog    = "datadog"
+	LogsElastic    = "elastic"
+	LogsS3         = "s3"
+)
+
+// LoggingConfig routes the XTDB and app logs. Anything but cloudwatch ships
+// them through a FireLens sidecar. SecretArn is a Secrets Manager secret
+// holding the Datadog API key or the Elastic password; Host is the Datadog
+// intake or Elastic endpoint; Bucket receives the logs for s3.
+type LoggingConfig struct {
+	Destination string `json:"destination"`
+	SecretArn   string `json:"secretArn"`
+	Host        string `json:"host"`
+	Index       string `json:"index"`
+	Username    string `json:"username"`
+	Bucket      string `json:"bucket"`
+}
+
+// StorageConfig controls XTDB's storage. ObjectStore is efs or s3; old
+// object versions in the s3 bucket expire after NoncurrentVersionDays.
+// InfrequentAccessDays of 0 keeps everything in standard EFS storage and
+// BackupRetentionDays of 0 disables the AWS Backup plan. XTDBUid/XTDBGid
+// own the data directory on the volume.
+type StorageConfig struct {
+	ObjectStore           string  `json:"objectStore"`
+	NoncurrentVersionDays float64 `json:"noncurrentVersionDays"`
+	ThroughputMode        string  `json:"throughputMode"`
+	ProvisionedMiBps      float64 `json:"provisionedMiBps"`
+	InfrequentAccessDays  float64 `json:"infrequentAccessDays"`
+	BackupRetentionDays   float64 `json:"backupRetentionDays"`
+	XTDBUid               float64 `json:"xtdbUid"`
+	XTDBGid               float64 `json:"xtdbGid"`
+}
+
+// Transaction log backends of XTDB
+const (
+	TxLogLocal = "local" // on the EFS volume
+	TxLogMsk   = "msk"   // an MSK Serverless cluster created by the stack
+	TxLogKafka = "kafka" // an existing Kafka cluster at BootstrapServers
+)
+
+// TxLogConfig selects where XTDB writes its transaction log
+type TxLogConfig struct {
+	Backend          string `json:"backend"`
+	BootstrapServers string `json:"bootstrapServers"`
+	Topic            string `json:"topic"`
+}
+
+// DatabaseConfig adds an Aurora PostgreSQL Serverless v2 cluster as XTDB's
+// backing store instead of its embedded setup. Capacities are in ACUs.
+type DatabaseConfig struct {
+	Enabled             bool    `json:"enabled"`
+	Name                string  `json:"name"`
+	Username            string  `json:"username"`
+	MinCapacity         float64 `json:"minCapacity"`
+	MaxCapacity         float64 `json:"maxCapacity"`
+	Readers             float64 `json:"readers"`
+	BackupRetentionDays float64 `json:"backupRetentionDays"`
+}
+
+// CacheConfig adds a Redis replication group for the app's sessions and
+// cache. Replicas of 0 runs a single node without automatic failover.
+type CacheConfig struct {
+	Enabled               bool    `json:"enabled"`
+	NodeType              string  `json:"nodeType"`
+	EngineVersion         string  `json:"engineVersion"`
+	Replicas              float64 `json:"replicas"`
+	SnapshotRetentionDays float64 `json:"snapshotRetentionDays"`
+}
+
+// MessagingConfig adds async infrastructure for the app: one SQS queue (and
+// dead-letter queue) per name in Queues, and optionally an EventBridge bus
+type MessagingConfig struct {
+	Queues                   []string `json:"queues"`
+	EventBus                 bool     `json:"eventBus"`
+	MaxReceiveCount          float64  `json:"maxReceiveCount"`
+	VisibilityTimeoutSeconds float64  `json:"visibilityTimeoutSeconds"`
+}
+
+// BackupConfig schedules a nightly export of the XTDB data volume to S3,
+// at Hour UTC, kept for RetentionDays
+type BackupConfig struct {
+	Enabled       bool    `json:"enabled"`
+	Hour          float64 `json:"hour"`
+	RetentionDays float64 `json:"retentionDays"`
+}
+
+// RegistryConfig sets how long app images are kept in ECR and how they are
+// scanned. DockerHubSecretArn enables a pull-through cache for Docker Hub.
+// The services run the app and xtdb images CI pushed with ImageTag; tags
+// are immutable, so each build pushes its own, e.g. the commit SHA.
+type RegistryConfig struct {
+	ImageTag           string  `json:"imageTag"`
+	KeepImages         float64 `json:"keepImages"`
+	UntaggedDays       float64 `json:"untaggedDays"`
+	EnhancedScanning   bool    `json:"enhancedScanning"`
+	DockerHubSecretArn string  `json:"dockerHubSecretArn"`
+}
+
+// CiConfig lets workflows of GithubRepository on one of Branches assume the
+// environment's deploy role through GitHub's OIDC provider
+type CiConfig struct {
+	GithubRepository string   `json:"githubRepository"`
+	Branches         []string `json:"branches"`
+	OidcProviderArn  string   `json:"oidcProviderArn"`
+	PolicyArns       []string `json:"policyArns"`
+}
+
+// WafConfig puts a WAF web ACL in front of the ALB. A client is blocked once
+// it sends more than RateLimit requests within RateWindowSeconds.
+type WafConfig struct {
+	Enabled           bool    `json:"enabled"`
+	RateLimit         float64 `json:"rateLimit"`
+	RateWindowSeconds float64 `json:"rateWindowSeconds"`
+}
+
+// CdnConfig puts CloudFront in front of the ALB. DomainName must differ from
+// the ALB's domain; its certificate is created unless CertificateArn names
+// one, which is required outside us-east-1.
+type CdnConfig struct {
+	Enabled        bool   `json:"enabled"`
+	DomainName     string `json:"domainName"`
+	CertificateArn string `json:"certificateArn"`
+}
+
+// AdminConfig deploys internal tools behind Cognito sign-in on the app's
+// domain, which must be set since ALB authentication needs HTTPS
+type AdminConfig struct {
+	PgAdmin bool `json:"pgAdmin"`
+}
+
+// TagsConfig sets the ownership tags put on every resource. With
+// ActivateCostAllocation the tag keys are also activated for billing; AWS
+// rejects keys it has not seen on a resource yet, so turn it on once the
+// tags show up in the billing console, about a day after the first apply.
+type TagsConfig struct {
+	Owner                  string `json:"owner"`
+	CostCenter             string `json:"costCenter"`
+	Service                string `json:"service"`
+	ActivateCostAllocation bool   `json:"activateCostAllocation"`
+}
+
+// BudgetConfig alerts on the environment's spend, selected by its
+// environment tag: when the month's actual cost passes AlertPercent of
+// MonthlyLimit or is forecast to exceed it, and when Cost Anomaly Detection
+// sees an anomaly costing more than AnomalyThreshold. Amounts are in USD;
+// a zero MonthlyLimit or AnomalyThreshold turns that alert off.
+type BudgetConfig struct {
+	MonthlyLimit     float64 `json:"monthlyLimit"`
+	AlertPercent     float64 `json:"alertPercent"`
+	AnomalyThreshold float64 `json:"anomalyThreshold"`
+}
+
+// FailoverConfig deploys a standby copy of the environment to Region for
+// disaster recovery. The domain fails over to the standby's ALB when the
+// primary's health check fails, and the XTDB object store replicates to the
+// standby's bucket. Needs a domainName and the S3 object store.
+type FailoverConfig struct {
+	Enabled bool   `json:"enabled"`
+	Region  string `json:"region"`
+	// Secondary marks the config of the standby copy, see Standby
+	Secondary bool `json:"-"`
+}
+
+// StackConfig controls the per-environment shape of the stack
+type StackConfig struct {
+	Environment     string              `json:"environment"`
+	Cloud           string              `json:"cloud"`
+	Region          string              `json:"region"`
+	Account         AccountConfig       `json:"account"`
+	NamePrefix      string              `json:"namePrefix"`
+	CpuArchitecture string              `json:"cpuArchitecture"`
+	XTDB            ServiceSizing       `json:"xtdb"`
+	App             ServiceSizing       `json:"app"`
+	State           StateBackendConfig  `json:"state"`
+	Domain          DomainConfig        `json:"domain"`
+	Secrets         SecretsConfig       `json:"secrets"`
+	Network         NetworkConfig       `json:"network"`
+	Scaling         ScalingConfig       `json:"scaling"`
+	Alerting        AlertingConfig      `json:"alerting"`
+	Observability   ObservabilityConfig `json:"observability"`
+	Logging         LoggingConfig       `json:"logging"`
+	Storage         StorageConfig       `json:"storage"`
+	TxLog           TxLogConfig         `json:"txLog"`
+	Database        DatabaseConfig      `json:"database"`
+	Cache           CacheConfig         `json:"cache"`
+	Messaging       MessagingConfig     `json:"messaging"`
+	Backup          BackupConfig        `json:"backup"`
+	Registry        RegistryConfig      `json:"registry"`
+	Ci              CiConfig            `json:"ci"`
+	Waf             WafConfig           `json:"waf"`
+	Cdn             CdnConfig           `json:"cdn"`
+	Admin           AdminConfig         `json:"admin"`
+	PreventDestroy  bool                `json:"preventDestroy"`
+	Tags            TagsConfig          `json:"tags"`
+	Budget          BudgetConfig        `json:"budget"`
+	Failover        FailoverConfig      `json:"failover"`
+	// AppSettings are passed to the app as variables through SSM parameters
+	AppSettings map[string]string `json:"appSettings"`
+	Existing    ExistingConfig    `json:"existing"`
+	Mesh        MeshConfig        `json:"mesh"`
+	Eks         EksConfig         `json:"eks"`
+	AppRunner   AppRunnerConfig   `json:"appRunner"`
+	Gcp         GcpConfig         `json:"gcp"`
+	Azure       AzureConfig       `json:"azure"`
+	Datadog     DatadogConfig     `json:"datadog"`
+	PagerDuty   PagerDutyConfig   `json:"pagerDuty"`
+}
+
+// StackID is the prefix of the environment's CDKTF stack ids, e.g.
+// infra-staging, or infra-prod-dr for the standby copy of prod
+func (c *StackConfig) StackID() string {
+	if c.Failover.Secondary {
+		return "infra-" + c.Environment + "-dr"
+	}
+	return "infra-" + c.Environment
+}
+
+// Standby is the config of the environment's copy in the failover region.
+// Its names get a -dr suffix. XTDB starts scaled to zero, since it must not
+// write to the replica bucket until the standby is promoted. Account-wide
+// resources stay with the primary: the CDN, pgAdmin's Cognito domain, the
+// tag-scoped budget and the cost allocation tags. Existing resources are
+// regional, except the hosted zone, so the standby creates its own.
+func (c StackConfig) Standby() StackConfig {
+	standby := c
+	standby.Region = c.Failover.Region
+	standby.NamePrefix = c.NamePrefix + "-dr"
+	standby.Failover.Secondary = true
+	standby.XTDB.DesiredCount = 0
+	standby.Cdn.Enabled = false
+	standby.Admin.PgAdmin = false
+	standby.Budget = BudgetConfig{}
+	standby.Tags.ActivateCostAllocation = false
+	standby.Existing = ExistingConfig{HostedZoneID: c.Existing.HostedZoneID}
+	return standby
+}
+
+// LayerStackID is the CDKTF stack id of one layer of the environment, e.g. infra-staging-app
+func (c *StackConfig) LayerStackID(layer string) string {
+	return c.StackID() + "-" + layer
+}
+
+// ClusterName is the name of the ECS cluster running the services
+func (c *StackConfig) ClusterName() string {
+	if c.Existing.ClusterName != "" {
+		return c.Existing.ClusterName
+	}
+	return c.NamePrefix
+}
+
+// Name prefixes a resource name with the environment's name prefix
+func (c *StackConfig) Name(name string) *string {
+	return jsii.String(c.NamePrefix + "-" + name)
+}
+
+// IsProduction reports whether the stack is the production environment
+func (c *StackConfig) IsProduction() bool {
+	return c.Environment == "prod"
+}
+
+// DefaultStackConfig returns the built-in settings for an environment
+func DefaultStackConfig(env string) StackConfig {
+	cfg := StackConfig{
+		Environment:     env,
+		Cloud:           CloudAws,
+		Region:          "us-east-1",
+		NamePrefix:      resourcePrefix + "-" + env,
+		CpuArchitecture: ArchX86_64,
+		XTDB:            ServiceSizing{Cpu: 512, MemoryMiB: 1024, DesiredCount: 1, SpotPercent: 100},
+		App:             ServiceSizing{Cpu: 256, MemoryMiB: 512, DesiredCount: 1, SpotPercent: 100},
+		State: StateBackendConfig{
+			Bucket:        resourcePrefix + "-tfstate",
+			LockTable:     resourcePrefix + "-tflock",
+			DeployTable:   resourcePrefix + "-deploys",
+			AuditLogGroup: resourcePrefix + "-audit",
+			Region:        "us-east-1",
+		},
+		Domain:  DomainConfig{HealthCheckPath: "/"},
+		Secrets: SecretsConfig{DatabaseUsername: "xtdb"},
+		Gcp:     GcpConfig{Region: "us-central1"},
+		Azure:   AzureConfig{Location: "eastus"},
+		Datadog: DatadogConfig{Site: "datadoghq.com", XTDBLagThreshold: 1000},
+		Network: NetworkConfig{
+			Cidr:              "10.0.0.0/16",
+			MaxAzs:            2,
+			PublicSubnetMask:  24,
+			PrivateSubnetMask: 20,
+			NatStrategy:       NatSingle,
+		},
+		Scaling: ScalingConfig{
+			MinCapacity:        1,
+			MaxCapacity:        2,
+			TargetCpuPercent:   70,
+			RequestsPerTarget:  500,
+			OffHoursScaleDown:  true,
+			BusinessHoursStart: 8,
+			BusinessHoursEnd:   19,
+			TimeZone:           "UTC",
+		},
+		Alerting: AlertingConfig{
+			CpuPercent:          85,
+			MemoryPercent:       85,
+			Error5xxPercent:     5,
+			ResponseTimeSeconds: 1,
+		},
+		Observability: ObservabilityConfig{
+			LogRetentionDays:  7,
+			ContainerInsights: InsightsEnabled,
+			XTDBMetrics:       true,
+		},
+		Logging: LoggingConfig{Destination: LogsCloudWatch},
+		Storage: StorageConfig{
+			ObjectStore:           ObjectStoreEfs,
+			NoncurrentVersionDays: 30,
+			ThroughputMode:        ThroughputElastic,
+			InfrequentAccessDays:  30,
+			BackupRetentionDays:   7,
+			XTDBUid:               1000,
+			XTDBGid:               1000,
+		},
+		TxLog: TxLogConfig{Backend: TxLogLocal, Topic: "xtdb-tx-log"},
+		Database: DatabaseConfig{
+			Name:                "xtdb",
+			Username:            "xtdb_admin",
+			MinCapacity:         0.5,
+			MaxCapacity:         4,
+			BackupRetentionDays: 7,
+		},
+		Cache: CacheConfig{
+			NodeType:      "cache.t4g.micro",
+			EngineVersion: "7.1",
+		},
+		Messaging: MessagingConfig{
+			MaxReceiveCount:          5,
+			VisibilityTimeoutSeconds: 60,
+		},
+		Backup:   BackupConfig{Enabled: true, Hour: 3, RetentionDays: 14},
+		Registry: RegistryConfig{ImageTag: "latest", KeepImages: 30, UntaggedDays: 7, EnhancedScanning: true},
+		Ci:       CiConfig{GithubRepository: "chiefkemist/clj-xtdb-devops", Branches: []string{"main"}},
+		Waf:      WafConfig{RateLimit: 2000, RateWindowSeconds: 300},
+		Tags: TagsConfig{
+			Owner:      "platform",
+			CostCenter: "engineering",
+			Service:    resourcePrefix,
+		},
+		Budget:      BudgetConfig{MonthlyLimit: 200, AlertPercent: 80, AnomalyThreshold: 50},
+		AppSettings: map[string]string{"APP_ENV": env},
+		Eks:         EksConfig{Version: "1.32", NodeCount: 2},
+	}
+	if env == "staging" {
+		// Keep the database on demand so staging data survives Spot reclaims
+		cfg.XTDB.SpotPercent = 0
+		cfg.App.SpotPercent = 70
+	}
+	if env == "prod" {
+		cfg.XTDB = ServiceSizing{Cpu: 1024, MemoryMiB: 4096, DesiredCount: 1}
+		cfg.App = ServiceSizing{Cpu: 512, MemoryMiB: 1024, DesiredCount: 2}
+		cfg.Network.MaxAzs = 3
+		cfg.Network.NatStrategy = NatPerAz
+		cfg.Network.VpcEndpoints = true
+		cfg.Scaling.MinCapacity = 2
+		cfg.Scaling.MaxCapacity = 6
+		cfg.Scaling.OffHoursScaleDown = false
+		cfg.Observability.LogRetentionDays = 90
+		cfg.Observability.ContainerInsights = InsightsEnhanced
+		cfg.Storage.BackupRetentionDays = 35
+		cfg.Storage.ObjectStore = ObjectStoreS3
+		cfg.Database.Readers = 1
+		cfg.Database.BackupRetentionDays = 35
+		cfg.Cache.Enabled = true
+		cfg.Cache.NodeType = "cache.t4g.small"
+		cfg.Cache.Replicas = 1
+		cfg.Cache.SnapshotRetentionDays = 7
+		cfg.Backup.RetentionDays = 30
+		cfg.Waf.Enabled = true
+		cfg.PreventDestroy = true
+		cfg.Budget.MonthlyLimit = 1500
+		cfg.Budget.AnomalyThreshold = 100
+	}
+	return cfg
+}
+
+// LoadStackConfigs builds the config of every environment. Each layer
+// overrides the previous one: built-in defaults, the JSON config file
+// (STACK_CONFIG_FILE, default stacks.json), the "stacks" entry of the
+// cdktf.json context, and finally STACK_<ENV>_<SETTING> environment variables.
+func LoadStackConfigs(app cdktf.App) ([]StackConfig, error) {
+	var fromFile map[string]json.RawMessage
+	path := os.Getenv("STACK_CONFIG_FILE")
+	if path == "" {
+		path = "stacks.json"
+	}
+	if data, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(data, &fromFile); err != nil {
+			return nil, fmt.Errorf("invalid stack config file %s: %w", path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	var fromContext map[string]json.RawMessage
+	if ctx := app.Node().TryGetContext(jsii.String("stacks")); ctx != nil {
+		data, err := json.Marshal(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &fromContext); err != nil {
+			return nil, fmt.Errorf("invalid stacks context: %w", err)
+		}
+	}
+
+	configs := make([]StackConfig, 0, len(environments))
+	for _, env := range environments {
+		cfg := DefaultStackConfig(env)
+		for _, layer := range []map[string]json.RawMessage{fromFile, fromContext} {
+			if raw, ok := layer[env]; ok {
+				if err := json.Unmarshal(raw, &cfg); err != nil {
+					return nil, fmt.Errorf("invalid config for %s: %w", env, err)
+				}
+			}
+		}
+		if err := cfg.applyEnv(); err != nil {
+			return nil, err
+		}
+		cfg.Environment = env
+		if cfg.State.RoleArn == "" {
+			cfg.State.RoleArn = cfg.Account.RoleArn
+		}
+		if err := cfg.validate(); err != nil {
+			return nil, err
+		}
+		configs = append(configs, cfg)
+	}
+	if err := validateStateBackends(configs); err != nil {
+		return nil, err
+	}
+	return configs, nil
+}
+
+// validateStateBackends rejects environments that share a state bucket but
+// reach it through different roles. Bucket names are global, so the bucket
+// can only be in one account and each account needs its own.
+func validateStateBackends(configs []StackConfig) error {
+	roles := map[string]StackConfig{}
+	for _, cfg := range configs {
+		if cfg.State.Local {
+			continue
+		}
+		if other, ok := roles[cfg.State.Bucket]; ok && other.State.RoleArn != cfg.State.RoleArn {
+			return fmt.Errorf("%s and %s share the state bucket %s through different roles; give each account its own state.bucket", other.Environment, cfg.Environment, cfg.State.Bucket)
+		}
+		roles[cfg.State.Bucket] = cfg
+	}
+	return nil
+}
+
+// applyEnv overrides settings from STACK_<ENV>_<SETTING> variables,
+// e.g. STACK_STAGING_APP_DESIRED_COUNT=2
+func (c *StackConfig) applyEnv() error {
+	prefix := "STACK_" + strings.ToUpper(c.Environment) + "_"
+	if v := os.Getenv(prefix + "REGION"); v != "" {
+		c.Region = v
+	}
+	if v := os.Getenv(prefix + "ACCOUNT_ID"); v != "" {
+		c.Account.ID = v
+	}
+	if v := os.Getenv(prefix + "ASSUME_ROLE_ARN"); v != "" {
+		c.Account.RoleArn = v
+	}
+	if v := os.Getenv(prefix + "NAME_PREFIX"); v != "" {
+		c.NamePrefix = v
+	}
+	if v := os.Getenv(prefix + "CPU_ARCHITECTURE"); v != "" {
+		c.CpuArchitecture = v
+	}
+	if v := os.Getenv(prefix + "DOMAIN_NAME"); v != "" {
+		c.Domain.DomainName = v
+	}
+	if v := os.Getenv(prefix + "HOSTED_ZONE_NAME"); v != "" {
+		c.Domain.HostedZoneName = v
+	}
+	if v := os.Getenv(prefix + "EXISTING_VPC_ID"); v != "" {
+		c.Existing.VpcID = v
+	}
+	if v := os.Getenv(prefix + "EXISTING_CLUSTER_NAME"); v != "" {
+		c.Existing.ClusterName = v
+	}
+	if v := os.Getenv(prefix + "EXISTING_HOSTED_ZONE_ID"); v != "" {
+		c.Existing.HostedZoneID = v
+	}
+	if v := os.Getenv(prefix + "ALERT_EMAILS"); v != "" {
+		c.Alerting.Emails = strings.Split(v, ",")
+	}
+	if v := os.Getenv(prefix + "SLACK_WORKSPACE_ID"); v != "" {
+		c.Alerting.SlackWorkspaceID = v
+	}
+	if v := os.Getenv(prefix + "SLACK_CHANNEL_ID"); v != "" {
+		c.Alerting.SlackChannelID = v
+	}
+	if v := os.Getenv(prefix + "DEPLOY_SLACK_CHANNEL_ID"); v != "" {
+		c.Alerting.DeploySlackChannelID = v
+	}
+	if v := os.Getenv(prefix + "XTDB_OBJECT_STORE"); v != "" {
+		c.Storage.ObjectStore = v
+	}
+	if v := os.Getenv(prefix + "OFF_HOURS_SCALE_DOWN"); v != "" {
+		c.Scaling.OffHoursScaleDown = v == "true"
+	}
+	if v := os.Getenv(prefix + "TIME_ZONE"); v != "" {
+		c.Scaling.TimeZone = v
+	}
+	if v := os.Getenv(prefix + "VPC_ENDPOINTS"); v != "" {
+		c.Network.VpcEndpoints = v == "true"
+	}
+	if v := os.Getenv(prefix + "PREVENT_DESTROY"); v != "" {
+		c.PreventDestroy = v == "true"
+	}
+	if v := os.Getenv(prefix + "MESH_ENABLED"); v != "" {
+		c.Mesh.Enabled = v == "true"
+	}
+	if v := os.Getenv(prefix + "EKS_ENABLED"); v != "" {
+		c.Eks.Enabled = v == "true"
+	}
+	if v := os.Getenv(prefix + "EKS_CLUSTER_NAME"); v != "" {
+		c.Eks.ClusterName = v
+	}
+	if v := os.Getenv(prefix + "APP_RUNNER_ENABLED"); v != "" {
+		c.AppRunner.Enabled = v == "true"
+	}
+	if v := os.Getenv(prefix + "DATADOG_ENABLED"); v != "" {
+		c.Datadog.Enabled = v == "true"
+	}
+	if v := os.Getenv(prefix + "DATADOG_SITE"); v != "" {
+		c.Datadog.Site = v
+	}
+	if v := os.Getenv(prefix + "PAGERDUTY_ENABLED"); v != "" {
+		c.PagerDuty.Enabled = v == "true"
+	}
+	if v := os.Getenv(prefix + "CLOUD"); v != "" {
+		c.Cloud = v
+	}
+	if v := os.Getenv(prefix + "GCP_PROJECT"); v != "" {
+		c.Gcp.Project = v
+	}
+	if v := os.Getenv(prefix + "GCP_REGION"); v != "" {
+		c.Gcp.Region = v
+	}
+	if v := os.Getenv(prefix + "IMAGE_TAG"); v != "" {
+		c.Registry.ImageTag = v
+	}
+	if v := os.Getenv(prefix + "GCP_IMAGE_TAG"); v != "" {
+		c.Gcp.ImageTag = v
+	}
+	if v := os.Getenv(prefix + "AZURE_SUBSCRIPTION_ID"); v != "" {
+		c.Azure.SubscriptionID = v
+	}
+	if v := os.Getenv(prefix + "AZURE_LOCATION"); v != "" {
+		c.Azure.Location = v
+	}
+	if v := os.Getenv(prefix + "AZURE_IMAGE_TAG"); v != "" {
+		c.Azure.ImageTag = v
+	}
+	if v := os.Getenv(prefix + "WAF_ENABLED"); v != "" {
+		c.Waf.Enabled = v == "true"
+	}
+	if v := os.Getenv(prefix + "CDN_ENABLED"); v != "" {
+		c.Cdn.Enabled = v == "true"
+	}
+	if v := os.Getenv(prefix + "CDN_DOMAIN_NAME"); v != "" {
+		c.Cdn.DomainName = v
+	}
+	if v := os.Getenv(prefix + "PGADMIN"); v != "" {
+		c.Admin.PgAdmin = v == "true"
+	}
+	if v := os.Getenv(prefix + "DATABASE_ENABLED"); v != "" {
+		c.Database.Enabled = v == "true"
+	}
+	if v := os.Getenv(prefix + "CACHE_ENABLED"); v != "" {
+		c.Cache.Enabled = v == "true"
+	}
+	if v := os.Getenv(prefix + "BACKUP_ENABLED"); v != "" {
+		c.Backup.Enabled = v == "true"
+	}
+	if v := os.Getenv(prefix + "DOCKER_HUB_SECRET_ARN"); v != "" {
+		c.Registry.DockerHubSecretArn = v
+	}
+	if v := os.Getenv(prefix + "OIDC_PROVIDER_ARN"); v != "" {
+		c.Ci.OidcProviderArn = v
+	}
+	if v := os.Getenv(prefix + "FAILOVER_REGION"); v != "" {
+		c.Failover.Enabled = true
+		c.Failover.Region = v
+	}
+	if v := os.Getenv(prefix + "OWNER"); v != "" {
+		c.Tags.Owner = v
+	}
+	if v := os.Getenv(prefix + "COST_CENTER"); v != "" {
+		c.Tags.CostCenter = v
+	}
+	if v := os.Getenv(prefix + "QUEUES"); v != "" {
+		c.Messaging.Queues = strings.Split(v, ",")
+	}
+	if v := os.Getenv(prefix + "TX_LOG_BACKEND"); v != "" {
+		c.TxLog.Backend = v
+	}
+	if v := os.Getenv(prefix + "KAFKA_BOOTSTRAP_SERVERS"); v != "" {
+		c.TxLog.BootstrapServers = v
+	}
+	if v := os.Getenv(prefix + "LOG_DESTINATION"); v != "" {
+		c.Logging.Destination = v
+	}
+	if v := os.Getenv(prefix + "LOG_SECRET_ARN"); v != "" {
+		c.Logging.SecretArn = v
+	}
+	if v := os.Getenv(prefix + "TRACING"); v != "" {
+		c.Observability.Tracing = v == "true"
+	}
+	if v := os.Getenv(prefix + "AMP_WORKSPACE_ARN"); v != "" {
+		c.Observability.AmpWorkspaceArn = v
+	}
+	if v := os.Getenv(prefix + "NAT_STRATEGY"); v != "" {
+		c.Network.NatStrategy = v
+	}
+	if os.Getenv("STACK_LOCAL_STATE") == "true" {
+		c.State.Local = true
+	}
+	numbers := map[string]*float64{
+		"XTDB_CPU":              &c.XTDB.Cpu,
+		"XTDB_MEMORY_MIB":       &c.XTDB.MemoryMiB,
+		"XTDB_DESIRED_COUNT":    &c.XTDB.DesiredCount,
+		"APP_CPU":               &c.App.Cpu,
+		"APP_MEMORY_MIB":        &c.App.MemoryMiB,
+		"APP_DESIRED_COUNT":     &c.App.DesiredCount,
+		"XTDB_SPOT_PERCENT":     &c.XTDB.SpotPercent,
+		"APP_SPOT_PERCENT":      &c.App.SpotPercent,
+		"APP_MIN_CAPACITY":      &c.Scaling.MinCapacity,
+		"APP_MAX_CAPACITY":      &c.Scaling.MaxCapacity,
+		"LOG_RETENTION_DAYS":    &c.Observability.LogRetentionDays,
+		"BACKUP_RETENTION_DAYS": &c.Storage.BackupRetentionDays,
+		"EXPORT_RETENTION_DAYS": &c.Backup.RetentionDays,
+		"WAF_RATE_LIMIT":        &c.Waf.RateLimit,
+		"BUSINESS_HOURS_START":  &c.Scaling.BusinessHoursStart,
+		"BUSINESS_HOURS_END":    &c.Scaling.BusinessHoursEnd,
+		"MONTHLY_BUDGET":        &c.Budget.MonthlyLimit,
+		"EKS_NODE_COUNT":        &c.Eks.NodeCount,
+	}
+	for name, field := range numbers {
+		v := os.Getenv(prefix + name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s%s: %w", prefix, name, err)
+		}
+		*field = n
+	}
+	return nil
+}
+
+// validate rejects settings that would only fail at plan time
+func (c *StackConfig) validate() error {
+	switch c.Network.NatStrategy {
+	case NatNone, NatSingle, NatPerAz:
+	default:
+		return fmt.Errorf("%s: unknown NAT strategy %q (use %s, %s or %s)", c.Environment, c.Network.NatStrategy, NatNone, NatSingle, NatPerAz)
+	}
+	if c.Existing.VpcID == "" {
+		if _, err := subnetCidrs(c.Network); err != nil {
+			return fmt.Errorf("%s: %w", c.Environment, err)
+		}
+	}
+	if c.Network.NatStrategy == NatNone && !c.Network.VpcEndpoints {
+		return fmt.Errorf("%s: without NAT gateways the tasks need vpcEndpoints to pull images and ship logs", c.Environment)
+	}
+	switch c.CpuArchitecture {
+	case ArchX86_64, ArchArm64:
+	default:
+		return fmt.Errorf("%s: unknown CPU architecture %q (use %s or %s)", c.Environment, c.CpuArchitecture, ArchX86_64, ArchArm64)
+	}
+	for name, sizing := range map[string]ServiceSizing{"xtdb": c.XTDB, "app": c.App} {
+		if sizing.SpotPercent < 0 || sizing.SpotPercent > 100 {
+			return fmt.Errorf("%s: %s spot percent must be between 0 and 100, got %v", c.Environment, name, sizing.SpotPercent)
+		}
+	}
+	switch c.Observability.ContainerInsights {
+	case InsightsEnabled, InsightsEnhanced:
+	default:
+		return fmt.Errorf("%s: unknown Container Insights mode %q (use %s or %s)", c.Environment, c.Observability.ContainerInsights, InsightsEnabled, InsightsEnhanced)
+	}
+	if _, ok := logRetentions[c.Observability.LogRetentionDays]; !ok {
+		return fmt.Errorf("%s: CloudWatch Logs does not support a retention of %v days", c.Environment, c.Observability.LogRetentionDays)
+	}
+	if arn := c.Observability.AmpWorkspaceArn; arn != "" {
+		if _, err := ampRemoteWriteURL(arn); err != nil {
+			return fmt.Errorf("%s: %w", c.Environment, err)
+		}
+	}
+	switch c.Storage.ObjectStore {
+	case ObjectStoreEfs, ObjectStoreS3:
+	default:
+		return fmt.Errorf("%s: unknown XTDB object store %q (use %s or %s)", c.Environment, c.Storage.ObjectStore, ObjectStoreEfs, ObjectStoreS3)
+	}
+	if c.Database.Enabled && (c.Database.MinCapacity < 0.5 || c.Database.MaxCapacity < c.Database.MinCapacity) {
+		return fmt.Errorf("%s: database capacity must satisfy 0.5 <= min (%v) <= max (%v) ACUs", c.Environment, c.Database.MinCapacity, c.Database.MaxCapacity)
+	}
+	if c.Backup.Enabled && (c.Backup.Hour < 0 || c.Backup.Hour > 23 || c.Backup.RetentionDays < 1) {
+		return fmt.Errorf("%s: backups need an hour between 0 and 23 and at least 1 day of retention", c.Environment)
+	}
+	if c.Registry.KeepImages < 1 || c.Registry.UntaggedDays < 1 {
+		return fmt.Errorf("%s: the registry must keep at least 1 image for at least 1 day", c.Environment)
+	}
+	if c.Ci.GithubRepository != "" && len(c.Ci.Branches) == 0 {
+		return fmt.Errorf("%s: the deploy role needs at least one branch", c.Environment)
+	}
+	if c.Waf.Enabled && (c.Waf.RateLimit < 10 || !wafRateWindows[c.Waf.RateWindowSeconds]) {
+		return fmt.Errorf("%s: WAF needs a rate limit of at least 10 over 60, 120, 300 or 600 seconds", c.Environment)
+	}
+	if c.Cdn.Enabled && c.Cdn.DomainName != "" {
+		if c.Cdn.DomainName == c.Domain.DomainName {
+			return fmt.Errorf("%s: the CDN domain must differ from the ALB domain %s", c.Environment, c.Domain.DomainName)
+		}
+		if c.Cdn.CertificateArn == "" && c.Region != "us-east-1" {
+			return fmt.Errorf("%s: CloudFront needs a us-east-1 certificateArn when the stack is in %s", c.Environment, c.Region)
+		}
+	}
+	if c.Admin.PgAdmin && c.Domain.DomainName == "" {
+		return fmt.Errorf("%s: pgAdmin is only deployed behind Cognito, which needs a domainName for HTTPS", c.Environment)
+	}
+	if c.Tags.Owner == "" || c.Tags.CostCenter == "" || c.Tags.Service == "" {
+		return fmt.Errorf("%s: every resource is tagged with an owner, costCenter and service, none may be empty", c.Environment)
+	}
+	if c.Eks.Enabled {
+		if c.Database.Enabled || c.Cache.Enabled || len(c.Messaging.Queues) > 0 || c.Messaging.EventBus || c.Mesh.Enabled || c.Failover.Enabled {
+			return fmt.Errorf("%s: the database, cache, queues, mesh and failover are only available on ECS, disable them to use eks", c.Environment)
+		}
+		if c.Eks.ClusterName == "" && c.Eks.NodeCount < 1 {
+			return fmt.Errorf("%s: an EKS cluster needs at least 1 node", c.Environment)
+		}
+	}
+	if c.Datadog.Enabled && (c.Datadog.Site == "" || c.Datadog.XTDBLagThreshold <= 0) {
+		return fmt.Errorf("%s: Datadog needs a site and a positive xtdbLagThreshold", c.Environment)
+	}
+	if c.PagerDuty.Enabled && c.PagerDuty.EscalationPolicyID == "" && c.PagerDuty.ScheduleID == "" {
+		return fmt.Errorf("%s: PagerDuty needs an escalationPolicyId or a scheduleId to page", c.Environment)
+	}
+	switch c.Cloud {
+	case CloudAws:
+		if c.Registry.ImageTag == "" {
+			return fmt.Errorf("%s: aws needs a registry.imageTag", c.Environment)
+		}
+	case CloudGcp:
+		if c.Gcp.Project == "" || c.Gcp.Region == "" || c.Gcp.ImageTag == "" {
+			return fmt.Errorf("%s: gcp needs a project, region and imageTag", c.Environment)
+		}
+	case CloudAzure:
+		if c.Azure.SubscriptionID == "" || c.Azure.Location == "" || c.Azure.ImageTag == "" {
+			return fmt.Errorf("%s: azure needs a subscriptionId, location and imageTag", c.Environment)
+		}
+		for _, sizing := range []ServiceSizing{c.XTDB, c.App} {
+			if sizing.Cpu < 256 || sizing.Cpu > 4096 || math.Mod(sizing.Cpu, 256) != 0 {
+				return fmt.Errorf("%s: Container Apps runs 0.25 to 4 cores in steps of 0.25, %v CPU units is not one", c.Environment, sizing.Cpu)
+			}
+		}
+		if c.Database.Enabled || c.Cache.Enabled {
+			return fmt.Errorf("%s: the database and cache are not available on azure", c.Environment)
+		}
+	default:
+		return fmt.Errorf("%s: unknown cloud %q (use %s, %s or %s)", c.Environment, c.Cloud, CloudAws, CloudGcp, CloudAzure)
+	}
+	if c.Cloud != CloudAws {
+		if c.Eks.Enabled || c.AppRunner.Enabled || c.Mesh.Enabled || c.Failover.Enabled || c.Domain.DomainName != "" || c.Cdn.Enabled || c.Waf.Enabled || c.Admin.PgAdmin || len(c.Messaging.Queues) > 0 || c.Messaging.EventBus || c.Datadog.Enabled || c.PagerDuty.Enabled {
+			return fmt.Errorf("%s: eks, appRunner, the mesh, failover, domain, CDN, WAF, admin tools, messaging, Datadog and PagerDuty are only available on aws", c.Environment)
+		}
+		if c.TxLog.Backend != TxLogLocal {
+			return fmt.Errorf("%s: XTDB on %s keeps its transaction log locally", c.Environment, c.Cloud)
+		}
+	}
+	if c.AppRunner.Enabled {
+		if c.Eks.Enabled {
+			return fmt.Errorf("%s: enable either eks or appRunner, not both", c.Environment)
+		}
+		if !slices.Contains(appRunnerMemory[c.App.Cpu], c.App.MemoryMiB) {
+			return fmt.Errorf("%s: App Runner does not offer %v CPU with %v MiB for the app", c.Environment, c.App.Cpu, c.App.MemoryMiB)
+		}
+		if c.Domain.DomainName != "" || c.Cdn.Enabled || c.Waf.Enabled || c.Admin.PgAdmin || c.Cache.Enabled || len(c.Messaging.Queues) > 0 || c.Messaging.EventBus || c.Mesh.Enabled || c.Failover.Enabled {
+			return fmt.Errorf("%s: the domain, CDN, WAF, admin tools, cache, queues, mesh and failover are only available behind the ALB, disable them to use appRunner", c.Environment)
+		}
+	}
+	if arn := c.Mesh.PrivateCaArn; arn != "" && !strings.HasPrefix(arn, "arn:aws:acm-pca:") {
+		return fmt.Errorf("%s: %q is not an AWS Private CA ARN", c.Environment, arn)
+	}
+	if c.Existing.HostedZoneID != "" && c.Domain.DomainName == "" {
+		return fmt.Errorf("%s: an existing hostedZoneId is only used for the domainName, which must be set", c.Environment)
+	}
+	if c.Account.ID != "" && !accountID.MatchString(c.Account.ID) {
+		return fmt.Errorf("%s: invalid account id %q (use the 12-digit AWS account id)", c.Environment, c.Account.ID)
+	}
+	for _, arn := range []string{c.Account.RoleArn, c.State.RoleArn} {
+		if arn != "" && !strings.HasPrefix(arn, "arn:aws:iam::") {
+			return fmt.Errorf("%s: %q is not an IAM role ARN", c.Environment, arn)
+		}
+	}
+	if c.Account.ID != "" && c.Account.RoleArn != "" && !strings.HasPrefix(c.Account.RoleArn, "arn:aws:iam::"+c.Account.ID+":") {
+		return fmt.Errorf("%s: the role %s is not in account %s", c.Environment, c.Account.RoleArn, c.Account.ID)
+	}
+	if c.Failover.Enabled {
+		if c.Failover.Region == "" || c.Failover.Region == c.Region {
+			return fmt.Errorf("%s: failover needs a region other than %s", c.Environment, c.Region)
+		}
+		if c.Domain.DomainName == "" {
+			return fmt.Errorf("%s: failover routes the domainName, which must be set", c.Environment)
+		}
+		if c.Storage.ObjectStore != ObjectStoreS3 {
+			return fmt.Errorf("%s: failover replicates the XTDB object store, which must be %s", c.Environment, ObjectStoreS3)
+		}
+	}
+	if c.Budget.MonthlyLimit > 0 && (c.Budget.AlertPercent <= 0 || c.Budget.AlertPercent > 100) {
+		return fmt.Errorf("%s: the budget alertPercent must be between 1 and 100", c.Environment)
+	}
+	for name, value := range c.AppSettings {
+		if !settingName.MatchString(name) {
+			return fmt.Errorf("%s: invalid app setting name %q (use uppercase letters, digits and underscores)", c.Environment, name)
+		}
+		if value == "" {
+			return fmt.Errorf("%s: app setting %s is empty, which SSM does not store", c.Environment, name)
+		}
+	}
+	for _, name := range c.Messaging.Queues {
+		if !queueName.MatchString(name) {
+			return fmt.Errorf("%s: invalid queue name %q (use lowercase letters, digits and dashes)", c.Environment, name)
+		}
+	}
+	switch c.TxLog.Backend {
+	case TxLogLocal, TxLogMsk:
+	case TxLogKafka:
+		if c.TxLog.BootstrapServers == "" {
+			return fmt.Errorf("%s: the kafka tx log needs bootstrapServers", c.Environment)
+		}
+	default:
+		return fmt.Errorf("%s: unknown tx log backend %q (use %s, %s or %s)", c.Environment, c.TxLog.Backend, TxLogLocal, TxLogMsk, TxLogKafka)
+	}
+	switch c.Storage.ThroughputMode {
+	case ThroughputElastic, ThroughputBursting:
+	case ThroughputProvisioned:
+		if c.Storage.ProvisionedMiBps <= 0 {
+			return fmt.Errorf("%s: provisioned EFS throughput needs provisionedMiBps", c.Environment)
+		}
+	default:
+		return fmt.Errorf("%s: unknown EFS throughput mode %q (use %s, %s or %s)", c.Environment, c.Storage.ThroughputMode, ThroughputElastic, ThroughputBursting, ThroughputProvisioned)
+	}
+	if _, ok := efsLifecyclePolicies[c.Storage.InfrequentAccessDays]; !ok && c.Storage.InfrequentAccessDays != 0 {
+		return fmt.Errorf("%s: EFS does not support moving files to infrequent access after %v days", c.Environment, c.Storage.InfrequentAccessDays)
+	}
+	if err := c.Logging.validate(); err != nil {
+		return fmt.Errorf("%s: %w", c.Environment, err)
+	}
+	if (c.Alerting.SlackWorkspaceID == "") != (c.Alerting.SlackChannelID == "") {
+		return fmt.Errorf("%s: Slack alerting needs both a workspace and a channel id", c.Environment)
+	}
+	if c.Alerting.DeploySlackChannelID != "" && c.Alerting.SlackWorkspaceID == "" {
+		return fmt.Errorf("%s: deploy notifications need the Slack workspace id", c.Environment)
+	}
+	if c.Scaling.MinCapacity < 1 || c.Scaling.MaxCapacity < c.Scaling.MinCapacity {
+		return fmt.Errorf("%s: scaling capacity must satisfy 1 <= min (%v) <= max (%v)", c.Environment, c.Scaling.MinCapacity, c.Scaling.MaxCapacity)
+	}
+	if c.Scaling.OffHoursScaleDown {
+		if c.IsProduction() {
+			return fmt.Errorf("%s: off-hours scale-down is not allowed in production", c.Environment)
+		}
+		start, end := c.Scaling.BusinessHoursStart, c.Scaling.BusinessHoursEnd
+		if start < 0 || end > 23 || start >= end {
+			return fmt.Errorf("%s: business hours must satisfy 0 <= start (%v) < end (%v) <= 23", c.Environment, start, end)
+		}
+	}
+	return nil
+}
+
+// validate checks the destination has the settings its output needs
+func (l *LoggingConfig) validate() error {
+	switch l.Destination {
+	case LogsCloudWatch:
+	case LogsDatadog:
+		if l.Host == "" || l.SecretArn == "" {
+			return fmt.Errorf("datadog logging needs a host and an API key secretArn")
+		}
+	case LogsElastic:
+		if l.Host == "" || l.Index == "" || l.Username == "" || l.SecretArn == "" {
+			return fmt.Errorf("elastic logging needs a host, index, username and password secretArn")
+		}
+	case LogsS3:
+		if l.Bucket == "" {
+			return fmt.Errorf("s3 logging needs a bucket")
+		}
+	default:
+		return fmt.Errorf("unknown log destination %q (use %s, %s, %s or %s)", l.Destination, LogsCloudWatch, LogsDatadog, LogsElastic, LogsS3)
+	}
+	return nil
+}
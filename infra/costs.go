@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/aws/jsii-runtime-go"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/budgetsbudget"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/ceanomalymonitor"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/ceanomalysubscription"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/snstopic"
+	"github.com/hashicorp/terraform-cdk-go/cdktf"
+)
+
+// NewCostAlerts creates the environment's monthly budget and a Cost Anomaly
+// Detection monitor, both scoped to resources carrying its environment tag,
+// and sends their alerts to the alerts topic, which lets both services
+// publish. Costs only carry the tag once it is an active cost allocation tag.
+func NewCostAlerts(stack cdktf.TerraformStack, cfg StackConfig, alerts snstopic.SnsTopic) {
+	if cfg.Budget.MonthlyLimit > 0 {
+		notify := func(notificationType string, threshold float64) *budgetsbudget.BudgetsBudgetNotification {
+			return &budgetsbudget.BudgetsBudgetNotification{
+				NotificationType:       jsii.String(notificationType),
+				ComparisonOperator:     jsii.String("GREATER_THAN"),
+				Threshold:              jsii.Number(threshold),
+				ThresholdType:          jsii.String("PERCENTAGE"),
+				SubscriberSnsTopicArns: &[]*string{alerts.Arn()},
+			}
+		}
+		budgetsbudget.NewBudgetsBudget(stack, jsii.String("MonthlyBudget"), &budgetsbudget.BudgetsBudgetConfig{
+			Name:        cfg.Name("monthly"),
+			BudgetType:  jsii.String("COST"),
+			TimeUnit:    jsii.String("MONTHLY"),
+			LimitAmount: jsii.String(strconv.FormatFloat(cfg.Budget.MonthlyLimit, 'f', -1, 64)),
+			LimitUnit:   jsii.String("USD"),
+			CostFilter: &[]*budgetsbudget.BudgetsBudgetCostFilter{{
+				Name:   jsii.String("TagKeyValue"),
+				Values: jsii.Strings("user:" + TagEnvironment + "$" + cfg.Environment),
+			}},
+			Notification: &[]*budgetsbudget.BudgetsBudgetNotification{
+				notify("ACTUAL", cfg.Budget.AlertPercent),
+				notify("FORECASTED", 100),
+			},
+		})
+	}
+
+	if cfg.Budget.AnomalyThreshold > 0 {
+		spec, _ := json.Marshal(map[string]interface{}{
+			"Tags": map[string]interface{}{
+				"Key":          TagEnvironment,
+				"Values":       []string{cfg.Environment},
+				"MatchOptions": []string{"EQUALS"},
+			},
+		})
+		monitor := ceanomalymonitor.NewCeAnomalyMonitor(stack, jsii.String("CostAnomalyMonitor"), &ceanomalymonitor.CeAnomalyMonitorConfig{
+			Name:                 cfg.Name("costs"),
+			MonitorType:          jsii.String("CUSTOM"),
+			MonitorSpecification: jsii.String(string(spec)),
+		})
+
+		// SNS subscribers are notified of each anomaly as it is detected
+		ceanomalysubscription.NewCeAnomalySubscription(stack, jsii.String("CostAnomalySubscription"), &ceanomalysubscription.CeAnomalySubscriptionConfig{
+			Name:           cfg.Name("costs"),
+			Frequency:      jsii.String("IMMEDIATE"),
+			MonitorArnList: &[]*string{monitor.Arn()},
+			ThresholdExpression: &ceanomalysubscription.CeAnomalySubscriptionThresholdExpression{
+				Dimension: &ceanomalysubscription.CeAnomalySubscriptionThresholdExpressionDimension{
+					Key:          jsii.String("ANOMALY_TOTAL_IMPACT_ABSOLUTE"),
+					Values:       jsii.Strings(strconv.FormatFloat(cfg.Budget.AnomalyThreshold, 'f', -1, 64)),
+					MatchOptions: jsii.Strings("GREATER_THAN_OR_EQUAL"),
+				},
+			},
+			Subscriber: &[]*ceanomalysubscription.CeAnomalySubscriptionSubscriber{{
+				Address: alerts.Arn(),
+				Type:    jsii.String("SNS"),
+			}},
+		})
+	}
+}
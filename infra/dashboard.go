@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/jsii-runtime-go"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/cloudwatchdashboard"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/efsfilesystem"
+	"github.com/hashicorp/terraform-cdk-go/cdktf"
+)
+
+// xtdbMetricsNamespace is the CloudWatch namespace XTDB's own metrics are
+// published under, dimensioned by ClusterName
+const xtdbMetricsNamespace = "XTDB"
+
+// widget is a dashboard widget in the dashboard body's JSON shape, without
+// its position
+type widget map[string]interface{}
+
+// dashboardMetric is one line of a graph: the namespace, metric name and
+// dimension pairs, followed by its rendering options
+func dashboardMetric(namespace string, name string, dimensions []interface{}, options map[string]interface{}) []interface{} {
+	return append(append([]interface{}{namespace, name}, dimensions...), options)
+}
+
+// NewDashboard creates the environment's on-call dashboard: ECS utilization
+// and task counts, ALB traffic, latency and errors, EFS throughput and the
+// XTDB metrics. Alarm thresholds are drawn on the matching graphs.
+func NewDashboard(stack cdktf.TerraformStack, cfg StackConfig, services []MonitoredService, alb *AppLoadBalancer, fs efsfilesystem.EfsFileSystem) cloudwatchdashboard.CloudwatchDashboard {
+	threshold := func(value float64, label string) map[string]interface{} {
+		return map[string]interface{}{"horizontal": []map[string]interface{}{{"value": value, "label": label}}}
+	}
+	graph := func(title string, width float64, metrics [][]interface{}, annotations map[string]interface{}) widget {
+		properties := map[string]interface{}{
+			"title":   title,
+			"view":    "timeSeries",
+			"region":  cfg.Region,
+			"period":  alarmPeriod,
+			"metrics": metrics,
+		}
+		if annotations != nil {
+			properties["annotations"] = annotations
+		}
+		return widget{"type": "metric", "width": width, "height": 6.0, "properties": properties}
+	}
+
+	var cpu, memory, tasks [][]interface{}
+	for _, svc := range services {
+		dimensions := []interface{}{"ClusterName", cfg.ClusterName(), "ServiceName", *cfg.Name(svc.Name)}
+		label := map[string]interface{}{"label": svc.Name}
+		cpu = append(cpu, dashboardMetric("AWS/ECS", "CPUUtilization", dimensions, label))
+		memory = append(memory, dashboardMetric("AWS/ECS", "MemoryUtilization", dimensions, label))
+		tasks = append(tasks, dashboardMetric("ECS/ContainerInsights", "RunningTaskCount", dimensions, label))
+	}
+
+	lb := []interface{}{"LoadBalancer", alb.ArnSuffix()}
+	sum := func(name string, dimensions []interface{}, label string) []interface{} {
+		return dashboardMetric("AWS/ApplicationELB", name, dimensions, map[string]interface{}{"stat": "Sum", "label": label})
+	}
+	targets := []interface{}{"LoadBalancer", alb.ArnSuffix(), "TargetGroup", alb.Targets.ArnSuffix()}
+	responseTime := func(stat string) []interface{} {
+		return dashboardMetric("AWS/ApplicationELB", "TargetResponseTime", targets, map[string]interface{}{"stat": stat, "label": stat})
+	}
+	efsMetric := func(name string, label string) []interface{} {
+		return dashboardMetric("AWS/EFS", name, []interface{}{"FileSystemId", fs.Id()}, map[string]interface{}{"stat": "Sum", "label": label})
+	}
+
+	// Picks up whatever XTDB metrics are published, without listing them here
+	xtdbMetrics := []interface{}{map[string]interface{}{
+		"expression": fmt.Sprintf(`SEARCH('{%s,ClusterName} ClusterName="%s"', 'Average', 60)`, xtdbMetricsNamespace, cfg.ClusterName()),
+		"id":         "xtdb",
+	}}
+
+	rows := [][]widget{
+		{
+			{"type": "text", "width": 24.0, "height": 2.0, "properties": map[string]interface{}{
+				"markdown": fmt.Sprintf("# %s\nAlarms publish to the `%s` SNS topic. The CI module's `env-health` prints a full health report.",
+					cfg.NamePrefix, *cfg.Name("alerts")),
+			}},
+		},
+		{
+			graph("ECS CPU utilization (%)", 8, cpu, threshold(cfg.Alerting.CpuPercent, "alarm")),
+			graph("ECS memory utilization (%)", 8, memory, threshold(cfg.Alerting.MemoryPercent, "alarm")),
+			graph("Running tasks", 8, tasks, nil),
+		},
+		{
+			graph("ALB requests", 8, [][]interface{}{sum("RequestCount", lb, "requests")}, nil),
+			graph("ALB 5xx responses", 8, [][]interface{}{
+				sum("HTTPCode_Target_5XX_Count", lb, "target"),
+				sum("HTTPCode_ELB_5XX_Count", lb, "elb"),
+			}, nil),
+			graph("App response time (s)", 8, [][]interface{}{responseTime("p50"), responseTime("p95"), responseTime("p99")},
+				threshold(cfg.Alerting.ResponseTimeSeconds, "p95 alarm")),
+		},
+		{
+			graph("EFS throughput (bytes)", 12, [][]interface{}{
+				efsMetric("DataReadIOBytes", "read"),
+				efsMetric("DataWriteIOBytes", "write"),
+				efsMetric("MetadataIOBytes", "metadata"),
+			}, nil),
+			graph("XTDB", 12, [][]interface{}{xtdbMetrics}, nil),
+		},
+	}
+
+	// Lay the rows out top to bottom, each row's widgets left to right
+	var widgets []widget
+	y := 0.0
+	for _, row := range rows {
+		x, height := 0.0, 0.0
+		for _, w := range row {
+			w["x"], w["y"] = x, y
+			x += w["width"].(float64)
+			height = max(height, w["height"].(float64))
+			widgets = append(widgets, w)
+		}
+		y += height
+	}
+	body, err := json.Marshal(map[string]interface{}{"start": "-PT3H", "widgets": widgets})
+	if err != nil {
+		panic(err)
+	}
+	return cloudwatchdashboard.NewCloudwatchDashboard(stack, jsii.String("Dashboard"), &cloudwatchdashboard.CloudwatchDashboardConfig{
+		DashboardName: cfg.Name("overview"),
+		DashboardBody: jsii.String(string(body)),
+	})
+}
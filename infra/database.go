@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/aws/jsii-runtime-go"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/dbsubnetgroup"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/kmskey"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/rdscluster"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/rdsclusterinstance"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/secretsmanagersecretrotation"
+	"github.com/hashicorp/terraform-cdk-go/cdktf"
+)
+
+// auroraPostgresVersion is the engine version of the backing database
+const auroraPostgresVersion = "16.6"
+
+// NewBackingDatabase creates an Aurora PostgreSQL Serverless v2 cluster for
+// XTDB when a managed database is configured, reachable from XTDB only.
+// RDS generates the master credentials into Secrets Manager. It returns nil
+// when XTDB uses its embedded setup.
+func NewBackingDatabase(stack cdktf.TerraformStack, cfg StackConfig, key kmskey.KmsKey, vpc *Vpc, sg *SecurityGroups) rdscluster.RdsCluster {
+	if !cfg.Database.Enabled {
+		return nil
+	}
+
+	dbSg := newSecurityGroup(stack, cfg, vpc, "DatabaseSecurityGroup", "database", "Aurora PostgreSQL, reachable from XTDB only")
+	allowTraffic(stack, "XTDBToDatabase", sg.XTDB, dbSg, tcp(5432), "Backing PostgreSQL")
+
+	subnets := dbsubnetgroup.NewDbSubnetGroup(stack, jsii.String("DatabaseSubnetGroup"), &dbsubnetgroup.DbSubnetGroupConfig{
+		Name:        cfg.Name("database"),
+		Description: jsii.String("Private subnets of " + cfg.NamePrefix),
+		SubnetIds:   vpc.PrivateSubnetIDs,
+	})
+
+	config := &rdscluster.RdsClusterConfig{
+		ClusterIdentifier:        cfg.Name("xtdb"),
+		Engine:                   jsii.String("aurora-postgresql"),
+		EngineVersion:            jsii.String(auroraPostgresVersion),
+		DatabaseName:             jsii.String(cfg.Database.Name),
+		MasterUsername:           jsii.String(cfg.Database.Username),
+		ManageMasterUserPassword: jsii.Bool(true),
+		MasterUserSecretKmsKeyId: key.Arn(),
+		DbSubnetGroupName:        subnets.Name(),
+		VpcSecurityGroupIds:      &[]*string{dbSg.Id()},
+		StorageEncrypted:         jsii.Bool(true),
+		KmsKeyId:                 key.Arn(),
+		BackupRetentionPeriod:    jsii.Number(cfg.Database.BackupRetentionDays),
+		CopyTagsToSnapshot:       jsii.Bool(true),
+		DeletionProtection:       jsii.Bool(cfg.IsProduction()),
+		Serverlessv2ScalingConfiguration: &rdscluster.RdsClusterServerlessv2ScalingConfiguration{
+			MinCapacity: jsii.Number(cfg.Database.MinCapacity),
+			MaxCapacity: jsii.Number(cfg.Database.MaxCapacity),
+		},
+	}
+	// Production keeps a final snapshot when the cluster is destroyed
+	if cfg.IsProduction() {
+		config.FinalSnapshotIdentifier = cfg.Name("xtdb-final")
+	} else {
+		config.SkipFinalSnapshot = jsii.Bool(true)
+	}
+	cluster := rdscluster.NewRdsCluster(stack, jsii.String("XTDBDatabase"), config)
+
+	// Readers share the writer's capacity limits and fail over in order
+	instance := func(id string, name string, tier int) {
+		rdsclusterinstance.NewRdsClusterInstance(stack, jsii.String(id), &rdsclusterinstance.RdsClusterInstanceConfig{
+			Identifier:        cfg.Name("xtdb-" + name),
+			ClusterIdentifier: cluster.Id(),
+			Engine:            cluster.Engine(),
+			EngineVersion:     cluster.EngineVersion(),
+			InstanceClass:     jsii.String("db.serverless"),
+			PromotionTier:     jsii.Number(float64(tier)),
+		})
+	}
+	instance("XTDBDatabaseWriter", "writer", 0)
+	for i := 0; i < int(cfg.Database.Readers); i++ {
+		instance(fmt.Sprintf("XTDBDatabaseReader%d", i+1), fmt.Sprintf("reader%d", i+1), 1)
+	}
+
+	// RDS rotates the master password in place, so XTDB opens new
+	// connections with it only once its tasks are restarted
+	if cfg.Secrets.RotationDays > 0 {
+		secretsmanagersecretrotation.NewSecretsmanagerSecretRotation(stack, jsii.String("XTDBDatabaseRotation"), &secretsmanagersecretrotation.SecretsmanagerSecretRotationConfig{
+			SecretId: databaseSecretArn(cluster),
+			RotationRules: &secretsmanagersecretrotation.SecretsmanagerSecretRotationRotationRules{
+				AutomaticallyAfterDays: jsii.Number(cfg.Secrets.RotationDays),
+			},
+		})
+	}
+	return cluster
+}
+
+// databaseSecretArn is the secret RDS keeps the master credentials in
+func databaseSecretArn(db rdscluster.RdsCluster) *string {
+	return db.MasterUserSecret().Get(jsii.Number(0)).SecretArn()
+}
+
+// useBackingDatabase points the XTDB container at the Aurora cluster, using
+// the same XTDB_POSTGRESQL_* settings as the local XTDB container
+func useBackingDatabase(cfg StackConfig, db rdscluster.RdsCluster, xtdb *Container, roles *ServiceRoles, key kmskey.KmsKey) {
+	xtdb.AddEnvironment("XTDB_ENABLE_POSTGRESQL", "true")
+	xtdb.AddEnvironment("XTDB_POSTGRESQL_HOST", *db.Endpoint())
+	xtdb.AddEnvironment("XTDB_POSTGRESQL_PORT", "5432")
+	xtdb.AddEnvironment("XTDB_POSTGRESQL_DATABASE", cfg.Database.Name)
+	credentialSecrets(xtdb, databaseSecretArn(db), "XTDB_POSTGRESQL_USER", "XTDB_POSTGRESQL_PASSWORD")
+	grantSecretRead(roles.ExecutionRole, "Database", databaseSecretArn(db), key)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aws/jsii-runtime-go"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/lb"
+	"github.com/hashicorp/terraform-cdk-go/cdktf"
+)
+
+// datadogXTDBLagMetric is XTDB's indexer lag behind the transaction log, as
+// the Datadog AWS integration imports it from the XTDB CloudWatch namespace
+const datadogXTDBLagMetric = "xtdb.tx.lag"
+
+// NewDatadogMonitors creates Datadog monitors on the running tasks of each
+// service, the ALB's p95 latency and, with XTDB metrics on, XTDB's indexer
+// lag, plus a dashboard of the environment. Metrics are selected by the
+// environment and service tags the stacks put on every resource, which the
+// Datadog AWS integration imports. The provider reads its keys from DD_API_KEY
+// and DD_APP_KEY. Nothing is created unless Datadog is enabled.
+func NewDatadogMonitors(stack cdktf.TerraformStack, cfg StackConfig, services []MonitoredService, alb lb.Lb) {
+	if !cfg.Datadog.Enabled {
+		return
+	}
+	requireProvider(stack, "datadog", "DataDog/datadog", "~> 3.50")
+	stack.AddOverride(jsii.String("provider.datadog"), []map[string]string{
+		{"api_url": "https://api." + cfg.Datadog.Site + "/"},
+	})
+
+	// Datadog lowercases the tag values it imports
+	scope := strings.ToLower(fmt.Sprintf("environment:%s,service:%s", cfg.Environment, cfg.Tags.Service))
+	tags := []string{}
+	for i, value := range []string{cfg.Environment, cfg.Tags.Service, cfg.Tags.Owner, cfg.Tags.CostCenter} {
+		tags = append(tags, costAllocationTags[i]+":"+value)
+	}
+	notify := strings.Join(cfg.Datadog.Notify, " ")
+	monitor := func(id string, name string, query string, message string, critical float64) {
+		providerResource(stack, id, "datadog_monitor", map[string]interface{}{
+			"name":                *cfg.Name(name),
+			"type":                "query alert",
+			"query":               query,
+			"message":             message + "\n\n" + notify,
+			"tags":                tags,
+			"notify_no_data":      false,
+			"require_full_window": false,
+			"monitor_thresholds":  map[string]float64{"critical": critical},
+		})
+	}
+
+	widgets := []map[string]interface{}{}
+	timeseries := func(title string, queries ...string) {
+		requests := []map[string]interface{}{}
+		for _, q := range queries {
+			requests = append(requests, map[string]interface{}{"q": q, "display_type": "line"})
+		}
+		widgets = append(widgets, map[string]interface{}{
+			"definition": map[string]interface{}{"type": "timeseries", "title": title, "requests": requests},
+		})
+	}
+
+	for _, svc := range services {
+		id := strings.ToUpper(svc.Name[:1]) + svc.Name[1:]
+		filter := fmt.Sprintf("%s,clustername:%s,servicename:%s", scope, cfg.ClusterName(), *cfg.Name(svc.Name))
+		monitor("Datadog"+id+"TasksMonitor", svc.Name+"-tasks-below-desired",
+			fmt.Sprintf("min(last_10m):avg:aws.ecs.service.desired{%[1]s} - avg:aws.ecs.service.running{%[1]s} > 0", filter),
+			svc.Name+" of "+cfg.Environment+" is running fewer tasks than desired", 0)
+		timeseries(svc.Name+" CPU and memory %",
+			fmt.Sprintf("avg:aws.ecs.service.cpuutilization{%s}", filter),
+			fmt.Sprintf("avg:aws.ecs.service.memory_utilization{%s}", filter))
+		timeseries(svc.Name+" tasks",
+			fmt.Sprintf("avg:aws.ecs.service.running{%s}", filter),
+			fmt.Sprintf("avg:aws.ecs.service.desired{%s}", filter))
+	}
+
+	if alb != nil {
+		filter := scope + ",name:" + *alb.Name()
+		monitor("DatadogLatencyMonitor", "app-response-time",
+			fmt.Sprintf("avg(last_10m):avg:aws.applicationelb.target_response_time.p95{%s} > %v", filter, cfg.Alerting.ResponseTimeSeconds),
+			"p95 app response time of "+cfg.Environment+" is high", cfg.Alerting.ResponseTimeSeconds)
+		timeseries("ALB p95 response time (s)", fmt.Sprintf("avg:aws.applicationelb.target_response_time.p95{%s}", filter))
+		timeseries("ALB requests and 5xx",
+			fmt.Sprintf("sum:aws.applicationelb.request_count{%s}.as_count()", filter),
+			fmt.Sprintf("sum:aws.applicationelb.httpcode_target_5xx{%s}.as_count()", filter))
+	}
+
+	if cfg.Observability.XTDBMetrics {
+		filter := "clustername:" + strings.ToLower(cfg.ClusterName())
+		monitor("DatadogXTDBLagMonitor", "xtdb-lag",
+			fmt.Sprintf("avg(last_15m):max:%s{%s} > %v", datadogXTDBLagMetric, filter, cfg.Datadog.XTDBLagThreshold),
+			"XTDB of "+cfg.Environment+" is falling behind its transaction log", cfg.Datadog.XTDBLagThreshold)
+		timeseries("XTDB indexer lag", fmt.Sprintf("max:%s{%s}", datadogXTDBLagMetric, filter))
+	}
+
+	dashboard, _ := json.Marshal(map[string]interface{}{
+		"title":       *cfg.Name("overview"),
+		"description": "Services of " + cfg.Environment + ", managed by CDKTF",
+		"layout_type": "ordered",
+		"widgets":     widgets,
+	})
+	providerResource(stack, "DatadogDashboard", "datadog_dashboard_json", map[string]interface{}{
+		"dashboard": string(dashboard),
+	})
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/aws/jsii-runtime-go"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/kmskey"
+	"github.com/hashicorp/terraform-cdk-go/cdktf"
+)
+
+// deployEvents maps the ECS deployment events to how they read in Slack.
+// A failed deployment is rolled back by the circuit breaker.
+var deployEvents = []struct{ id, event, emoji, verb string }{
+	{"Started", "SERVICE_DEPLOYMENT_IN_PROGRESS", ":rocket:", "started"},
+	{"Succeeded", "SERVICE_DEPLOYMENT_COMPLETED", ":white_check_mark:", "succeeded"},
+	{"RolledBack", "SERVICE_DEPLOYMENT_FAILED", ":rewind:", "rolled back"},
+}
+
+// NewDeployNotifications posts when a deployment of each service starts,
+// succeeds or is rolled back to the deploy Slack channel, through AWS
+// Chatbot custom notifications. Nothing is created without a deploy channel.
+func NewDeployNotifications(stack cdktf.TerraformStack, cfg StackConfig, key kmskey.KmsKey, services []MonitoredService) {
+	if cfg.Alerting.DeploySlackChannelID == "" {
+		return
+	}
+
+	topic := newTopic(stack, "DeployTopic", cfg.Name("deploys"), key, "events.amazonaws.com")
+	newSlackChannel(stack, cfg, "DeploySlackChannel", "deploys", cfg.Alerting.DeploySlackChannelID, topic.Arn())
+
+	for _, svc := range services {
+		id := strings.ToUpper(svc.Name[:1]) + svc.Name[1:]
+		for _, e := range deployEvents {
+			rule := newEventRule(stack, id+"Deploy"+e.id, cfg.Name(svc.Name+"-deploy-"+strings.ReplaceAll(e.verb, " ", "-")),
+				"Posts to Slack when a deployment of "+svc.Name+" "+e.verb, map[string]interface{}{
+					"source":      []string{"aws.ecs"},
+					"detail-type": []string{"ECS Deployment State Change"},
+					// An ECS service's id is its ARN
+					"resources": []*string{svc.Service.Id()},
+					"detail":    map[string]interface{}{"eventName": []string{e.event}},
+				})
+			rule.Notify("Slack", topic.Arn(), map[string]interface{}{
+				"version": "1.0",
+				"source":  "custom",
+				"content": map[string]interface{}{
+					"textType":    "client-markdown",
+					"title":       e.emoji + " " + svc.Name + " deploy " + e.verb + " in " + cfg.Environment,
+					"description": "Deployment `<deploymentId>`: <reason>",
+				},
+			}, map[string]*string{
+				"deploymentId": jsii.String("$.detail.deploymentId"),
+				"reason":       jsii.String("$.detail.reason"),
+			})
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"github.com/aws/jsii-runtime-go"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/ecsservice"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/servicediscoveryprivatednsnamespace"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/servicediscoveryservice"
+	"github.com/hashicorp/terraform-cdk-go/cdktf"
+)
+
+// discoveryDomain is the private DNS zone the services register in outside
+// the mesh; the app and the admin tools reach XTDB as xtdb-service.local
+const discoveryDomain = "local"
+
+// ServiceDiscovery is the Cloud Map namespace the services register their
+// tasks in when they are not in the mesh
+type ServiceDiscovery struct {
+	Namespace servicediscoveryprivatednsnamespace.ServiceDiscoveryPrivateDnsNamespace
+	stack     cdktf.TerraformStack
+}
+
+// NewServiceDiscovery creates the private DNS namespace in the VPC
+func NewServiceDiscovery(stack cdktf.TerraformStack, cfg StackConfig, vpc *Vpc) *ServiceDiscovery {
+	namespace := servicediscoveryprivatednsnamespace.NewServiceDiscoveryPrivateDnsNamespace(stack, jsii.String("DiscoveryNamespace"), &servicediscoveryprivatednsnamespace.ServiceDiscoveryPrivateDnsNamespaceConfig{
+		Name:        jsii.String(discoveryDomain),
+		Description: jsii.String("Service discovery namespace of " + cfg.NamePrefix),
+		Vpc:         vpc.ID,
+	})
+	return &ServiceDiscovery{Namespace: namespace, stack: stack}
+}
+
+// Register publishes an A record per running task of the service as
+// name.local. ECS reports the tasks' health, so a stopped task drops out of
+// the answer without a Route53 health check.
+func (d *ServiceDiscovery) Register(id string, service ecsservice.EcsService, name string) {
+	registry := servicediscoveryservice.NewServiceDiscoveryService(d.stack, jsii.String(id), &servicediscoveryservice.ServiceDiscoveryServiceConfig{
+		Name: jsii.String(name),
+		DnsConfig: &servicediscoveryservice.ServiceDiscoveryServiceDnsConfig{
+			NamespaceId:   d.Namespace.Id(),
+			RoutingPolicy: jsii.String("MULTIVALUE"),
+			DnsRecords: &[]*servicediscoveryservice.ServiceDiscoveryServiceDnsConfigDnsRecords{
+				{Type: jsii.String("A"), Ttl: jsii.Number(10)},
+			},
+		},
+		HealthCheckCustomConfig: &servicediscoveryservice.ServiceDiscoveryServiceHealthCheckCustomConfig{FailureThreshold: jsii.Number(1)},
+	})
+	service.PutServiceRegistries(&ecsservice.EcsServiceServiceRegistries{RegistryArn: registry.Arn()})
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/aws/constructs-go/constructs/v10"
+	"github.com/aws/jsii-runtime-go"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/eksaccessentry"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/eksaccesspolicyassociation"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/eksaddon"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/ekscluster"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/eksnodegroup"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/ekspodidentityassociation"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/iamrole"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/kmskey"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/vpcsecuritygroupingressrule"
+	"github.com/hashicorp/terraform-cdk-go/cdktf"
+)
+
+// eksNamespace is the Kubernetes namespace the services are installed in
+const eksNamespace = resourcePrefix
+
+// EksStack is the Kubernetes alternative to the app stack: the images, the
+// cluster and the identities of the services' pods
+type EksStack struct {
+	Stack       cdktf.TerraformStack
+	ClusterName *string
+}
+
+// NewEksStack creates the eks layer of an environment. It creates the same
+// image repositories as the app stack, creates a cluster unless an existing one
+// is configured, and gives the xtdb and app service accounts roles through
+// EKS Pod Identity. Everything the Helm release needs is in its helm_values
+// output.
+func NewEksStack(scope constructs.Construct, cfg StackConfig, network *NetworkStack, data *DataStack) *EksStack {
+	stack := newEnvironmentStack(scope, cfg, LayerEks)
+	stack.AddDependency(data.Stack)
+	key, storage := data.Key, data.Storage
+
+	// CI pushes both images, tagged alike
+	xtdbRepo := newRepository(stack, cfg, key, "XTDBRepo", "xtdb", cfg.Existing.XTDBRepository)
+	appRepo := NewAppRepository(stack, cfg, key)
+
+	clusterName := jsii.String(cfg.Eks.ClusterName)
+	if cfg.Eks.ClusterName == "" {
+		clusterName = newEksCluster(stack, cfg, key, network)
+	}
+
+	// Let the listed roles run helm and kubectl against the cluster
+	for i, arn := range cfg.Eks.AdminRoleArns {
+		entry := eksaccessentry.NewEksAccessEntry(stack, jsii.String(fmt.Sprint("EksAdmin", i)), &eksaccessentry.EksAccessEntryConfig{
+			ClusterName:  clusterName,
+			PrincipalArn: jsii.String(arn),
+		})
+		eksaccesspolicyassociation.NewEksAccessPolicyAssociation(stack, jsii.String(fmt.Sprint("EksAdminPolicy", i)), &eksaccesspolicyassociation.EksAccessPolicyAssociationConfig{
+			ClusterName:  clusterName,
+			PrincipalArn: entry.PrincipalArn(),
+			PolicyArn:    jsii.String("arn:aws:eks::aws:cluster-access-policy/AmazonEKSClusterAdminPolicy"),
+			AccessScope:  &eksaccesspolicyassociation.EksAccessPolicyAssociationAccessScope{Type: jsii.String("cluster")},
+		})
+	}
+
+	xtdbRole := newPodRole(stack, cfg, clusterName, "XTDB", "xtdb")
+	storage.GrantReadWrite(&ServiceRoles{TaskRole: xtdbRole})
+	grantSecretRead(xtdbRole, "Credentials", data.Credentials.Arn(), key)
+	appRole := newPodRole(stack, cfg, clusterName, "App", "app")
+	grantSecretRead(appRole, "Credentials", data.Credentials.Arn(), key)
+
+	values := map[string]interface{}{
+		"xtdb": map[string]interface{}{
+			"image":          map[string]interface{}{"repository": xtdbRepo.RepositoryUrl(), "tag": cfg.Registry.ImageTag},
+			"serviceAccount": "xtdb",
+			"efs": map[string]interface{}{
+				"fileSystemId":  storage.FileSystem.Id(),
+				"accessPointId": storage.AccessPoint.Id(),
+			},
+		},
+		"app": map[string]interface{}{
+			"image":          map[string]interface{}{"repository": appRepo.RepositoryUrl(), "tag": cfg.Registry.ImageTag},
+			"serviceAccount": "app",
+			"settings":       cfg.AppSettings,
+		},
+		"credentialsSecretArn": data.Credentials.Arn(),
+	}
+	if storage.ObjectStore != nil {
+		values["xtdb"].(map[string]interface{})["bucket"] = storage.ObjectStore.Bucket()
+	}
+
+	output(stack, "eks_cluster_name", clusterName, "EKS cluster running the services")
+	output(stack, "eks_namespace", jsii.String(eksNamespace), "Namespace to install the Helm release in")
+	output(stack, "eks_kubeconfig_command", cdktf.Fn_Join(jsii.String(" "), &[]*string{
+		jsii.String("aws eks update-kubeconfig --region " + cfg.Region + " --name"), clusterName,
+	}), "Points kubectl and helm at the cluster")
+	output(stack, "helm_values", cdktf.Fn_Jsonencode(values), "Values for the services' Helm release")
+
+	return &EksStack{Stack: stack, ClusterName: clusterName}
+}
+
+// newEksCluster creates a cluster in the private subnets with its secrets
+// encrypted by the stack key, a managed node group, and the Pod Identity and
+// EFS CSI add-ons the services' pods need. It returns the cluster name.
+func newEksCluster(stack cdktf.TerraformStack, cfg StackConfig, key kmskey.KmsKey, network *NetworkStack) *string {
+	subnets := network.Vpc.PrivateSubnetIDs
+
+	clusterRole := newRole(stack, "EksClusterRole", cfg.Name("eks-cluster"), "Manages the EKS cluster", servicePrincipal("eks.amazonaws.com"), nil)
+	clusterRole.Attach("Cluster", jsii.String("arn:aws:iam::aws:policy/AmazonEKSClusterPolicy"))
+	allowStackKey(clusterRole, key, "kms:Encrypt", "kms:Decrypt", "kms:DescribeKey", "kms:CreateGrant")
+
+	cluster := ekscluster.NewEksCluster(stack, jsii.String("EksCluster"), &ekscluster.EksClusterConfig{
+		Name:    jsii.String(cfg.NamePrefix),
+		Version: jsii.String(cfg.Eks.Version),
+		RoleArn: clusterRole.Arn(),
+		VpcConfig: &ekscluster.EksClusterVpcConfig{
+			SubnetIds:             subnets,
+			EndpointPrivateAccess: jsii.Bool(true),
+			EndpointPublicAccess:  jsii.Bool(true),
+		},
+		AccessConfig: &ekscluster.EksClusterAccessConfig{
+			AuthenticationMode:                      jsii.String("API"),
+			BootstrapClusterCreatorAdminPermissions: jsii.Bool(true),
+		},
+		EncryptionConfig: &ekscluster.EksClusterEncryptionConfig{
+			Provider:  &ekscluster.EksClusterEncryptionConfigProvider{KeyArn: key.Arn()},
+			Resources: jsii.Strings("secrets"),
+		},
+	})
+
+	nodeRole := newRole(stack, "EksNodeRole", cfg.Name("eks-node"), "Runs the EKS nodes", servicePrincipal("ec2.amazonaws.com"), nil)
+	for _, policy := range []string{"AmazonEKSWorkerNodePolicy", "AmazonEKS_CNI_Policy", "AmazonEC2ContainerRegistryReadOnly"} {
+		nodeRole.Attach(policy, jsii.String("arn:aws:iam::aws:policy/"+policy))
+	}
+	// The app repository is encrypted with the stack key
+	allowStackKey(nodeRole, key, "kms:Decrypt")
+
+	amiType, instanceType := "AL2023_x86_64_STANDARD", "t3.medium"
+	if cfg.CpuArchitecture == ArchArm64 {
+		amiType, instanceType = "AL2023_ARM_64_STANDARD", "t4g.medium"
+	}
+	if cfg.Eks.InstanceType != "" {
+		instanceType = cfg.Eks.InstanceType
+	}
+	eksnodegroup.NewEksNodeGroup(stack, jsii.String("EksNodes"), &eksnodegroup.EksNodeGroupConfig{
+		ClusterName:   cluster.Name(),
+		NodeGroupName: cfg.Name("nodes"),
+		NodeRoleArn:   nodeRole.Arn(),
+		SubnetIds:     subnets,
+		AmiType:       jsii.String(amiType),
+		InstanceTypes: jsii.Strings(instanceType),
+		ScalingConfig: &eksnodegroup.EksNodeGroupScalingConfig{
+			MinSize:     jsii.Number(cfg.Eks.NodeCount),
+			DesiredSize: jsii.Number(cfg.Eks.NodeCount),
+			MaxSize:     jsii.Number(cfg.Eks.NodeCount * 2),
+		},
+	})
+
+	eksaddon.NewEksAddon(stack, jsii.String("PodIdentityAddon"), &eksaddon.EksAddonConfig{
+		ClusterName: cluster.Name(),
+		AddonName:   jsii.String("eks-pod-identity-agent"),
+	})
+	efsCsiRole := newPodIdentityRole(stack, "EfsCsiRole", cfg.Name("eks-efs-csi"), "Mounts EFS volumes for the EKS pods")
+	efsCsiRole.Attach("EfsCsi", jsii.String("arn:aws:iam::aws:policy/service-role/AmazonEFSCSIDriverPolicy"))
+	eksaddon.NewEksAddon(stack, jsii.String("EfsCsiAddon"), &eksaddon.EksAddonConfig{
+		ClusterName: cluster.Name(),
+		AddonName:   jsii.String("aws-efs-csi-driver"),
+		PodIdentityAssociation: &[]*eksaddon.EksAddonPodIdentityAssociation{{
+			RoleArn:        efsCsiRole.Arn(),
+			ServiceAccount: jsii.String("efs-csi-controller-sa"),
+		}},
+	})
+
+	// Nodes are in the cluster security group; the EFS group is in the
+	// network stack, so the rule is added from here
+	vpcsecuritygroupingressrule.NewVpcSecurityGroupIngressRule(stack, jsii.String("EfsFromEks"), &vpcsecuritygroupingressrule.VpcSecurityGroupIngressRuleConfig{
+		SecurityGroupId:           network.SecurityGroups.Efs.Id(),
+		ReferencedSecurityGroupId: cluster.VpcConfig().ClusterSecurityGroupId(),
+		IpProtocol:                jsii.String("tcp"),
+		FromPort:                  jsii.Number(2049),
+		ToPort:                    jsii.Number(2049),
+		Description:               jsii.String("NFS from the EKS nodes"),
+	})
+
+	return cluster.Name()
+}
+
+// newPodIdentityRole creates a role EKS Pod Identity can hand to pods, which
+// tags the sessions it opens
+func newPodIdentityRole(stack cdktf.TerraformStack, id string, name *string, description string) *Role {
+	role := iamrole.NewIamRole(stack, jsii.String(id), &iamrole.IamRoleConfig{
+		Name:        name,
+		Description: jsii.String(description),
+		AssumeRolePolicy: policyDocument(statement{
+			Effect:    "Allow",
+			Principal: servicePrincipal("pods.eks.amazonaws.com"),
+			Action:    []string{"sts:AssumeRole", "sts:TagSession"},
+		}),
+	})
+	return &Role{IamRole: role, stack: stack, id: id}
+}
+
+// newPodRole creates the role a service account assumes through EKS Pod
+// Identity. It is named like the ECS task roles, so the stack key allows it.
+func newPodRole(stack cdktf.TerraformStack, cfg StackConfig, clusterName *string, id string, name string) *Role {
+	role := newPodIdentityRole(stack, id+"PodRole", cfg.Name(name+"-task"), "Runs the "+name+" pods")
+	ekspodidentityassociation.NewEksPodIdentityAssociation(stack, jsii.String(id+"PodIdentity"), &ekspodidentityassociation.EksPodIdentityAssociationConfig{
+		ClusterName:    clusterName,
+		Namespace:      jsii.String(eksNamespace),
+		ServiceAccount: jsii.String(name),
+		RoleArn:        role.Arn(),
+	})
+	return role
+}
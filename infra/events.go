@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/aws/jsii-runtime-go"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/cloudwatcheventrule"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/cloudwatcheventtarget"
+	"github.com/hashicorp/terraform-cdk-go/cdktf"
+)
+
+// EventRule is an EventBridge rule on the default bus that targets are
+// added to
+type EventRule struct {
+	cloudwatcheventrule.CloudwatchEventRule
+	stack cdktf.TerraformStack
+	id    string
+}
+
+// newEventRule matches events against the pattern, which may hold tokens
+func newEventRule(stack cdktf.TerraformStack, id string, name *string, description string, pattern map[string]interface{}) *EventRule {
+	document, err := json.Marshal(pattern)
+	if err != nil {
+		panic(err)
+	}
+	rule := cloudwatcheventrule.NewCloudwatchEventRule(stack, jsii.String(id), &cloudwatcheventrule.CloudwatchEventRuleConfig{
+		Name:         name,
+		Description:  jsii.String(description),
+		EventPattern: jsii.String(string(document)),
+	})
+	return &EventRule{CloudwatchEventRule: rule, stack: stack, id: id}
+}
+
+// newScheduledRule fires on the schedule expression, a cron(...) or rate(...)
+func newScheduledRule(stack cdktf.TerraformStack, id string, name *string, description string, schedule string) *EventRule {
+	rule := cloudwatcheventrule.NewCloudwatchEventRule(stack, jsii.String(id), &cloudwatcheventrule.CloudwatchEventRuleConfig{
+		Name:               name,
+		Description:        jsii.String(description),
+		ScheduleExpression: jsii.String(schedule),
+	})
+	return &EventRule{CloudwatchEventRule: rule, stack: stack, id: id}
+}
+
+// AddTarget sends matching events to the target, which names the rule
+func (r *EventRule) AddTarget(id string, target *cloudwatcheventtarget.CloudwatchEventTargetConfig) cloudwatcheventtarget.CloudwatchEventTarget {
+	target.Rule = r.Name()
+	return cloudwatcheventtarget.NewCloudwatchEventTarget(r.stack, jsii.String(r.id+id), target)
+}
+
+// Notify publishes the message to the topic for each matching event, as
+// plain text for a string and as JSON otherwise. The message refers to the
+// event fields in paths as <name>; the topic has to let EventBridge publish
+// to it.
+func (r *EventRule) Notify(id string, topicArn *string, message interface{}, paths map[string]*string) {
+	// Keep the <name> placeholders readable to EventBridge
+	var template bytes.Buffer
+	encoder := json.NewEncoder(&template)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(message); err != nil {
+		panic(err)
+	}
+	rendered := jsii.String(strings.TrimSpace(template.String()))
+	target := &cloudwatcheventtarget.CloudwatchEventTargetConfig{Arn: topicArn}
+	if len(paths) > 0 {
+		target.InputTransformer = &cloudwatcheventtarget.CloudwatchEventTargetInputTransformer{
+			InputPaths:    &paths,
+			InputTemplate: rendered,
+		}
+	} else {
+		target.Input = rendered
+	}
+	r.AddTarget(id, target)
+}
+
+// Invoke calls the function with each matching event
+func (r *EventRule) Invoke(id string, fn *Function) {
+	r.AddTarget(id, &cloudwatcheventtarget.CloudwatchEventTargetConfig{Arn: fn.Arn()})
+	fn.AllowInvoke(r.id+id, "events.amazonaws.com", r.Arn())
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/aws/jsii-runtime-go"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/cloudwatchloggroup"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/ecscluster"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/kmskey"
+	"github.com/hashicorp/terraform-cdk-go/cdktf"
+)
+
+// execCommandConfiguration encrypts ECS Exec sessions with the stack key and
+// records everything typed in them to an encrypted audit log group. The
+// task roles of services with exec enabled need the SSM and logging
+// permissions this takes, see allowExec.
+func execCommandConfiguration(stack cdktf.TerraformStack, cfg StackConfig, key kmskey.KmsKey) *ecscluster.EcsClusterConfigurationExecuteCommandConfiguration {
+	logGroup := cloudwatchloggroup.NewCloudwatchLogGroup(stack, jsii.String("ExecLogGroup"), &cloudwatchloggroup.CloudwatchLogGroupConfig{
+		Name:            jsii.String("/ecs/" + *cfg.Name("exec")),
+		RetentionInDays: logRetention(cfg),
+		KmsKeyId:        key.Arn(),
+	})
+	return &ecscluster.EcsClusterConfigurationExecuteCommandConfiguration{
+		KmsKeyId: key.Arn(),
+		Logging:  jsii.String("OVERRIDE"),
+		LogConfiguration: &ecscluster.EcsClusterConfigurationExecuteCommandConfigurationLogConfiguration{
+			CloudWatchLogGroupName:      logGroup.Name(),
+			CloudWatchEncryptionEnabled: jsii.Bool(true),
+		},
+	}
+}
+
+// NewExecOutputs outputs, per service, the command that opens a shell in
+// one of its tasks through the CI module's EcsExec function
+func NewExecOutputs(stack cdktf.TerraformStack, cfg StackConfig, services []MonitoredService) {
+	for _, svc := range services {
+		cdktf.NewTerraformOutput(stack, jsii.String(svc.Name+"_exec_command"), &cdktf.TerraformOutputConfig{
+			Value: jsii.String(fmt.Sprintf(
+				"dagger call ecs-exec --cluster %s --service %s --region %s --aws-creds file:$HOME/.aws/credentials",
+				cfg.ClusterName(), *cfg.Name(svc.Name), cfg.Region,
+			)),
+			Description: jsii.String("Opens a shell in a running " + svc.Name + " task"),
+		})
+	}
+}
+
+// allowExec lets a service's tasks open the SSM channel ECS Exec sessions
+// run over and, in a cluster the stack created, record the sessions to its
+// exec log group
+func allowExec(cfg StackConfig, roles *ServiceRoles, cluster *Cluster) {
+	statements := []statement{
+		allow([]string{"ssmmessages:CreateControlChannel", "ssmmessages:CreateDataChannel", "ssmmessages:OpenControlChannel", "ssmmessages:OpenDataChannel"}, jsii.String("*")),
+	}
+	if cluster.execKey != nil {
+		statements = append(statements,
+			allow([]string{"logs:DescribeLogGroups"}, jsii.String("*")),
+			allow([]string{"logs:CreateLogStream", "logs:DescribeLogStreams", "logs:PutLogEvents"}, jsii.String("arn:aws:logs:"+cfg.Region+":*:log-group:/ecs/"+*cfg.Name("exec")+":*")),
+			allow([]string{"kms:Decrypt", "kms:GenerateDataKey"}, cluster.execKey.Arn()),
+		)
+	}
+	roles.TaskRole.Grant("Exec", statements...)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"github.com/aws/jsii-runtime-go"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/dataawsroute53zone"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/lb"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/route53healthcheck"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/route53record"
+	"github.com/hashicorp/terraform-cdk-go/cdktf"
+)
+
+// newFailoverRecord aliases the domain to this region's ALB as one half of
+// a failover pair. Route53 checks the primary's ALB directly and answers
+// with the standby's ALB while that check fails.
+func newFailoverRecord(stack cdktf.TerraformStack, cfg StackConfig, zone dataawsroute53zone.DataAwsRoute53Zone, alb lb.Lb) {
+	record := &route53record.Route53RecordConfig{
+		ZoneId:                zone.ZoneId(),
+		Name:                  jsii.String(cfg.Domain.DomainName),
+		Type:                  jsii.String("A"),
+		SetIdentifier:         jsii.String(cfg.Region),
+		FailoverRoutingPolicy: &route53record.Route53RecordFailoverRoutingPolicy{Type: jsii.String("SECONDARY")},
+		Alias: &route53record.Route53RecordAlias{
+			Name:                 alb.DnsName(),
+			ZoneId:               alb.ZoneId(),
+			EvaluateTargetHealth: jsii.Bool(true),
+		},
+	}
+
+	if !cfg.Failover.Secondary {
+		// Route53 does not validate certificates, so the ALB is checked by its own name
+		check := route53healthcheck.NewRoute53HealthCheck(stack, jsii.String("AppHealthCheck"), &route53healthcheck.Route53HealthCheckConfig{
+			Type:             jsii.String("HTTPS"),
+			Fqdn:             alb.DnsName(),
+			Port:             jsii.Number(443),
+			ResourcePath:     jsii.String(cfg.Domain.HealthCheckPath),
+			RequestInterval:  jsii.Number(30),
+			FailureThreshold: jsii.Number(3),
+			Tags:             &map[string]*string{"Name": cfg.Name("alb")},
+		})
+		record.FailoverRoutingPolicy = &route53record.Route53RecordFailoverRoutingPolicy{Type: jsii.String("PRIMARY")}
+		record.HealthCheckId = check.Id()
+	}
+	route53record.NewRoute53Record(stack, jsii.String("AppFailoverRecord"), record)
+}
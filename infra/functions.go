@@ -0,0 +1,76 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/aws/jsii-runtime-go"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/lambdafunction"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/lambdapermission"
+	"github.com/hashicorp/terraform-cdk-go/cdktf"
+)
+
+// Function is a Lambda function and the role it runs as
+type Function struct {
+	lambdafunction.LambdaFunction
+	Role  *Role
+	stack cdktf.TerraformStack
+	id    string
+}
+
+// inlineArchive zips the files, keyed by their path in the archive, when
+// Terraform plans the stack. The scripts stay in the Go source like they
+// did with the CDK's inline code, and a changed script changes the hash.
+func inlineArchive(stack cdktf.TerraformStack, id string, files map[string]string) cdktf.TerraformDataSource {
+	requireProvider(stack, "archive", "hashicorp/archive", "~> 2.7")
+	archive := cdktf.NewTerraformDataSource(stack, jsii.String(id), &cdktf.TerraformResourceConfig{
+		TerraformResourceType: jsii.String("archive_file"),
+	})
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sources []map[string]string
+	for _, name := range names {
+		sources = append(sources, map[string]string{"filename": name, "content": files[name]})
+	}
+	archive.AddOverride(jsii.String("type"), "zip")
+	archive.AddOverride(jsii.String("output_path"), "${path.module}/"+id+".zip")
+	archive.AddOverride(jsii.String("source"), sources)
+	return archive
+}
+
+// newPythonFunction runs the script's handler on Python 3.12 as a role that
+// may only write its logs; callers grant it whatever else it touches
+func newPythonFunction(stack cdktf.TerraformStack, cfg StackConfig, id string, name string, description string, script string, environment map[string]*string) *Function {
+	role := newRole(stack, id+"Role", cfg.Name(name), description, servicePrincipal("lambda.amazonaws.com"), nil)
+	role.Attach("Logs", jsii.String("arn:aws:iam::aws:policy/service-role/AWSLambdaBasicExecutionRole"))
+
+	code := inlineArchive(stack, id+"Code", map[string]string{"index.py": script})
+	config := &lambdafunction.LambdaFunctionConfig{
+		FunctionName:   cfg.Name(name),
+		Description:    jsii.String(description),
+		Role:           role.Arn(),
+		Runtime:        jsii.String("python3.12"),
+		Handler:        jsii.String("index.handler"),
+		Filename:       code.GetStringAttribute(jsii.String("output_path")),
+		SourceCodeHash: code.GetStringAttribute(jsii.String("output_base64sha256")),
+		Timeout:        jsii.Number(30),
+	}
+	if len(environment) > 0 {
+		config.Environment = &lambdafunction.LambdaFunctionEnvironment{Variables: &environment}
+	}
+	fn := lambdafunction.NewLambdaFunction(stack, jsii.String(id), config)
+	return &Function{LambdaFunction: fn, Role: role, stack: stack, id: id}
+}
+
+// AllowInvoke lets the service principal invoke the function on behalf of
+// the source resource
+func (f *Function) AllowInvoke(id string, principal string, sourceArn *string) lambdapermission.LambdaPermission {
+	return lambdapermission.NewLambdaPermission(f.stack, jsii.String(f.id+id), &lambdapermission.LambdaPermissionConfig{
+		Action:       jsii.String("lambda:InvokeFunction"),
+		FunctionName: f.FunctionName(),
+		Principal:    jsii.String(principal),
+		SourceArn:    sourceArn,
+	})
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/constructs-go/constructs/v10"
+	"github.com/aws/jsii-runtime-go"
+	"github.com/hashicorp/terraform-cdk-go/cdktf"
+)
+
+// Cloud providers an environment can be deployed to
+const (
+	CloudAws = "aws"
+	CloudGcp = "gcp"
+)
+
+// gcpApis are the Google APIs the gcp stack uses
+var gcpApis = []string{
+	"artifactregistry.googleapis.com",
+	"compute.googleapis.com",
+	"redis.googleapis.com",
+	"run.googleapis.com",
+	"secretmanager.googleapis.com",
+	"servicenetworking.googleapis.com",
+	"sqladmin.googleapis.com",
+}
+
+// gcpLabelValue matches the characters Google does not allow in label values
+var gcpLabelValue = regexp.MustCompile(`[^a-z0-9_-]`)
+
+// gcpStack collects the resources of the gcp stack
+type gcpStack struct {
+	stack  cdktf.TerraformStack
+	cfg    StackConfig
+	labels map[string]string
+	apis   []string
+}
+
+// resource declares a Google resource with its attributes. Everything waits
+// for the APIs, which are enabled by the stack itself.
+func (g *gcpStack) resource(id string, resourceType string, attributes map[string]interface{}) cdktf.TerraformResource {
+	r := providerResource(g.stack, id, resourceType, attributes)
+	if resourceType != "google_project_service" && len(g.apis) > 0 {
+		r.AddOverride(jsii.String("depends_on"), g.apis)
+	}
+	return r
+}
+
+// secret stores a value in Secret Manager and returns the secret's id
+func (g *gcpStack) secret(id string, name string, value *string) *string {
+	secret := g.resource(id, "google_secret_manager_secret", map[string]interface{}{
+		"secret_id":   *g.cfg.Name(name),
+		"labels":      g.labels,
+		"replication": map[string]interface{}{"auto": map[string]interface{}{}},
+	})
+	g.resource(id+"Version", "google_secret_manager_secret_version", map[string]interface{}{
+		"secret":      secret.GetStringAttribute(jsii.String("id")),
+		"secret_data": value,
+	})
+	return secret.GetStringAttribute(jsii.String("secret_id"))
+}
+
+// grantSecret lets member read the secret's versions
+func (g *gcpStack) grantSecret(id string, secret *string, member string) {
+	g.resource(id, "google_secret_manager_secret_iam_member", map[string]interface{}{
+		"secret_id": secret,
+		"role":      "roles/secretmanager.secretAccessor",
+		"member":    member,
+	})
+}
+
+// NewGcpStack creates an environment on GCP for the product lines that must
+// run there. It is the whole environment in one stack, with the same shape
+// as the AWS layers:
+//   - a VPC with a private subnet, which Cloud Run reaches through direct VPC egress
+//   - an Artifact Registry repository CI pushes the images to, tagged gcp.imageTag
+//   - XTDB on Cloud Run, internal only, with its object store in GCS and its
+//     local transaction log on a GCS volume
+//   - the app on Cloud Run, public, scaled between the scaling capacities
+//   - Cloud SQL as XTDB's backing store and Memorystore for the app when
+//     the database and cache are enabled
+//
+// Its state stays in the environment's S3 backend.
+func NewGcpStack(scope constructs.Construct, cfg StackConfig) cdktf.TerraformStack {
+	stack := cdktf.NewTerraformStack(scope, jsii.String(cfg.LayerStackID(LayerGcp)))
+	configureBackend(stack, cfg, LayerGcp)
+	requireProvider(stack, "google", "hashicorp/google", "~> 6.0")
+	stack.AddOverride(jsii.String("provider.google"), []map[string]string{
+		{"project": cfg.Gcp.Project, "region": cfg.Gcp.Region},
+	})
+
+	g := &gcpStack{stack: stack, cfg: cfg, labels: gcpLabels(cfg)}
+	var apis []string
+	for _, api := range gcpApis {
+		name := strings.Split(api, ".")[0]
+		id := "Api" + strings.ToUpper(name[:1]) + name[1:]
+		apis = append(apis, *g.resource(id, "google_project_service", map[string]interface{}{
+			"service":            api,
+			"disable_on_destroy": false,
+		}).Fqn())
+	}
+	g.apis = apis
+
+	// Cloud Run, Cloud SQL and Memorystore all reach each other through the VPC
+	network := g.resource("Network", "google_compute_network", map[string]interface{}{
+		"name":                    cfg.NamePrefix,
+		"auto_create_subnetworks": false,
+	})
+	subnet := g.resource("PrivateSubnet", "google_compute_subnetwork", map[string]interface{}{
+		"name":                     *cfg.Name("private"),
+		"network":                  network.GetStringAttribute(jsii.String("id")),
+		"ip_cidr_range":            cfg.Network.Cidr,
+		"private_ip_google_access": true,
+	})
+	networkID := network.GetStringAttribute(jsii.String("id"))
+
+	registry := g.resource("Registry", "google_artifact_registry_repository", map[string]interface{}{
+		"repository_id": cfg.NamePrefix,
+		"format":        "DOCKER",
+		"description":   "Images of " + cfg.NamePrefix,
+		"labels":        g.labels,
+		"docker_config": map[string]interface{}{"immutable_tags": true},
+	})
+	imageBase := fmt.Sprintf("%s-docker.pkg.dev/%s/%s/", cfg.Gcp.Region, cfg.Gcp.Project, cfg.NamePrefix)
+
+	xtdbAccount := g.resource("XTDBServiceAccount", "google_service_account", map[string]interface{}{
+		"account_id":   *cfg.Name("xtdb"),
+		"display_name": "Runs xtdb of " + cfg.Environment,
+	})
+	appAccount := g.resource("AppServiceAccount", "google_service_account", map[string]interface{}{
+		"account_id":   *cfg.Name("app"),
+		"display_name": "Runs the app of " + cfg.Environment,
+	})
+	xtdbMember := "serviceAccount:" + *xtdbAccount.GetStringAttribute(jsii.String("email"))
+	appMember := "serviceAccount:" + *appAccount.GetStringAttribute(jsii.String("email"))
+
+	// XTDB's object store, and the volume its local log and disk cache live on
+	objects := g.bucket("XTDBObjectStore", "xtdb-objects", xtdbMember)
+	volume := g.bucket("XTDBData", "xtdb-data", xtdbMember)
+
+	// The credentials XTDB and the app share
+	usernameSecret := g.secret("XTDBUsername", "xtdb-username", jsii.String(cfg.Secrets.DatabaseUsername))
+	passwordSecret := g.secret("XTDBPassword", "xtdb-password", randomPassword(g.stack, "XTDBPasswordValue"))
+	g.grantSecret("XTDBUsernameAccess", usernameSecret, xtdbMember)
+	g.grantSecret("XTDBPasswordAccess", passwordSecret, xtdbMember)
+	g.grantSecret("AppUsernameAccess", usernameSecret, appMember)
+	g.grantSecret("AppPasswordAccess", passwordSecret, appMember)
+
+	// Cloud Run mounts the node config from Secret Manager
+	xtdbConfig := g.secret("XTDBConfig", "xtdb-config", jsii.String(gcpNodeConfig(cfg, *objects.GetStringAttribute(jsii.String("name")))))
+	g.grantSecret("XTDBConfigAccess", xtdbConfig, xtdbMember)
+
+	xtdbEnv := []map[string]interface{}{
+		gcpSecretEnv("POSTGRES_USER", usernameSecret),
+		gcpSecretEnv("POSTGRES_PASSWORD", passwordSecret),
+	}
+	if cfg.Database.Enabled {
+		xtdbEnv = append(xtdbEnv, g.database(networkID, xtdbMember)...)
+	}
+
+	xtdb := g.resource("XTDBService", "google_cloud_run_v2_service", map[string]interface{}{
+		"name":                *cfg.Name("xtdb"),
+		"location":            cfg.Gcp.Region,
+		"ingress":             "INGRESS_TRAFFIC_INTERNAL_ONLY",
+		"deletion_protection": cfg.PreventDestroy,
+		"labels":              g.labels,
+		"template": map[string]interface{}{
+			"service_account":       xtdbAccount.GetStringAttribute(jsii.String("email")),
+			"execution_environment": "EXECUTION_ENVIRONMENT_GEN2", // GCS volumes need gen2
+			// The local log has a single writer
+			"scaling": map[string]interface{}{
+				"min_instance_count": 1,
+				"max_instance_count": 1,
+			},
+			"vpc_access": gcpVpcAccess(network, subnet),
+			"containers": []map[string]interface{}{{
+				"image": imageBase + "xtdb:" + cfg.Gcp.ImageTag,
+				"args":  []string{"-f", "/etc/xtdb/xtdb.yaml"},
+				"ports": map[string]interface{}{"container_port": 3000},
+				"env":   xtdbEnv,
+				"resources": map[string]interface{}{
+					"limits":   gcpLimits(cfg.XTDB),
+					"cpu_idle": false, // XTDB indexes in the background
+				},
+				"volume_mounts": []map[string]string{
+					{"name": "xtdb-data", "mount_path": xtdbDataDir},
+					{"name": "xtdb-config", "mount_path": "/etc/xtdb"},
+				},
+			}},
+			"volumes": []map[string]interface{}{
+				{"name": "xtdb-data", "gcs": map[string]interface{}{"bucket": volume.GetStringAttribute(jsii.String("name"))}},
+				{"name": "xtdb-config", "secret": map[string]interface{}{
+					"secret": xtdbConfig,
+					"items":  []map[string]string{{"version": "latest", "path": "xtdb.yaml"}},
+				}},
+			},
+		},
+	})
+	xtdbURL := xtdb.GetStringAttribute(jsii.String("uri"))
+	// Internal ingress is the boundary; the app does not sign its requests
+	g.resource("XTDBInvoker", "google_cloud_run_v2_service_iam_member", map[string]interface{}{
+		"name":     xtdb.GetStringAttribute(jsii.String("name")),
+		"location": cfg.Gcp.Region,
+		"role":     "roles/run.invoker",
+		"member":   "allUsers",
+	})
+
+	appEnv := []map[string]interface{}{
+		{"name": "XTDB_ADDR", "value": cdktf.Fn_Join(jsii.String(""), &[]*string{cdktf.Fn_Trimprefix(xtdbURL, jsii.String("https://")), jsii.String(":443")})},
+		gcpSecretEnv("XTDB_USERNAME", usernameSecret),
+		gcpSecretEnv("XTDB_PASSWORD", passwordSecret),
+	}
+	settings := make([]string, 0, len(cfg.AppSettings))
+	for name := range cfg.AppSettings {
+		settings = append(settings, name)
+	}
+	sort.Strings(settings)
+	for _, name := range settings {
+		appEnv = append(appEnv, map[string]interface{}{"name": name, "value": cfg.AppSettings[name]})
+	}
+	if cfg.Cache.Enabled {
+		appEnv = append(appEnv, g.cache(networkID, appMember)...)
+	}
+
+	app := g.resource("AppService", "google_cloud_run_v2_service", map[string]interface{}{
+		"name":                *cfg.Name("app"),
+		"location":            cfg.Gcp.Region,
+		"ingress":             "INGRESS_TRAFFIC_ALL",
+		"deletion_protection": cfg.PreventDestroy,
+		"labels":              g.labels,
+		"template": map[string]interface{}{
+			"service_account": appAccount.GetStringAttribute(jsii.String("email")),
+			"scaling": map[string]interface{}{
+				"min_instance_count": cfg.Scaling.MinCapacity,
+				"max_instance_count": cfg.Scaling.MaxCapacity,
+			},
+			"vpc_access": gcpVpcAccess(network, subnet),
+			"containers": []map[string]interface{}{{
+				"image":     imageBase + "app:" + cfg.Gcp.ImageTag,
+				"ports":     map[string]interface{}{"container_port": 58950},
+				"env":       appEnv,
+				"resources": map[string]interface{}{"limits": gcpLimits(cfg.App)},
+				"startup_probe": map[string]interface{}{
+					"http_get": map[string]interface{}{"path": cfg.Domain.HealthCheckPath},
+				},
+			}},
+		},
+	})
+	g.resource("AppInvoker", "google_cloud_run_v2_service_iam_member", map[string]interface{}{
+		"name":     app.GetStringAttribute(jsii.String("name")),
+		"location": cfg.Gcp.Region,
+		"role":     "roles/run.invoker",
+		"member":   "allUsers",
+	})
+
+	output(stack, "app_url", app.GetStringAttribute(jsii.String("uri")), "URL the app is served on")
+	output(stack, "xtdb_url", xtdbURL, "Internal URL of xtdb")
+	output(stack, "image_repository", jsii.String(imageBase[:len(imageBase)-1]), "Artifact Registry repository to push the app and xtdb images to")
+	output(stack, "registry_id", registry.GetStringAttribute(jsii.String("id")), "Artifact Registry repository of the images")
+	output(stack, "xtdb_bucket_name", objects.GetStringAttribute(jsii.String("name")), "GCS bucket of the XTDB object store")
+	return stack
+}
+
+// bucket creates a private, versioned bucket that member may read and write
+func (g *gcpStack) bucket(id string, name string, member string) cdktf.TerraformResource {
+	bucket := g.resource(id, "google_storage_bucket", map[string]interface{}{
+		"name":                        *g.cfg.Name(name) + "-" + g.cfg.Gcp.Project, // bucket names are global
+		"location":                    g.cfg.Gcp.Region,
+		"uniform_bucket_level_access": true,
+		"public_access_prevention":    "enforced",
+		"force_destroy":               !g.cfg.PreventDestroy,
+		"labels":                      g.labels,
+		"versioning":                  map[string]interface{}{"enabled": true},
+		"lifecycle_rule": []map[string]interface{}{{
+			"action":    map[string]string{"type": "Delete"},
+			"condition": map[string]interface{}{"days_since_noncurrent_time": g.cfg.Storage.NoncurrentVersionDays},
+		}},
+	})
+	g.resource(id+"Access", "google_storage_bucket_iam_member", map[string]interface{}{
+		"bucket": bucket.GetStringAttribute(jsii.String("name")),
+		"role":   "roles/storage.objectUser",
+		"member": member,
+	})
+	return bucket
+}
+
+// database creates a Cloud SQL PostgreSQL instance on a private IP as XTDB's
+// backing store and returns the variables that point XTDB at it
+func (g *gcpStack) database(networkID *string, member string) []map[string]interface{} {
+	cfg := g.cfg
+	availability := "ZONAL"
+	if cfg.Database.Readers > 0 {
+		availability = "REGIONAL"
+	}
+	peeringRange := g.resource("ServicePeeringRange", "google_compute_global_address", map[string]interface{}{
+		"name":          *cfg.Name("services"),
+		"purpose":       "VPC_PEERING",
+		"address_type":  "INTERNAL",
+		"prefix_length": 20,
+		"network":       networkID,
+	})
+	peering := g.resource("ServicePeering", "google_service_networking_connection", map[string]interface{}{
+		"network":                 networkID,
+		"service":                 "servicenetworking.googleapis.com",
+		"reserved_peering_ranges": []*string{peeringRange.GetStringAttribute(jsii.String("name"))},
+	})
+
+	instance := g.resource("Database", "google_sql_database_instance", map[string]interface{}{
+		"name":                *cfg.Name("db"),
+		"database_version":    "POSTGRES_16",
+		"region":              cfg.Gcp.Region,
+		"deletion_protection": cfg.PreventDestroy,
+		"settings": map[string]interface{}{
+			"tier":              "db-custom-1-3840",
+			"availability_type": availability,
+			"user_labels":       g.labels,
+			"ip_configuration": map[string]interface{}{
+				"ipv4_enabled":    false,
+				"private_network": peering.GetStringAttribute(jsii.String("network")),
+				"ssl_mode":        "ENCRYPTED_ONLY",
+			},
+			"backup_configuration": map[string]interface{}{
+				"enabled": true,
+				"backup_retention_settings": map[string]interface{}{
+					"retained_backups": cfg.Database.BackupRetentionDays,
+				},
+			},
+		},
+	})
+	g.resource("DatabaseName", "google_sql_database", map[string]interface{}{
+		"name":     cfg.Database.Name,
+		"instance": instance.GetStringAttribute(jsii.String("name")),
+	})
+
+	password := randomPassword(g.stack, "DatabasePassword")
+	g.resource("DatabaseUser", "google_sql_user", map[string]interface{}{
+		"name":     cfg.Database.Username,
+		"instance": instance.GetStringAttribute(jsii.String("name")),
+		"password": password,
+	})
+	secret := g.secret("DatabaseSecret", "db-password", password)
+	g.grantSecret("DatabaseSecretAccess", secret, member)
+
+	return []map[string]interface{}{
+		{"name": "XTDB_ENABLE_POSTGRESQL", "value": "true"},
+		{"name": "XTDB_POSTGRESQL_HOST", "value": instance.GetStringAttribute(jsii.String("private_ip_address"))},
+		{"name": "XTDB_POSTGRESQL_PORT", "value": "5432"},
+		{"name": "XTDB_POSTGRESQL_DATABASE", "value": cfg.Database.Name},
+		{"name": "XTDB_POSTGRESQL_USER", "value": cfg.Database.Username},
+		gcpSecretEnv("XTDB_POSTGRESQL_PASSWORD", secret),
+	}
+}
+
+// cache creates a Memorystore Redis instance with AUTH and in-transit
+// encryption and returns the variables that connect the app to it
+func (g *gcpStack) cache(networkID *string, member string) []map[string]interface{} {
+	cfg := g.cfg
+	tier := "BASIC"
+	if cfg.Cache.Replicas > 0 {
+		tier = "STANDARD_HA"
+	}
+	redis := g.resource("Cache", "google_redis_instance", map[string]interface{}{
+		"name":                    *cfg.Name("cache"),
+		"region":                  cfg.Gcp.Region,
+		"tier":                    tier,
+		"memory_size_gb":          1,
+		"authorized_network":      networkID,
+		"auth_enabled":            true,
+		"transit_encryption_mode": "SERVER_AUTHENTICATION",
+		"labels":                  g.labels,
+	})
+	token := g.secret("CacheAuthToken", "cache-auth-token", redis.GetStringAttribute(jsii.String("auth_string")))
+	g.grantSecret("CacheAuthTokenAccess", token, member)
+
+	return []map[string]interface{}{
+		{"name": "REDIS_HOST", "value": redis.GetStringAttribute(jsii.String("host"))},
+		{"name": "REDIS_PORT", "value": cdktf.Token_AsString(redis.GetNumberAttribute(jsii.String("port")), nil)},
+		{"name": "REDIS_TLS", "value": "true"},
+		gcpSecretEnv("REDIS_AUTH_TOKEN", token),
+	}
+}
+
+// gcpNodeConfig is the XTDB node config with its object store in the GCS
+// bucket and its log and disk cache on the data volume
+func gcpNodeConfig(cfg StackConfig, bucket string) string {
+	return fmt.Sprintf(`server:
+  port: 5432
+healthz:
+  port: 8080
+modules:
+  - !HttpServer
+    port: 3000
+log: !Local
+  path: %[1]s/log
+storage: !Remote
+  objectStore: !GoogleCloud
+    projectId: %[2]s
+    bucket: %[3]s
+    prefix: xtdb
+  localDiskCache: %[1]s/cache
+`, xtdbDataDir, cfg.Gcp.Project, bucket)
+}
+
+// gcpSecretEnv is a Cloud Run variable read from the latest secret version
+func gcpSecretEnv(name string, secret *string) map[string]interface{} {
+	return map[string]interface{}{
+		"name": name,
+		"value_source": map[string]interface{}{
+			"secret_key_ref": map[string]interface{}{"secret": secret, "version": "latest"},
+		},
+	}
+}
+
+// gcpVpcAccess sends all of a Cloud Run service's egress through the subnet
+func gcpVpcAccess(network cdktf.TerraformResource, subnet cdktf.TerraformResource) map[string]interface{} {
+	return map[string]interface{}{
+		"egress": "ALL_TRAFFIC",
+		"network_interfaces": []map[string]interface{}{{
+			"network":    network.GetStringAttribute(jsii.String("id")),
+			"subnetwork": subnet.GetStringAttribute(jsii.String("id")),
+		}},
+	}
+}
+
+// gcpLimits converts a Fargate sizing to Cloud Run limits. Cloud Run counts
+// whole vCPUs, where 1024 Fargate units are one.
+func gcpLimits(sizing ServiceSizing) map[string]string {
+	cpu := math.Max(1, math.Ceil(sizing.Cpu/1024))
+	return map[string]string{
+		"cpu":    strconv.FormatFloat(cpu, 'f', -1, 64),
+		"memory": strconv.FormatFloat(sizing.MemoryMiB, 'f', -1, 64) + "Mi",
+	}
+}
+
+// gcpLabels are the stack's tags as GCP labels, which only allow lowercase
+// letters, digits, dashes and underscores
+func gcpLabels(cfg StackConfig) map[string]string {
+	labels := map[string]string{}
+	for i, value := range []string{cfg.Environment, cfg.Tags.Service, cfg.Tags.Owner, cfg.Tags.CostCenter} {
+		labels[costAllocationTags[i]] = gcpLabelValue.ReplaceAllString(strings.ToLower(value), "_")
+	}
+	return labels
+}
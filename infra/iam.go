@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/aws/jsii-runtime-go"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/cloudwatchloggroup"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/iamrole"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/iamrolepolicy"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/iamrolepolicyattachment"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/kmskey"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/secretsmanagersecret"
+	"github.com/hashicorp/terraform-cdk-go/cdktf"
+)
+
+// statement is one statement of an IAM policy. Resources and conditions may
+// hold tokens, which Terraform interpolates into the rendered document.
+type statement struct {
+	Sid       string                 `json:"Sid,omitempty"`
+	Effect    string                 `json:"Effect"`
+	Principal map[string]interface{} `json:"Principal,omitempty"`
+	Action    []string               `json:"Action"`
+	Resource  []*string              `json:"Resource,omitempty"`
+	Condition map[string]interface{} `json:"Condition,omitempty"`
+}
+
+// allow is a statement allowing the actions on the resources
+func allow(actions []string, resources ...*string) statement {
+	return statement{Effect: "Allow", Action: actions, Resource: resources}
+}
+
+// servicePrincipal is the principal of one or more AWS services
+func servicePrincipal(services ...string) map[string]interface{} {
+	return map[string]interface{}{"Service": services}
+}
+
+// policyDocument renders the statements as an IAM policy document
+func policyDocument(statements ...statement) *string {
+	document, err := json.Marshal(map[string]interface{}{
+		"Version":   "2012-10-17",
+		"Statement": statements,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return jsii.String(string(document))
+}
+
+// Role is an IAM role whose permissions are inline policies, one per grant,
+// created in the role's own stack
+type Role struct {
+	iamrole.IamRole
+	stack cdktf.TerraformStack
+	id    string
+}
+
+// newRole creates a role with no permissions that the principal may assume
+func newRole(stack cdktf.TerraformStack, id string, name *string, description string, principal map[string]interface{}, conditions map[string]interface{}) *Role {
+	assume := statement{Effect: "Allow", Principal: principal, Action: []string{"sts:AssumeRole"}, Condition: conditions}
+	if _, federated := principal["Federated"]; federated {
+		assume.Action = []string{"sts:AssumeRoleWithWebIdentity"}
+	}
+	role := iamrole.NewIamRole(stack, jsii.String(id), &iamrole.IamRoleConfig{
+		Name:             name,
+		Description:      jsii.String(description),
+		AssumeRolePolicy: policyDocument(assume),
+	})
+	return &Role{IamRole: role, stack: stack, id: id}
+}
+
+// Grant adds the statements to the role as the inline policy name
+func (r *Role) Grant(name string, statements ...statement) {
+	iamrolepolicy.NewIamRolePolicy(r.stack, jsii.String(r.id+name), &iamrolepolicy.IamRolePolicyConfig{
+		Role:   r.Name(),
+		Name:   jsii.String(name),
+		Policy: policyDocument(statements...),
+	})
+}
+
+// Allow grants the actions on the resources as the inline policy name
+func (r *Role) Allow(name string, actions []string, resources ...*string) {
+	r.Grant(name, allow(actions, resources...))
+}
+
+// Attach attaches a managed policy to the role
+func (r *Role) Attach(id string, policyArn *string) {
+	iamrolepolicyattachment.NewIamRolePolicyAttachment(r.stack, jsii.String(r.id+id), &iamrolepolicyattachment.IamRolePolicyAttachmentConfig{
+		Role:      r.Name(),
+		PolicyArn: policyArn,
+	})
+}
+
+// ServiceRoles are the IAM roles and log group owned by a single ECS service.
+// The execution role is used by the ECS agent to start the task; the task
+// role is what the containers themselves run as.
+type ServiceRoles struct {
+	TaskRole      *Role
+	ExecutionRole *Role
+	LogGroup      cloudwatchloggroup.CloudwatchLogGroup
+}
+
+// NewServiceRoles creates roles for a service that start with no permissions.
+// The execution role may only pull from repo, write to the service's own log
+// group and read the database credentials, either of which may be nil;
+// anything the containers need is granted to the task role by the caller.
+func NewServiceRoles(stack cdktf.TerraformStack, cfg StackConfig, key kmskey.KmsKey, id string, name string, repo Repository, credentials secretsmanagersecret.SecretsmanagerSecret) *ServiceRoles {
+	roles := &ServiceRoles{
+		LogGroup: cloudwatchloggroup.NewCloudwatchLogGroup(stack, jsii.String(id+"LogGroup"), &cloudwatchloggroup.CloudwatchLogGroupConfig{
+			Name:            jsii.String("/ecs/" + *cfg.Name(name)),
+			RetentionInDays: logRetention(cfg),
+			KmsKeyId:        key.Arn(),
+		}),
+		ExecutionRole: newRole(stack, id+"ExecutionRole", cfg.Name(name+"-execution"), "Starts "+name+" tasks", servicePrincipal("ecs-tasks.amazonaws.com"), nil),
+		TaskRole:      newRole(stack, id+"TaskRole", cfg.Name(name+"-task"), "Runs the "+name+" containers", servicePrincipal("ecs-tasks.amazonaws.com"), nil),
+	}
+
+	if repo != nil {
+		grantPull(roles.ExecutionRole, repo)
+	}
+	roles.ExecutionRole.Allow("Logs", []string{"logs:CreateLogStream", "logs:PutLogEvents"}, jsii.String(*roles.LogGroup.Arn()+":*"))
+	if credentials != nil {
+		grantSecretRead(roles.ExecutionRole, "Credentials", credentials.Arn(), key)
+	}
+	return roles
+}
+
+// grantSecretRead lets the role read a secret encrypted with the stack key
+func grantSecretRead(role *Role, name string, secretArn *string, key kmskey.KmsKey) {
+	role.Grant(name,
+		allow([]string{"secretsmanager:GetSecretValue", "secretsmanager:DescribeSecret"}, secretArn),
+		allow([]string{"kms:Decrypt"}, key.Arn()),
+	)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/aws/jsii-runtime-go"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/dataawsmskbootstrapbrokers"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/mskserverlesscluster"
+	"github.com/hashicorp/terraform-cdk-go/cdktf"
+)
+
+// mskIamPort is the MSK broker port for IAM-authenticated clients
+const mskIamPort = 9098
+
+// KafkaTxLog is the Kafka cluster XTDB writes its transaction log to
+type KafkaTxLog struct {
+	cfg              StackConfig
+	BootstrapServers *string
+	// ClusterArn is only set for the MSK Serverless cluster
+	ClusterArn *string
+}
+
+// NewKafkaTxLog creates an MSK Serverless cluster with IAM auth that only
+// XTDB can reach, or points at an existing Kafka cluster, depending on the
+// tx log backend. It returns nil for the local tx log.
+func NewKafkaTxLog(stack cdktf.TerraformStack, cfg StackConfig, vpc *Vpc, sg *SecurityGroups) *KafkaTxLog {
+	switch cfg.TxLog.Backend {
+	case TxLogKafka:
+		// Brokers may live outside the VPC, so allow the usual Kafka ports anywhere
+		allowToInternet(stack, "XTDBKafka", sg.XTDB, tcpRange(9092, mskIamPort), "Kafka tx log")
+		return &KafkaTxLog{cfg: cfg, BootstrapServers: jsii.String(cfg.TxLog.BootstrapServers)}
+	case TxLogMsk:
+	default:
+		return nil
+	}
+
+	kafkaSg := newSecurityGroup(stack, cfg, vpc, "KafkaSecurityGroup", "kafka", "XTDB tx log brokers, reachable from XTDB only")
+	allowTraffic(stack, "XTDBToKafka", sg.XTDB, kafkaSg, tcp(mskIamPort), "Kafka tx log from XTDB")
+
+	cluster := mskserverlesscluster.NewMskServerlessCluster(stack, jsii.String("TxLogCluster"), &mskserverlesscluster.MskServerlessClusterConfig{
+		ClusterName: cfg.Name("xtdb-txlog"),
+		ClientAuthentication: &mskserverlesscluster.MskServerlessClusterClientAuthentication{
+			Sasl: &mskserverlesscluster.MskServerlessClusterClientAuthenticationSasl{
+				Iam: &mskserverlesscluster.MskServerlessClusterClientAuthenticationSaslIam{Enabled: jsii.Bool(true)},
+			},
+		},
+		VpcConfig: &[]*mskserverlesscluster.MskServerlessClusterVpcConfig{{
+			SubnetIds:        vpc.PrivateSubnetIDs,
+			SecurityGroupIds: &[]*string{kafkaSg.Id()},
+		}},
+	})
+
+	// MSK Serverless does not expose its brokers as an attribute
+	brokers := dataawsmskbootstrapbrokers.NewDataAwsMskBootstrapBrokers(stack, jsii.String("TxLogBootstrapBrokers"), &dataawsmskbootstrapbrokers.DataAwsMskBootstrapBrokersConfig{
+		ClusterArn: cluster.Arn(),
+	})
+
+	return &KafkaTxLog{
+		cfg:              cfg,
+		BootstrapServers: brokers.BootstrapBrokersSaslIam(),
+		ClusterArn:       cluster.Arn(),
+	}
+}
+
+// GrantReadWrite lets a task produce to and consume the tx log topic over
+// IAM auth; existing clusters manage their own authorization
+func (k *KafkaTxLog) GrantReadWrite(roles *ServiceRoles) {
+	if k.ClusterArn == nil {
+		return
+	}
+	clusterName := *k.cfg.Name("xtdb-txlog")
+	arn := func(kind string) string {
+		return fmt.Sprintf("arn:aws:kafka:%s:*:%s/%s/*", k.cfg.Region, kind, clusterName)
+	}
+	roles.TaskRole.Allow("TxLog", []string{
+		"kafka-cluster:Connect",
+		"kafka-cluster:DescribeCluster",
+		"kafka-cluster:CreateTopic",
+		"kafka-cluster:DescribeTopic",
+		"kafka-cluster:ReadData",
+		"kafka-cluster:WriteData",
+		"kafka-cluster:AlterGroup",
+		"kafka-cluster:DescribeGroup",
+	}, k.ClusterArn, jsii.String(arn("topic")), jsii.String(arn("group")))
+}
+
+// logConfig is the log section of the XTDB node config. MSK clients sign in
+// with the task role, which needs the aws-msk-iam-auth jar in the XTDB image.
+func (k *KafkaTxLog) logConfig() string {
+	config := fmt.Sprintf("log: !Kafka\n  bootstrapServers: %s\n  topic: %s\n", *k.BootstrapServers, k.cfg.TxLog.Topic)
+	if k.ClusterArn != nil {
+		config += `  propertiesMap:
+    security.protocol: SASL_SSL
+    sasl.mechanism: AWS_MSK_IAM
+    sasl.jaas.config: software.amazon.msk.auth.iam.IAMLoginModule required;
+    sasl.client.callback.handler.class: software.amazon.msk.auth.iam.IAMClientCallbackHandler
+`
+	}
+	return config
+}
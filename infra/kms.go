@@ -0,0 +1,115 @@
+package main
+
+import (
+	"github.com/aws/jsii-runtime-go"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/kmsalias"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/kmskey"
+	"github.com/hashicorp/terraform-cdk-go/cdktf"
+)
+
+// NewStackKey creates the environment's customer-managed key, which every
+// encrypted resource in the stack uses instead of an AWS-managed key. It is
+// the former XTDB data key, so the EFS volume keeps its key. Only the
+// services that write to the stack's log groups and alerts topic, and the
+// environment's service roles, may use it besides the account.
+func NewStackKey(stack cdktf.TerraformStack, cfg StackConfig) kmskey.KmsKey {
+	statements := []statement{
+		// Without it no IAM policy could grant the key, and nobody could manage it
+		{
+			Sid:       "AccountAdmin",
+			Effect:    "Allow",
+			Principal: map[string]interface{}{"AWS": "arn:aws:iam::" + *stackAccount(stack) + ":root"},
+			Action:    []string{"kms:*"},
+			Resource:  []*string{jsii.String("*")},
+		},
+		{
+			Sid:       "CloudWatchLogs",
+			Effect:    "Allow",
+			Principal: servicePrincipal("logs." + cfg.Region + ".amazonaws.com"),
+			Action:    []string{"kms:Encrypt*", "kms:Decrypt*", "kms:ReEncrypt*", "kms:GenerateDataKey*", "kms:Describe*"},
+			Resource:  []*string{jsii.String("*")},
+			Condition: map[string]interface{}{
+				"ArnLike": map[string]interface{}{
+					"kms:EncryptionContext:aws:logs:arn": "arn:aws:logs:" + cfg.Region + ":*:log-group:/ecs/" + cfg.NamePrefix + "-*",
+				},
+			},
+		},
+		// Alarms, EventBridge rules and cost alerts publish to the encrypted alerts topic
+		{
+			Sid:       "AlertPublishers",
+			Effect:    "Allow",
+			Principal: servicePrincipal("cloudwatch.amazonaws.com", "events.amazonaws.com", "budgets.amazonaws.com", "costalerts.amazonaws.com"),
+			Action:    []string{"kms:Decrypt", "kms:GenerateDataKey*"},
+			Resource:  []*string{jsii.String("*")},
+		},
+		// Tasks read their secrets and mount the volume with the key, ECS keeps
+		// the mesh's certificate keys with it and EKS its secrets. The roles live
+		// in the app or eks stack, so they are matched by name rather than by
+		// ARN, which would make the data stack depend on those stacks.
+		{
+			Sid:       "ServiceRoles",
+			Effect:    "Allow",
+			Principal: map[string]interface{}{"AWS": "arn:aws:iam::" + *stackAccount(stack) + ":root"},
+			Action:    []string{"kms:Decrypt", "kms:DescribeKey", "kms:GenerateDataKey*"},
+			Resource:  []*string{jsii.String("*")},
+			Condition: map[string]interface{}{
+				"ArnLike": map[string]interface{}{
+					"aws:PrincipalArn": []string{
+						"arn:aws:iam::*:role/" + cfg.NamePrefix + "-*-task",
+						"arn:aws:iam::*:role/" + cfg.NamePrefix + "-*-execution",
+						"arn:aws:iam::*:role/" + *cfg.Name(meshTlsRoleName),
+						"arn:aws:iam::*:role/" + cfg.NamePrefix + "-eks-*",
+					},
+				},
+			},
+		},
+	}
+
+	// EKS encrypts the cluster's secrets with grants on the key
+	if cfg.Eks.Enabled && cfg.Eks.ClusterName == "" {
+		statements = append(statements, statement{
+			Sid:       "EksSecrets",
+			Effect:    "Allow",
+			Principal: map[string]interface{}{"AWS": "arn:aws:iam::" + *stackAccount(stack) + ":root"},
+			Action:    []string{"kms:Encrypt", "kms:CreateGrant"},
+			Resource:  []*string{jsii.String("*")},
+			Condition: map[string]interface{}{
+				"ArnLike": map[string]interface{}{
+					"aws:PrincipalArn": "arn:aws:iam::*:role/" + *cfg.Name("eks-cluster"),
+				},
+			},
+		})
+	}
+
+	// CloudFront reads the encrypted static assets. Scoped to any
+	// distribution, as the distribution lives in the app stack.
+	if cfg.Cdn.Enabled {
+		statements = append(statements, statement{
+			Sid:       "CloudFrontAssets",
+			Effect:    "Allow",
+			Principal: servicePrincipal("cloudfront.amazonaws.com"),
+			Action:    []string{"kms:Decrypt"},
+			Resource:  []*string{jsii.String("*")},
+			Condition: map[string]interface{}{
+				"ArnLike": map[string]interface{}{"aws:SourceArn": "arn:aws:cloudfront::*:distribution/*"},
+			},
+		})
+	}
+
+	key := kmskey.NewKmsKey(stack, jsii.String("XTDBDataKey"), &kmskey.KmsKeyConfig{
+		Description:       jsii.String("Encrypts the data of " + cfg.Environment),
+		EnableKeyRotation: jsii.Bool(true),
+		Policy:            policyDocument(statements...),
+	})
+	kmsalias.NewKmsAlias(stack, jsii.String("XTDBDataKeyAlias"), &kmsalias.KmsAliasConfig{
+		Name:        jsii.String("alias/" + *cfg.Name("data")),
+		TargetKeyId: key.KeyId(),
+	})
+	return key
+}
+
+// allowStackKey lets a role in another stack use the key. The key policy must
+// allow the role by name, see NewStackKey.
+func allowStackKey(role *Role, key kmskey.KmsKey, actions ...string) {
+	role.Allow("StackKey", actions, key.Arn())
+}
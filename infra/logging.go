@@ -0,0 +1,80 @@
+package main
+
+import (
+	"github.com/aws/jsii-runtime-go"
+)
+
+// fluentBitImage is the AWS build of Fluent Bit used as the FireLens log router
+const fluentBitImage = "public.ecr.aws/aws-observability/aws-for-fluent-bit:stable"
+
+// LogShipping decides where the application containers send their logs:
+// CloudWatch through awslogs, or a third-party backend through a FireLens
+// (Fluent Bit) sidecar in each task.
+type LogShipping struct {
+	cfg StackConfig
+}
+
+// NewLogShipping ships logs to the configured destination. The destination's
+// credentials secret and bucket are managed outside the stacks.
+func NewLogShipping(cfg StackConfig) *LogShipping {
+	return &LogShipping{cfg: cfg}
+}
+
+// Driver returns the log configuration of a service's main container,
+// adding the FireLens router to its task definition when logs leave
+// CloudWatch
+func (l *LogShipping) Driver(taskDef *TaskDefinition, name string) *LogConfiguration {
+	roles := taskDef.Roles
+	if l.cfg.Logging.Destination == LogsCloudWatch {
+		return awsLogs(l.cfg, roles.LogGroup, name)
+	}
+
+	// The router's own logs stay in CloudWatch so shipping failures are visible
+	taskDef.AddContainer(&Container{
+		Name:              "LogRouter",
+		Image:             fluentBitImage,
+		Essential:         true,
+		MemoryReservation: 50,
+		FirelensConfiguration: &FirelensConfiguration{
+			Type:    "fluentbit",
+			Options: map[string]string{"enable-ecs-log-metadata": "true"},
+		},
+		LogConfiguration: awsLogs(l.cfg, roles.LogGroup, "firelens"),
+	})
+
+	driver := &LogConfiguration{LogDriver: "awsfirelens", Options: map[string]string{}}
+	options := driver.Options
+	switch l.cfg.Logging.Destination {
+	case LogsDatadog:
+		options["Name"] = "datadog"
+		options["Host"] = l.cfg.Logging.Host
+		options["TLS"] = "on"
+		options["provider"] = "ecs"
+		options["dd_service"] = name
+		options["dd_source"] = name
+		options["dd_tags"] = "env:" + l.cfg.Environment
+		driver.SecretOptions = []ContainerSecret{{Name: "apikey", ValueFrom: l.cfg.Logging.SecretArn}}
+	case LogsElastic:
+		options["Name"] = "es"
+		options["Host"] = l.cfg.Logging.Host
+		options["Port"] = "443"
+		options["tls"] = "On"
+		options["Index"] = l.cfg.Logging.Index
+		options["Suppress_Type_Name"] = "On"
+		options["HTTP_User"] = l.cfg.Logging.Username
+		driver.SecretOptions = []ContainerSecret{{Name: "HTTP_Passwd", ValueFrom: l.cfg.Logging.SecretArn}}
+	case LogsS3:
+		options["Name"] = "s3"
+		options["bucket"] = l.cfg.Logging.Bucket
+		options["region"] = l.cfg.Region
+		options["compression"] = "gzip"
+		options["total_file_size"] = "10M"
+		options["upload_timeout"] = "1m"
+		options["s3_key_format"] = "/" + l.cfg.NamePrefix + "/" + name + "/%Y/%m/%d/%H-%M-%S-$UUID.gz"
+		roles.TaskRole.Allow("LogShipping", []string{"s3:PutObject", "s3:AbortMultipartUpload"}, jsii.String("arn:aws:s3:::"+l.cfg.Logging.Bucket+"/*"))
+	}
+	if arn := l.cfg.Logging.SecretArn; arn != "" {
+		roles.ExecutionRole.Allow("LogShippingSecret", []string{"secretsmanager:GetSecretValue", "secretsmanager:DescribeSecret"}, jsii.String(arn))
+	}
+	return driver
+}
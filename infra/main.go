@@ -1,129 +1,214 @@
 package main
 
 import (
-	"github.com/aws/aws-cdk-go/awscdk/v2"
-	"github.com/aws/aws-cdk-go/awscdk/v2/awsecs"
-	"github.com/aws/aws-cdk-go/awscdk/v2/awsefs"
-	"github.com/aws/aws-cdk-go/awscdk/v2/awsecrassets"
-    "github.com/aws/aws-cdk-go/awscdk/v2/awsecr" // Import ECR
+	"log"
+
 	"github.com/aws/constructs-go/constructs/v10"
 	"github.com/aws/jsii-runtime-go"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/dataawsecscluster"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/ecscluster"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/ecsclustercapacityproviders"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/ecsservice"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/kmskey"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/servicediscoveryprivatednsnamespace"
 	"github.com/hashicorp/terraform-cdk-go/cdktf"
 )
 
-func NewMyStack(scope constructs.Construct, id string) cdktf.TerraformStack {
-	stack := cdktf.NewTerraformStack(scope, &id)
+// NewAppStack creates the application layer of an environment: the image
+// repositories, the ECS cluster and services, the load balancer in front of
+// them and their monitoring. It changes on every deploy.
+func NewAppStack(scope constructs.Construct, cfg StackConfig, network *NetworkStack, data *DataStack) cdktf.TerraformStack {
+	stack := newEnvironmentStack(scope, cfg, LayerApp)
+	stack.AddDependency(data.Stack)
+	vpc, securityGroups := network.Vpc, network.SecurityGroups
+	key, storage, database, cache, messaging := data.Key, data.Storage, data.Database, data.Cache, data.Messaging
 
-	// Configure the AWS Provider
-	cdktf.NewTerraformAwsProvider(stack, jsii.String("AWS"), &cdktf.TerraformAwsProviderConfig{
-		Region: jsii.String("us-east-1"), // Or your preferred region
-	})
+	// Create an ECR Repository for the Clojure App image; CI pushes to it
+	appRepo := NewAppRepository(stack, cfg, key)
 
-	// Create an ECR Asset for the XTDB image (assuming you'll push it to ECR)
-	xtdbImage := awsecrassets.NewDockerImageAsset(stack, jsii.String("XTDBImage"), &awsecrassets.DockerImageAssetProps{
-		Directory: jsii.String("../my-app"), // Assuming Dockerfile is in my-app
-		AssetName: jsii.String("xtdb-image"),
-	})
-
-    // Create an ECR Repository for the Clojure App image
-    appRepo := awsecr.NewRepository(stack, jsii.String("AppRepo"), &awsecr.RepositoryProps{
-        RepositoryName: jsii.String("clj-xtdb-devops-app"), // Choose a suitable name
-    })
+	// Create an ECS Cluster
+	cluster := NewCluster(stack, cfg, key)
 
+	fs := storage.FileSystem
+	dbCredentials := data.Credentials
 
-    // Create an ECR Asset for the Clojure App image
-	appImage := awsecrassets.NewDockerImageAsset(stack, jsii.String("AppImage"), &awsecrassets.DockerImageAssetProps{
-		Directory: jsii.String("../my-app"),
-		AssetName: jsii.String("app-image"),
-        Repository: appRepo, // Associate with the ECR repository
-	})
+	// Decide where the application logs go
+	logShipping := NewLogShipping(cfg)
 
+	// Run XTDB on Fargate
+	xtdb := NewXTDBService(stack, cfg, network, data, cluster, logShipping)
+	xtdbService, xtdbRoles := xtdb.Service, xtdb.Roles
 
-	// Create an ECS Cluster
-	cluster := awsecs.NewCluster(stack, jsii.String("XTDBCluster"), &awsecs.ClusterProps{})
+	// Create the App roles; the app talks to XTDB only, so its task role starts empty
+	appRoles := NewServiceRoles(stack, cfg, key, "App", "app", appRepo, dbCredentials)
 
-	// Create an EFS filesystem for persistent XTDB data
-	fs := awsefs.NewFileSystem(stack, jsii.String("XTDBFileSystem"), &awsefs.FileSystemProps{
-		Vpc: cluster.Vpc(),
-	})
+	// Create a Task Definition for the Clojure App
+	appTaskDef := newTaskDefinition(stack, cfg, "AppTaskDef", "app", cfg.App.Cpu, cfg.App.MemoryMiB, appRoles)
 
-	// Create a Task Definition for XTDB
-	taskDef := awsecs.NewFargateTaskDefinition(stack, jsii.String("XTDBTaskDef"), &awsecs.FargateTaskDefinitionProps{
-		MemoryLimitMiB: jsii.Number(1024),
-		Cpu:            jsii.Number(512),
-		Volumes: &[]*awsecs.Volume{
+	app := appTaskDef.AddContainer(&Container{
+		Name:      "AppContainer",
+		Image:     imageURI(cfg, appRepo), // Tags are immutable, so each build has its own
+		Essential: true,
+		PortMappings: []PortMapping{
 			{
-				Name: jsii.String("xtdb-data"),
-				EfsVolumeConfiguration: &awsecs.EfsVolumeConfiguration{
-					FileSystemId: fs.FileSystemId(),
-				},
+				ContainerPort: appPort,
+				HostPort:      appPort,
 			},
 		},
 	})
-
-	taskDef.AddContainer(jsii.String("XTDBContainer"), &awsecs.ContainerDefinitionOptions{
-		Image: awsecs.ContainerImage_FromEcrRepository(xtdbImage.Repository(), xtdbImage.ImageTag()),
-		PortMappings: &[]*awsecs.PortMapping{
-			{
-				ContainerPort: jsii.Number(3000),
-				HostPort:      jsii.Number(3000),
-			},
-		},
-		Logging: awsecs.LogDrivers_AwsLogs(&awsecs.AwsLogDriverProps{
-			StreamPrefix: jsii.String("xtdb"),
-		}),
+	app.AddEnvironment("XTDB_ADDR", "xtdb-service."+discoveryDomain+":3000")
+	credentialSecrets(app, dbCredentials.Arn(), "XTDB_USERNAME", "XTDB_PASSWORD")
+	app.LogConfiguration = logShipping.Driver(appTaskDef, "clj-app")
+
+	NewAppSettings(stack, cfg).Connect(app, appRoles)
+	if cache != nil {
+		cache.Connect(app, appRoles)
+	}
+	if messaging != nil {
+		messaging.Connect(app, appRoles)
+	}
+	if cfg.Observability.Tracing {
+		addOtelCollector(cfg, appTaskDef, app, "app")
+	}
+
+	// Create a Service for the Clojure App
+	appService := newFargateService(stack, cfg, "AppService", "app", cluster, appTaskDef, cfg.App, vpc, securityGroups.App)
+
+	// Encrypt the app's traffic to XTDB in the mesh, or resolve XTDB through
+	// Cloud Map without it
+	namespace := servicediscoveryprivatednsnamespace.ServiceDiscoveryPrivateDnsNamespace(nil)
+	if mesh := NewServiceMesh(stack, cfg, key, vpc); mesh != nil {
+		mesh.Serve(xtdbService, "xtdb", "xtdb-service."+discoveryDomain, 3000)
+		mesh.Join(appService)
+		namespace = mesh.Namespace
+	} else {
+		discovery := NewServiceDiscovery(stack, cfg, vpc)
+		discovery.Register("XTDBServiceDiscovery", xtdbService, "xtdb-service")
+		namespace = discovery.Namespace
+	}
+
+	// Put the App behind a load balancer and scale it with load
+	alb := NewAppLoadBalancer(stack, cfg, vpc, securityGroups.Alb, appService)
+	NewAppAutoScaling(stack, cfg, appService, alb)
+	NewXTDBHibernation(stack, cfg, xtdbService)
+	NewWebAcl(stack, cfg, alb.Lb)
+	NewAppCdn(stack, cfg, key, alb.Lb)
+
+	// Internal tools sit behind Cognito on the same listener
+	NewAdminTools(stack, cfg, key, cluster, alb, network)
+
+	// Alert on unhealthy services and a degraded ALB
+	services := []MonitoredService{
+		{Name: "xtdb", Service: xtdbService},
+		{Name: "app", Service: appService},
+	}
+	alerts := NewServiceAlarms(stack, cfg, key, services, alb)
+	NewCostAlerts(stack, cfg, alerts)
+	NewPagerDutyRouting(stack, cfg, alerts)
+	NewDatadogMonitors(stack, cfg, services, alb.Lb)
+	NewDeployNotifications(stack, cfg, key, services)
+	NewUptimeCanary(stack, cfg, key, alb.Lb, alerts)
+	if database != nil {
+		NewRotationRestarts(stack, cfg, services, dbCredentials.Arn(), databaseSecretArn(database))
+	} else {
+		NewRotationRestarts(stack, cfg, services, dbCredentials.Arn())
+	}
+	// Roll back app deploys that start failing requests; by name, since the
+	// alarm already depends on the service
+	appService.PutAlarms(&ecsservice.EcsServiceAlarms{
+		AlarmNames: &[]*string{cfg.Name(alb5xxAlarmName)},
+		Enable:     jsii.Bool(true),
+		Rollback:   jsii.Bool(true),
 	})
+	NewDashboard(stack, cfg, services, alb, fs)
+	NewExecOutputs(stack, cfg, services)
+	NewAppOutputs(stack, cfg, cluster, services, alb.Lb, namespace, xtdb.Repo, appRepo)
+
+	// Export the XTDB data volume to S3 every night
+	NewXTDBBackups(stack, cfg, key, cluster, storage, vpc, securityGroups.XTDB, alerts)
+
+	// Let CI deploy through OIDC instead of access keys. The OIDC provider is
+	// account-wide, so only the primary creates a deploy role.
+	if !cfg.Failover.Secondary {
+		NewDeployRole(stack, cfg, []Repository{xtdb.Repo, appRepo}, services, []*ServiceRoles{xtdbRoles, appRoles})
+	}
+	return stack
+}
 
-	taskDef.FindContainer(jsii.String("XTDBContainer")).AddMountPoints(&awsecs.MountPoint{
-		ContainerPath: jsii.String("/var/lib/xtdb"),
-		SourceVolume:  jsii.String("xtdb-data"),
-		ReadOnly:      jsii.Bool(false),
-	})
+// Cluster is the environment's ECS cluster, created or looked up
+type Cluster struct {
+	Name *string
+	Arn  *string
+	// capacityProviders must exist before a service uses FARGATE_SPOT
+	capacityProviders cdktf.ITerraformDependable
+	// execKey encrypts the ECS Exec sessions of a cluster the stack created
+	execKey kmskey.KmsKey
+}
 
-	// Create a Service for XTDB
-	awsecs.NewFargateService(stack, jsii.String("XTDBService"), &awsecs.FargateServiceProps{
-		Cluster:        cluster,
-		TaskDefinition: taskDef,
-		DesiredCount:   jsii.Number(1),
+// NewCluster creates the environment's ECS cluster, or references the
+// existing one when configured
+func NewCluster(stack cdktf.TerraformStack, cfg StackConfig, key kmskey.KmsKey) *Cluster {
+	if cfg.Existing.ClusterName != "" {
+		existing := dataawsecscluster.NewDataAwsEcsCluster(stack, jsii.String("XTDBCluster"), &dataawsecscluster.DataAwsEcsClusterConfig{
+			ClusterName: jsii.String(cfg.ClusterName()),
+		})
+		return &Cluster{Name: existing.ClusterName(), Arn: existing.Arn()}
+	}
+	cluster := ecscluster.NewEcsCluster(stack, jsii.String("XTDBCluster"), &ecscluster.EcsClusterConfig{
+		Name:          jsii.String(cfg.ClusterName()),
+		Setting:       &[]*ecscluster.EcsClusterSetting{{Name: jsii.String("containerInsights"), Value: jsii.String(containerInsights(cfg))}},
+		Configuration: &ecscluster.EcsClusterConfiguration{ExecuteCommandConfiguration: execCommandConfiguration(stack, cfg, key)},
 	})
-
-    // Create a Task Definition for the Clojure App
-    appTaskDef := awsecs.NewFargateTaskDefinition(stack, jsii.String("AppTaskDef"), &awsecs.FargateTaskDefinitionProps{
-        MemoryLimitMiB: jsii.Number(512),
-        Cpu:            jsii.Number(256), // Adjust as needed
-    })
-
-    appTaskDef.AddContainer(jsii.String("AppContainer"), &awsecs.ContainerDefinitionOptions{
-        Image: awsecs.ContainerImage_FromEcrRepository(appRepo, jsii.String("latest")), // Use the ECR repo and tag
-        PortMappings: &[]*awsecs.PortMapping{
-            {
-                ContainerPort: jsii.Number(58950),
-                HostPort:      jsii.Number(58950),
-            },
-        },
-        Environment: &map[string]*string{
-            "XTDB_ADDR": jsii.String("xtdb-service.local:3000"), // Assuming service discovery is set up.  This needs to be resolvable.
-			"APP_ENV":   jsii.String("production"),
-        },
-		Logging: awsecs.LogDrivers_AwsLogs(&awsecs.AwsLogDriverProps{ // Add logging
-			StreamPrefix: jsii.String("clj-app"),
-		}),
-    })
-
-
-    // Create a Service for the Clojure App
-    awsecs.NewFargateService(stack, jsii.String("AppService"), &awsecs.FargateServiceProps{
-        Cluster:        cluster,
-        TaskDefinition: appTaskDef,
-        DesiredCount:   jsii.Number(1),
-    })
-	return stack
+	capacityProviders := ecsclustercapacityproviders.NewEcsClusterCapacityProviders(stack, jsii.String("XTDBClusterCapacityProviders"), &ecsclustercapacityproviders.EcsClusterCapacityProvidersConfig{
+		ClusterName:       cluster.Name(),
+		CapacityProviders: jsii.Strings("FARGATE", "FARGATE_SPOT"),
+	})
+	return &Cluster{Name: cluster.Name(), Arn: cluster.Arn(), capacityProviders: capacityProviders, execKey: key}
 }
 
 func main() {
 	app := cdktf.NewApp(nil)
 
-	NewMyStack(app, "infra")
+	configs, err := LoadStackConfigs(app)
+	if err != nil {
+		log.Fatal(err)
+	}
+	targets := bootstrapTargets(configs)
+	for _, state := range targets {
+		id := "infra-bootstrap"
+		if len(targets) > 1 {
+			id += "-" + state.Bucket
+		}
+		// The state backend is shared by the environments
+		NewBootstrapStack(app, id, state, configs[0].Tags)
+	}
+	for _, cfg := range configs {
+		switch cfg.Cloud {
+		case CloudGcp:
+			NewGcpStack(app, cfg)
+			continue
+		case CloudAzure:
+			NewAzureStack(app, cfg)
+			continue
+		}
+		var standby *DataStack
+		if cfg.Failover.Enabled {
+			dr := cfg.Standby()
+			drNetwork := NewNetworkStack(app, dr)
+			standby = NewDataStack(app, dr, drNetwork, nil)
+			NewAppStack(app, dr, drNetwork, standby)
+		}
+		network := NewNetworkStack(app, cfg)
+		data := NewDataStack(app, cfg, network, standby)
+		switch {
+		case cfg.Eks.Enabled:
+			NewEksStack(app, cfg, network, data)
+		case cfg.AppRunner.Enabled:
+			NewAppRunnerStack(app, cfg, network, data)
+		default:
+			NewAppStack(app, cfg, network, data)
+		}
+	}
 
 	app.Synth()
-}
\ No newline at end of file
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"github.com/aws/jsii-runtime-go"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/acmpcacertificate"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/acmpcacertificateauthority"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/acmpcacertificateauthoritycertificate"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/ecsservice"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/kmskey"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/servicediscoveryprivatednsnamespace"
+	"github.com/hashicorp/terraform-cdk-go/cdktf"
+)
+
+// meshTlsRoleName names the role ECS issues the mesh's certificates with; the
+// stack key allows it by name
+const meshTlsRoleName = "mesh-tls"
+
+// ServiceMesh is the Service Connect namespace the services join and the
+// private CA their proxies' certificates come from
+type ServiceMesh struct {
+	Namespace servicediscoveryprivatednsnamespace.ServiceDiscoveryPrivateDnsNamespace
+	caArn     *string
+	tlsRole   *Role
+	key       kmskey.KmsKey
+}
+
+// NewServiceMesh creates the Service Connect namespace, the role ECS uses to
+// issue certificates and, unless one is configured, a short-lived private CA.
+// It returns nil when the mesh is disabled.
+func NewServiceMesh(stack cdktf.TerraformStack, cfg StackConfig, key kmskey.KmsKey, vpc *Vpc) *ServiceMesh {
+	if !cfg.Mesh.Enabled {
+		return nil
+	}
+
+	namespace := servicediscoveryprivatednsnamespace.NewServiceDiscoveryPrivateDnsNamespace(stack, jsii.String("MeshNamespace"), &servicediscoveryprivatednsnamespace.ServiceDiscoveryPrivateDnsNamespaceConfig{
+		Name:        jsii.String(cfg.NamePrefix + ".internal"),
+		Description: jsii.String("Service Connect namespace of " + cfg.NamePrefix),
+		Vpc:         vpc.ID,
+	})
+
+	caArn := jsii.String(cfg.Mesh.PrivateCaArn)
+	if cfg.Mesh.PrivateCaArn == "" {
+		caArn = newMeshCertificateAuthority(stack, cfg)
+	}
+
+	tlsRole := newRole(stack, "MeshTlsRole", cfg.Name(meshTlsRoleName), "Issues the mesh's certificates", servicePrincipal("ecs.amazonaws.com"), nil)
+	tlsRole.Attach("ServiceConnectTls", jsii.String("arn:aws:iam::aws:policy/service-role/AmazonECSInfrastructureRolePolicyForServiceConnectTransportLayerSecurity"))
+	allowStackKey(tlsRole, key, "kms:Decrypt", "kms:DescribeKey", "kms:GenerateDataKey*")
+
+	return &ServiceMesh{Namespace: namespace, caArn: caArn, tlsRole: tlsRole, key: key}
+}
+
+// newMeshCertificateAuthority creates and activates a root CA in short-lived
+// certificate mode, which is all Service Connect needs and a fraction of the
+// cost of a general purpose CA
+func newMeshCertificateAuthority(stack cdktf.TerraformStack, cfg StackConfig) *string {
+	ca := acmpcacertificateauthority.NewAcmpcaCertificateAuthority(stack, jsii.String("MeshCa"), &acmpcacertificateauthority.AcmpcaCertificateAuthorityConfig{
+		Type:      jsii.String("ROOT"),
+		UsageMode: jsii.String("SHORT_LIVED_CERTIFICATE"),
+		CertificateAuthorityConfiguration: &acmpcacertificateauthority.AcmpcaCertificateAuthorityCertificateAuthorityConfiguration{
+			KeyAlgorithm:     jsii.String("EC_prime256v1"),
+			SigningAlgorithm: jsii.String("SHA256WITHECDSA"),
+			Subject: &acmpcacertificateauthority.AcmpcaCertificateAuthorityCertificateAuthorityConfigurationSubject{
+				CommonName: jsii.String(cfg.NamePrefix + " mesh"),
+			},
+		},
+	})
+	cert := acmpcacertificate.NewAcmpcaCertificate(stack, jsii.String("MeshCaCertificate"), &acmpcacertificate.AcmpcaCertificateConfig{
+		CertificateAuthorityArn:   ca.Arn(),
+		CertificateSigningRequest: ca.CertificateSigningRequest(),
+		SigningAlgorithm:          jsii.String("SHA256WITHECDSA"),
+		TemplateArn:               jsii.String("arn:aws:acm-pca:::template/RootCACertificate/V1"),
+		Validity: &acmpcacertificate.AcmpcaCertificateValidity{
+			Type:  jsii.String("YEARS"),
+			Value: jsii.String("10"),
+		},
+	})
+	// Installing the CA's own certificate activates it
+	acmpcacertificateauthoritycertificate.NewAcmpcaCertificateAuthorityCertificate(stack, jsii.String("MeshCaActivation"), &acmpcacertificateauthoritycertificate.AcmpcaCertificateAuthorityCertificateConfig{
+		CertificateAuthorityArn: ca.Arn(),
+		Certificate:             cert.Certificate(),
+	})
+	return ca.Arn()
+}
+
+// Serve publishes the service's named port in the mesh under dnsName, where
+// its proxy terminates TLS before handing the traffic to the container
+func (m *ServiceMesh) Serve(service ecsservice.EcsService, portMappingName string, dnsName string, port float64) {
+	service.PutServiceConnectConfiguration(&ecsservice.EcsServiceServiceConnectConfiguration{
+		Enabled:   jsii.Bool(true),
+		Namespace: m.Namespace.Arn(),
+		Service: &[]*ecsservice.EcsServiceServiceConnectConfigurationService{
+			{
+				PortName:    jsii.String(portMappingName),
+				ClientAlias: &ecsservice.EcsServiceServiceConnectConfigurationServiceClientAlias{DnsName: jsii.String(dnsName), Port: jsii.Number(port)},
+				Tls: &ecsservice.EcsServiceServiceConnectConfigurationServiceTls{
+					IssuerCertAuthority: &ecsservice.EcsServiceServiceConnectConfigurationServiceTlsIssuerCertAuthority{AwsPcaAuthorityArn: m.caArn},
+					KmsKey:              m.key.Arn(),
+					RoleArn:             m.tlsRole.Arn(),
+				},
+			},
+		},
+	})
+}
+
+// Join lets the service reach the services in the mesh through its proxy
+func (m *ServiceMesh) Join(service ecsservice.EcsService) {
+	service.PutServiceConnectConfiguration(&ecsservice.EcsServiceServiceConnectConfiguration{
+		Enabled:   jsii.Bool(true),
+		Namespace: m.Namespace.Arn(),
+	})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/aws/jsii-runtime-go"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/cloudwatcheventbus"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/kmskey"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/sqsqueue"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/sqsqueuepolicy"
+	"github.com/hashicorp/terraform-cdk-go/cdktf"
+)
+
+// AppMessaging is the async infrastructure behind the app's background jobs
+type AppMessaging struct {
+	Queues map[string]sqsqueue.SqsQueue
+	Bus    cloudwatcheventbus.CloudwatchEventBus
+	names  []string
+	key    kmskey.KmsKey
+}
+
+// NewAppMessaging creates one encrypted SQS queue with a dead-letter queue
+// per configured name, and an EventBridge bus when enabled. It returns nil
+// when neither is configured.
+func NewAppMessaging(stack cdktf.TerraformStack, cfg StackConfig, key kmskey.KmsKey) *AppMessaging {
+	if len(cfg.Messaging.Queues) == 0 && !cfg.Messaging.EventBus {
+		return nil
+	}
+
+	messaging := &AppMessaging{Queues: map[string]sqsqueue.SqsQueue{}, names: cfg.Messaging.Queues, key: key}
+	for _, name := range cfg.Messaging.Queues {
+		id := queueID(name)
+		dlq := newQueue(stack, id+"DeadLetterQueue", cfg.Name(name+"-dlq"), key, &sqsqueue.SqsQueueConfig{
+			MessageRetentionSeconds: jsii.Number(14 * 24 * 60 * 60),
+		})
+		redrive, _ := json.Marshal(map[string]interface{}{
+			"deadLetterTargetArn": *dlq.Arn(),
+			"maxReceiveCount":     cfg.Messaging.MaxReceiveCount,
+		})
+		messaging.Queues[name] = newQueue(stack, id+"Queue", cfg.Name(name), key, &sqsqueue.SqsQueueConfig{
+			VisibilityTimeoutSeconds: jsii.Number(cfg.Messaging.VisibilityTimeoutSeconds),
+			RedrivePolicy:            jsii.String(string(redrive)),
+		})
+	}
+
+	if cfg.Messaging.EventBus {
+		messaging.Bus = cloudwatcheventbus.NewCloudwatchEventBus(stack, jsii.String("AppEventBus"), &cloudwatcheventbus.CloudwatchEventBusConfig{
+			Name:             cfg.Name("events"),
+			KmsKeyIdentifier: key.Arn(),
+		})
+	}
+	return messaging
+}
+
+// newQueue creates a queue encrypted with the stack key that only accepts
+// TLS requests
+func newQueue(stack cdktf.TerraformStack, id string, name *string, key kmskey.KmsKey, config *sqsqueue.SqsQueueConfig) sqsqueue.SqsQueue {
+	config.Name = name
+	config.KmsMasterKeyId = key.Arn()
+	queue := sqsqueue.NewSqsQueue(stack, jsii.String(id), config)
+	sqsqueuepolicy.NewSqsQueuePolicy(stack, jsii.String(id+"Policy"), &sqsqueuepolicy.SqsQueuePolicyConfig{
+		QueueUrl: queue.Url(),
+		Policy: policyDocument(statement{
+			Sid:       "EnforceSSL",
+			Effect:    "Deny",
+			Principal: map[string]interface{}{"AWS": "*"},
+			Action:    []string{"sqs:*"},
+			Resource:  []*string{queue.Arn()},
+			Condition: map[string]interface{}{"Bool": map[string]interface{}{"aws:SecureTransport": "false"}},
+		}),
+	})
+	return queue
+}
+
+// Connect grants the app's task role use of the queues and bus and passes
+// their URLs and name to the app container, e.g. QUEUE_EMAIL_JOBS_URL
+func (m *AppMessaging) Connect(app *Container, roles *ServiceRoles) {
+	var queues []*string
+	for _, name := range m.names {
+		queue := m.Queues[name]
+		queues = append(queues, queue.Arn())
+		envName := "QUEUE_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_URL"
+		app.AddEnvironment(envName, *queue.Url())
+	}
+	statements := []statement{allow([]string{"kms:Decrypt", "kms:GenerateDataKey*"}, m.key.Arn())}
+	if len(queues) > 0 {
+		statements = append(statements, allow([]string{
+			"sqs:SendMessage",
+			"sqs:ReceiveMessage",
+			"sqs:DeleteMessage",
+			"sqs:ChangeMessageVisibility",
+			"sqs:GetQueueAttributes",
+			"sqs:GetQueueUrl",
+		}, queues...))
+	}
+	if m.Bus != nil {
+		statements = append(statements, allow([]string{"events:PutEvents"}, m.Bus.Arn()))
+		app.AddEnvironment("EVENT_BUS_NAME", *m.Bus.Name())
+	}
+	roles.TaskRole.Grant("Messaging", statements...)
+}
+
+// queueID turns a queue name like email-jobs into a construct id like EmailJobs
+func queueID(name string) string {
+	var id strings.Builder
+	for _, part := range strings.Split(name, "-") {
+		if part != "" {
+			id.WriteString(strings.ToUpper(part[:1]) + part[1:])
+		}
+	}
+	return id.String()
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"github.com/aws/jsii-runtime-go"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/dataawsavailabilityzones"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/dataawsroutetables"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/dataawssubnets"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/dataawsvpc"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/defaultsecuritygroup"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/eip"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/internetgateway"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/natgateway"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/route"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/routetable"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/routetableassociation"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/subnet"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/vpc"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/vpcendpoint"
+	"github.com/hashicorp/terraform-cdk-go/cdktf"
+)
+
+// Vpc is the environment's VPC: public subnets for the load balancer and
+// private subnets, with their route tables, for everything else
+type Vpc struct {
+	ID                   *string
+	PublicSubnetIDs      *[]*string
+	PrivateSubnetIDs     *[]*string
+	PrivateRouteTableIDs *[]*string
+}
+
+// NewNetwork creates the environment's VPC with a public subnet per AZ for
+// the load balancer and a private subnet per AZ for the Fargate tasks, or
+// looks up the existing VPC when one is configured
+func NewNetwork(stack cdktf.TerraformStack, cfg StackConfig) *Vpc {
+	if id := cfg.Existing.VpcID; id != "" {
+		return existingVpc(stack, id)
+	}
+
+	net := cfg.Network
+	cidrs, err := subnetCidrs(net)
+	if err != nil {
+		panic(err)
+	}
+	zones := dataawsavailabilityzones.NewDataAwsAvailabilityZones(stack, jsii.String("Zones"), &dataawsavailabilityzones.DataAwsAvailabilityZonesConfig{
+		State: jsii.String("available"),
+	})
+	network := vpc.NewVpc(stack, jsii.String("Vpc"), &vpc.VpcConfig{
+		CidrBlock:          jsii.String(net.Cidr),
+		EnableDnsHostnames: jsii.Bool(true),
+		EnableDnsSupport:   jsii.Bool(true),
+		Tags:               &map[string]*string{"Name": cfg.Name("vpc")},
+	})
+
+	// Take every rule off the default security group, so nothing that lands
+	// in it by accident can talk to anything
+	defaultsecuritygroup.NewDefaultSecurityGroup(stack, jsii.String("DefaultSecurityGroup"), &defaultsecuritygroup.DefaultSecurityGroupConfig{
+		VpcId: network.Id(),
+	})
+
+	gateway := internetgateway.NewInternetGateway(stack, jsii.String("InternetGateway"), &internetgateway.InternetGatewayConfig{
+		VpcId: network.Id(),
+		Tags:  &map[string]*string{"Name": cfg.Name("igw")},
+	})
+	public := routetable.NewRouteTable(stack, jsii.String("PublicRouteTable"), &routetable.RouteTableConfig{
+		VpcId: network.Id(),
+		Tags:  &map[string]*string{"Name": cfg.Name("public")},
+	})
+	route.NewRoute(stack, jsii.String("PublicDefaultRoute"), &route.RouteConfig{
+		RouteTableId:         public.Id(),
+		DestinationCidrBlock: jsii.String("0.0.0.0/0"),
+		GatewayId:            gateway.Id(),
+	})
+
+	result := &Vpc{ID: network.Id()}
+	var publicIDs, privateIDs, routeTableIDs []*string
+	var nats []natgateway.NatGateway
+	for i := 0; i < int(net.MaxAzs); i++ {
+		zone := cdktf.Token_AsString(cdktf.Fn_Element(zones.Names(), jsii.Number(i)), nil)
+		suffix := fmt.Sprint(i + 1)
+
+		publicSubnet := subnet.NewSubnet(stack, jsii.String("PublicSubnet"+suffix), &subnet.SubnetConfig{
+			VpcId:               network.Id(),
+			CidrBlock:           jsii.String(cidrs[i]),
+			AvailabilityZone:    zone,
+			MapPublicIpOnLaunch: jsii.Bool(true),
+			Tags:                &map[string]*string{"Name": cfg.Name("public-" + suffix)},
+		})
+		routetableassociation.NewRouteTableAssociation(stack, jsii.String("PublicSubnet"+suffix+"RouteTable"), &routetableassociation.RouteTableAssociationConfig{
+			SubnetId:     publicSubnet.Id(),
+			RouteTableId: public.Id(),
+		})
+		publicIDs = append(publicIDs, publicSubnet.Id())
+
+		// One NAT gateway per AZ, or a single one in the first AZ
+		if net.NatStrategy == NatPerAz || (net.NatStrategy == NatSingle && i == 0) {
+			address := eip.NewEip(stack, jsii.String("NatAddress"+suffix), &eip.EipConfig{
+				Domain: jsii.String("vpc"),
+				Tags:   &map[string]*string{"Name": cfg.Name("nat-" + suffix)},
+			})
+			nats = append(nats, natgateway.NewNatGateway(stack, jsii.String("NatGateway"+suffix), &natgateway.NatGatewayConfig{
+				SubnetId:     publicSubnet.Id(),
+				AllocationId: address.Id(),
+				Tags:         &map[string]*string{"Name": cfg.Name("nat-" + suffix)},
+			}))
+		}
+
+		privateSubnet := subnet.NewSubnet(stack, jsii.String("PrivateSubnet"+suffix), &subnet.SubnetConfig{
+			VpcId:            network.Id(),
+			CidrBlock:        jsii.String(cidrs[int(net.MaxAzs)+i]),
+			AvailabilityZone: zone,
+			Tags:             &map[string]*string{"Name": cfg.Name("private-" + suffix)},
+		})
+		private := routetable.NewRouteTable(stack, jsii.String("PrivateRouteTable"+suffix), &routetable.RouteTableConfig{
+			VpcId: network.Id(),
+			Tags:  &map[string]*string{"Name": cfg.Name("private-" + suffix)},
+		})
+		routetableassociation.NewRouteTableAssociation(stack, jsii.String("PrivateSubnet"+suffix+"RouteTable"), &routetableassociation.RouteTableAssociationConfig{
+			SubnetId:     privateSubnet.Id(),
+			RouteTableId: private.Id(),
+		})
+		// Without NAT the private subnets are isolated
+		if len(nats) > 0 {
+			route.NewRoute(stack, jsii.String("PrivateSubnet"+suffix+"DefaultRoute"), &route.RouteConfig{
+				RouteTableId:         private.Id(),
+				DestinationCidrBlock: jsii.String("0.0.0.0/0"),
+				NatGatewayId:         nats[len(nats)-1].Id(),
+			})
+		}
+		privateIDs = append(privateIDs, privateSubnet.Id())
+		routeTableIDs = append(routeTableIDs, private.Id())
+	}
+	result.PublicSubnetIDs, result.PrivateSubnetIDs, result.PrivateRouteTableIDs = &publicIDs, &privateIDs, &routeTableIDs
+	return result
+}
+
+// existingVpc looks up a VPC the landing zone manages. Its public subnets
+// are the ones that assign public IPs on launch, every other subnet is
+// private.
+func existingVpc(stack cdktf.TerraformStack, id string) *Vpc {
+	network := dataawsvpc.NewDataAwsVpc(stack, jsii.String("Vpc"), &dataawsvpc.DataAwsVpcConfig{
+		Id: jsii.String(id),
+	})
+	subnets := func(id string, public string) *[]*string {
+		return dataawssubnets.NewDataAwsSubnets(stack, jsii.String(id), &dataawssubnets.DataAwsSubnetsConfig{
+			Filter: &[]*dataawssubnets.DataAwsSubnetsFilter{
+				{Name: jsii.String("vpc-id"), Values: jsii.Strings(id)},
+				{Name: jsii.String("map-public-ip-on-launch"), Values: jsii.Strings(public)},
+			},
+		}).Ids()
+	}
+	routeTables := dataawsroutetables.NewDataAwsRouteTables(stack, jsii.String("PrivateRouteTables"), &dataawsroutetables.DataAwsRouteTablesConfig{
+		VpcId: network.Id(),
+	})
+	return &Vpc{
+		ID:                   network.Id(),
+		PublicSubnetIDs:      subnets("PublicSubnets", "true"),
+		PrivateSubnetIDs:     subnets("PrivateSubnets", "false"),
+		PrivateRouteTableIDs: routeTables.Ids(),
+	}
+}
+
+// subnetCidrs lays the public subnets and then the private subnets out one
+// after the other in the VPC's range, each aligned to its own size
+func subnetCidrs(layout NetworkConfig) ([]string, error) {
+	_, vpcRange, err := net.ParseCIDR(layout.Cidr)
+	if err != nil || vpcRange.IP.To4() == nil {
+		return nil, fmt.Errorf("invalid VPC CIDR %q", layout.Cidr)
+	}
+	vpcBits, _ := vpcRange.Mask.Size()
+	next := uint64(binary.BigEndian.Uint32(vpcRange.IP.To4()))
+	end := next + 1<<(32-vpcBits)
+
+	var cidrs []string
+	for _, mask := range []float64{layout.PublicSubnetMask, layout.PrivateSubnetMask} {
+		if int(mask) < vpcBits || mask > 28 {
+			return nil, fmt.Errorf("subnet mask /%v does not fit in %s (use /%d to /28)", mask, layout.Cidr, vpcBits)
+		}
+		size := uint64(1) << (32 - int(mask))
+		for i := 0; i < int(layout.MaxAzs); i++ {
+			// Round up to the next boundary of the subnet's size
+			next = (next + size - 1) / size * size
+			if next+size > end {
+				return nil, fmt.Errorf("%v public /%v and private /%v subnets do not fit in %s", layout.MaxAzs, layout.PublicSubnetMask, layout.PrivateSubnetMask, layout.Cidr)
+			}
+			ip := make(net.IP, 4)
+			binary.BigEndian.PutUint32(ip, uint32(next))
+			cidrs = append(cidrs, fmt.Sprintf("%s/%v", ip, mask))
+			next += size
+		}
+	}
+	return cidrs, nil
+}
+
+// vpcInterfaceEndpoints are the services a task needs to start without NAT:
+// pulling its image, fetching its secrets and shipping its logs, plus the
+// SSM channel ECS Exec sessions use
+var vpcInterfaceEndpoints = []struct {
+	id      string
+	service string
+}{
+	{"EcrApiEndpoint", "ecr.api"},
+	{"EcrDockerEndpoint", "ecr.dkr"},
+	{"LogsEndpoint", "logs"},
+	{"SecretsManagerEndpoint", "secretsmanager"},
+	{"SsmMessagesEndpoint", "ssmmessages"},
+}
+
+// NewVpcEndpoints keeps the tasks' AWS traffic inside the VPC: an S3 gateway
+// endpoint (ECR image layers live in S3) and interface endpoints reachable
+// from the app and XTDB. This saves NAT data charges and is what lets the
+// isolated subnets of the none NAT strategy run tasks at all; images from
+// public registries still need NAT there.
+func NewVpcEndpoints(stack cdktf.TerraformStack, cfg StackConfig, vpc *Vpc, sg *SecurityGroups) {
+	if !cfg.Network.VpcEndpoints {
+		return
+	}
+
+	vpcendpoint.NewVpcEndpoint(stack, jsii.String("S3Endpoint"), &vpcendpoint.VpcEndpointConfig{
+		VpcId:           vpc.ID,
+		ServiceName:     jsii.String("com.amazonaws." + cfg.Region + ".s3"),
+		VpcEndpointType: jsii.String("Gateway"),
+		RouteTableIds:   vpc.PrivateRouteTableIDs,
+	})
+
+	endpoints := newSecurityGroup(stack, cfg, vpc, "EndpointSecurityGroup", "endpoints", "VPC endpoints, reachable from the tasks only")
+	allowTraffic(stack, "AppToEndpoints", sg.App, endpoints, tcp(443), "HTTPS from the tasks")
+	allowTraffic(stack, "XTDBToEndpoints", sg.XTDB, endpoints, tcp(443), "HTTPS from the tasks")
+	for _, endpoint := range vpcInterfaceEndpoints {
+		vpcendpoint.NewVpcEndpoint(stack, jsii.String(endpoint.id), &vpcendpoint.VpcEndpointConfig{
+			VpcId:             vpc.ID,
+			ServiceName:       jsii.String("com.amazonaws." + cfg.Region + "." + endpoint.service),
+			VpcEndpointType:   jsii.String("Interface"),
+			SubnetIds:         vpc.PrivateSubnetIDs,
+			SecurityGroupIds:  &[]*string{endpoints.Id()},
+			PrivateDnsEnabled: jsii.Bool(true),
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aws/jsii-runtime-go"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/cloudwatchloggroup"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/kmskey"
+	"github.com/hashicorp/terraform-cdk-go/cdktf"
+)
+
+// cloudWatchAgentImage is the CloudWatch agent that scrapes XTDB's metrics
+const cloudWatchAgentImage = "public.ecr.aws/cloudwatch-agent/cloudwatch-agent:latest"
+
+// otelCollectorImage is the AWS Distro for OpenTelemetry collector
+const otelCollectorImage = "public.ecr.aws/aws-observability/aws-otel-collector:v0.41.1"
+
+// logRetentions are the retentions CloudWatch Logs supports, in days
+var logRetentions = map[float64]bool{
+	1: true, 3: true, 5: true, 7: true, 14: true, 30: true, 60: true, 90: true,
+	120: true, 150: true, 180: true, 365: true, 400: true, 545: true, 731: true,
+	1827: true, 3653: true,
+}
+
+// logRetention is the configured retention of every log group in the stack
+func logRetention(cfg StackConfig) *float64 {
+	return jsii.Number(cfg.Observability.LogRetentionDays)
+}
+
+// containerInsights is the configured Container Insights mode of the cluster
+func containerInsights(cfg StackConfig) string {
+	if cfg.Observability.ContainerInsights == InsightsEnhanced {
+		return "enhanced"
+	}
+	return "enabled"
+}
+
+// addXTDBMetricsAgent runs a CloudWatch agent sidecar that scrapes XTDB's
+// Prometheus endpoint and publishes the metrics under xtdbMetricsNamespace
+// as embedded metric format logs, dimensioned by ClusterName.
+func addXTDBMetricsAgent(stack cdktf.TerraformStack, cfg StackConfig, key kmskey.KmsKey, taskDef *TaskDefinition) {
+	logGroupName := "/ecs/" + *cfg.Name("xtdb-metrics")
+	metricsLogGroup := cloudwatchloggroup.NewCloudwatchLogGroup(stack, jsii.String("XTDBMetricsLogGroup"), &cloudwatchloggroup.CloudwatchLogGroupConfig{
+		Name:            jsii.String(logGroupName),
+		RetentionInDays: logRetention(cfg),
+		KmsKeyId:        key.Arn(),
+	})
+	taskDef.Roles.TaskRole.Grant("Metrics",
+		allow([]string{"logs:CreateLogStream", "logs:PutLogEvents"}, jsii.String(*metricsLogGroup.Arn()+":*")),
+		allow([]string{"logs:DescribeLogGroups", "logs:DescribeLogStreams"}, jsii.String("*")),
+	)
+
+	agentConfig, _ := json.Marshal(map[string]any{
+		"logs": map[string]any{
+			"metrics_collected": map[string]any{
+				"prometheus": map[string]any{
+					"log_group_name":         logGroupName,
+					"prometheus_config_path": "env:PROMETHEUS_CONFIG_CONTENT",
+					"emf_processor": map[string]any{
+						"metric_namespace": xtdbMetricsNamespace,
+						"metric_declaration": []map[string]any{{
+							"source_labels":    []string{"job"},
+							"label_matcher":    "^xtdb$",
+							"dimensions":       [][]string{{"ClusterName"}},
+							"metric_selectors": []string{"^.*$"},
+						}},
+					},
+				},
+			},
+			"force_flush_interval": 5,
+		},
+	})
+
+	// XTDB serves Prometheus metrics next to its healthz endpoints
+	prometheusConfig := fmt.Sprintf(`global:
+  scrape_interval: 1m
+  scrape_timeout: 10s
+scrape_configs:
+  - job_name: xtdb
+    metrics_path: /metrics
+    static_configs:
+      - targets: ["localhost:8080"]
+        labels:
+          ClusterName: %s
+`, cfg.ClusterName())
+
+	taskDef.AddContainer(&Container{
+		Name:              "XTDBMetricsAgent",
+		Image:             cloudWatchAgentImage,
+		MemoryReservation: 64,
+		Environment: []NameValue{
+			{Name: "CW_CONFIG_CONTENT", Value: string(agentConfig)},
+			{Name: "PROMETHEUS_CONFIG_CONTENT", Value: prometheusConfig},
+		},
+		LogConfiguration: awsLogs(cfg, taskDef.Roles.LogGroup, "cwagent"),
+	})
+}
+
+// ampRemoteWriteURL derives the remote write endpoint of an Amazon Managed
+// Prometheus workspace from its ARN, arn:aws:aps:<region>:<account>:workspace/<id>
+func ampRemoteWriteURL(workspaceArn string) (string, error) {
+	parts := strings.Split(workspaceArn, ":")
+	if len(parts) != 6 || parts[2] != "aps" || !strings.HasPrefix(parts[5], "workspace/") {
+		return "", fmt.Errorf("invalid AMP workspace ARN %q", workspaceArn)
+	}
+	return fmt.Sprintf("https://aps-workspaces.%s.amazonaws.com/workspaces/%s/api/v1/remote_write",
+		parts[3], strings.TrimPrefix(parts[5], "workspace/")), nil
+}
+
+// otelCollectorConfig receives OTLP on localhost and exports traces to X-Ray
+// and, with an AMP workspace configured, metrics to Managed Prometheus
+func otelCollectorConfig(cfg StackConfig) string {
+	config := fmt.Sprintf(`extensions:
+  health_check:
+  sigv4auth:
+    region: %[1]s
+    service: aps
+receivers:
+  otlp:
+    protocols:
+      grpc:
+        endpoint: 0.0.0.0:4317
+      http:
+        endpoint: 0.0.0.0:4318
+processors:
+  batch:
+exporters:
+  awsxray:
+    region: %[1]s
+`, cfg.Region)
+
+	if cfg.Observability.AmpWorkspaceArn != "" {
+		endpoint, _ := ampRemoteWriteURL(cfg.Observability.AmpWorkspaceArn)
+		config += fmt.Sprintf(`  prometheusremotewrite:
+    endpoint: %s
+    auth:
+      authenticator: sigv4auth
+    resource_to_telemetry_conversion:
+      enabled: true
+`, endpoint)
+	}
+
+	config += `service:
+  extensions: [health_check, sigv4auth]
+  pipelines:
+    traces:
+      receivers: [otlp]
+      processors: [batch]
+      exporters: [awsxray]
+`
+	if cfg.Observability.AmpWorkspaceArn != "" {
+		config += `    metrics:
+      receivers: [otlp]
+      processors: [batch]
+      exporters: [prometheusremotewrite]
+`
+	}
+	return config
+}
+
+// addOtelCollector adds an ADOT collector sidecar to a task and points the
+// container's OpenTelemetry SDK at it with the standard OTEL_* variables,
+// so the application needs no knowledge of X-Ray or AMP.
+func addOtelCollector(cfg StackConfig, taskDef *TaskDefinition, container *Container, name string) {
+	statements := []statement{
+		allow([]string{"xray:PutTraceSegments", "xray:PutTelemetryRecords", "xray:GetSamplingRules", "xray:GetSamplingTargets"}, jsii.String("*")),
+	}
+	metricsExporter := "none"
+	if arn := cfg.Observability.AmpWorkspaceArn; arn != "" {
+		metricsExporter = "otlp"
+		statements = append(statements, allow([]string{"aps:RemoteWrite"}, jsii.String(arn)))
+	}
+	taskDef.Roles.TaskRole.Grant("Tracing", statements...)
+
+	collector := taskDef.AddContainer(&Container{
+		Name:              "OtelCollector",
+		Image:             otelCollectorImage,
+		MemoryReservation: 64,
+		Environment:       []NameValue{{Name: "AOT_CONFIG_CONTENT", Value: otelCollectorConfig(cfg)}},
+		HealthCheck:       &HealthCheck{Command: []string{"CMD", "/healthcheck"}, Interval: 30, Timeout: 5, Retries: 3},
+		LogConfiguration:  awsLogs(cfg, taskDef.Roles.LogGroup, "otel"),
+	})
+
+	// Spans emitted during startup should not be dropped
+	container.DependOn(collector, "HEALTHY")
+	for _, env := range [][2]string{
+		{"OTEL_SERVICE_NAME", name},
+		{"OTEL_RESOURCE_ATTRIBUTES", "deployment.environment=" + cfg.Environment + ",service.namespace=" + cfg.NamePrefix},
+		{"OTEL_EXPORTER_OTLP_ENDPOINT", "http://localhost:4318"},
+		{"OTEL_EXPORTER_OTLP_PROTOCOL", "http/protobuf"},
+		{"OTEL_TRACES_EXPORTER", "otlp"},
+		{"OTEL_METRICS_EXPORTER", metricsExporter},
+		{"OTEL_LOGS_EXPORTER", "none"},
+		{"OTEL_PROPAGATORS", "tracecontext,baggage,xray"},
+	} {
+		container.AddEnvironment(env[0], env[1])
+	}
+}
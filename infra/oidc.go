@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/aws/jsii-runtime-go"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/iamopenidconnectprovider"
+	"github.com/hashicorp/terraform-cdk-go/cdktf"
+)
+
+// githubOidcURL is the issuer of GitHub Actions' OIDC tokens
+const githubOidcURL = "https://token.actions.githubusercontent.com"
+
+// NewDeployRole creates the role CI assumes with a GitHub Actions OIDC token,
+// so no long-lived access keys are stored in the repository. Only workflows
+// of the configured repository running on one of the configured branches can
+// assume it. The role may push app images, roll the services, use the
+// Terraform state and record deployments; the policies in PolicyArns are
+// attached on top for full infra applies. Returns nil when no repository is
+// configured.
+//
+// An account holds one provider per issuer, so when several environments
+// share an account all but one set oidcProviderArn to reuse it.
+func NewDeployRole(stack cdktf.TerraformStack, cfg StackConfig, repos []Repository, services []MonitoredService, roles []*ServiceRoles) *Role {
+	if cfg.Ci.GithubRepository == "" {
+		return nil
+	}
+
+	providerArn := jsii.String(cfg.Ci.OidcProviderArn)
+	if cfg.Ci.OidcProviderArn == "" {
+		providerArn = iamopenidconnectprovider.NewIamOpenidConnectProvider(stack, jsii.String("GithubOidc"), &iamopenidconnectprovider.IamOpenidConnectProviderConfig{
+			Url:          jsii.String(githubOidcURL),
+			ClientIdList: jsii.Strings("sts.amazonaws.com"),
+		}).Arn()
+	}
+
+	subjects := []string{}
+	for _, branch := range cfg.Ci.Branches {
+		subjects = append(subjects, "repo:"+cfg.Ci.GithubRepository+":ref:refs/heads/"+branch)
+	}
+	role := newRole(stack, "DeployRole", cfg.Name("deploy"), "Assumed by CI to deploy "+cfg.Environment,
+		map[string]interface{}{"Federated": providerArn},
+		map[string]interface{}{
+			"StringEquals": map[string]interface{}{
+				"token.actions.githubusercontent.com:aud": "sts.amazonaws.com",
+			},
+			"StringLike": map[string]interface{}{
+				"token.actions.githubusercontent.com:sub": subjects,
+			},
+		})
+	role.SetMaxSessionDuration(jsii.Number(60 * 60))
+	for i, arn := range cfg.Ci.PolicyArns {
+		role.Attach(fmt.Sprint("Policy", i), jsii.String(arn))
+	}
+
+	var repoArns, serviceArns []*string
+	for _, repo := range repos {
+		repoArns = append(repoArns, repo.Arn())
+	}
+	for _, svc := range services {
+		// An ECS service's id is its ARN
+		serviceArns = append(serviceArns, svc.Service.Id())
+	}
+	var roleArns []*string
+	for _, r := range roles {
+		roleArns = append(roleArns, r.TaskRole.Arn(), r.ExecutionRole.Arn())
+	}
+	anyResource := jsii.String("*")
+	role.Grant("Deploy",
+		allow([]string{
+			"ecr:BatchCheckLayerAvailability", "ecr:GetDownloadUrlForLayer", "ecr:BatchGetImage",
+			"ecr:PutImage", "ecr:InitiateLayerUpload", "ecr:UploadLayerPart", "ecr:CompleteLayerUpload",
+		}, repoArns...),
+		allow([]string{"ecr:GetAuthorizationToken"}, anyResource),
+		allow([]string{"ecs:UpdateService", "ecs:DescribeServices"}, serviceArns...),
+		// Task definitions cannot be scoped before they are registered
+		allow([]string{"ecs:RegisterTaskDefinition", "ecs:DescribeTaskDefinition"}, anyResource),
+		allow([]string{"iam:PassRole"}, roleArns...),
+		// Reconcile leaves the desired count of autoscaled services alone
+		allow([]string{"application-autoscaling:DescribeScalableTargets"}, anyResource),
+		// Drift checks report to the encrypted alerts topic
+		allow([]string{"sns:Publish"}, jsii.String("arn:aws:sns:"+cfg.Region+":*:"+*cfg.Name("alerts"))),
+		allow([]string{"sns:ListTopics"}, anyResource),
+		statement{
+			Effect:    "Allow",
+			Action:    []string{"kms:GenerateDataKey*", "kms:Decrypt"},
+			Resource:  []*string{anyResource},
+			Condition: map[string]interface{}{"StringEquals": map[string]interface{}{"kms:ViaService": "sns." + cfg.Region + ".amazonaws.com"}},
+		},
+		// DeployToECS records the image digests the tasks run
+		statement{
+			Effect:    "Allow",
+			Action:    []string{"ecs:ListTasks", "ecs:DescribeTasks"},
+			Resource:  []*string{anyResource},
+			Condition: map[string]interface{}{"ArnEquals": map[string]interface{}{"ecs:cluster": "arn:aws:ecs:" + cfg.Region + ":*:cluster/" + cfg.ClusterName()}},
+		},
+		// Approvals of gated deployments are issued by people, CI only consumes them
+		allow([]string{"ssm:GetParameter", "ssm:DeleteParameter"}, jsii.String("arn:aws:ssm:"+cfg.Region+":*:parameter/"+cfg.NamePrefix+"/deploy-approval")),
+		allow([]string{"ssm:GetParameter"}, jsii.String("arn:aws:ssm:"+cfg.Region+":*:parameter/"+cfg.NamePrefix+"/change-tickets/*")),
+	)
+
+	if !cfg.State.Local {
+		role.Grant("State",
+			allow([]string{"s3:ListBucket"}, jsii.String("arn:aws:s3:::"+cfg.State.Bucket)),
+			allow([]string{"s3:GetObject", "s3:PutObject"}, jsii.String("arn:aws:s3:::"+cfg.State.Bucket+"/"+cfg.StackID()+"-*/*")),
+			allow([]string{"dynamodb:GetItem", "dynamodb:PutItem", "dynamodb:DeleteItem"}, jsii.String("arn:aws:dynamodb:"+cfg.State.Region+":*:table/"+cfg.State.LockTable)),
+			allow([]string{"dynamodb:GetItem", "dynamodb:UpdateItem", "dynamodb:Scan"}, jsii.String("arn:aws:dynamodb:"+cfg.State.Region+":*:table/"+cfg.State.DeployTable)),
+			// CI appends to the audit log but cannot read or change it
+			allow([]string{"logs:CreateLogStream", "logs:PutLogEvents"}, jsii.String("arn:aws:logs:"+cfg.State.Region+":*:log-group:"+cfg.State.AuditLogGroup+":*")),
+		)
+	}
+
+	// Terraform assumes the account and state roles on top of CI's credentials
+	var assumed []*string
+	for _, arn := range []string{cfg.Account.RoleArn, cfg.State.RoleArn} {
+		if arn != "" {
+			assumed = append(assumed, jsii.String(arn))
+		}
+	}
+	if len(assumed) > 0 {
+		role.Allow("AssumeRoles", []string{"sts:AssumeRole"}, assumed...)
+	}
+
+	output(stack, "deploy_role_arn", role.Arn(), "Role CI assumes to deploy "+cfg.Environment)
+	return role
+}
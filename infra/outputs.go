@@ -0,0 +1,43 @@
+package main
+
+import (
+	"github.com/aws/jsii-runtime-go"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/lb"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/servicediscoveryprivatednsnamespace"
+	"github.com/hashicorp/terraform-cdk-go/cdktf"
+)
+
+// output adds a Terraform output to the stack. Names are snake_case so the
+// CI module can read them with `terraform output -json`.
+func output(stack cdktf.TerraformStack, name string, value *string, description string) {
+	cdktf.NewTerraformOutput(stack, jsii.String(name), &cdktf.TerraformOutputConfig{
+		Value:       value,
+		Description: jsii.String(description),
+	})
+}
+
+// NewDataOutputs outputs the identifiers of the data layer
+func NewDataOutputs(data *DataStack) {
+	output(data.Stack, "xtdb_file_system_id", data.Storage.FileSystem.Id(), "EFS filesystem holding the XTDB data")
+	if data.Storage.ObjectStore != nil {
+		output(data.Stack, "xtdb_bucket_name", data.Storage.ObjectStore.Bucket(), "S3 bucket of the XTDB object store")
+	}
+}
+
+// NewAppOutputs outputs where the environment is served from and the names
+// a deploy needs: the repositories to push to and the cluster and services
+// to update, and the namespace the services resolve each other in
+func NewAppOutputs(stack cdktf.TerraformStack, cfg StackConfig, cluster *Cluster, services []MonitoredService, alb lb.Lb, namespace servicediscoveryprivatednsnamespace.ServiceDiscoveryPrivateDnsNamespace, xtdbRepo Repository, appRepo Repository) {
+	output(stack, "alb_dns_name", alb.DnsName(), "DNS name of the app's load balancer")
+	if cfg.Domain.DomainName != "" {
+		output(stack, "app_url", jsii.String("https://"+cfg.Domain.DomainName), "Route53 record aliased to the load balancer")
+	}
+	output(stack, "cluster_name", cluster.Name, "ECS cluster running the services")
+	for _, svc := range services {
+		output(stack, svc.Name+"_service_name", svc.Service.Name(), "ECS service running "+svc.Name)
+	}
+	output(stack, "service_discovery_namespace_id", namespace.Id(), "Cloud Map namespace the services are registered in")
+	output(stack, "service_discovery_namespace_name", namespace.Name(), "DNS name of the service discovery namespace")
+	output(stack, "xtdb_repository_url", xtdbRepo.RepositoryUrl(), "ECR repository of the xtdb image")
+	output(stack, "app_repository_url", appRepo.RepositoryUrl(), "ECR repository of the app image")
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"github.com/aws/jsii-runtime-go"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/snstopic"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/snstopicsubscription"
+	"github.com/hashicorp/terraform-cdk-go/cdktf"
+)
+
+// NewPagerDutyRouting creates a PagerDuty service for the environment with
+// an Amazon CloudWatch integration, and subscribes the integration to the
+// alert topic, so alarms open and resolve incidents. The service escalates
+// through the configured escalation policy, or through a policy created here
+// that pages the on-call schedule. The provider reads its token from
+// PAGERDUTY_TOKEN. Nothing is created unless PagerDuty is enabled.
+func NewPagerDutyRouting(stack cdktf.TerraformStack, cfg StackConfig, alerts snstopic.SnsTopic) {
+	if !cfg.PagerDuty.Enabled {
+		return
+	}
+	requireProvider(stack, "pagerduty", "PagerDuty/pagerduty", "~> 3.18")
+
+	policyID := jsii.String(cfg.PagerDuty.EscalationPolicyID)
+	if cfg.PagerDuty.EscalationPolicyID == "" {
+		policyID = providerResource(stack, "PagerDutyEscalationPolicy", "pagerduty_escalation_policy", map[string]interface{}{
+			"name":      *cfg.Name("on-call"),
+			"num_loops": 2,
+			"rule": []map[string]interface{}{{
+				"escalation_delay_in_minutes": 30,
+				"target": []map[string]string{{
+					"type": "schedule_reference",
+					"id":   cfg.PagerDuty.ScheduleID,
+				}},
+			}},
+		}).GetStringAttribute(jsii.String("id"))
+	}
+
+	service := providerResource(stack, "PagerDutyService", "pagerduty_service", map[string]interface{}{
+		"name":                    *cfg.Name("alerts"),
+		"description":             "CloudWatch alarms of " + cfg.Environment,
+		"escalation_policy":       policyID,
+		"alert_creation":          "create_alerts_and_incidents",
+		"acknowledgement_timeout": "null", // alarms resolve their own incidents
+		"auto_resolve_timeout":    "null",
+	})
+
+	vendor := cdktf.NewTerraformDataSource(stack, jsii.String("PagerDutyCloudWatch"), &cdktf.TerraformResourceConfig{
+		TerraformResourceType: jsii.String("pagerduty_vendor"),
+	})
+	vendor.AddOverride(jsii.String("name"), "Amazon CloudWatch")
+	integration := providerResource(stack, "PagerDutyIntegration", "pagerduty_service_integration", map[string]interface{}{
+		"name":    "Amazon CloudWatch",
+		"service": service.GetStringAttribute(jsii.String("id")),
+		"vendor":  vendor.GetStringAttribute(jsii.String("id")),
+	})
+
+	snstopicsubscription.NewSnsTopicSubscription(stack, jsii.String("PagerDutySubscription"), &snstopicsubscription.SnsTopicSubscriptionConfig{
+		TopicArn:             alerts.Arn(),
+		Protocol:             jsii.String("https"),
+		Endpoint:             jsii.String("https://events.pagerduty.com/integration/" + *integration.GetStringAttribute(jsii.String("integration_key")) + "/enqueue"),
+		EndpointAutoConfirms: jsii.Bool(true),
+	})
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/aws/jsii-runtime-go"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/dataawsecrrepository"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/ecrlifecyclepolicy"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/ecrpullthroughcacherule"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/ecrregistryscanningconfiguration"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/ecrrepository"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/kmskey"
+	"github.com/hashicorp/terraform-cdk-go/cdktf"
+)
+
+// Repository is an ECR repository, created by the stack or looked up
+type Repository interface {
+	Arn() *string
+	Name() *string
+	RepositoryUrl() *string
+}
+
+// imageURI is the image of the repository the services run
+func imageURI(cfg StackConfig, repo Repository) string {
+	return *repo.RepositoryUrl() + ":" + cfg.Registry.ImageTag
+}
+
+// grantPull lets the role pull images from the repository
+func grantPull(role *Role, repo Repository) {
+	role.Grant("Pull",
+		allow([]string{"ecr:BatchCheckLayerAvailability", "ecr:GetDownloadUrlForLayer", "ecr:BatchGetImage"}, repo.Arn()),
+		allow([]string{"ecr:GetAuthorizationToken"}, jsii.String("*")),
+	)
+}
+
+// NewAppRepository creates the app's ECR repository and configures the
+// registry's scanning and Docker Hub cache. An existing repository is used
+// as is, with its own tag and lifecycle rules.
+func NewAppRepository(stack cdktf.TerraformStack, cfg StackConfig, key kmskey.KmsKey) Repository {
+	repo := newRepository(stack, cfg, key, "AppRepo", "app", cfg.Existing.AppRepository)
+
+	if cfg.Registry.EnhancedScanning {
+		// Scanning is configured for the whole registry; every environment
+		// writes the same rule so stacks sharing an account agree
+		ecrregistryscanningconfiguration.NewEcrRegistryScanningConfiguration(stack, jsii.String("RegistryScanning"), &ecrregistryscanningconfiguration.EcrRegistryScanningConfigurationConfig{
+			ScanType: jsii.String("ENHANCED"),
+			Rule: &[]*ecrregistryscanningconfiguration.EcrRegistryScanningConfigurationRule{{
+				ScanFrequency: jsii.String("SCAN_ON_PUSH"),
+				RepositoryFilter: &[]*ecrregistryscanningconfiguration.EcrRegistryScanningConfigurationRuleRepositoryFilter{{
+					Filter:     jsii.String(resourcePrefix + "-*"),
+					FilterType: jsii.String("WILDCARD"),
+				}},
+			}},
+		})
+	}
+
+	// Docker Hub needs credentials, stored in a secret named ecr-pullthroughcache/...
+	if arn := cfg.Registry.DockerHubSecretArn; arn != "" {
+		ecrpullthroughcacherule.NewEcrPullThroughCacheRule(stack, jsii.String("DockerHubCache"), &ecrpullthroughcacherule.EcrPullThroughCacheRuleConfig{
+			EcrRepositoryPrefix: cfg.Name("docker-hub"),
+			UpstreamRegistryUrl: jsii.String("registry-1.docker.io"),
+			CredentialArn:       jsii.String(arn),
+		})
+	}
+	return repo
+}
+
+// newRepository creates a service's ECR repository, encrypted with the stack
+// key, or looks up the existing one when a name is configured. Tags cannot
+// be overwritten, so every image CI pushes keeps its tag; untagged images
+// expire and only the most recent tagged images are kept.
+func newRepository(stack cdktf.TerraformStack, cfg StackConfig, key kmskey.KmsKey, id string, name string, existing string) Repository {
+	if existing != "" {
+		return dataawsecrrepository.NewDataAwsEcrRepository(stack, jsii.String(id), &dataawsecrrepository.DataAwsEcrRepositoryConfig{
+			Name: jsii.String(existing),
+		})
+	}
+
+	repo := ecrrepository.NewEcrRepository(stack, jsii.String(id), &ecrrepository.EcrRepositoryConfig{
+		Name:               cfg.Name(name),
+		ImageTagMutability: jsii.String("IMMUTABLE"),
+		ImageScanningConfiguration: &ecrrepository.EcrRepositoryImageScanningConfiguration{
+			ScanOnPush: jsii.Bool(true), // Basic scanning when enhanced scanning is off
+		},
+		EncryptionConfiguration: &[]*ecrrepository.EcrRepositoryEncryptionConfiguration{{
+			EncryptionType: jsii.String("KMS"),
+			KmsKey:         key.Arn(),
+		}},
+	})
+
+	policy, _ := json.Marshal(map[string]interface{}{
+		"rules": []map[string]interface{}{
+			{
+				"rulePriority": 1,
+				"description":  "Expire untagged images",
+				"selection": map[string]interface{}{
+					"tagStatus":   "untagged",
+					"countType":   "sinceImagePushed",
+					"countUnit":   "days",
+					"countNumber": cfg.Registry.UntaggedDays,
+				},
+				"action": map[string]string{"type": "expire"},
+			},
+			{
+				"rulePriority": 2,
+				"description":  "Keep the most recent images",
+				"selection": map[string]interface{}{
+					"tagStatus":   "any",
+					"countType":   "imageCountMoreThan",
+					"countNumber": cfg.Registry.KeepImages,
+				},
+				"action": map[string]string{"type": "expire"},
+			},
+		},
+	})
+	ecrlifecyclepolicy.NewEcrLifecyclePolicy(stack, jsii.String(id+"Lifecycle"), &ecrlifecyclepolicy.EcrLifecyclePolicyConfig{
+		Repository: repo.Name(),
+		Policy:     jsii.String(string(policy)),
+	})
+	return repo
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/aws/jsii-runtime-go"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/kmskey"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/secretsmanagersecret"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/secretsmanagersecretrotation"
+	"github.com/hashicorp/terraform-cdk-go/cdktf"
+)
+
+// regenerateScript rotates a {"username", "password"} secret by generating a
+// new password. Nothing needs to be told about it: XTDB and the app only
+// read the credentials at task start, so setSecret and testSecret have
+// nothing to do and the tasks are restarted once the rotation succeeds.
+const regenerateScript = `import json
+import boto3
+
+sm = boto3.client("secretsmanager")
+
+
+def handler(event, context):
+    arn, token, step = event["SecretId"], event["ClientRequestToken"], event["Step"]
+    if step == "createSecret":
+        try:
+            sm.get_secret_value(SecretId=arn, VersionId=token, VersionStage="AWSPENDING")
+        except sm.exceptions.ResourceNotFoundException:
+            secret = json.loads(sm.get_secret_value(SecretId=arn, VersionStage="AWSCURRENT")["SecretString"])
+            secret["password"] = sm.get_random_password(PasswordLength=32, ExcludePunctuation=True)["RandomPassword"]
+            sm.put_secret_value(SecretId=arn, ClientRequestToken=token, SecretString=json.dumps(secret), VersionStages=["AWSPENDING"])
+    elif step == "finishSecret":
+        for version, stages in sm.describe_secret(SecretId=arn)["VersionIdsToStages"].items():
+            if "AWSCURRENT" in stages and version != token:
+                sm.update_secret_version_stage(SecretId=arn, VersionStage="AWSCURRENT", MoveToVersionId=token, RemoveFromVersionId=version)
+`
+
+// restartScript forces a new deployment of each service, so their tasks
+// start again with the current version of the secrets
+const restartScript = `import os
+import boto3
+
+ecs = boto3.client("ecs")
+
+
+def handler(event, context):
+    for service in os.environ["SERVICES"].split(","):
+        ecs.update_service(cluster=os.environ["CLUSTER"], service=service, forceNewDeployment=True)
+`
+
+// newRegenerateRotation rotates the generated pgwire credentials with a
+// Lambda that generates a new password
+func newRegenerateRotation(stack cdktf.TerraformStack, cfg StackConfig, secret secretsmanagersecret.SecretsmanagerSecret, key kmskey.KmsKey) {
+	fn := newPythonFunction(stack, cfg, "CredentialsRotation", "rotate-credentials",
+		"Generates a new password for the "+cfg.Environment+" database credentials", regenerateScript, nil)
+	fn.Role.Grant("Rotation",
+		allow([]string{
+			"secretsmanager:GetSecretValue",
+			"secretsmanager:PutSecretValue",
+			"secretsmanager:DescribeSecret",
+			"secretsmanager:UpdateSecretVersionStage",
+		}, secret.Arn()),
+		allow([]string{"secretsmanager:GetRandomPassword"}, jsii.String("*")),
+		allow([]string{"kms:Decrypt", "kms:GenerateDataKey"}, key.Arn()),
+	)
+	permission := fn.AllowInvoke("SecretsManager", "secretsmanager.amazonaws.com", secret.Arn())
+
+	secretsmanagersecretrotation.NewSecretsmanagerSecretRotation(stack, jsii.String("CredentialsRotationSchedule"), &secretsmanagersecretrotation.SecretsmanagerSecretRotationConfig{
+		SecretId:          secret.Id(),
+		RotationLambdaArn: fn.Arn(),
+		RotationRules: &secretsmanagersecretrotation.SecretsmanagerSecretRotationRotationRules{
+			AutomaticallyAfterDays: jsii.Number(cfg.Secrets.RotationDays),
+		},
+		DependsOn: &[]cdktf.ITerraformDependable{permission},
+	})
+}
+
+// NewRotationRestarts restarts the services whenever one of the secrets
+// finishes rotating. Task definitions reference the secrets without a
+// version, so ECS resolves AWSCURRENT when a task starts, but running tasks
+// keep the credentials they started with until they are replaced. The
+// restart is an ordinary deployment, rolled back by the circuit breaker if
+// the new tasks do not come up.
+func NewRotationRestarts(stack cdktf.TerraformStack, cfg StackConfig, services []MonitoredService, secretArns ...*string) {
+	if cfg.Secrets.RotationDays <= 0 {
+		return
+	}
+
+	var names []string
+	var arns []*string
+	for _, svc := range services {
+		names = append(names, *cfg.Name(svc.Name))
+		// An ECS service's id is its ARN
+		arns = append(arns, svc.Service.Id())
+	}
+	var rotated []*string
+	for _, arn := range secretArns {
+		if arn != nil {
+			rotated = append(rotated, arn)
+		}
+	}
+
+	fn := newPythonFunction(stack, cfg, "RotationRestart", "restart-on-rotation",
+		"Restarts the "+cfg.Environment+" services after a credentials rotation", restartScript, map[string]*string{
+			"CLUSTER":  jsii.String(cfg.ClusterName()),
+			"SERVICES": jsii.String(strings.Join(names, ",")),
+		})
+	fn.Role.Allow("Restart", []string{"ecs:UpdateService"}, arns...)
+
+	rule := newEventRule(stack, "RotationSucceeded", cfg.Name("rotation-succeeded"), "Restarts the services once their credentials have rotated", map[string]interface{}{
+		"source":      []string{"aws.secretsmanager"},
+		"detail-type": []string{"AWS Service Event via CloudTrail"},
+		"detail": map[string]interface{}{
+			"eventName": []string{"RotationSucceeded"},
+			"additionalEventData": map[string]interface{}{
+				"SecretId": rotated,
+			},
+		},
+	})
+	rule.Invoke("Restart", fn)
+}
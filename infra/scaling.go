@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/jsii-runtime-go"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/appautoscalingpolicy"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/appautoscalingscheduledaction"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/appautoscalingtarget"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/ecsservice"
+	"github.com/hashicorp/terraform-cdk-go/cdktf"
+)
+
+// newScalableTaskCount lets Application Auto Scaling set the service's
+// desired count between min and max
+func newScalableTaskCount(stack cdktf.TerraformStack, cfg StackConfig, id string, service ecsservice.EcsService, min float64, max float64) appautoscalingtarget.AppautoscalingTarget {
+	return appautoscalingtarget.NewAppautoscalingTarget(stack, jsii.String(id), &appautoscalingtarget.AppautoscalingTargetConfig{
+		ServiceNamespace:  jsii.String("ecs"),
+		ScalableDimension: jsii.String("ecs:service:DesiredCount"),
+		ResourceId:        jsii.String(fmt.Sprintf("service/%s/%s", cfg.ClusterName(), *service.Name())),
+		MinCapacity:       jsii.Number(min),
+		MaxCapacity:       jsii.Number(max),
+	})
+}
+
+// NewAppAutoScaling scales the app service on CPU and on ALB requests per
+// target. Outside production it can also scale the service to zero outside
+// business hours and back up on weekday mornings.
+func NewAppAutoScaling(stack cdktf.TerraformStack, cfg StackConfig, appService ecsservice.EcsService, alb *AppLoadBalancer) appautoscalingtarget.AppautoscalingTarget {
+	scaling := newScalableTaskCount(stack, cfg, "AppScaling", appService, cfg.Scaling.MinCapacity, cfg.Scaling.MaxCapacity)
+
+	trackTarget := func(id string, metric string, label *string, target float64) {
+		appautoscalingpolicy.NewAppautoscalingPolicy(stack, jsii.String(id), &appautoscalingpolicy.AppautoscalingPolicyConfig{
+			Name:              cfg.Name("app-" + metric),
+			PolicyType:        jsii.String("TargetTrackingScaling"),
+			ServiceNamespace:  scaling.ServiceNamespace(),
+			ScalableDimension: scaling.ScalableDimension(),
+			ResourceId:        scaling.ResourceId(),
+			TargetTrackingScalingPolicyConfiguration: &appautoscalingpolicy.AppautoscalingPolicyTargetTrackingScalingPolicyConfiguration{
+				TargetValue: jsii.Number(target),
+				PredefinedMetricSpecification: &appautoscalingpolicy.AppautoscalingPolicyTargetTrackingScalingPolicyConfigurationPredefinedMetricSpecification{
+					PredefinedMetricType: jsii.String(metric),
+					ResourceLabel:        label,
+				},
+				ScaleInCooldown:  jsii.Number(300),
+				ScaleOutCooldown: jsii.Number(60),
+			},
+		})
+	}
+	trackTarget("CpuScaling", "ECSServiceAverageCPUUtilization", nil, cfg.Scaling.TargetCpuPercent)
+	trackTarget("RequestScaling", "ALBRequestCountPerTarget", targetGroupLabel(alb), cfg.Scaling.RequestsPerTarget)
+
+	if cfg.Scaling.OffHoursScaleDown {
+		scaleOnBusinessHours(stack, cfg, "App", scaling, cfg.Scaling.MinCapacity, cfg.Scaling.MaxCapacity)
+	}
+
+	return scaling
+}
+
+// NewXTDBHibernation scales the XTDB service to zero outside business hours
+// along with the app, and back to its desired count on weekday mornings.
+// The data stays on EFS and S3 in the meantime. A service that is already
+// at zero, like the standby's, is left alone.
+func NewXTDBHibernation(stack cdktf.TerraformStack, cfg StackConfig, xtdbService ecsservice.EcsService) {
+	if !cfg.Scaling.OffHoursScaleDown || cfg.XTDB.DesiredCount == 0 {
+		return
+	}
+	scaling := newScalableTaskCount(stack, cfg, "XTDBScaling", xtdbService, cfg.XTDB.DesiredCount, cfg.XTDB.DesiredCount)
+	scaleOnBusinessHours(stack, cfg, "XTDB", scaling, cfg.XTDB.DesiredCount, cfg.XTDB.DesiredCount)
+}
+
+// scaleOnBusinessHours sets the capacity to min..max on weekday mornings and
+// to zero on weekday evenings; the Friday evening scale-down holds through
+// the weekend
+func scaleOnBusinessHours(stack cdktf.TerraformStack, cfg StackConfig, id string, scaling appautoscalingtarget.AppautoscalingTarget, min float64, max float64) {
+	schedule := func(suffix string, name string, hour float64, min float64, max float64) {
+		appautoscalingscheduledaction.NewAppautoscalingScheduledAction(stack, jsii.String(id+suffix), &appautoscalingscheduledaction.AppautoscalingScheduledActionConfig{
+			Name:              cfg.Name(strings.ToLower(id) + "-" + name),
+			ServiceNamespace:  scaling.ServiceNamespace(),
+			ScalableDimension: scaling.ScalableDimension(),
+			ResourceId:        scaling.ResourceId(),
+			Schedule:          jsii.String(fmt.Sprintf("cron(0 %v ? * MON-FRI *)", hour)),
+			Timezone:          jsii.String(cfg.Scaling.TimeZone),
+			ScalableTargetAction: &appautoscalingscheduledaction.AppautoscalingScheduledActionScalableTargetAction{
+				MinCapacity: jsii.String(fmt.Sprint(min)),
+				MaxCapacity: jsii.String(fmt.Sprint(max)),
+			},
+		})
+	}
+	schedule("BusinessHoursScaleUp", "business-hours", cfg.Scaling.BusinessHoursStart, min, max)
+	schedule("OffHoursScaleDown", "off-hours", cfg.Scaling.BusinessHoursEnd, 0, 0)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/aws/jsii-runtime-go"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/kmskey"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/secretsmanagersecret"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/secretsmanagersecretversion"
+	"github.com/hashicorp/terraform-cdk-go/cdktf"
+)
+
+// newGeneratedSecret stores a value generated by Terraform in Secrets
+// Manager, encrypted with the stack key. Rotation takes over once the first
+// version is written, so later applies leave the current value alone.
+func newGeneratedSecret(stack cdktf.TerraformStack, key kmskey.KmsKey, id string, name *string, description string, value *string) secretsmanagersecret.SecretsmanagerSecret {
+	secret := secretsmanagersecret.NewSecretsmanagerSecret(stack, jsii.String(id), &secretsmanagersecret.SecretsmanagerSecretConfig{
+		Name:        name,
+		Description: jsii.String(description),
+		KmsKeyId:    key.Arn(),
+	})
+	secretsmanagersecretversion.NewSecretsmanagerSecretVersion(stack, jsii.String(id+"Version"), &secretsmanagersecretversion.SecretsmanagerSecretVersionConfig{
+		SecretId:     secret.Id(),
+		SecretString: value,
+		Lifecycle:    &cdktf.TerraformResourceLifecycle{IgnoreChanges: jsii.Strings("secret_string")},
+	})
+	return secret
+}
+
+// NewDatabaseCredentials generates the pgwire credentials XTDB and the app
+// share, stored as {"username": ..., "password": ...} in Secrets Manager
+func NewDatabaseCredentials(stack cdktf.TerraformStack, cfg StackConfig, key kmskey.KmsKey) secretsmanagersecret.SecretsmanagerSecret {
+	value, _ := json.Marshal(map[string]string{
+		"username": cfg.Secrets.DatabaseUsername,
+		"password": *randomPassword(stack, "DatabasePassword"),
+	})
+	secret := newGeneratedSecret(stack, key, "DatabaseCredentials", cfg.Name("database-credentials"), "XTDB pgwire credentials for "+cfg.Environment, jsii.String(string(value)))
+
+	// XTDB takes its users from these variables, so there is no database to
+	// log in to and update; a new password is enough
+	if cfg.Secrets.RotationDays > 0 {
+		newRegenerateRotation(stack, cfg, secret, key)
+	}
+
+	return secret
+}
+
+// credentialSecrets maps container variables to the credential fields. ECS
+// resolves them at task start, once the execution role may read the secret.
+// No version is pinned, so a new task always gets the current credentials;
+// NewRotationRestarts replaces the running tasks after a rotation.
+func credentialSecrets(container *Container, secretArn *string, usernameVar string, passwordVar string) {
+	container.AddSecret(usernameVar, *secretArn+":username::")
+	container.AddSecret(passwordVar, *secretArn+":password::")
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"github.com/aws/jsii-runtime-go"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/securitygroup"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/vpcsecuritygroupegressrule"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/vpcsecuritygroupingressrule"
+	"github.com/hashicorp/terraform-cdk-go/cdktf"
+)
+
+// SecurityGroups holds one security group per tier. Traffic only flows
+// internet → ALB → app → XTDB → EFS, each hop on its own ports.
+type SecurityGroups struct {
+	Alb  securitygroup.SecurityGroup
+	App  securitygroup.SecurityGroup
+	XTDB securitygroup.SecurityGroup
+	Efs  securitygroup.SecurityGroup
+}
+
+// portRange is a range of TCP ports a rule opens
+type portRange struct {
+	from, to float64
+}
+
+func tcp(port float64) portRange {
+	return portRange{port, port}
+}
+
+func tcpRange(from, to float64) portRange {
+	return portRange{from, to}
+}
+
+// newSecurityGroup creates a security group that allows no traffic until rules are added
+func newSecurityGroup(stack cdktf.TerraformStack, cfg StackConfig, vpc *Vpc, id string, name string, description string) securitygroup.SecurityGroup {
+	return securitygroup.NewSecurityGroup(stack, jsii.String(id), &securitygroup.SecurityGroupConfig{
+		VpcId:       vpc.ID,
+		Name:        cfg.Name(name),
+		Description: jsii.String(description),
+		Tags:        &map[string]*string{"Name": cfg.Name(name)},
+	})
+}
+
+// allowTraffic lets one security group reach another on the ports, with an
+// egress rule on the source and the matching ingress rule on the destination
+func allowTraffic(stack cdktf.TerraformStack, id string, from, to securitygroup.SecurityGroup, ports portRange, description string) {
+	vpcsecuritygroupegressrule.NewVpcSecurityGroupEgressRule(stack, jsii.String(id+"Egress"), &vpcsecuritygroupegressrule.VpcSecurityGroupEgressRuleConfig{
+		SecurityGroupId:           from.Id(),
+		ReferencedSecurityGroupId: to.Id(),
+		IpProtocol:                jsii.String("tcp"),
+		FromPort:                  jsii.Number(ports.from),
+		ToPort:                    jsii.Number(ports.to),
+		Description:               jsii.String(description),
+	})
+	vpcsecuritygroupingressrule.NewVpcSecurityGroupIngressRule(stack, jsii.String(id+"Ingress"), &vpcsecuritygroupingressrule.VpcSecurityGroupIngressRuleConfig{
+		SecurityGroupId:           to.Id(),
+		ReferencedSecurityGroupId: from.Id(),
+		IpProtocol:                jsii.String("tcp"),
+		FromPort:                  jsii.Number(ports.from),
+		ToPort:                    jsii.Number(ports.to),
+		Description:               jsii.String(description),
+	})
+}
+
+// allowFromInternet opens the ports of a security group to any IPv4 address
+func allowFromInternet(stack cdktf.TerraformStack, id string, to securitygroup.SecurityGroup, ports portRange, description string) {
+	vpcsecuritygroupingressrule.NewVpcSecurityGroupIngressRule(stack, jsii.String(id), &vpcsecuritygroupingressrule.VpcSecurityGroupIngressRuleConfig{
+		SecurityGroupId: to.Id(),
+		CidrIpv4:        jsii.String("0.0.0.0/0"),
+		IpProtocol:      jsii.String("tcp"),
+		FromPort:        jsii.Number(ports.from),
+		ToPort:          jsii.Number(ports.to),
+		Description:     jsii.String(description),
+	})
+}
+
+// allowToInternet lets a security group reach any IPv4 address on the ports
+func allowToInternet(stack cdktf.TerraformStack, id string, from securitygroup.SecurityGroup, ports portRange, description string) {
+	vpcsecuritygroupegressrule.NewVpcSecurityGroupEgressRule(stack, jsii.String(id), &vpcsecuritygroupegressrule.VpcSecurityGroupEgressRuleConfig{
+		SecurityGroupId: from.Id(),
+		CidrIpv4:        jsii.String("0.0.0.0/0"),
+		IpProtocol:      jsii.String("tcp"),
+		FromPort:        jsii.Number(ports.from),
+		ToPort:          jsii.Number(ports.to),
+		Description:     jsii.String(description),
+	})
+}
+
+// NewSecurityGroups creates the least-privilege security groups between the ALB, app, XTDB and EFS
+func NewSecurityGroups(stack cdktf.TerraformStack, cfg StackConfig, vpc *Vpc) *SecurityGroups {
+	sg := &SecurityGroups{
+		Alb:  newSecurityGroup(stack, cfg, vpc, "AlbSecurityGroup", "alb", "Public HTTP(S) entry point"),
+		App:  newSecurityGroup(stack, cfg, vpc, "AppSecurityGroup", "app", "Clojure app tasks, reachable from the ALB only"),
+		XTDB: newSecurityGroup(stack, cfg, vpc, "XTDBSecurityGroup", "xtdb", "XTDB tasks, reachable from the app only"),
+		Efs:  newSecurityGroup(stack, cfg, vpc, "EfsSecurityGroup", "efs", "XTDB data volume, reachable from XTDB only"),
+	}
+
+	// The ALB is the only thing facing the internet
+	allowFromInternet(stack, "AlbHttp", sg.Alb, tcp(80), "HTTP from the internet")
+	allowFromInternet(stack, "AlbHttps", sg.Alb, tcp(443), "HTTPS from the internet")
+	allowTraffic(stack, "AlbToApp", sg.Alb, sg.App, tcp(58950), "App HTTP from the ALB")
+
+	allowTraffic(stack, "AppToXTDBHttp", sg.App, sg.XTDB, tcp(3000), "XTDB HTTP API from the app")
+	allowTraffic(stack, "AppToXTDBPgwire", sg.App, sg.XTDB, tcp(5432), "XTDB pgwire from the app")
+	allowTraffic(stack, "XTDBToEfs", sg.XTDB, sg.Efs, tcp(2049), "NFS from XTDB to the data volume")
+
+	// Tasks still need HTTPS out to pull images, fetch secrets and ship logs
+	allowToInternet(stack, "AppHttps", sg.App, tcp(443), "HTTPS to AWS APIs and ECR")
+	allowToInternet(stack, "XTDBHttps", sg.XTDB, tcp(443), "HTTPS to AWS APIs and ECR")
+
+	return sg
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/aws/jsii-runtime-go"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/ssmparameter"
+	"github.com/hashicorp/terraform-cdk-go/cdktf"
+)
+
+// AppSettings is the app's configuration as SSM parameters, one per
+// setting, keyed by the variable name the app reads
+type AppSettings struct {
+	Parameters map[string]ssmparameter.SsmParameter
+	names      []string // sorted, for a stable container definition
+}
+
+// NewAppSettings writes each configured app setting to an SSM parameter
+// under /<name prefix>/app/, e.g. /clj-xtdb-devops-staging/app/APP_ENV, so
+// flags and tuning knobs can be read and audited outside the task
+// definition. Values change with the config; the app sees a new value when
+// its tasks next start.
+func NewAppSettings(stack cdktf.TerraformStack, cfg StackConfig) *AppSettings {
+	settings := &AppSettings{Parameters: map[string]ssmparameter.SsmParameter{}}
+	for name := range cfg.AppSettings {
+		settings.names = append(settings.names, name)
+	}
+	sort.Strings(settings.names)
+
+	for _, name := range settings.names {
+		settings.Parameters[name] = ssmparameter.NewSsmParameter(stack, jsii.String("AppSetting"+name), &ssmparameter.SsmParameterConfig{
+			Name:        jsii.String("/" + cfg.NamePrefix + "/app/" + name),
+			Type:        jsii.String("String"),
+			Value:       jsii.String(cfg.AppSettings[name]),
+			Description: jsii.String("App setting " + name + " for " + cfg.Environment),
+		})
+	}
+	return settings
+}
+
+// Connect passes every setting to the app container as a variable of the
+// same name, and lets the execution role read them when ECS starts a task
+func (s *AppSettings) Connect(app *Container, roles *ServiceRoles) {
+	if len(s.names) == 0 {
+		return
+	}
+	var arns []*string
+	for _, name := range s.names {
+		arn := s.Parameters[name].Arn()
+		app.AddSecret(name, *arn)
+		arns = append(arns, arn)
+	}
+	roles.ExecutionRole.Allow("AppSettings", []string{"ssm:GetParameters"}, arns...)
+}
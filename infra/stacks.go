@@ -0,0 +1,207 @@
+package main
+
+import (
+	"github.com/aws/constructs-go/constructs/v10"
+	"github.com/aws/jsii-runtime-go"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/dataawscalleridentity"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/kmskey"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/provider"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/rdscluster"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/secretsmanagersecret"
+	"github.com/hashicorp/terraform-cdk-go/cdktf"
+)
+
+// Each environment is split into stacks by how often they change, so an app
+// deploy never plans against the VPC or the data stores. Later layers
+// reference earlier ones through CDKTF's cross-stack outputs.
+const (
+	LayerNetwork = "network"
+	LayerData    = "data"
+	LayerApp     = "app"
+	// LayerEks replaces the app layer when the services run on EKS
+	LayerEks = "eks"
+	// LayerAppRunner replaces the app layer when the app runs on App Runner
+	LayerAppRunner = "apprunner"
+	// LayerGcp is the whole environment when it runs on GCP
+	LayerGcp = "gcp"
+	// LayerAzure is the whole environment when it runs on Azure
+	LayerAzure = "azure"
+)
+
+// newEnvironmentStack creates one layer's stack with its own state and AWS provider
+func newEnvironmentStack(scope constructs.Construct, cfg StackConfig, layer string) cdktf.TerraformStack {
+	stack := cdktf.NewTerraformStack(scope, jsii.String(cfg.LayerStackID(layer)))
+	configureBackend(stack, cfg, layer)
+
+	// Configure the AWS Provider
+	newAwsProvider(stack, "", cfg.Region, cfg.Account.RoleArn, cfg.Account.ID, defaultTags(cfg.Tags, cfg.Environment))
+	return stack
+}
+
+// requireProvider pins a provider of a stack that declares its resources
+// with providerResource, next to whatever providers the stack already has
+func requireProvider(stack cdktf.TerraformStack, name string, source string, version string) {
+	stack.AddOverride(jsii.String("terraform.required_providers."+name), map[string]string{
+		"source":  source,
+		"version": version,
+	})
+}
+
+// providerResource declares a resource of a provider the module has no
+// bindings for, with its attributes as they are written in Terraform. Only
+// the AWS provider has bindings in go.mod; the other clouds and SaaS
+// providers are declared this way.
+func providerResource(stack cdktf.TerraformStack, id string, resourceType string, attributes map[string]interface{}) cdktf.TerraformResource {
+	r := cdktf.NewTerraformResource(stack, jsii.String(id), &cdktf.TerraformResourceConfig{
+		TerraformResourceType: jsii.String(resourceType),
+	})
+	for name, value := range attributes {
+		r.AddOverride(jsii.String(name), value)
+	}
+	return r
+}
+
+// randomPassword generates a password, kept in the Terraform state like
+// every other generated secret
+func randomPassword(stack cdktf.TerraformStack, id string) *string {
+	requireProvider(stack, "random", "hashicorp/random", "~> 3.6")
+	return providerResource(stack, id, "random_password", map[string]interface{}{
+		"length":  32,
+		"special": false,
+	}).GetStringAttribute(jsii.String("result"))
+}
+
+// newAwsProvider configures a stack's AWS provider for the region, tagging
+// everything the stack creates. With a roleArn it assumes that role, and with
+// an accountID it refuses to touch any other account. An alias adds a
+// provider for another region that resources have to name.
+func newAwsProvider(stack cdktf.TerraformStack, alias, region, roleArn, accountID string, tags *map[string]*string) provider.AwsProvider {
+	id := "AWS"
+	config := &provider.AwsProviderConfig{
+		Region:      jsii.String(region),
+		DefaultTags: &[]*provider.AwsProviderDefaultTags{{Tags: tags}},
+	}
+	if alias != "" {
+		id += "-" + alias
+		config.Alias = jsii.String(alias)
+	}
+	if roleArn != "" {
+		config.AssumeRole = &[]*provider.AwsProviderAssumeRole{{
+			RoleArn:     jsii.String(roleArn),
+			SessionName: stack.Node().Id(),
+		}}
+	}
+	if accountID != "" {
+		config.AllowedAccountIds = jsii.Strings(accountID)
+	}
+	return provider.NewAwsProvider(stack, jsii.String(id), config)
+}
+
+// NetworkStack is the environment's VPC, the security groups between its
+// tiers and its VPC endpoints
+type NetworkStack struct {
+	Stack          cdktf.TerraformStack
+	Vpc            *Vpc
+	SecurityGroups *SecurityGroups
+}
+
+// NewNetworkStack creates the network layer of an environment
+func NewNetworkStack(scope constructs.Construct, cfg StackConfig) *NetworkStack {
+	stack := newEnvironmentStack(scope, cfg, LayerNetwork)
+
+	// Create the VPC
+	vpc := NewNetwork(stack, cfg)
+
+	// Create the security groups between the tiers
+	securityGroups := NewSecurityGroups(stack, cfg, vpc)
+	NewVpcEndpoints(stack, cfg, vpc, securityGroups)
+
+	if cfg.Tags.ActivateCostAllocation {
+		activateCostAllocationTags(stack)
+	}
+
+	return &NetworkStack{Stack: stack, Vpc: vpc, SecurityGroups: securityGroups}
+}
+
+// DataStack is everything in an environment that holds state: the stack
+// key, XTDB's storage and transaction log, the optional database, cache and
+// queues, and the generated credentials
+type DataStack struct {
+	Stack       cdktf.TerraformStack
+	Key         kmskey.KmsKey
+	Storage     *XTDBStorage
+	Database    rdscluster.RdsCluster
+	Cache       *AppCache
+	Messaging   *AppMessaging
+	Credentials secretsmanagersecret.SecretsmanagerSecret
+}
+
+// NewDataStack creates the data layer of an environment. When configured,
+// Terraform refuses to destroy any of its resources. With a standby, the
+// object store replicates to the standby's.
+func NewDataStack(scope constructs.Construct, cfg StackConfig, network *NetworkStack, standby *DataStack) *DataStack {
+	stack := newEnvironmentStack(scope, cfg, LayerData)
+	stack.AddDependency(network.Stack)
+	vpc, securityGroups := network.Vpc, network.SecurityGroups
+
+	var replica *Bucket
+	var replicaKey kmskey.KmsKey
+	if standby != nil {
+		stack.AddDependency(standby.Stack)
+		replica, replicaKey = standby.Storage.ObjectStore, standby.Key
+	}
+
+	// Encrypt everything in the environment with its own key
+	key := NewStackKey(stack, cfg)
+
+	// Create an EFS filesystem for persistent XTDB data
+	storage := NewXTDBStorage(stack, cfg, key, vpc, securityGroups.Efs, replica, replicaKey)
+	storage.TxLog = NewKafkaTxLog(stack, cfg, vpc, securityGroups)
+
+	data := &DataStack{
+		Stack:     stack,
+		Key:       key,
+		Storage:   storage,
+		Database:  NewBackingDatabase(stack, cfg, key, vpc, securityGroups),
+		Cache:     NewAppCache(stack, cfg, key, vpc, securityGroups),
+		Messaging: NewAppMessaging(stack, cfg, key),
+		// Generate the database credentials shared by XTDB and the App
+		Credentials: NewDatabaseCredentials(stack, cfg, key),
+	}
+
+	NewDataOutputs(data)
+
+	if cfg.PreventDestroy {
+		cdktf.Aspects_Of(stack).Add(&preventDestroy{})
+	}
+	return data
+}
+
+// preventDestroy sets prevent_destroy on every Terraform resource it visits
+type preventDestroy struct{}
+
+func (preventDestroy) Visit(node constructs.IConstruct) {
+	resource, ok := node.(cdktf.TerraformResource)
+	if !ok {
+		return
+	}
+	lifecycle := resource.Lifecycle()
+	if lifecycle == nil {
+		lifecycle = &cdktf.TerraformResourceLifecycle{}
+	}
+	lifecycle.PreventDestroy = jsii.Bool(true)
+	resource.SetLifecycle(lifecycle)
+}
+
+// accountIDs caches the account of each stack
+var accountIDs = map[cdktf.TerraformStack]*string{}
+
+// stackAccount is the account the stack deploys to, looked up once per stack
+func stackAccount(stack cdktf.TerraformStack) *string {
+	if id, ok := accountIDs[stack]; ok {
+		return id
+	}
+	caller := dataawscalleridentity.NewDataAwsCallerIdentity(stack, jsii.String("CallerIdentity"), &dataawscalleridentity.DataAwsCallerIdentityConfig{})
+	accountIDs[stack] = caller.AccountId()
+	return accountIDs[stack]
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/aws/jsii-runtime-go"
+	"github.com/hashicorp/terraform-cdk-go/cdktf"
+)
+
+// update rewrites the golden files: go test ./... -update
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// testConfigs are the representative configs every test synthesizes: the
+// defaults of each environment, and prod with every optional feature on
+var testConfigs = map[string]func() StackConfig{
+	"dev":     func() StackConfig { return DefaultStackConfig("dev") },
+	"staging": func() StackConfig { return DefaultStackConfig("staging") },
+	"prod":    func() StackConfig { return DefaultStackConfig("prod") },
+	"prod-full": func() StackConfig {
+		cfg := DefaultStackConfig("prod")
+		cfg.Domain.DomainName = "app.example.com"
+		cfg.Database.Enabled = true
+		cfg.Messaging.Queues = []string{"email-jobs"}
+		cfg.Messaging.EventBus = true
+		cfg.Observability.Tracing = true
+		cfg.Cdn.Enabled = true
+		cfg.Admin.PgAdmin = true
+		cfg.Secrets.RotationDays = 30
+		cfg.Failover = FailoverConfig{Enabled: true, Region: "us-west-2"}
+		return cfg
+	},
+}
+
+// terraformJSON is the part of a synthesized stack the tests look at
+type terraformJSON struct {
+	Resource map[string]map[string]map[string]any `json:"resource"`
+}
+
+// resources returns the resources of a type, keyed by their logical name
+func (s terraformJSON) resources(resourceType string) map[string]map[string]any {
+	return s.Resource[resourceType]
+}
+
+// synthesizedEnvironment maps each stack id of an environment to its JSON
+type synthesizedEnvironment map[string]string
+
+// synthesize builds the stacks of a config the way main does, with local
+// state, and returns each stack synthesized
+func synthesize(t *testing.T, cfg StackConfig) synthesizedEnvironment {
+	t.Helper()
+	cfg.State.Local = true
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("invalid test config: %v", err)
+	}
+
+	app := cdktf.Testing_App(&cdktf.TestingAppConfig{StackTraces: jsii.Bool(false)})
+	var stacks []cdktf.TerraformStack
+	var standby *DataStack
+	if cfg.Failover.Enabled {
+		dr := cfg.Standby()
+		drNetwork := NewNetworkStack(app, dr)
+		standby = NewDataStack(app, dr, drNetwork, nil)
+		stacks = append(stacks, drNetwork.Stack, standby.Stack, NewAppStack(app, dr, drNetwork, standby))
+	}
+	network := NewNetworkStack(app, cfg)
+	data := NewDataStack(app, cfg, network, standby)
+	stacks = append(stacks, network.Stack, data.Stack, NewAppStack(app, cfg, network, data))
+
+	synthesized := synthesizedEnvironment{}
+	for _, stack := range stacks {
+		synthesized[*stack.Node().Id()] = *cdktf.Testing_Synth(stack, jsii.Bool(false))
+	}
+	return synthesized
+}
+
+func (e synthesizedEnvironment) parse(t *testing.T, stackID string) terraformJSON {
+	t.Helper()
+	raw, ok := e[stackID]
+	if !ok {
+		t.Fatalf("stack %s was not synthesized", stackID)
+	}
+	var parsed terraformJSON
+	if err := json.Unmarshal([]byte(raw), &parsed); err != nil {
+		t.Fatalf("stack %s is not valid JSON: %v", stackID, err)
+	}
+	return parsed
+}
+
+// forEachConfig runs a subtest per representative config
+func forEachConfig(t *testing.T, test func(t *testing.T, cfg StackConfig, stacks synthesizedEnvironment)) {
+	for name, config := range testConfigs {
+		t.Run(name, func(t *testing.T) {
+			cfg := config()
+			test(t, cfg, synthesize(t, cfg))
+		})
+	}
+}
+
+// coreResources are the resources every environment needs, by layer; the
+// other tests would pass vacuously on a stack that emits none of them
+var coreResources = map[string][]string{
+	LayerNetwork: {"aws_vpc", "aws_subnet", "aws_nat_gateway", "aws_security_group"},
+	LayerData:    {"aws_kms_key", "aws_efs_file_system", "aws_efs_access_point", "aws_secretsmanager_secret"},
+	LayerApp:     {"aws_ecs_cluster", "aws_ecs_task_definition", "aws_ecs_service", "aws_lb", "aws_lb_target_group", "aws_lb_listener", "aws_ecr_repository"},
+}
+
+func TestCoreResources(t *testing.T) {
+	forEachConfig(t, func(t *testing.T, cfg StackConfig, stacks synthesizedEnvironment) {
+		for layer, resourceTypes := range coreResources {
+			stack := stacks.parse(t, cfg.LayerStackID(layer))
+			for _, resourceType := range resourceTypes {
+				if len(stack.resources(resourceType)) == 0 {
+					t.Errorf("the %s stack has no %s", layer, resourceType)
+				}
+			}
+		}
+
+		services := map[string]bool{}
+		for _, service := range stacks.parse(t, cfg.LayerStackID(LayerApp)).resources("aws_ecs_service") {
+			services[strings.Trim(mustJSON(t, service["name"]), `"`)] = true
+		}
+		for _, name := range []string{"xtdb", "app"} {
+			if !services[*cfg.Name(name)] {
+				t.Errorf("no ECS service %s in the app stack", *cfg.Name(name))
+			}
+		}
+	})
+}
+
+func TestEncryptionAtRest(t *testing.T) {
+	forEachConfig(t, func(t *testing.T, cfg StackConfig, stacks synthesizedEnvironment) {
+		for id := range stacks {
+			stack := stacks.parse(t, id)
+			for name, fs := range stack.resources("aws_efs_file_system") {
+				if fs["encrypted"] != true || fs["kms_key_id"] == nil {
+					t.Errorf("%s: EFS %s is not encrypted with the stack key", id, name)
+				}
+			}
+			for name, db := range stack.resources("aws_rds_cluster") {
+				if db["storage_encrypted"] != true || db["kms_key_id"] == nil {
+					t.Errorf("%s: Aurora cluster %s is not encrypted with the stack key", id, name)
+				}
+			}
+			for name, repo := range stack.resources("aws_ecr_repository") {
+				if !strings.Contains(mustJSON(t, repo["encryption_configuration"]), "KMS") {
+					t.Errorf("%s: ECR repository %s is not KMS encrypted", id, name)
+				}
+			}
+			for _, resourceType := range []string{"aws_sqs_queue", "aws_sns_topic"} {
+				for name, r := range stack.resources(resourceType) {
+					if r["kms_master_key_id"] == nil {
+						t.Errorf("%s: %s %s is not KMS encrypted", id, resourceType, name)
+					}
+				}
+			}
+			for name, group := range stack.resources("aws_cloudwatch_log_group") {
+				if group["kms_key_id"] == nil {
+					t.Errorf("%s: log group %s is not encrypted with the stack key", id, name)
+				}
+			}
+			encrypted := map[string]bool{}
+			for _, sse := range stack.resources("aws_s3_bucket_server_side_encryption_configuration") {
+				encrypted[mustJSON(t, sse["bucket"])] = true
+			}
+			for name := range stack.resources("aws_s3_bucket") {
+				if !encrypted[mustJSON(t, "${aws_s3_bucket."+name+".id}")] && !encrypted[mustJSON(t, "${aws_s3_bucket."+name+".bucket}")] {
+					t.Errorf("%s: bucket %s has no server-side encryption configuration", id, name)
+				}
+			}
+		}
+	})
+}
+
+func TestNoOpenIngress(t *testing.T) {
+	forEachConfig(t, func(t *testing.T, cfg StackConfig, stacks synthesizedEnvironment) {
+		for id := range stacks {
+			stack := stacks.parse(t, id)
+			groups := stack.resources("aws_security_group")
+			isAlb := func(ref any) bool {
+				for name, sg := range groups {
+					if strings.Contains(mustJSON(t, ref), "aws_security_group."+name+".") {
+						return strings.HasSuffix(mustJSON(t, sg["name"]), `-alb"`)
+					}
+				}
+				return false
+			}
+			open := func(rule map[string]any) bool {
+				return strings.Contains(mustJSON(t, rule["cidr_blocks"]), "0.0.0.0/0") ||
+					strings.Contains(mustJSON(t, rule["cidr_ipv4"]), "0.0.0.0/0") ||
+					strings.Contains(mustJSON(t, rule["ipv6_cidr_blocks"]), "::/0")
+			}
+			webPort := func(rule map[string]any) bool {
+				port := mustJSON(t, rule["from_port"])
+				return (port == "80" || port == "443") && port == mustJSON(t, rule["to_port"])
+			}
+
+			for name, sg := range groups {
+				ingress, _ := sg["ingress"].([]any)
+				for _, r := range ingress {
+					rule := r.(map[string]any)
+					if open(rule) && !(strings.HasSuffix(mustJSON(t, sg["name"]), `-alb"`) && webPort(rule)) {
+						t.Errorf("%s: security group %s is open to the internet on %v", id, name, rule["from_port"])
+					}
+				}
+			}
+			for name, rule := range stack.resources("aws_security_group_rule") {
+				if rule["type"] == "ingress" && open(rule) && !(isAlb(rule["security_group_id"]) && webPort(rule)) {
+					t.Errorf("%s: rule %s opens port %v to the internet", id, name, rule["from_port"])
+				}
+			}
+			for name, rule := range stack.resources("aws_vpc_security_group_ingress_rule") {
+				if open(rule) && !(isAlb(rule["security_group_id"]) && webPort(rule)) {
+					t.Errorf("%s: rule %s opens port %v to the internet", id, name, rule["from_port"])
+				}
+			}
+		}
+	})
+}
+
+// containerDefinition is the part of an ECS container definition the tests look at
+type containerDefinition struct {
+	Name        string `json:"name"`
+	Environment []struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	} `json:"environment"`
+	Secrets []struct {
+		Name      string `json:"name"`
+		ValueFrom string `json:"valueFrom"`
+	} `json:"secrets"`
+}
+
+// containers returns the containers of every task definition in the stack
+func containers(t *testing.T, stack terraformJSON) []containerDefinition {
+	t.Helper()
+	var all []containerDefinition
+	for name, taskDef := range stack.resources("aws_ecs_task_definition") {
+		raw, _ := taskDef["container_definitions"].(string)
+		var defs []containerDefinition
+		if err := json.Unmarshal([]byte(raw), &defs); err != nil {
+			t.Fatalf("task definition %s has invalid container definitions: %v", name, err)
+		}
+		all = append(all, defs...)
+	}
+	return all
+}
+
+func TestContainerWiring(t *testing.T) {
+	forEachConfig(t, func(t *testing.T, cfg StackConfig, stacks synthesizedEnvironment) {
+		app := stacks.parse(t, cfg.LayerStackID(LayerApp))
+		found := map[string]bool{}
+		for _, c := range containers(t, app) {
+			vars := map[string]string{}
+			for _, env := range c.Environment {
+				vars[env.Name] = env.Value
+			}
+			secrets := map[string]string{}
+			for _, secret := range c.Secrets {
+				secrets[secret.Name] = secret.ValueFrom
+			}
+
+			switch c.Name {
+			case "AppContainer":
+				found[c.Name] = true
+				if vars["XTDB_ADDR"] == "" {
+					t.Error("the app container has no XTDB_ADDR")
+				}
+				for _, name := range []string{"XTDB_USERNAME", "XTDB_PASSWORD", "APP_ENV"} {
+					if secrets[name] == "" {
+						t.Errorf("the app container does not get %s from a secret or parameter", name)
+					}
+				}
+				if cfg.Cache.Enabled && (vars["REDIS_HOST"] == "" || secrets["REDIS_AUTH_TOKEN"] == "") {
+					t.Error("the app container is not connected to the cache")
+				}
+				for _, queue := range cfg.Messaging.Queues {
+					if vars["QUEUE_"+strings.ToUpper(strings.ReplaceAll(queue, "-", "_"))+"_URL"] == "" {
+						t.Errorf("the app container has no URL for queue %s", queue)
+					}
+				}
+			case "XTDBContainer":
+				found[c.Name] = true
+				for _, name := range []string{"POSTGRES_USER", "POSTGRES_PASSWORD"} {
+					if secrets[name] == "" {
+						t.Errorf("the XTDB container does not get %s from a secret", name)
+					}
+				}
+				if cfg.Database.Enabled && secrets["XTDB_POSTGRESQL_PASSWORD"] == "" {
+					t.Error("the XTDB container is not connected to the backing database")
+				}
+			}
+			// Credentials only ever come from secrets
+			for name, value := range vars {
+				if strings.Contains(name, "PASSWORD") || strings.Contains(name, "TOKEN") {
+					t.Errorf("container %s has %s=%q in plain text", c.Name, name, value)
+				}
+			}
+		}
+		for _, name := range []string{"AppContainer", "XTDBContainer"} {
+			if !found[name] {
+				t.Errorf("no %s in the app stack", name)
+			}
+		}
+		// Outside the mesh XTDB_ADDR only resolves through Cloud Map
+		if !cfg.Mesh.Enabled {
+			registered := false
+			for _, svc := range app.resources("aws_service_discovery_service") {
+				if svc["name"] == "xtdb-service" {
+					registered = true
+				}
+			}
+			if !registered {
+				t.Error("XTDB is not registered in service discovery, so XTDB_ADDR does not resolve")
+			}
+		}
+	})
+}
+
+// volatile matches the parts of a synthesized stack that change without the
+// definition changing: asset hashes and the CDKTF version
+var volatile = regexp.MustCompile(`[0-9a-f]{64}|"version": "[0-9.]+"`)
+
+func TestSnapshots(t *testing.T) {
+	forEachConfig(t, func(t *testing.T, cfg StackConfig, stacks synthesizedEnvironment) {
+		for id, raw := range stacks {
+			got := volatile.ReplaceAllString(raw, "<volatile>")
+			// One directory per config, since prod and prod-full share stack ids
+			path := filepath.Join("testdata", filepath.Base(t.Name()), id+".json")
+			if *update {
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				continue
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("no snapshot of %s, create it with go test ./... -update: %v", id, err)
+			}
+			if got != string(want) {
+				t.Errorf("%s differs from %s; review the change and rerun with -update if it is intended", id, path)
+			}
+		}
+	})
+}
+
+func mustJSON(t *testing.T, v any) string {
+	t.Helper()
+	out, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/aws/jsii-runtime-go"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/backupplan"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/backupselection"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/backupvault"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/efsaccesspoint"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/efsfilesystem"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/efsmounttarget"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/kmskey"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/s3bucketlifecycleconfiguration"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/s3bucketreplicationconfiguration"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/securitygroup"
+	"github.com/hashicorp/terraform-cdk-go/cdktf"
+)
+
+// EFS throughput modes
+const (
+	ThroughputElastic     = "elastic"
+	ThroughputBursting    = "bursting"
+	ThroughputProvisioned = "provisioned"
+)
+
+// Where XTDB keeps its object store. With s3 the EFS volume only holds the
+// local transaction log and a disk cache of the object store.
+const (
+	ObjectStoreEfs = "efs"
+	ObjectStoreS3  = "s3"
+)
+
+// xtdbDataDir is where the data volume is mounted in the XTDB task
+const xtdbDataDir = "/var/lib/xtdb"
+
+// xtdbConfigPath is the node config written for the S3 object store
+const xtdbConfigPath = xtdbDataDir + "/xtdb.yaml"
+
+// xtdbConfigImage writes the XTDB node config onto the data volume
+const xtdbConfigImage = "public.ecr.aws/docker/library/busybox:1.37"
+
+// efsLifecyclePolicies maps the infrequent-access transitions EFS supports, in days
+var efsLifecyclePolicies = map[float64]string{
+	1:   "AFTER_1_DAY",
+	7:   "AFTER_7_DAYS",
+	14:  "AFTER_14_DAYS",
+	30:  "AFTER_30_DAYS",
+	60:  "AFTER_60_DAYS",
+	90:  "AFTER_90_DAYS",
+	180: "AFTER_180_DAYS",
+	270: "AFTER_270_DAYS",
+	365: "AFTER_365_DAYS",
+}
+
+// XTDBStorage is XTDB's data volume, the access point tasks mount it
+// through and, when configured, the S3 object store bucket and Kafka
+// transaction log
+type XTDBStorage struct {
+	FileSystem  efsfilesystem.EfsFileSystem
+	AccessPoint efsaccesspoint.EfsAccessPoint
+	ObjectStore *Bucket
+	TxLog       *KafkaTxLog
+	key         kmskey.KmsKey
+}
+
+// NewXTDBStorage creates XTDB's EFS volume, encrypted with the stack key
+// and mounted in every private subnet. Tasks only see /xtdb through an
+// access point that enforces the XTDB POSIX user, idle files move to
+// infrequent access, and AWS Backup snapshots the volume daily when a
+// retention is configured. With a replica, the object store replicates to
+// that bucket, encrypted with replicaKey.
+func NewXTDBStorage(stack cdktf.TerraformStack, cfg StackConfig, key kmskey.KmsKey, vpc *Vpc, sg securitygroup.SecurityGroup, replica *Bucket, replicaKey kmskey.KmsKey) *XTDBStorage {
+	lifecycle := []*efsfilesystem.EfsFileSystemLifecyclePolicy{
+		{TransitionToPrimaryStorageClass: jsii.String("AFTER_1_ACCESS")},
+	}
+	if days := cfg.Storage.InfrequentAccessDays; days > 0 {
+		lifecycle = append(lifecycle, &efsfilesystem.EfsFileSystemLifecyclePolicy{TransitionToIa: jsii.String(efsLifecyclePolicies[days])})
+	}
+	config := &efsfilesystem.EfsFileSystemConfig{
+		CreationToken:   cfg.Name("xtdb-data"),
+		Encrypted:       jsii.Bool(true),
+		KmsKeyId:        key.Arn(),
+		LifecyclePolicy: &lifecycle,
+		ThroughputMode:  jsii.String(cfg.Storage.ThroughputMode),
+		Tags:            &map[string]*string{"Name": cfg.Name("xtdb-data")},
+	}
+	if cfg.Storage.ThroughputMode == ThroughputProvisioned {
+		config.ProvisionedThroughputInMibps = jsii.Number(cfg.Storage.ProvisionedMiBps)
+	}
+	fs := efsfilesystem.NewEfsFileSystem(stack, jsii.String("XTDBFileSystem"), config)
+
+	subnets := cdktf.TerraformIterator_FromList(vpc.PrivateSubnetIDs)
+	efsmounttarget.NewEfsMountTarget(stack, jsii.String("XTDBMountTargets"), &efsmounttarget.EfsMountTargetConfig{
+		ForEach:        subnets,
+		FileSystemId:   fs.Id(),
+		SubnetId:       cdktf.Token_AsString(subnets.Value(), nil),
+		SecurityGroups: &[]*string{sg.Id()},
+	})
+
+	uid, gid := jsii.Number(cfg.Storage.XTDBUid), jsii.Number(cfg.Storage.XTDBGid)
+	accessPoint := efsaccesspoint.NewEfsAccessPoint(stack, jsii.String("XTDBAccessPoint"), &efsaccesspoint.EfsAccessPointConfig{
+		FileSystemId: fs.Id(),
+		PosixUser:    &efsaccesspoint.EfsAccessPointPosixUser{Uid: uid, Gid: gid},
+		RootDirectory: &efsaccesspoint.EfsAccessPointRootDirectory{
+			Path:         jsii.String("/xtdb"),
+			CreationInfo: &efsaccesspoint.EfsAccessPointRootDirectoryCreationInfo{OwnerUid: uid, OwnerGid: gid, Permissions: jsii.String("750")},
+		},
+	})
+
+	if days := cfg.Storage.BackupRetentionDays; days > 0 {
+		vault := backupvault.NewBackupVault(stack, jsii.String("XTDBBackupVault"), &backupvault.BackupVaultConfig{
+			Name:      cfg.Name("xtdb-data"),
+			KmsKeyArn: key.Arn(),
+		})
+		plan := backupplan.NewBackupPlan(stack, jsii.String("XTDBBackupPlan"), &backupplan.BackupPlanConfig{
+			Name: cfg.Name("xtdb-data"),
+			Rule: &[]*backupplan.BackupPlanRule{{
+				RuleName:        jsii.String("daily"),
+				TargetVaultName: vault.Name(),
+				Schedule:        jsii.String("cron(0 5 * * ? *)"),
+				Lifecycle:       &backupplan.BackupPlanRuleLifecycle{DeleteAfter: jsii.Number(days)},
+			}},
+		})
+		role := newRole(stack, "XTDBBackupRole", cfg.Name("xtdb-data-backup"), "Snapshots the XTDB data volume", servicePrincipal("backup.amazonaws.com"), nil)
+		role.Attach("Backup", jsii.String("arn:aws:iam::aws:policy/service-role/AWSBackupServiceRolePolicyForBackup"))
+		backupselection.NewBackupSelection(stack, jsii.String("XTDBBackupSelection"), &backupselection.BackupSelectionConfig{
+			Name:       cfg.Name("xtdb-data"),
+			PlanId:     plan.Id(),
+			IamRoleArn: role.Arn(),
+			Resources:  &[]*string{fs.Arn()},
+		})
+	}
+
+	storage := &XTDBStorage{FileSystem: fs, AccessPoint: accessPoint, key: key}
+	if cfg.Storage.ObjectStore == ObjectStoreS3 {
+		bucket := newPrivateBucket(stack, "XTDBObjectStore", cfg.Name("xtdb-objects"), key, true)
+		s3bucketlifecycleconfiguration.NewS3BucketLifecycleConfiguration(stack, jsii.String("XTDBObjectStoreLifecycle"), &s3bucketlifecycleconfiguration.S3BucketLifecycleConfigurationConfig{
+			Bucket: bucket.Id(),
+			Rule: &[]*s3bucketlifecycleconfiguration.S3BucketLifecycleConfigurationRule{
+				{
+					Id:     jsii.String("expire-old-versions"),
+					Status: jsii.String("Enabled"),
+					Filter: &s3bucketlifecycleconfiguration.S3BucketLifecycleConfigurationRuleFilter{},
+					NoncurrentVersionExpiration: &s3bucketlifecycleconfiguration.S3BucketLifecycleConfigurationRuleNoncurrentVersionExpiration{
+						NoncurrentDays: jsii.Number(cfg.Storage.NoncurrentVersionDays),
+					},
+					AbortIncompleteMultipartUpload: &s3bucketlifecycleconfiguration.S3BucketLifecycleConfigurationRuleAbortIncompleteMultipartUpload{
+						DaysAfterInitiation: jsii.Number(7),
+					},
+				},
+				{
+					Id:     jsii.String("intelligent-tiering"),
+					Status: jsii.String("Enabled"),
+					Filter: &s3bucketlifecycleconfiguration.S3BucketLifecycleConfigurationRuleFilter{},
+					Transition: &[]*s3bucketlifecycleconfiguration.S3BucketLifecycleConfigurationRuleTransition{{
+						StorageClass: jsii.String("INTELLIGENT_TIERING"),
+						Days:         jsii.Number(30),
+					}},
+				},
+			},
+		})
+		if replica != nil {
+			replicate(stack, cfg, bucket, key, replica, replicaKey)
+		}
+		storage.ObjectStore = bucket
+	}
+	return storage
+}
+
+// replicate copies new objects and deletes of the bucket to the replica,
+// re-encrypting them with the replica's key. Both buckets have to be
+// versioned first.
+func replicate(stack cdktf.TerraformStack, cfg StackConfig, bucket *Bucket, key kmskey.KmsKey, replica *Bucket, replicaKey kmskey.KmsKey) {
+	role := newRole(stack, "XTDBObjectStoreReplicationRole", cfg.Name("xtdb-objects-replication"), "Replicates the XTDB object store", servicePrincipal("s3.amazonaws.com"), nil)
+	role.Grant("Replication",
+		allow([]string{"s3:GetReplicationConfiguration", "s3:ListBucket"}, bucket.Arn()),
+		allow([]string{"s3:GetObjectVersionForReplication", "s3:GetObjectVersionAcl", "s3:GetObjectVersionTagging"}, jsii.String(*bucket.Arn()+"/*")),
+		allow([]string{"s3:ReplicateObject", "s3:ReplicateDelete", "s3:ReplicateTags"}, jsii.String(*replica.Arn()+"/*")),
+		allow([]string{"kms:Decrypt"}, key.Arn()),
+		allow([]string{"kms:Encrypt"}, replicaKey.Arn()),
+	)
+
+	s3bucketreplicationconfiguration.NewS3BucketReplicationConfigurationA(stack, jsii.String("XTDBObjectStoreReplication"), &s3bucketreplicationconfiguration.S3BucketReplicationConfigurationAConfig{
+		Bucket: bucket.Id(),
+		Role:   role.Arn(),
+		Rule: &[]*s3bucketreplicationconfiguration.S3BucketReplicationConfigurationRule{{
+			Id:     jsii.String("failover"),
+			Status: jsii.String("Enabled"),
+			Filter: &s3bucketreplicationconfiguration.S3BucketReplicationConfigurationRuleFilter{},
+			DeleteMarkerReplication: &s3bucketreplicationconfiguration.S3BucketReplicationConfigurationRuleDeleteMarkerReplication{
+				Status: jsii.String("Enabled"),
+			},
+			SourceSelectionCriteria: &s3bucketreplicationconfiguration.S3BucketReplicationConfigurationRuleSourceSelectionCriteria{
+				SseKmsEncryptedObjects: &s3bucketreplicationconfiguration.S3BucketReplicationConfigurationRuleSourceSelectionCriteriaSseKmsEncryptedObjects{
+					Status: jsii.String("Enabled"),
+				},
+			},
+			Destination: &s3bucketreplicationconfiguration.S3BucketReplicationConfigurationRuleDestination{
+				Bucket: replica.Arn(),
+				EncryptionConfiguration: &s3bucketreplicationconfiguration.S3BucketReplicationConfigurationRuleDestinationEncryptionConfiguration{
+					ReplicaKmsKeyId: replicaKey.Arn(),
+				},
+			},
+		}},
+		DependsOn: &[]cdktf.ITerraformDependable{bucket.Versioning},
+	})
+}
+
+// GrantReadWrite lets a task role use the volume, object store and tx log
+func (s *XTDBStorage) GrantReadWrite(roles *ServiceRoles) {
+	roles.TaskRole.Allow("DataVolume", []string{
+		"elasticfilesystem:ClientMount",
+		"elasticfilesystem:ClientWrite",
+		"elasticfilesystem:ClientRootAccess",
+	}, s.FileSystem.Arn())
+	if s.ObjectStore != nil {
+		grantBucketReadWrite(roles.TaskRole, "ObjectStore", s.ObjectStore, s.key)
+	}
+	if s.TxLog != nil {
+		s.TxLog.GrantReadWrite(roles)
+	}
+}
+
+// needsNodeConfig reports whether the image's default local setup is replaced
+func (s *XTDBStorage) needsNodeConfig() bool {
+	return s.ObjectStore != nil || s.TxLog != nil
+}
+
+// nodeConfig is the XTDB node config for a remote object store or Kafka
+// transaction log; whatever stays local lives on the EFS volume
+func (s *XTDBStorage) nodeConfig(cfg StackConfig) string {
+	config := `server:
+  port: 5432
+healthz:
+  port: 8080
+modules:
+  - !HttpServer
+    port: 3000
+`
+	if s.TxLog != nil {
+		config += s.TxLog.logConfig()
+	} else {
+		config += fmt.Sprintf("log: !Local\n  path: %s/log\n", xtdbDataDir)
+	}
+
+	if s.ObjectStore != nil {
+		config += fmt.Sprintf(`storage: !Remote
+  objectStore: !S3
+    bucket: %s
+    prefix: xtdb
+  localDiskCache: %s/cache
+`, *cfg.Name("xtdb-objects"), xtdbDataDir)
+	} else {
+		config += fmt.Sprintf("storage: !Local\n  path: %s/objects\n", xtdbDataDir)
+	}
+	return config
+}
+
+// Command is the XTDB container command, nil to keep the image's default
+func (s *XTDBStorage) Command() []string {
+	if !s.needsNodeConfig() {
+		return nil
+	}
+	return []string{"-f", xtdbConfigPath}
+}
+
+// Configure points the XTDB container at the S3 object store and Kafka
+// transaction log. An init container writes the node config onto the data
+// volume before XTDB starts with it; with neither configured the image's
+// own config is used.
+func (s *XTDBStorage) Configure(cfg StackConfig, taskDef *TaskDefinition, xtdb *Container, volume string) {
+	if !s.needsNodeConfig() {
+		return
+	}
+
+	writer := taskDef.AddContainer(&Container{
+		Name:              "XTDBConfigWriter",
+		Image:             xtdbConfigImage,
+		MemoryReservation: 16,
+		EntryPoint:        []string{"sh", "-c"},
+		Command:           []string{`printf '%s' "$XTDB_CONFIG" > ` + xtdbConfigPath},
+		LogConfiguration:  awsLogs(cfg, taskDef.Roles.LogGroup, "config"),
+	})
+	writer.AddEnvironment("XTDB_CONFIG", s.nodeConfig(cfg))
+	writer.AddMountPoint(volume, xtdbDataDir)
+
+	xtdb.DependOn(writer, "SUCCESS")
+	xtdb.AddEnvironment("AWS_REGION", cfg.Region)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"github.com/aws/jsii-runtime-go"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/cecostallocationtag"
+	"github.com/hashicorp/terraform-cdk-go/cdktf"
+)
+
+// Tag keys put on every resource, in the order they are applied
+const (
+	TagEnvironment = "environment"
+	TagService     = "service"
+	TagOwner       = "owner"
+	TagCostCenter  = "cost-center"
+)
+
+var costAllocationTags = []string{TagEnvironment, TagService, TagOwner, TagCostCenter}
+
+// defaultTags are the environment and the configured ownership tags, which
+// the AWS provider puts on every resource of the stack that supports tags
+func defaultTags(tags TagsConfig, environment string) *map[string]*string {
+	values := []string{environment, tags.Service, tags.Owner, tags.CostCenter}
+	defaults := map[string]*string{}
+	for i, key := range costAllocationTags {
+		defaults[key] = jsii.String(values[i])
+	}
+	return &defaults
+}
+
+// activateCostAllocationTags makes the tag keys available in Cost Explorer
+// and budgets. Activation is account-wide, so every environment writes the
+// same keys.
+func activateCostAllocationTags(stack cdktf.TerraformStack) {
+	for _, key := range costAllocationTags {
+		cecostallocationtag.NewCeCostAllocationTag(stack, jsii.String("CostAllocationTag-"+key), &cecostallocationtag.CeCostAllocationTagConfig{
+			TagKey: jsii.String(key),
+			Status: jsii.String("Active"),
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/jsii-runtime-go"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/cloudwatchloggroup"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/ecsservice"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/ecstaskdefinition"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/securitygroup"
+	"github.com/hashicorp/terraform-cdk-go/cdktf"
+)
+
+// Container is one container of a task definition, in the shape of the
+// task definition's container definitions JSON
+type Container struct {
+	Name                  string                 `json:"name"`
+	Image                 string                 `json:"image"`
+	Essential             bool                   `json:"essential"`
+	MemoryReservation     float64                `json:"memoryReservation,omitempty"`
+	EntryPoint            []string               `json:"entryPoint,omitempty"`
+	Command               []string               `json:"command,omitempty"`
+	PortMappings          []PortMapping          `json:"portMappings,omitempty"`
+	Environment           []NameValue            `json:"environment,omitempty"`
+	Secrets               []ContainerSecret      `json:"secrets,omitempty"`
+	MountPoints           []MountPoint           `json:"mountPoints,omitempty"`
+	DependsOn             []ContainerDependency  `json:"dependsOn,omitempty"`
+	HealthCheck           *HealthCheck           `json:"healthCheck,omitempty"`
+	LogConfiguration      *LogConfiguration      `json:"logConfiguration,omitempty"`
+	FirelensConfiguration *FirelensConfiguration `json:"firelensConfiguration,omitempty"`
+}
+
+// PortMapping publishes a container port; named ports can join the mesh
+type PortMapping struct {
+	Name          string  `json:"name,omitempty"`
+	ContainerPort float64 `json:"containerPort"`
+	HostPort      float64 `json:"hostPort"`
+	Protocol      string  `json:"protocol,omitempty"`
+}
+
+// NameValue is an environment variable or log option
+type NameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// ContainerSecret is a variable ECS sets from Secrets Manager or SSM when
+// the task starts
+type ContainerSecret struct {
+	Name      string `json:"name"`
+	ValueFrom string `json:"valueFrom"`
+}
+
+// MountPoint mounts one of the task's volumes
+type MountPoint struct {
+	SourceVolume  string `json:"sourceVolume"`
+	ContainerPath string `json:"containerPath"`
+	ReadOnly      bool   `json:"readOnly"`
+}
+
+// ContainerDependency holds a container back until another reaches the condition
+type ContainerDependency struct {
+	ContainerName string `json:"containerName"`
+	Condition     string `json:"condition"`
+}
+
+// HealthCheck is a container's Docker health check
+type HealthCheck struct {
+	Command  []string `json:"command"`
+	Interval float64  `json:"interval"`
+	Timeout  float64  `json:"timeout"`
+	Retries  float64  `json:"retries"`
+}
+
+// LogConfiguration is a container's log driver
+type LogConfiguration struct {
+	LogDriver     string            `json:"logDriver"`
+	Options       map[string]string `json:"options,omitempty"`
+	SecretOptions []ContainerSecret `json:"secretOptions,omitempty"`
+}
+
+// FirelensConfiguration makes a container the task's FireLens log router
+type FirelensConfiguration struct {
+	Type    string            `json:"type"`
+	Options map[string]string `json:"options,omitempty"`
+}
+
+// AddEnvironment sets a variable
+func (c *Container) AddEnvironment(name string, value string) {
+	c.Environment = append(c.Environment, NameValue{Name: name, Value: value})
+}
+
+// AddSecret sets a variable from a secret or parameter ARN when the task starts
+func (c *Container) AddSecret(name string, valueFrom string) {
+	c.Secrets = append(c.Secrets, ContainerSecret{Name: name, ValueFrom: valueFrom})
+}
+
+// AddMountPoint mounts a volume of the task read-write
+func (c *Container) AddMountPoint(volume string, path string) {
+	c.MountPoints = append(c.MountPoints, MountPoint{SourceVolume: volume, ContainerPath: path})
+}
+
+// DependOn starts the container once the other reaches the condition,
+// START, COMPLETE, SUCCESS or HEALTHY
+func (c *Container) DependOn(other *Container, condition string) {
+	c.DependsOn = append(c.DependsOn, ContainerDependency{ContainerName: other.Name, Condition: condition})
+}
+
+// awsLogs ships a container's output to the log group
+func awsLogs(cfg StackConfig, logGroup cloudwatchloggroup.CloudwatchLogGroup, prefix string) *LogConfiguration {
+	return &LogConfiguration{
+		LogDriver: "awslogs",
+		Options: map[string]string{
+			"awslogs-group":         *logGroup.Name(),
+			"awslogs-region":        cfg.Region,
+			"awslogs-stream-prefix": prefix,
+		},
+	}
+}
+
+// TaskDefinition is a Fargate task definition whose containers can be
+// added until the app is synthesized, like the CDK construct it replaces
+type TaskDefinition struct {
+	ecstaskdefinition.EcsTaskDefinition
+	Roles      *ServiceRoles
+	containers []*Container
+}
+
+// newTaskDefinition registers a Fargate task definition of the family,
+// run by the service's roles on the configured CPU architecture
+func newTaskDefinition(stack cdktf.TerraformStack, cfg StackConfig, id string, family string, cpu float64, memoryMiB float64, roles *ServiceRoles, volumes ...*ecstaskdefinition.EcsTaskDefinitionVolume) *TaskDefinition {
+	taskDef := &TaskDefinition{Roles: roles}
+	config := &ecstaskdefinition.EcsTaskDefinitionConfig{
+		Family:                  cfg.Name(family),
+		Cpu:                     jsii.String(fmt.Sprint(cpu)),
+		Memory:                  jsii.String(fmt.Sprint(memoryMiB)),
+		NetworkMode:             jsii.String("awsvpc"),
+		RequiresCompatibilities: jsii.Strings("FARGATE"),
+		RuntimePlatform:         runtimePlatform(cfg),
+		TaskRoleArn:             roles.TaskRole.Arn(),
+		ExecutionRoleArn:        roles.ExecutionRole.Arn(),
+		ContainerDefinitions:    cdktf.Lazy_StringValue(&containerDefinitions{taskDef}, nil),
+	}
+	if len(volumes) > 0 {
+		config.Volume = &volumes
+	}
+	taskDef.EcsTaskDefinition = ecstaskdefinition.NewEcsTaskDefinition(stack, jsii.String(id), config)
+	return taskDef
+}
+
+// AddContainer adds the container to the task
+func (t *TaskDefinition) AddContainer(container *Container) *Container {
+	t.containers = append(t.containers, container)
+	return container
+}
+
+// FindContainer returns the task's container of that name, or nil
+func (t *TaskDefinition) FindContainer(name string) *Container {
+	for _, container := range t.containers {
+		if container.Name == name {
+			return container
+		}
+	}
+	return nil
+}
+
+// containerDefinitions renders a task's containers once every helper has
+// had its say
+type containerDefinitions struct {
+	taskDef *TaskDefinition
+}
+
+func (d *containerDefinitions) Produce(context cdktf.IResolveContext) *string {
+	definitions, err := json.Marshal(d.taskDef.containers)
+	if err != nil {
+		panic(err)
+	}
+	return jsii.String(string(definitions))
+}
+
+// newFargateService runs a task definition in the private subnets, rolling
+// back deploys whose tasks never get healthy. Operators can open a shell in
+// its tasks with ECS Exec.
+func newFargateService(stack cdktf.TerraformStack, cfg StackConfig, id string, name string, cluster *Cluster, taskDef *TaskDefinition, sizing ServiceSizing, vpc *Vpc, sg securitygroup.SecurityGroup) ecsservice.EcsService {
+	config := &ecsservice.EcsServiceConfig{
+		Name:           cfg.Name(name),
+		Cluster:        cluster.Arn,
+		TaskDefinition: taskDef.Arn(),
+		DesiredCount:   jsii.Number(sizing.DesiredCount),
+		NetworkConfiguration: &ecsservice.EcsServiceNetworkConfiguration{
+			Subnets:        vpc.PrivateSubnetIDs,
+			SecurityGroups: &[]*string{sg.Id()},
+		},
+		DeploymentCircuitBreaker: &ecsservice.EcsServiceDeploymentCircuitBreaker{Enable: jsii.Bool(true), Rollback: jsii.Bool(true)},
+		EnableExecuteCommand:     jsii.Bool(true),
+		PropagateTags:            jsii.String("SERVICE"),
+	}
+	if strategies := capacityProviderStrategies(sizing); strategies != nil {
+		config.CapacityProviderStrategy = strategies
+	} else {
+		config.LaunchType = jsii.String("FARGATE")
+	}
+	if cluster.capacityProviders != nil {
+		config.DependsOn = &[]cdktf.ITerraformDependable{cluster.capacityProviders}
+	}
+	allowExec(cfg, taskDef.Roles, cluster)
+	return ecsservice.NewEcsService(stack, jsii.String(id), config)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"github.com/aws/jsii-runtime-go"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/ecsservice"
+	"github.com/cdktf/cdktf-provider-aws-go/aws/v19/ecstaskdefinition"
+	"github.com/hashicorp/terraform-cdk-go/cdktf"
+)
+
+// XTDBService is the XTDB node running on Fargate, its roles and the
+// repository its image is pulled from
+type XTDBService struct {
+	Service ecsservice.EcsService
+	Roles   *ServiceRoles
+	Repo    Repository
+}
+
+// xtdbVolume mounts the data volume through the access point, as the task role
+func xtdbVolume(storage *XTDBStorage) *ecstaskdefinition.EcsTaskDefinitionVolume {
+	return &ecstaskdefinition.EcsTaskDefinitionVolume{
+		Name: jsii.String("xtdb-data"),
+		EfsVolumeConfiguration: &ecstaskdefinition.EcsTaskDefinitionVolumeEfsVolumeConfiguration{
+			FileSystemId:      storage.FileSystem.Id(),
+			TransitEncryption: jsii.String("ENABLED"),
+			AuthorizationConfig: &ecstaskdefinition.EcsTaskDefinitionVolumeEfsVolumeConfigurationAuthorizationConfig{
+				AccessPointId: storage.AccessPoint.Id(),
+				Iam:           jsii.String("ENABLED"),
+			},
+		},
+	}
+}
+
+// NewXTDBService runs XTDB in the private subnets with its data volume
+// mounted, its object store, tx log and backing database configured, and its
+// metrics and traces shipped when enabled. Every app layer variant runs it
+// the same way.
+func NewXTDBService(stack cdktf.TerraformStack, cfg StackConfig, network *NetworkStack, data *DataStack, cluster *Cluster, logShipping *LogShipping) *XTDBService {
+	key, storage, database, dbCredentials := data.Key, data.Storage, data.Database, data.Credentials
+
+	// CI pushes the XTDB image, tagged like the app's
+	xtdbRepo := newRepository(stack, cfg, key, "XTDBRepo", "xtdb", cfg.Existing.XTDBRepository)
+
+	// Create the XTDB roles; its task role may only use its own storage
+	xtdbRoles := NewServiceRoles(stack, cfg, key, "XTDB", "xtdb", xtdbRepo, dbCredentials)
+	storage.GrantReadWrite(xtdbRoles)
+
+	// Create a Task Definition for XTDB
+	taskDef := newTaskDefinition(stack, cfg, "XTDBTaskDef", "xtdb", cfg.XTDB.Cpu, cfg.XTDB.MemoryMiB, xtdbRoles, xtdbVolume(storage))
+
+	xtdb := taskDef.AddContainer(&Container{
+		Name:      "XTDBContainer",
+		Image:     imageURI(cfg, xtdbRepo),
+		Essential: true,
+		Command:   storage.Command(),
+		PortMappings: []PortMapping{
+			{
+				Name:          "xtdb", // Published in the mesh when enabled
+				ContainerPort: 3000,
+				HostPort:      3000,
+			},
+		},
+	})
+	credentialSecrets(xtdb, dbCredentials.Arn(), "POSTGRES_USER", "POSTGRES_PASSWORD")
+	xtdb.LogConfiguration = logShipping.Driver(taskDef, "xtdb")
+	xtdb.AddMountPoint("xtdb-data", xtdbDataDir)
+
+	storage.Configure(cfg, taskDef, xtdb, "xtdb-data")
+	if database != nil {
+		useBackingDatabase(cfg, database, xtdb, xtdbRoles, key)
+	}
+
+	// Publish XTDB's Prometheus metrics to CloudWatch
+	if cfg.Observability.XTDBMetrics {
+		addXTDBMetricsAgent(stack, cfg, key, taskDef)
+	}
+	if cfg.Observability.Tracing {
+		addOtelCollector(cfg, taskDef, xtdb, "xtdb")
+	}
+
+	// Create a Service for XTDB; the data layer already mounted its volume
+	xtdbService := newFargateService(stack, cfg, "XTDBService", "xtdb", cluster, taskDef, cfg.XTDB, network.Vpc, network.SecurityGroups.XTDB)
+
+	return &XTDBService{Service: xtdbService, Roles: xtdbRoles, Repo: xtdbRepo}
+}
@@ -17,7 +17,7 @@ run_local() {
     echo_step "This will start XTDB and the Clojure web application"
     
     cd ci
-    dagger call run-local-web-app --src-dir ../my-app up \
+    dagger call run-local-web-app --src-dir ../my-app service up \
         --ports 58950:58950 \
         --ports 3000:3000 \
         --ports 5432:5432 \
@@ -31,12 +31,30 @@ run_local() {
     echo "  - XTDB Health: http://localhost:8080/healthz/alive"
 }
 
+# Function to run the local environment behind the reverse proxy
+run_proxy() {
+    echo_step "Starting local development environment behind the reverse proxy..."
+    echo_step "This will start XTDB, pgAdmin, the Clojure web application and Caddy"
+
+    cd ci
+    dagger call run-local-web-app --src-dir ../my-app --with-proxy service up \
+        --ports 8000:80 \
+        --ports 5432:5432
+
+    echo_step "Local environment is running!"
+    echo_step "Access points:"
+    echo "  - Web App: http://app.localhost:8000"
+    echo "  - XTDB HTTP API: http://xtdb.localhost:8000"
+    echo "  - pgAdmin: http://pgadmin.localhost:8000"
+    echo "  - XTDB PostgreSQL: localhost:5432"
+}
+
 # Function to run just the database environment
 run_db() {
     echo_step "Starting database environment (XTDB)..."
     
     cd ci
-    dagger call run-local-development up \
+    dagger call run-local-development service up \
         --ports 3000:3000 \
         --ports 5432:5432 \
         --ports 8080:8080
@@ -59,14 +77,26 @@ publish_app() {
     echo "Check the output above for the published image URLs"
 }
 
+# Function to scan the application source for committed secrets
+scan_secrets() {
+    echo_step "Scanning Clojure web application for secrets..."
+
+    cd ci
+    dagger call secret-scan --src-dir ../my-app
+
+    echo_step "No secrets found!"
+}
+
 # Help message
 show_help() {
     echo "Usage: $0 [command]"
     echo
     echo "Commands:"
     echo "  local    - Run full local environment (XTDB + Web App)"
+    echo "  proxy    - Run full local environment behind a reverse proxy"
     echo "  db       - Run only database environment (XTDB)"
     echo "  publish  - Build and publish the web application"
+    echo "  scan     - Scan the web application source for secrets"
     echo "  help     - Show this help message"
 }
 
@@ -75,12 +105,18 @@ case "$1" in
     "local")
         run_local
         ;;
+    "proxy")
+        run_proxy
+        ;;
     "db")
         run_db
         ;;
     "publish")
         publish_app
         ;;
+    "scan")
+        scan_secrets
+        ;;
     "help"|"")
         show_help
         ;;